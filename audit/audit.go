@@ -0,0 +1,308 @@
+/*
+Package audit provides a Store wrapper recording every write to an audit
+trail, for the compliance requirements around sensitive keyspaces.
+
+Every Add, Set, SetWithTimeout, SetWithDeadline, Update and Delete, whether
+it succeeds or not, is recorded to a Sink with its time, key, method and
+outcome, and with the actor given to the context with As. With WithHashes,
+the records also hold the SHA-256 hashes of the values before and after the
+write, at the cost of a read before every write; the values themselves are
+never recorded.
+
+The Sink may be a function, another Store (StoreSink) or a writer of JSON
+lines (WriterSink). The records are written after the operation: a failing
+Sink does not fail the operation, and its error is passed to the error
+handler, by default the standard logger.
+*/
+package audit // import "github.com/gokv/store/audit"
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Record is the audit record of a write.
+type Record struct {
+	// Time is when the write started.
+	Time time.Time `json:"time"`
+
+	// Actor is the actor given to the context with As, if any.
+	Actor string `json:"actor,omitempty"`
+
+	// Method is the method of the write.
+	Method string `json:"method"`
+
+	// Key is the written key, empty if Add failed.
+	Key string `json:"key"`
+
+	// Ok reports whether the write applied: it is false for the failures, and
+	// for the Update and Delete of a key not found.
+	Ok bool `json:"ok"`
+
+	// Err is the error message of the failures.
+	Err string `json:"error,omitempty"`
+
+	// Before and After are the hex-encoded SHA-256 hashes of the value before
+	// and after the write, with WithHashes. They are empty when there is no
+	// value.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Sink receives the audit records.
+type Sink interface {
+
+	// Write records r.
+	// Err is non-nil in case of failure.
+	Write(ctx context.Context, r *Record) error
+}
+
+// SinkFunc is a function used as a Sink.
+type SinkFunc func(ctx context.Context, r *Record) error
+
+// Write calls f.
+func (f SinkFunc) Write(ctx context.Context, r *Record) error {
+	return f(ctx, r)
+}
+
+// record marshals a Record for a Store.
+type record Record
+
+func (r *record) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*Record)(r))
+}
+
+// StoreSink returns a Sink adding the records, as JSON, to s.
+func StoreSink(s store.Store) Sink {
+	return SinkFunc(func(ctx context.Context, r *Record) error {
+		_, err := s.Add(ctx, (*record)(r))
+		return err
+	})
+}
+
+// WriterSink returns a Sink writing the records to w, one JSON object per
+// line. The writes to w are serialized.
+func WriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(_ context.Context, r *Record) error {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = w.Write(append(line, '\n'))
+		return err
+	})
+}
+
+type actorKey struct{}
+
+// As returns a copy of ctx whose writes are recorded as done by actor, for
+// example a user or service name.
+func As(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf returns the actor given to ctx with As, if any.
+func ActorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithHashes records the hashes of the values before and after the writes.
+func WithHashes() Option {
+	return func(s *Store) { s.hashes = true }
+}
+
+// WithPrefixes only records the writes of the keys starting with one of the
+// given prefixes. Add, whose key is assigned by the wrapped Store, is
+// recorded whatever the prefixes.
+func WithPrefixes(prefixes ...string) Option {
+	return func(s *Store) { s.prefixes = append(s.prefixes, prefixes...) }
+}
+
+// WithErrorHandler sets the function called with the failures of the Sink,
+// in place of the standard logger.
+func WithErrorHandler(fn func(r *Record, err error)) Option {
+	return func(s *Store) { s.onError = fn }
+}
+
+// Store is a store.Store recording the writes to the wrapped Store.
+type Store struct {
+	store.Wrapper
+	sink     Sink
+	hashes   bool
+	prefixes []string
+	onError  func(r *Record, err error)
+}
+
+// Wrap returns a Store recording the writes to s in sink.
+func Wrap(s store.Store, sink Sink, opts ...Option) *Store {
+	a := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		sink:    sink,
+		onError: func(r *Record, err error) {
+			log.Printf("audit: recording %s %q: %v", r.Method, r.Key, err)
+		},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// audited reports whether the writes of k are recorded.
+func (s *Store) audited(k string) bool {
+	if len(s.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// begin starts the record of a write of k, with the hash of its current
+// value if required.
+func (s *Store) begin(ctx context.Context, method, k string) *Record {
+	r := &Record{Time: time.Now(), Actor: ActorOf(ctx), Method: method, Key: k}
+	if s.hashes && k != "" {
+		var v json.RawMessage
+		if ok, err := s.Store.Get(ctx, k, &v); err == nil && ok {
+			r.Before = hash(v)
+		}
+	}
+	return r
+}
+
+// end completes r with the outcome of the write, and sends it to the Sink.
+func (s *Store) end(ctx context.Context, r *Record, ok bool, err error) {
+	r.Ok = ok && err == nil
+	if err != nil {
+		r.Err = err.Error()
+	}
+	if werr := s.sink.Write(ctx, r); werr != nil {
+		s.onError(r, werr)
+	}
+}
+
+// marshal returns the JSON of v, and completes the After hash of r with it.
+func (s *Store) marshal(r *Record, v json.Marshaler) (json.RawMessage, error) {
+	data, err := v.MarshalJSON()
+	if err == nil && s.hashes {
+		r.After = hash(data)
+	}
+	return data, err
+}
+
+// set records the write of v to k with fn.
+func (s *Store) set(ctx context.Context, method, k string, v json.Marshaler, fn func(json.Marshaler) (bool, error)) (ok bool, err error) {
+	if !s.audited(k) {
+		return fn(v)
+	}
+	r := s.begin(ctx, method, k)
+	defer func() { s.end(ctx, r, ok, err) }()
+	data, err := s.marshal(r, v)
+	if err != nil {
+		return false, err
+	}
+	ok, err = fn(data)
+	if !ok || err != nil {
+		r.After = r.Before
+	}
+	return ok, err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	r := s.begin(ctx, "Add", "")
+	defer func() {
+		r.Key = k
+		s.end(ctx, r, true, err)
+	}()
+	data, err := s.marshal(r, v)
+	if err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, data)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	_, err := s.set(ctx, "Set", k, v, func(v json.Marshaler) (bool, error) {
+		return true, s.Store.Set(ctx, k, v)
+	})
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	_, err := s.set(ctx, "SetWithTimeout", k, v, func(v json.Marshaler) (bool, error) {
+		return true, s.Store.SetWithDeadline(ctx, k, v, deadline)
+	})
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	_, err := s.set(ctx, "SetWithDeadline", k, v, func(v json.Marshaler) (bool, error) {
+		return true, s.Store.SetWithDeadline(ctx, k, v, deadline)
+	})
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	return s.set(ctx, "Update", k, v, func(v json.Marshaler) (bool, error) {
+		return s.Store.Update(ctx, k, v)
+	})
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	if !s.audited(k) {
+		return s.Store.Delete(ctx, k)
+	}
+	r := s.begin(ctx, "Delete", k)
+	defer func() {
+		if !ok || err != nil {
+			r.After = r.Before
+		}
+		s.end(ctx, r, ok, err)
+	}()
+	return s.Store.Delete(ctx, k)
+}
+
+var _ store.Store = (*Store)(nil)
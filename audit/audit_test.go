@@ -0,0 +1,23 @@
+package audit_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/audit"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store auditing the writes of an empty memstore to a
+// discarded log.
+func newStore() store.Store {
+	return audit.Wrap(memstore.New(), audit.WriterSink(io.Discard))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,418 @@
+/*
+Package azblob implements store.Store on top of an Azure Blob Storage
+container, one block blob per key, for parity with the s3 and gcs packages.
+
+The blobs are stored under a common prefix, with the JSON encoding of the
+value as their content. The deadline of an expiring blob is kept in its
+metadata, so that the expired blobs are hidden on read. The lifecycle
+management of Azure is not part of the data plane: Sweep deletes the expired
+blobs, and is meant to be called periodically.
+
+Add, Update and CompareAndSet are conditional on the ETag of the blobs, and
+retried on concurrent modifications; Update and CompareAndSet keep the
+expiration of the key.
+*/
+package azblob // import "github.com/gokv/store/azblob"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gokv/store"
+)
+
+// deadlineMeta is the metadata holding the deadline of a blob, in the
+// RFC 3339 format. The metadata names must be valid C# identifiers.
+const deadlineMeta = "gokv_deadline"
+
+// Store is a store.Store backed by an Azure Blob container.
+type Store struct {
+	c      *container.Client
+	prefix string
+}
+
+// New returns a Store keeping the items in the container of c, under the
+// given prefix.
+func New(c *container.Client, prefix string) *Store {
+	return &Store{c: c, prefix: prefix}
+}
+
+func (s *Store) blob(k string) *blob.Client {
+	return s.c.NewBlobClient(s.prefix + k)
+}
+
+func notFound(err error) bool {
+	return bloberror.HasCode(err, bloberror.BlobNotFound)
+}
+
+// conflict reports whether err is the failure of a conditional write.
+func conflict(err error) bool {
+	return bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists)
+}
+
+// blobDeadline returns the deadline in the metadata of a blob, or the zero
+// time. The names of the metadata are case-insensitive.
+func blobDeadline(meta map[string]*string) time.Time {
+	for name, v := range meta {
+		if strings.EqualFold(name, deadlineMeta) && v != nil {
+			d, _ := time.Parse(time.RFC3339Nano, *v)
+			return d
+		}
+	}
+	return time.Time{}
+}
+
+// deref returns the value of p, or the zero value if p is nil.
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
+func expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !deadline.After(now)
+}
+
+func ifMatch(etag *azcore.ETag) *blob.AccessConditions {
+	return &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: etag}}
+}
+
+// object is the state of a blob read by get.
+type object struct {
+	value    []byte
+	etag     *azcore.ETag
+	deadline time.Time
+	created  time.Time
+	modified time.Time
+}
+
+// get downloads the blob of k, or returns nil if it was not found or
+// expired.
+func (s *Store) get(ctx context.Context, k string) (*object, error) {
+	resp, err := s.blob(k).DownloadStream(ctx, nil)
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	o := &object{etag: resp.ETag, deadline: blobDeadline(resp.Metadata)}
+	if expired(o.deadline, time.Now()) {
+		return nil, nil
+	}
+	if o.value, err = io.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// head reads the properties of the blob of k, or returns nil if it was not
+// found or expired.
+func (s *Store) head(ctx context.Context, k string) (*object, error) {
+	resp, err := s.blob(k).GetProperties(ctx, nil)
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := &object{
+		etag:     resp.ETag,
+		deadline: blobDeadline(resp.Metadata),
+		created:  deref(resp.CreationTime),
+		modified: deref(resp.LastModified),
+	}
+	if expired(o.deadline, time.Now()) {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// put uploads the blob of k under the given access conditions.
+func (s *Store) put(ctx context.Context, k string, value []byte, deadline time.Time, cond *blob.AccessConditions) error {
+	opts := &blockblob.UploadOptions{
+		HTTPHeaders:      &blob.HTTPHeaders{BlobContentType: to.Ptr("application/json")},
+		AccessConditions: cond,
+	}
+	if !deadline.IsZero() {
+		opts.Metadata = map[string]*string{deadlineMeta: to.Ptr(deadline.UTC().Format(time.RFC3339Nano))}
+	}
+	_, err := s.c.NewBlockBlobClient(s.prefix+k).Upload(ctx, streaming.NopCloser(bytes.NewReader(value)), opts)
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	o, err := s.get(ctx, k)
+	if err != nil || o == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(o.value)
+}
+
+// GetAll unmarshals to c every item under the prefix of the Store, ordered by
+// key. Each blob is downloaded as it is listed.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.list(ctx, "", func(k string) error {
+		o, err := s.get(ctx, k)
+		if err != nil || o == nil {
+			// Deleted or expired since it was listed.
+			return err
+		}
+		return c.New().UnmarshalJSON(o.value)
+	})
+}
+
+// list calls fn with every key starting with prefix whose blob has not
+// expired, in order.
+func (s *Store) list(ctx context.Context, prefix string, fn func(k string) error) error {
+	p := s.c.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  to.Ptr(s.prefix + prefix),
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+	now := time.Now()
+	for p.More() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Segment.BlobItems {
+			if expired(blobDeadline(item.Metadata), now) {
+				continue
+			}
+			if err := fn(strings.TrimPrefix(deref(item.Name), s.prefix)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	cond := &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		err := s.put(ctx, k, value, time.Time{}, cond)
+		if err == nil {
+			return k, nil
+		}
+		if !conflict(err) {
+			return "", err
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if expired(deadline, time.Now()) {
+		if _, err := s.blob(k).Delete(ctx, nil); err != nil && !notFound(err) {
+			return err
+		}
+		return nil
+	}
+	return s.put(ctx, k, value, deadline, nil)
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		o, err := s.head(ctx, k)
+		if err != nil || o == nil {
+			return false, err
+		}
+		err = s.put(ctx, k, value, o.deadline, ifMatch(o.etag))
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	for {
+		resp, err := s.blob(k).GetProperties(ctx, nil)
+		if notFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		_, err = s.blob(k).Delete(ctx, &blob.DeleteOptions{AccessConditions: ifMatch(resp.ETag)})
+		if err == nil {
+			return !expired(blobDeadline(resp.Metadata), time.Now()), nil
+		}
+		if !notFound(err) && !conflict(err) {
+			return false, err
+		}
+	}
+}
+
+// Ping returns a non-nil error if the container can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.c.GetProperties(ctx, nil)
+	return err
+}
+
+// Close is a no-op: the Azure client holds no resources.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Sweep deletes the expired blobs under the prefix of the Store, and returns
+// their number.
+// Err is non-nil in case of failure.
+func (s *Store) Sweep(ctx context.Context) (int64, error) {
+	p := s.c.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  to.Ptr(s.prefix),
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+	var n int64
+	now := time.Now()
+	for p.More() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return n, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			if !expired(blobDeadline(item.Metadata), now) {
+				continue
+			}
+			// The blob may have been overwritten since it was listed.
+			_, err := s.c.NewBlobClient(deref(item.Name)).Delete(ctx, &blob.DeleteOptions{
+				AccessConditions: ifMatch(item.Properties.ETag),
+			})
+			if err == nil {
+				n++
+			} else if !notFound(err) && !conflict(err) {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// GetMeta retrieves the metadata of the given key: the ETag of the blob as
+// its version, its creation time and its last modification time.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	o, err := s.head(ctx, k)
+	if err != nil || o == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{Version: string(deref(o.etag)), CreatedAt: o.created, UpdatedAt: o.modified}, true, nil
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	ov, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		o, err := s.get(ctx, k)
+		if err != nil || o == nil || !bytes.Equal(o.value, ov) {
+			return false, err
+		}
+		err = s.put(ctx, k, value, o.deadline, ifMatch(o.etag))
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	o, err := s.head(ctx, k)
+	return o != nil, err
+}
+
+// Keys returns every key starting with prefix, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.list(ctx, prefix, func(k string) error {
+		ks = append(ks, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+)
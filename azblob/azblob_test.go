@@ -0,0 +1,58 @@
+package azblob_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gokv/store"
+	"github.com/gokv/store/azblob"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store under a new prefix of
+// the container of the GOKV_AZBLOB_CONTAINER environment variable, in the
+// account of the GOKV_AZBLOB_CONNECTION_STRING connection string, e.g. the
+// Azurite development storage. The blobs are deleted when the test completes.
+// The test is skipped if the container or the connection string is not set.
+func newStore(tb testing.TB) func() store.Store {
+	conn, name := os.Getenv("GOKV_AZBLOB_CONNECTION_STRING"), os.Getenv("GOKV_AZBLOB_CONTAINER")
+	if conn == "" || name == "" {
+		tb.Skip("GOKV_AZBLOB_CONNECTION_STRING or GOKV_AZBLOB_CONTAINER is not set")
+	}
+	c, err := container.NewClientFromConnectionString(conn, name, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return func() store.Store {
+		s := azblob.New(c, "gokv-test/"+randomHex()+"/")
+		tb.Cleanup(func() { deleteAll(s) })
+		return s
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// deleteAll deletes every key of s.
+func deleteAll(s *azblob.Store) {
+	ctx := context.Background()
+	ks, _ := s.Keys(ctx, "")
+	for _, k := range ks {
+		s.Delete(ctx, k)
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
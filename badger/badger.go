@@ -0,0 +1,286 @@
+/*
+Package badger implements store.Store on top of a Badger database, an
+embedded store with native expiration.
+
+The values are stored as their JSON encoding. SetWithTimeout and
+SetWithDeadline map to the Badger entry TTL, which has a one second
+resolution. Update keeps the expiration of the key.
+
+Badger detects the conflicts between concurrent transactions: the writes
+failing because of one return an error wrapping store.ErrConflict.
+*/
+package badger // import "github.com/gokv/store/badger"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store backed by Badger.
+type Store struct {
+	db *badgerdb.DB
+}
+
+// New returns a Store using db. Closing the Store closes db.
+func New(db *badgerdb.DB) *Store {
+	return &Store{db: db}
+}
+
+// wrapErr maps the Badger errors to the store errors.
+func wrapErr(err error) error {
+	if errors.Is(err, badgerdb.ErrConflict) {
+		return fmt.Errorf("%w: %v", store.ErrConflict, err)
+	}
+	return err
+}
+
+func (s *Store) view(ctx context.Context, fn func(txn *badgerdb.Txn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+func (s *Store) update(ctx context.Context, fn func(txn *badgerdb.Txn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return wrapErr(s.db.Update(fn))
+}
+
+// get returns the item of k, or nil if it was not found.
+func get(txn *badgerdb.Txn, k string) (*badgerdb.Item, error) {
+	item, err := txn.Get([]byte(k))
+	if err == badgerdb.ErrKeyNotFound {
+		return nil, nil
+	}
+	return item, err
+}
+
+// expiresAt returns the Badger expiration of deadline, in seconds since the
+// Unix epoch, rounded up so that the keys never clear early.
+func expiresAt(deadline time.Time) uint64 {
+	if deadline.IsZero() {
+		return 0
+	}
+	e := deadline.Unix()
+	if deadline.Nanosecond() > 0 {
+		e++
+	}
+	return uint64(e)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.view(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		if err != nil || item == nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.view(ctx, func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid() && limit != 0; it.Next() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			err := it.Item().Value(func(value []byte) error {
+				return c.New().UnmarshalJSON(append([]byte(nil), value...))
+			})
+			if err != nil {
+				return err
+			}
+			limit--
+		}
+		return nil
+	})
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.update(ctx, func(txn *badgerdb.Txn) error {
+		for {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			k = hex.EncodeToString(b)
+			item, err := get(txn, k)
+			if err != nil {
+				return err
+			}
+			if item == nil {
+				return txn.Set([]byte(k), value)
+			}
+		}
+	})
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, func(txn *badgerdb.Txn) error {
+		if !deadline.IsZero() && !deadline.After(time.Now()) {
+			return txn.Delete([]byte(k))
+		}
+		e := badgerdb.NewEntry([]byte(k), value)
+		e.ExpiresAt = expiresAt(deadline)
+		return txn.SetEntry(e)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		if ok = item != nil; err != nil || !ok {
+			return err
+		}
+		e := badgerdb.NewEntry([]byte(k), value)
+		e.ExpiresAt = item.ExpiresAt()
+		return txn.SetEntry(e)
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.update(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		if ok = item != nil; err != nil || !ok {
+			return err
+		}
+		return txn.Delete([]byte(k))
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if the database is closed.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.db.IsClosed() {
+		return badgerdb.ErrDBClosed
+	}
+	return nil
+}
+
+// Close closes the database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (ttl time.Duration, ok bool, err error) {
+	err = s.view(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		if ok = item != nil; err != nil || !ok {
+			return err
+		}
+		if e := item.ExpiresAt(); e != 0 {
+			ttl = time.Until(time.Unix(int64(e), 0))
+		}
+		return nil
+	})
+	return ttl, ok, err
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	err = s.update(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		if ok = item != nil; err != nil || !ok {
+			return err
+		}
+		if timeout <= 0 {
+			return txn.Delete([]byte(k))
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(badgerdb.NewEntry([]byte(k), value).WithTTL(timeout))
+	})
+	return ok, err
+}
+
+var (
+	_ store.Store    = (*Store)(nil)
+	_ store.Pager    = (*Store)(nil)
+	_ store.TTLStore = (*Store)(nil)
+)
@@ -0,0 +1,25 @@
+package badger_test
+
+import (
+	"testing"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/gokv/store"
+	"github.com/gokv/store/badger"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore opens an empty Store in a new in-memory database.
+func newStore() store.Store {
+	db, err := badgerdb.Open(badgerdb.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		panic(err)
+	}
+	return badger.New(db)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
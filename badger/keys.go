@@ -0,0 +1,85 @@
+package badger
+
+import (
+	"context"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/gokv/store"
+)
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.view(ctx, func(txn *badgerdb.Txn) error {
+		item, err := get(txn, k)
+		ok = item != nil
+		return err
+	})
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, func(k []byte) {
+		ks = append(ks, string(k))
+	})
+	return ks, err
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.scan(ctx, prefix, func([]byte) { n++ })
+	return n, err
+}
+
+// scan calls fn with every key starting with prefix, without fetching the
+// values.
+func (s *Store) scan(ctx context.Context, prefix string, fn func(k []byte)) error {
+	return s.view(ctx, func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			fn(it.Item().Key())
+		}
+		return nil
+	})
+}
+
+// Clear removes every key and value from the store, with DropAll. The
+// database blocks the writes while clearing.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.DropAll()
+}
+
+// ClearPrefix removes every key starting with prefix, and its value, with
+// DropPrefix. The database blocks the writes while clearing.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.DropPrefix([]byte(prefix))
+}
+
+var (
+	_ store.Exister   = (*Store)(nil)
+	_ store.KeyLister = (*Store)(nil)
+	_ store.Sizer     = (*Store)(nil)
+	_ store.Clearer   = (*Store)(nil)
+)
@@ -0,0 +1,80 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/gokv/store"
+)
+
+// Begin starts a new read-write Badger transaction. Badger transactions are
+// optimistic: Commit returns an error wrapping store.ErrConflict if a key
+// read by the transaction was written concurrently. If ctx is done before
+// Commit, Commit discards the transaction and returns the context error.
+// Err is non-nil in case of failure.
+func (s *Store) Begin(ctx context.Context) (store.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &badgerTx{txn: s.db.NewTransaction(true), ctx: ctx}, nil
+}
+
+// badgerTx is a store.Tx over a Badger transaction. As the latter, it must
+// only be used by one goroutine at a time.
+type badgerTx struct {
+	txn *badgerdb.Txn
+	ctx context.Context
+}
+
+func (t *badgerTx) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	item, err := get(t.txn, k)
+	if err != nil || item == nil {
+		return false, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+func (t *badgerTx) Set(ctx context.Context, k string, v json.Marshaler) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return t.txn.Set([]byte(k), value)
+}
+
+func (t *badgerTx) Delete(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	item, err := get(t.txn, k)
+	if err != nil || item == nil {
+		return false, err
+	}
+	return true, t.txn.Delete([]byte(k))
+}
+
+func (t *badgerTx) Commit() error {
+	if err := t.ctx.Err(); err != nil {
+		t.txn.Discard()
+		return err
+	}
+	return wrapErr(t.txn.Commit())
+}
+
+func (t *badgerTx) Rollback() error {
+	t.txn.Discard()
+	return nil
+}
+
+var _ store.Txn = (*Store)(nil)
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// MultiGetter defines a method for retrieving multiple values at once.
+type MultiGetter interface {
+
+	// GetMulti retrieves the values of the given keys and unmarshals each of
+	// them to the element of vs with the same index. ks and vs must have the
+	// same length.
+	// Ok[i] is false if the key ks[i] was not found.
+	// Err is non-nil in case of failure.
+	GetMulti(ctx context.Context, ks []string, vs []json.Unmarshaler) (ok []bool, err error)
+}
+
+// MultiSetter defines a method for assigning multiple values at once.
+type MultiSetter interface {
+
+	// SetMulti idempotently assigns each element of vs to the key of ks with
+	// the same index. ks and vs must have the same length.
+	// Err is non-nil in case of failure.
+	SetMulti(ctx context.Context, ks []string, vs []json.Marshaler) error
+}
+
+// MultiDeleter defines a method for removing multiple keys at once.
+type MultiDeleter interface {
+
+	// DeleteMulti removes the given keys and their values from the store.
+	// Ok[i] is false if the key ks[i] was not found.
+	// Err is non-nil in case of failure.
+	DeleteMulti(ctx context.Context, ks []string) (ok []bool, err error)
+}
+
+// Batch groups the multi-key methods. Implementations backed by a persistence
+// with native batching (e.g. pipelines or batch writes) should expose it
+// through these methods rather than looping over single-key calls.
+type Batch interface {
+	MultiGetter
+	MultiSetter
+	MultiDeleter
+}
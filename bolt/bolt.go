@@ -0,0 +1,381 @@
+/*
+Package bolt implements store.Store on top of a bbolt database, for
+single-binary deployments needing embedded persistence.
+
+The items are kept in a bucket, each value prefixed with its expiration.
+Bolt has no native expiration: the expired keys are hidden on read, and a
+sweep goroutine removes them periodically, walking a sidecar bucket which
+indexes the keys by expiration.
+
+Update keeps the expiration of the key. Add generates the keys from the
+bucket sequence.
+*/
+package bolt // import "github.com/gokv/store/bolt"
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// keys, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+// Store is a store.Store backed by a bbolt bucket.
+type Store struct {
+	db     *bbolt.DB
+	bucket []byte
+	expiry []byte
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store keeping the items in the given bucket of db, and the
+// expiration index in a bucket named after it with the ".expiry" suffix.
+// The buckets are created if needed. Closing the Store closes db.
+func New(db *bbolt.DB, bucket string) (*Store, error) {
+	return NewWithSweepInterval(db, bucket, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired keys.
+func NewWithSweepInterval(db *bbolt.DB, bucket string, interval time.Duration) (*Store, error) {
+	s := &Store{
+		db:     db,
+		bucket: []byte(bucket),
+		expiry: []byte(bucket + ".expiry"),
+		stop:   make(chan struct{}),
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(s.bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(s.expiry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s, nil
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_ = s.sweep(now)
+		}
+	}
+}
+
+// sweep removes every key expired at now. The expired index keys are
+// collected before the deletions, as deleting under a bbolt cursor skips
+// the entry following each deleted one.
+func (s *Store) sweep(now time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, x := tx.Bucket(s.bucket), tx.Bucket(s.expiry)
+		var iks [][]byte
+		c := x.Cursor()
+		for ik, _ := c.First(); ik != nil; ik, _ = c.Next() {
+			if deadline, _ := splitIndexKey(ik); deadline > now.UnixNano() {
+				break
+			}
+			iks = append(iks, append([]byte(nil), ik...))
+		}
+		for _, ik := range iks {
+			deadline, k := splitIndexKey(ik)
+			if r := b.Get(k); r != nil && recordDeadline(r) == deadline {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			if err := x.Delete(ik); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// A record is the stored form of a value: the expiration deadline in
+// nanoseconds since the Unix epoch (zero if the key does not expire), as a
+// big-endian int64, followed by the JSON encoding of the value.
+func newRecord(deadline int64, value []byte) []byte {
+	r := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(r, uint64(deadline))
+	copy(r[8:], value)
+	return r
+}
+
+func recordDeadline(r []byte) int64 {
+	return int64(binary.BigEndian.Uint64(r))
+}
+
+// recordValue returns a copy of the value of r, which is only valid during
+// the transaction.
+func recordValue(r []byte) []byte {
+	return append([]byte(nil), r[8:]...)
+}
+
+func expired(r []byte, now time.Time) bool {
+	d := recordDeadline(r)
+	return d != 0 && d <= now.UnixNano()
+}
+
+// The keys of the expiry bucket are the deadline as a big-endian int64,
+// followed by the key, so that the cursor walks them by expiration.
+func indexKey(deadline int64, k []byte) []byte {
+	ik := make([]byte, 8+len(k))
+	binary.BigEndian.PutUint64(ik, uint64(deadline))
+	copy(ik[8:], k)
+	return ik
+}
+
+func splitIndexKey(ik []byte) (deadline int64, k []byte) {
+	return int64(binary.BigEndian.Uint64(ik)), ik[8:]
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	if n := t.UnixNano(); n != 0 {
+		return n
+	}
+	// Zero means no expiration.
+	return 1
+}
+
+// get returns the record of k in b, or nil if it was not found or expired.
+func get(b *bbolt.Bucket, k []byte, now time.Time) []byte {
+	r := b.Get(k)
+	if r == nil || expired(r, now) {
+		return nil
+	}
+	return r
+}
+
+// put writes the record of k, maintaining the expiry index.
+func (s *Store) put(tx *bbolt.Tx, k, value []byte, deadline int64) error {
+	b, x := tx.Bucket(s.bucket), tx.Bucket(s.expiry)
+	if old := b.Get(k); old != nil {
+		if d := recordDeadline(old); d != 0 {
+			if err := x.Delete(indexKey(d, k)); err != nil {
+				return err
+			}
+		}
+	}
+	if deadline != 0 {
+		if err := x.Put(indexKey(deadline, k), nil); err != nil {
+			return err
+		}
+	}
+	return b.Put(k, newRecord(deadline, value))
+}
+
+// remove deletes k, maintaining the expiry index. Ok is false if k was not
+// found or expired.
+func (s *Store) remove(tx *bbolt.Tx, k []byte, now time.Time) (ok bool, err error) {
+	b, x := tx.Bucket(s.bucket), tx.Bucket(s.expiry)
+	r := b.Get(k)
+	if r == nil {
+		return false, nil
+	}
+	ok = !expired(r, now)
+	if d := recordDeadline(r); d != 0 {
+		if err := x.Delete(indexKey(d, k)); err != nil {
+			return false, err
+		}
+	}
+	return ok, b.Delete(k)
+}
+
+func (s *Store) view(ctx context.Context, fn func(b *bbolt.Bucket, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket(s.bucket), time.Now())
+	})
+}
+
+func (s *Store) update(ctx context.Context, fn func(tx *bbolt.Tx, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(tx, time.Now())
+	})
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.view(ctx, func(b *bbolt.Bucket, now time.Time) error {
+		if r := get(b, []byte(k), now); r != nil {
+			value = recordValue(r)
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.view(ctx, func(b *bbolt.Bucket, now time.Time) error {
+		cur := b.Cursor()
+		for k, r := cur.First(); k != nil && limit != 0; k, r = cur.Next() {
+			if expired(r, now) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if err := c.New().UnmarshalJSON(recordValue(r)); err != nil {
+				return err
+			}
+			limit--
+		}
+		return nil
+	})
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys
+// are the decimal values of the bucket sequence.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.update(ctx, func(tx *bbolt.Tx, now time.Time) error {
+		b := tx.Bucket(s.bucket)
+		for {
+			n, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			k = strconv.FormatUint(n, 10)
+			if get(b, []byte(k), now) == nil {
+				return s.put(tx, []byte(k), value, 0)
+			}
+		}
+	})
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.set(ctx, k, v, unixNano(time.Now().Add(timeout)))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.set(ctx, k, v, unixNano(deadline))
+}
+
+func (s *Store) set(ctx context.Context, k string, v json.Marshaler, deadline int64) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bbolt.Tx, _ time.Time) error {
+		return s.put(tx, []byte(k), value, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(tx *bbolt.Tx, now time.Time) error {
+		r := get(tx.Bucket(s.bucket), []byte(k), now)
+		if ok = r != nil; !ok {
+			return nil
+		}
+		return s.put(tx, []byte(k), value, recordDeadline(r))
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.update(ctx, func(tx *bbolt.Tx, now time.Time) error {
+		ok, err = s.remove(tx, []byte(k), now)
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if the database can not be read.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.view(ctx, func(b *bbolt.Bucket, _ time.Time) error {
+		if b == nil {
+			return errors.New("bolt: bucket not found")
+		}
+		return nil
+	})
+}
+
+// Close stops the sweep goroutine and closes the database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	return s.db.Close()
+}
+
+var (
+	_ store.Store = (*Store)(nil)
+	_ store.Pager = (*Store)(nil)
+)
@@ -0,0 +1,34 @@
+package bolt_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/bolt"
+	"github.com/gokv/store/storetest"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// newStore returns a function opening an empty Store in a new database file
+// of a temporary directory.
+func newStore(tb testing.TB) func() store.Store {
+	return func() store.Store {
+		db, err := bbolt.Open(filepath.Join(tb.TempDir(), "bolt.db"), 0o600, nil)
+		if err != nil {
+			panic(err)
+		}
+		s, err := bolt.NewWithSweepInterval(db, "items", 100*time.Millisecond)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,10 @@
+module github.com/gokv/store/bolt
+
+go 1.26.7
+
+require (
+	github.com/gokv/store v0.1.0
+	go.etcd.io/bbolt v1.5.0
+)
+
+require golang.org/x/sys v0.45.0 // indirect
@@ -0,0 +1,99 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gokv/store"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.view(ctx, func(b *bbolt.Bucket, now time.Time) error {
+		ok = get(b, []byte(k), now) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, func(k []byte) {
+		ks = append(ks, string(k))
+	})
+	return ks, err
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.scan(ctx, prefix, func([]byte) { n++ })
+	return n, err
+}
+
+// scan calls fn with every key starting with prefix which has not expired.
+func (s *Store) scan(ctx context.Context, prefix string, fn func(k []byte)) error {
+	p := []byte(prefix)
+	return s.view(ctx, func(b *bbolt.Bucket, now time.Time) error {
+		c := b.Cursor()
+		for k, r := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, r = c.Next() {
+			if !expired(r, now) {
+				fn(k)
+			}
+		}
+		return nil
+	})
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.update(ctx, func(tx *bbolt.Tx, _ time.Time) error {
+		for _, name := range [][]byte{s.bucket, s.expiry} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	p := []byte(prefix)
+	return s.update(ctx, func(tx *bbolt.Tx, now time.Time) error {
+		var ks [][]byte
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			ks = append(ks, append([]byte(nil), k...))
+		}
+		for _, k := range ks {
+			if _, err := s.remove(tx, k, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var (
+	_ store.Exister   = (*Store)(nil)
+	_ store.KeyLister = (*Store)(nil)
+	_ store.Sizer     = (*Store)(nil)
+	_ store.Clearer   = (*Store)(nil)
+)
@@ -0,0 +1,77 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Begin starts a new read-write Bolt transaction. Bolt serializes the
+// read-write transactions: Begin blocks until the previous one is
+// terminated. If ctx is done before Commit, Commit rolls the transaction
+// back and returns the context error.
+// Err is non-nil in case of failure.
+func (s *Store) Begin(ctx context.Context) (store.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTx{s: s, tx: tx, ctx: ctx}, nil
+}
+
+// boltTx is a store.Tx over a Bolt transaction. As the latter, it must only
+// be used by one goroutine at a time.
+type boltTx struct {
+	s   *Store
+	tx  *bbolt.Tx
+	ctx context.Context
+}
+
+func (t *boltTx) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r := get(t.tx.Bucket(t.s.bucket), []byte(k), time.Now())
+	if r == nil {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(recordValue(r))
+}
+
+func (t *boltTx) Set(ctx context.Context, k string, v json.Marshaler) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return t.s.put(t.tx, []byte(k), value, 0)
+}
+
+func (t *boltTx) Delete(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return t.s.remove(t.tx, []byte(k), time.Now())
+}
+
+func (t *boltTx) Commit() error {
+	if err := t.ctx.Err(); err != nil {
+		t.tx.Rollback()
+		return err
+	}
+	return t.tx.Commit()
+}
+
+func (t *boltTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+var _ store.Txn = (*Store)(nil)
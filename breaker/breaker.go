@@ -0,0 +1,347 @@
+/*
+Package breaker provides a circuit breaker Store wrapper, failing fast while
+the wrapped Store is degraded.
+
+The breaker starts closed: the operations are passed to the wrapped Store,
+and their failures are counted over consecutive windows. Once a window has
+seen enough operations and the rate of failures reaches the threshold, the
+breaker opens: the operations fail immediately with ErrOpen, or are passed
+to the fallback Store if any. After a while, the breaker half-opens and lets
+a few probe operations through: it closes if they succeed, and opens again
+if they fail. A probe aborted by its context, without being a failure, does
+not count either way: the breaker stays half-open.
+
+The context errors and the errors wrapping store.ErrNotFound,
+store.ErrConflict or store.ErrNotSupported are not failures of the wrapped
+Store, unless specified otherwise with WithClassifier.
+*/
+package breaker // import "github.com/gokv/store/breaker"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrOpen is returned by the operations rejected while the breaker is open.
+var ErrOpen = errors.New("breaker: circuit open")
+
+// The default settings of the Store.
+const (
+	DefaultFailureRate = 0.5
+	DefaultMinRequests = 20
+	DefaultWindow      = 10 * time.Second
+	DefaultOpenTimeout = 30 * time.Second
+	DefaultProbes      = 1
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+// The states of a circuit breaker.
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (st State) String() string {
+	switch st {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithThreshold sets the rate of failures opening the breaker, and the
+// minimum number of operations of a window before it opens.
+func WithThreshold(rate float64, minRequests int) Option {
+	return func(s *Store) { s.rate, s.minRequests = rate, minRequests }
+}
+
+// WithWindow sets the duration of the windows over which the failures are
+// counted.
+func WithWindow(window time.Duration) Option {
+	return func(s *Store) { s.window = window }
+}
+
+// WithOpenTimeout sets the duration of the open state, before probing.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(s *Store) { s.openTimeout = timeout }
+}
+
+// WithProbes sets the maximum number of concurrent probe operations in the
+// half-open state.
+func WithProbes(n int) Option {
+	return func(s *Store) { s.maxProbes = n }
+}
+
+// WithFallback sets the Store receiving the operations while the breaker is
+// open, instead of failing with ErrOpen.
+func WithFallback(fallback store.Store) Option {
+	return func(s *Store) { s.fallback = fallback }
+}
+
+// WithClassifier sets the function reporting whether an error is a failure
+// of the wrapped Store, in place of Failure.
+func WithClassifier(failure func(error) bool) Option {
+	return func(s *Store) { s.failure = failure }
+}
+
+// Failure is the default classifier. It reports whether err is a failure of
+// the wrapped Store.
+func Failure(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case store.IsNotFound(err), store.IsConflict(err), store.IsNotSupported(err):
+		return false
+	}
+	return true
+}
+
+// Store is a store.Store guarded by a circuit breaker.
+type Store struct {
+	store.Wrapper
+	fallback    store.Store
+	rate        float64
+	minRequests int
+	window      time.Duration
+	openTimeout time.Duration
+	maxProbes   int
+	failure     func(error) bool
+
+	mu       sync.Mutex
+	state    State
+	opened   time.Time // start of the open state
+	started  time.Time // start of the window
+	requests int
+	failures int
+	probes   int
+}
+
+// Wrap returns a Store guarding s with a circuit breaker.
+func Wrap(s store.Store, opts ...Option) *Store {
+	b := &Store{
+		Wrapper:     store.Wrapper{Store: s},
+		rate:        DefaultFailureRate,
+		minRequests: DefaultMinRequests,
+		window:      DefaultWindow,
+		openTimeout: DefaultOpenTimeout,
+		maxProbes:   DefaultProbes,
+		failure:     Failure,
+		started:     time.Now(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// State returns the current state of the breaker.
+func (s *Store) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == Open && time.Since(s.opened) >= s.openTimeout {
+		return HalfOpen
+	}
+	return s.state
+}
+
+// allow reports whether an operation may be passed to the wrapped Store,
+// and whether it is a probe.
+func (s *Store) allow() (ok, probe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	switch s.state {
+	case Closed:
+		if now.Sub(s.started) >= s.window {
+			s.reset(now)
+		}
+		return true, false
+	case Open:
+		if now.Sub(s.opened) < s.openTimeout {
+			return false, false
+		}
+		s.state, s.probes = HalfOpen, 0
+	}
+	if s.probes >= s.maxProbes {
+		return false, false
+	}
+	s.probes++
+	return true, true
+}
+
+// record records the outcome of an operation allowed by allow.
+func (s *Store) record(probe bool, err error) {
+	failed := s.failure(err)
+	aborted := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if probe {
+		if s.probes > 0 {
+			s.probes--
+		}
+		if s.state != HalfOpen {
+			return
+		}
+		if failed {
+			s.state, s.opened = Open, now
+			return
+		}
+		if aborted {
+			return
+		}
+		s.state = Closed
+		s.reset(now)
+		return
+	}
+	if s.state != Closed {
+		return
+	}
+	s.requests++
+	if failed {
+		s.failures++
+	}
+	if s.requests >= s.minRequests && float64(s.failures) >= s.rate*float64(s.requests) {
+		s.state, s.opened = Open, now
+	}
+}
+
+func (s *Store) reset(now time.Time) {
+	s.started, s.requests, s.failures = now, 0, 0
+}
+
+// do calls fn with the wrapped Store if the breaker allows it, with the
+// fallback otherwise.
+func (s *Store) do(fn func(store.Store) error) error {
+	ok, probe := s.allow()
+	if !ok {
+		if s.fallback == nil {
+			return ErrOpen
+		}
+		return fn(s.fallback)
+	}
+	err := fn(s.Store)
+	s.record(probe, err)
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	err = s.do(func(st store.Store) (err error) {
+		ok, err = st.Get(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.do(func(st store.Store) error {
+		return st.GetAll(ctx, c)
+	})
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	err = s.do(func(st store.Store) (err error) {
+		k, err = st.Add(ctx, v)
+		return err
+	})
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.do(func(st store.Store) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.do(func(st store.Store) error {
+		return st.SetWithTimeout(ctx, k, v, timeout)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.do(func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	err = s.do(func(st store.Store) (err error) {
+		ok, err = st.Update(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.do(func(st store.Store) (err error) {
+		ok, err = st.Delete(ctx, k)
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns ErrOpen while the breaker is open, whether or not there is a
+// fallback, and a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	ok, probe := s.allow()
+	if !ok {
+		return ErrOpen
+	}
+	err := s.Store.Ping(ctx)
+	s.record(probe, err)
+	return err
+}
+
+// Close closes the wrapped Store and the fallback, if any.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.Store.Close()
+	if s.fallback != nil {
+		if ferr := s.fallback.Close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,234 @@
+package breaker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/breaker"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store guarding an empty memstore with a circuit
+// breaker.
+func newStore() store.Store {
+	return breaker.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+var errDown = errors.New("down")
+
+// failing returns a Fake whose Get fails with errDown while *down is set.
+func failing(down *atomic.Bool) *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc: func(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+			if down.Load() {
+				return false, errDown
+			}
+			return true, ctx.Err()
+		},
+	}
+}
+
+// trip fails the Get operations of s until the breaker opens.
+func trip(t *testing.T, s *breaker.Store) {
+	t.Helper()
+	var v json.RawMessage
+	for i := 0; i < 2; i++ {
+		if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, errDown) {
+			t.Fatalf("Get: got %v, want %v", err, errDown)
+		}
+	}
+	if st := s.State(); st != breaker.Open {
+		t.Fatalf("after the failures: got %v, want %v", st, breaker.Open)
+	}
+}
+
+func TestTrip(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	f := failing(&down)
+	s := breaker.Wrap(f, breaker.WithThreshold(0.5, 2), breaker.WithOpenTimeout(time.Hour))
+
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, errDown) {
+		t.Fatalf("Get: got %v, want %v", err, errDown)
+	}
+	if st := s.State(); st != breaker.Closed {
+		t.Fatalf("below the minimum of operations: got %v, want %v", st, breaker.Closed)
+	}
+	if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, errDown) {
+		t.Fatalf("Get: got %v, want %v", err, errDown)
+	}
+	if st := s.State(); st != breaker.Open {
+		t.Fatalf("after the failures: got %v, want %v", st, breaker.Open)
+	}
+
+	f.Reset()
+	if _, err := s.Get(context.Background(), "k", &v); err != breaker.ErrOpen {
+		t.Errorf("Get while open: got %v, want %v", err, breaker.ErrOpen)
+	}
+	if err := s.Ping(context.Background()); err != breaker.ErrOpen {
+		t.Errorf("Ping while open: got %v, want %v", err, breaker.ErrOpen)
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("the wrapped Store was called while open: %v", calls)
+	}
+}
+
+func TestNotFailures(t *testing.T) {
+	f := &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, store.ErrNotFound
+		},
+	}
+	s := breaker.Wrap(f, breaker.WithThreshold(0.5, 2))
+
+	var v json.RawMessage
+	for i := 0; i < 5; i++ {
+		s.Get(context.Background(), "k", &v)
+	}
+	if st := s.State(); st != breaker.Closed {
+		t.Errorf("after ErrNotFound: got %v, want %v", st, breaker.Closed)
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	f := failing(&down)
+	s := breaker.Wrap(f, breaker.WithThreshold(0.5, 2), breaker.WithOpenTimeout(10*time.Millisecond))
+
+	trip(t, s)
+	time.Sleep(20 * time.Millisecond)
+	if st := s.State(); st != breaker.HalfOpen {
+		t.Fatalf("after the open timeout: got %v, want %v", st, breaker.HalfOpen)
+	}
+
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, errDown) {
+		t.Fatalf("failed probe: got %v, want %v", err, errDown)
+	}
+	if st := s.State(); st != breaker.Open {
+		t.Fatalf("after a failed probe: got %v, want %v", st, breaker.Open)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	down.Store(false)
+	if _, err := s.Get(context.Background(), "k", &v); err != nil {
+		t.Fatalf("successful probe: %v", err)
+	}
+	if st := s.State(); st != breaker.Closed {
+		t.Errorf("after a successful probe: got %v, want %v", st, breaker.Closed)
+	}
+}
+
+func TestProbeLimit(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	release := make(chan struct{})
+	f := failing(&down)
+	s := breaker.Wrap(f, breaker.WithThreshold(0.5, 2), breaker.WithOpenTimeout(10*time.Millisecond))
+
+	trip(t, s)
+	time.Sleep(20 * time.Millisecond)
+	down.Store(false)
+	f.GetFunc = func(context.Context, string, json.Unmarshaler) (bool, error) {
+		<-release
+		return true, nil
+	}
+
+	done := make(chan error)
+	go func() {
+		var v json.RawMessage
+		_, err := s.Get(context.Background(), "k", &v)
+		done <- err
+	}()
+	for len(f.CallsTo("Get")) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); err != breaker.ErrOpen {
+		t.Errorf("Get during the probe: got %v, want %v", err, breaker.ErrOpen)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if st := s.State(); st != breaker.Closed {
+		t.Errorf("after the probe: got %v, want %v", st, breaker.Closed)
+	}
+}
+
+func TestAbortedProbe(t *testing.T) {
+	for _, cause := range []error{context.Canceled, context.DeadlineExceeded} {
+		t.Run(cause.Error(), func(t *testing.T) {
+			var down atomic.Bool
+			down.Store(true)
+			f := failing(&down)
+			s := breaker.Wrap(f, breaker.WithThreshold(0.5, 2), breaker.WithOpenTimeout(10*time.Millisecond))
+
+			trip(t, s)
+			time.Sleep(20 * time.Millisecond)
+			f.GetFunc = func(context.Context, string, json.Unmarshaler) (bool, error) {
+				return false, fmt.Errorf("get: %w", cause)
+			}
+
+			var v json.RawMessage
+			if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, cause) {
+				t.Fatalf("aborted probe: got %v, want %v", err, cause)
+			}
+			if st := s.State(); st != breaker.HalfOpen {
+				t.Fatalf("after an aborted probe: got %v, want %v", st, breaker.HalfOpen)
+			}
+
+			// The slot of the aborted probe is released.
+			f.GetFunc = nil
+			if _, err := s.Get(context.Background(), "k", &v); err != nil {
+				t.Fatalf("next probe: %v", err)
+			}
+			if st := s.State(); st != breaker.Closed {
+				t.Errorf("after the next probe: got %v, want %v", st, breaker.Closed)
+			}
+		})
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	f := failing(&down)
+	fallback := memstore.New()
+	if err := fallback.Set(context.Background(), "k", json.RawMessage(`"fallback"`)); err != nil {
+		t.Fatal(err)
+	}
+	s := breaker.Wrap(f,
+		breaker.WithThreshold(0.5, 2),
+		breaker.WithOpenTimeout(time.Hour),
+		breaker.WithFallback(fallback),
+	)
+
+	trip(t, s)
+	f.Reset()
+	var v json.RawMessage
+	if ok, err := s.Get(context.Background(), "k", &v); err != nil || !ok || string(v) != `"fallback"` {
+		t.Errorf("Get while open: got %s, %v, %v, want the value of the fallback", v, ok, err)
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("the wrapped Store was called while open: %v", calls)
+	}
+	if err := s.Ping(context.Background()); err != breaker.ErrOpen {
+		t.Errorf("Ping while open: got %v, want %v", err, breaker.ErrOpen)
+	}
+}
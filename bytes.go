@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// ByteStore is the bytes-oriented variant of Store. The values are opaque
+// byte slices: the implementation does not need to know how they are
+// encoded, which allows for binary payloads (e.g. images, compressed blobs or
+// protobufs).
+//
+// The methods follow the semantics of their Store counterparts.
+type ByteStore interface {
+
+	// GetBytes retrieves the value of the given key.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	GetBytes(ctx context.Context, k string) (v []byte, ok bool, err error)
+
+	// GetAllBytes returns every value in the store.
+	// Err is non-nil in case of failure.
+	GetAllBytes(ctx context.Context) ([][]byte, error)
+
+	// AddBytes assigns the given value to a new key, and returns the key.
+	// Err is non-nil in case of failure.
+	AddBytes(ctx context.Context, v []byte) (k string, err error)
+
+	// SetBytes idempotently assigns the given value to the given key.
+	// Err is non-nil in case of failure.
+	SetBytes(ctx context.Context, k string, v []byte) error
+
+	// SetBytesWithTimeout assigns the given value to the given key, possibly
+	// overwriting. The assigned key will clear after timeout. The lifespan
+	// starts when this function is called.
+	// Err is non-nil in case of failure.
+	SetBytesWithTimeout(ctx context.Context, k string, v []byte, timeout time.Duration) error
+
+	// SetBytesWithDeadline assigns the given value to the given key, possibly
+	// overwriting. The assigned key will clear after deadline.
+	// Err is non-nil in case of failure.
+	SetBytesWithDeadline(ctx context.Context, k string, v []byte, deadline time.Time) error
+
+	// UpdateBytes assigns the given value to the given key, if it exists.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	UpdateBytes(ctx context.Context, k string, v []byte) (ok bool, err error)
+
+	// Delete removes a key and its value from the store.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	Delete(ctx context.Context, k string) (ok bool, err error)
+
+	// Ping returns a non-nil error if the ByteStore is not healthy or if the
+	// connection to the persistence is compromised.
+	Ping(ctx context.Context) error
+
+	// Close releases the resources associated with the ByteStore.
+	// Any further operation may cause panic.
+	// Err is non-nil in case of failure.
+	Close() error
+}
+
+// FromByteStore returns a Store that encodes and decodes the values with c
+// and delegates to bs.
+func FromByteStore(bs ByteStore, c Codec) Store {
+	return codecStore{bs: bs, c: c}
+}
+
+type codecStore struct {
+	bs ByteStore
+	c  Codec
+}
+
+func (s codecStore) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	b, ok, err := s.bs.GetBytes(ctx, k)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, s.c.Unmarshal(b, v)
+}
+
+func (s codecStore) GetAll(ctx context.Context, c Collection) error {
+	bs, err := s.bs.GetAllBytes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		if err := s.c.Unmarshal(b, c.New()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s codecStore) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b, err := s.c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return s.bs.AddBytes(ctx, b)
+}
+
+func (s codecStore) Set(ctx context.Context, k string, v json.Marshaler) error {
+	b, err := s.c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.bs.SetBytes(ctx, k, b)
+}
+
+func (s codecStore) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	b, err := s.c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.bs.SetBytesWithTimeout(ctx, k, b, timeout)
+}
+
+func (s codecStore) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	b, err := s.c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.bs.SetBytesWithDeadline(ctx, k, b, deadline)
+}
+
+func (s codecStore) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	b, err := s.c.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	return s.bs.UpdateBytes(ctx, k, b)
+}
+
+func (s codecStore) Delete(ctx context.Context, k string) (bool, error) {
+	return s.bs.Delete(ctx, k)
+}
+
+func (s codecStore) Ping(ctx context.Context) error {
+	return s.bs.Ping(ctx)
+}
+
+func (s codecStore) Close() error {
+	return s.bs.Close()
+}
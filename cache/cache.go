@@ -0,0 +1,179 @@
+/*
+Package cache provides a read-through caching Store wrapper combining two
+stores: the primary, which holds the data, and a cache in front of it.
+
+Get reads from the cache first, and on a miss reads from the primary and
+copies the item to the cache. Set, SetWithTimeout and SetWithDeadline write
+to the primary, then write through to the cache; Update and Delete write to
+the primary, then remove the key from the cache, as the expiration kept by
+Update is not known by the wrapper.
+
+The cached items clear after the ttl given to New, and never after the
+expiration of the primary item: on a miss, it is read from the primary when it
+implements store.TTLStore. The ttl thus bounds the staleness of the items
+written to the primary by other means, or concurrently with a miss. The
+failures of the cache are not reported on read, where the primary is used
+instead; when a write to the cache fails, the key is removed from it.
+*/
+package cache // import "github.com/gokv/store/cache"
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store reading through a cache.
+type Store struct {
+	store.Wrapper
+	cache store.Store
+	ttl   time.Duration
+}
+
+// New returns a Store keeping the items in primary, and caching them in cache
+// for ttl. A non-positive ttl means that the cached items only clear with the
+// primary ones.
+func New(primary, cache store.Store, ttl time.Duration) *Store {
+	return &Store{
+		Wrapper: store.Wrapper{Store: primary},
+		cache:   cache,
+		ttl:     ttl,
+	}
+}
+
+// deadline returns the deadline of a cached item whose primary clears at d,
+// or the zero time if neither expires.
+func (s *Store) deadline(d time.Time) time.Time {
+	if s.ttl <= 0 {
+		return d
+	}
+	if t := time.Now().Add(s.ttl); d.IsZero() || t.Before(d) {
+		return t
+	}
+	return d
+}
+
+// fill writes data to the cache, to clear at deadline unless it is zero. If
+// the write fails, the key is removed from the cache; the error is only
+// returned if the removal fails too.
+func (s *Store) fill(ctx context.Context, k string, data json.RawMessage, deadline time.Time) error {
+	var err error
+	if deadline.IsZero() {
+		err = s.cache.Set(ctx, k, data)
+	} else {
+		err = s.cache.SetWithDeadline(ctx, k, data, deadline)
+	}
+	if err == nil {
+		return nil
+	}
+	if _, derr := s.cache.Delete(ctx, k); derr != nil {
+		return err
+	}
+	return nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v, from the cache if
+// it holds the key, from the primary otherwise.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var data json.RawMessage
+	if ok, err := s.cache.Get(ctx, k, &data); err == nil && ok {
+		return true, v.UnmarshalJSON(data)
+	}
+
+	if ok, err := s.Store.Get(ctx, k, &data); err != nil || !ok {
+		return false, err
+	}
+	var d time.Time
+	if t, ok := s.Store.(store.TTLStore); ok {
+		if ttl, ok, err := t.GetTTL(ctx, k); err == nil && ok && ttl > 0 {
+			d = time.Now().Add(ttl)
+		}
+	}
+	// The item is returned even if it could not be cached.
+	_ = s.fill(ctx, k, data, s.deadline(d))
+	return true, v.UnmarshalJSON(data)
+}
+
+// Set idempotently assigns the given value to the given key, in the primary
+// and in the cache.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting, in the primary and in the cache. The assigned key will clear
+// after timeout. The lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting, in the primary and in the cache. The assigned key will clear
+// after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if deadline.IsZero() {
+		err = s.Store.Set(ctx, k, json.RawMessage(data))
+	} else {
+		err = s.Store.SetWithDeadline(ctx, k, json.RawMessage(data), deadline)
+	}
+	if err != nil {
+		// The primary may have been written anyway.
+		_, _ = s.cache.Delete(ctx, k)
+		return err
+	}
+	return s.fill(ctx, k, data, s.deadline(deadline))
+}
+
+// Update assigns the given value to the given key in the primary, if it
+// exists, and removes it from the cache.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	ok, err := s.Store.Update(ctx, k, v)
+	if _, derr := s.cache.Delete(ctx, k); err == nil {
+		err = derr
+	}
+	return ok, err
+}
+
+// Delete removes a key and its value from the primary and from the cache.
+// Ok is false if the key was not found in the primary.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	ok, err := s.Store.Delete(ctx, k)
+	if _, derr := s.cache.Delete(ctx, k); err == nil {
+		err = derr
+	}
+	return ok, err
+}
+
+// Ping returns a non-nil error if the primary or the cache is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.Store.Ping(ctx); err != nil {
+		return err
+	}
+	return s.cache.Ping(ctx)
+}
+
+// Close closes the primary and the cache.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.Store.Close()
+	if cerr := s.cache.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,22 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/cache"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store caching an empty memstore in another one.
+func newStore() store.Store {
+	return cache.New(memstore.New(), memstore.New(), time.Minute)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,274 @@
+/*
+Package canary provides a Store splitting the traffic between a baseline
+Store and a canary Store, to roll out a new implementation of the same
+backend incrementally.
+
+The keys under the canary prefixes, and a percentage of the other keys, are
+routed to the canary; the split is by the hash of the key, so that all the
+operations of a key go to the same arm for a given percentage. GetAll and
+Add, which have no key, are routed at random with the same percentage. As
+the percentage can be changed while the Store is in use, both arms are
+expected to serve the same data.
+
+The operations are counted per arm, see ArmStats, and may be reported to an
+observer, for example to export them as metrics.
+*/
+package canary // import "github.com/gokv/store/canary"
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Arm is a side of the traffic split.
+type Arm int
+
+// The arms of a Store.
+const (
+	Baseline Arm = iota
+	Canary
+)
+
+func (a Arm) String() string {
+	if a == Canary {
+		return "canary"
+	}
+	return "baseline"
+}
+
+// ArmStats are the operations routed to an arm.
+type ArmStats struct {
+	// Calls counts the operations, and Errors the failed ones.
+	Calls, Errors uint64
+
+	// Latency is the total duration of the operations.
+	Latency time.Duration
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithPercent sets the initial percentage of the keys routed to the canary.
+func WithPercent(percent float64) Option {
+	return func(s *Store) { s.SetPercent(percent) }
+}
+
+// WithPrefixes routes the keys under the given prefixes to the canary,
+// whatever the percentage.
+func WithPrefixes(prefixes ...string) Option {
+	return func(s *Store) { s.prefixes = append(s.prefixes, prefixes...) }
+}
+
+// WithObserver sets a function called after every operation with its arm,
+// method, duration and error.
+func WithObserver(observe func(arm Arm, method string, d time.Duration, err error)) Option {
+	return func(s *Store) { s.observe = observe }
+}
+
+// armStats are the counters of an arm.
+type armStats struct {
+	calls, errors, latency uint64
+}
+
+// Store is a store.Store splitting the traffic between two Stores.
+type Store struct {
+	arms     [2]store.Store
+	prefixes []string
+	observe  func(arm Arm, method string, d time.Duration, err error)
+
+	percent uint64 // the bits of a float64
+	stats   [2]armStats
+
+	mu   sync.Mutex // guards rand
+	rand *rand.Rand
+}
+
+// Wrap returns a Store routing the traffic to baseline, but for the share
+// routed to canary.
+func Wrap(baseline, canary store.Store, opts ...Option) *Store {
+	s := &Store{
+		arms: [2]store.Store{baseline, canary},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetPercent sets the percentage, between 0 and 100, of the keys routed to
+// the canary. It may be called while the Store is in use.
+func (s *Store) SetPercent(percent float64) {
+	atomic.StoreUint64(&s.percent, math.Float64bits(percent))
+}
+
+// Percent returns the percentage of the keys routed to the canary.
+func (s *Store) Percent() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.percent))
+}
+
+// ArmStats returns the operations routed to arm so far.
+func (s *Store) ArmStats(arm Arm) ArmStats {
+	st := &s.stats[arm]
+	return ArmStats{
+		Calls:   atomic.LoadUint64(&st.calls),
+		Errors:  atomic.LoadUint64(&st.errors),
+		Latency: time.Duration(atomic.LoadUint64(&st.latency)),
+	}
+}
+
+// arm returns the arm of k.
+func (s *Store) arm(k string) Arm {
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(k, prefix) {
+			return Canary
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(k))
+	if float64(h.Sum32()%10000) < s.Percent()*100 {
+		return Canary
+	}
+	return Baseline
+}
+
+// pick returns a random arm, for the operations without a key.
+func (s *Store) pick() Arm {
+	s.mu.Lock()
+	f := s.rand.Float64()
+	s.mu.Unlock()
+	if f*100 < s.Percent() {
+		return Canary
+	}
+	return Baseline
+}
+
+// do calls fn with the Store of arm, and records the operation.
+func (s *Store) do(arm Arm, method string, fn func(store.Store) error) error {
+	start := time.Now()
+	err := fn(s.arms[arm])
+	d := time.Since(start)
+
+	st := &s.stats[arm]
+	atomic.AddUint64(&st.calls, 1)
+	atomic.AddUint64(&st.latency, uint64(d))
+	if err != nil {
+		atomic.AddUint64(&st.errors, 1)
+	}
+	if s.observe != nil {
+		s.observe(arm, method, d, err)
+	}
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	err = s.do(s.arm(k), "Get", func(st store.Store) (err error) {
+		ok, err = st.Get(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.do(s.pick(), "GetAll", func(st store.Store) error {
+		return st.GetAll(ctx, c)
+	})
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	err = s.do(s.pick(), "Add", func(st store.Store) (err error) {
+		k, err = st.Add(ctx, v)
+		return err
+	})
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.do(s.arm(k), "Set", func(st store.Store) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.do(s.arm(k), "SetWithTimeout", func(st store.Store) error {
+		return st.SetWithTimeout(ctx, k, v, timeout)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.do(s.arm(k), "SetWithDeadline", func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	err = s.do(s.arm(k), "Update", func(st store.Store) (err error) {
+		ok, err = st.Update(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.do(s.arm(k), "Delete", func(st store.Store) (err error) {
+		ok, err = st.Delete(ctx, k)
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if either arm is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	err := s.do(Baseline, "Ping", func(st store.Store) error {
+		return st.Ping(ctx)
+	})
+	if cerr := s.do(Canary, "Ping", func(st store.Store) error {
+		return st.Ping(ctx)
+	}); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close closes both arms.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.arms[Baseline].Close()
+	if cerr := s.arms[Canary].Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
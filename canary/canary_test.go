@@ -0,0 +1,24 @@
+package canary_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/canary"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store splitting the traffic in halves between two arms
+// which are the same empty memstore, as the arms are expected to serve the
+// same data.
+func newStore() store.Store {
+	m := memstore.New()
+	return canary.Wrap(m, m, canary.WithPercent(50))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
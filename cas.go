@@ -0,0 +1,18 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// CompareAndSetter defines a conditional write, for implementing optimistic
+// concurrency without backend-specific code.
+type CompareAndSetter interface {
+
+	// CompareAndSet assigns v to the given key only if its current value is
+	// equal to old. The comparison is made on the JSON encodings of the
+	// values, so old must marshal to exactly the bytes that are stored.
+	// Ok is false if the key was not found or if its value was not old.
+	// Err is non-nil in case of failure.
+	CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (ok bool, err error)
+}
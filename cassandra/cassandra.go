@@ -0,0 +1,367 @@
+/*
+Package cassandra implements store.Store on top of a Cassandra or ScyllaDB
+table, for the deployments running wide-column stores at scale.
+
+The table has a blob partition key "k" and a text column "v" holding the JSON
+encoding of the value; see CreateTable. The expirations are the native
+per-write TTLs, with a one second resolution, rounded up so that the keys
+never clear early.
+
+Add, Update, Delete and CompareAndSet are lightweight transactions. Update
+and CompareAndSet keep the expiration of the key. Set is a plain write:
+Cassandra does not order it with the concurrent lightweight transactions on
+the same key.
+
+GetAll walks the token ring with range queries, each one resuming after the
+last token read, so that long scans do not depend on the paging state of a
+coordinator; the items are thus ordered by token, which requires the default
+Murmur3Partitioner.
+*/
+package cassandra // import "github.com/gokv/store/cassandra"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+	"github.com/gokv/store"
+)
+
+// pageSize is the number of rows read at once by the table scans.
+const pageSize = 1000
+
+// Store is a store.Store backed by a Cassandra table.
+type Store struct {
+	s     *gocql.Session
+	table string
+}
+
+// New returns a Store keeping the items in the given table, which must exist;
+// see CreateTable. The table name, optionally qualified with the keyspace, is
+// used verbatim in the statements.
+func New(s *gocql.Session, table string) *Store {
+	return &Store{s: s, table: table}
+}
+
+// CreateTable creates a table suitable for New, unless it exists.
+func CreateTable(ctx context.Context, s *gocql.Session, table string) error {
+	return s.Query(`CREATE TABLE IF NOT EXISTS ` + table + ` (k blob PRIMARY KEY, v text)`).ExecContext(ctx)
+}
+
+// cas executes the lightweight transaction q, and reports whether it was
+// applied.
+func cas(ctx context.Context, q *gocql.Query) (bool, error) {
+	return q.MapScanCASContext(ctx, map[string]any{})
+}
+
+// ttlSeconds returns the TTL of deadline, rounded up to the second.
+func ttlSeconds(deadline time.Time) int {
+	return int(math.Ceil(time.Until(deadline).Seconds()))
+}
+
+// get returns the value of k and its TTL in seconds, zero if it does not
+// expire. Ok is false if k was not found.
+func (s *Store) get(ctx context.Context, k string) (value string, ttl int, ok bool, err error) {
+	var t *int
+	err = s.s.Query(`SELECT v, TTL(v) FROM `+s.table+` WHERE k = ?`, []byte(k)).ScanContext(ctx, &value, &t)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+	if t != nil {
+		ttl = *t
+	}
+	return value, ttl, true, nil
+}
+
+// updateIf assigns value to k, keeping its TTL, if k is found and match
+// reports true for its current value. The write is conditioned on the value
+// read, and retried if it changed meanwhile.
+func (s *Store) updateIf(ctx context.Context, k, value string, match func(cur string) bool) (bool, error) {
+	for {
+		cur, ttl, ok, err := s.get(ctx, k)
+		if err != nil || !ok || !match(cur) {
+			return false, err
+		}
+		applied, err := cas(ctx, s.s.Query(`UPDATE `+s.table+` USING TTL ? SET v = ? WHERE k = ? IF v = ?`,
+			ttl, value, []byte(k), cur))
+		if err != nil || applied {
+			return applied, err
+		}
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	value, _, ok, err := s.get(ctx, k)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, v.UnmarshalJSON([]byte(value))
+}
+
+// GetAll unmarshals to c every item in the store, ordered by token.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.scan(ctx, func(_ []byte, value string) error {
+		return c.New().UnmarshalJSON([]byte(value))
+	})
+}
+
+// scan calls fn with every item of the table, walking the token ring one
+// page at a time.
+func (s *Store) scan(ctx context.Context, fn func(k []byte, value string) error) error {
+	// The Murmur3 tokens are greater than the minimum int64.
+	after := int64(math.MinInt64)
+	for {
+		rows, err := s.page(ctx, `SELECT token(k), k, v FROM `+s.table+` WHERE token(k) > ? LIMIT ?`, after, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(rows) == pageSize {
+			// The page is completed with every row of its last token, so that
+			// the next one starts after it.
+			last := rows[len(rows)-1].token
+			for len(rows) > 0 && rows[len(rows)-1].token == last {
+				rows = rows[:len(rows)-1]
+			}
+			same, err := s.page(ctx, `SELECT token(k), k, v FROM `+s.table+` WHERE token(k) = ?`, last)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, same...)
+		}
+		for _, r := range rows {
+			if err := fn(r.k, r.v); err != nil {
+				return err
+			}
+		}
+		if len(rows) < pageSize {
+			return nil
+		}
+		after = rows[len(rows)-1].token
+	}
+}
+
+type row struct {
+	token int64
+	k     []byte
+	v     string
+}
+
+func (s *Store) page(ctx context.Context, stmt string, values ...any) ([]row, error) {
+	iter := s.s.Query(stmt, values...).IterContext(ctx)
+	var rows []row
+	var r row
+	for iter.Scan(&r.token, &r.k, &r.v) {
+		rows = append(rows, r)
+		r = row{}
+	}
+	return rows, iter.Close()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		applied, err := cas(ctx, s.s.Query(`INSERT INTO `+s.table+` (k, v) VALUES (?, ?) IF NOT EXISTS`, []byte(k), string(value)))
+		if err != nil {
+			return "", err
+		}
+		if applied {
+			return k, nil
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.s.Query(`INSERT INTO `+s.table+` (k, v) VALUES (?, ?) USING TTL 0`, []byte(k), string(value)).ExecContext(ctx)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	if deadline.IsZero() {
+		return s.Set(ctx, k, v)
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	ttl := ttlSeconds(deadline)
+	if ttl <= 0 {
+		return s.s.Query(`DELETE FROM `+s.table+` WHERE k = ?`, []byte(k)).ExecContext(ctx)
+	}
+	return s.s.Query(`INSERT INTO `+s.table+` (k, v) VALUES (?, ?) USING TTL ?`, []byte(k), string(value), ttl).ExecContext(ctx)
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, string(value), func(string) bool { return true })
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	return cas(ctx, s.s.Query(`DELETE FROM `+s.table+` WHERE k = ? IF EXISTS`, []byte(k)))
+}
+
+// Ping returns a non-nil error if the cluster can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	var version string
+	return s.s.Query(`SELECT release_version FROM system.local`).ScanContext(ctx, &version)
+}
+
+// Close is a no-op: the session is owned by the caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	var found []byte
+	err := s.s.Query(`SELECT k FROM `+s.table+` WHERE k = ?`, []byte(k)).ScanContext(ctx, &found)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Keys returns every key starting with prefix, ordered by token. The whole
+// table is scanned.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, func(k []byte, _ string) error {
+		if strings.HasPrefix(string(k), prefix) {
+			ks = append(ks, string(k))
+		}
+		return nil
+	})
+	return ks, err
+}
+
+// Clear removes every key and value from the store, truncating the table.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.s.Query(`TRUNCATE ` + s.table).ExecContext(ctx)
+}
+
+// ClearPrefix removes every key starting with prefix, and its value. The
+// whole table is scanned.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	ks, err := s.Keys(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		if err := s.s.Query(`DELETE FROM `+s.table+` WHERE k = ?`, []byte(k)).ExecContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	_, ttl, ok, err := s.get(ctx, k)
+	return time.Duration(ttl) * time.Second, ok, err
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	ttl := ttlSeconds(time.Now().Add(timeout))
+	if ttl <= 0 {
+		return s.Delete(ctx, k)
+	}
+	for {
+		cur, _, ok, err := s.get(ctx, k)
+		if err != nil || !ok {
+			return false, err
+		}
+		applied, err := cas(ctx, s.s.Query(`UPDATE `+s.table+` USING TTL ? SET v = ? WHERE k = ? IF v = ?`,
+			ttl, cur, []byte(k), cur))
+		if err != nil || applied {
+			return applied, err
+		}
+	}
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, string(value), func(cur string) bool {
+		return cur == string(o)
+	})
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,52 @@
+package cassandra_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+	"github.com/gokv/store"
+	"github.com/gokv/store/cassandra"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store in the items table of
+// the gokv_test keyspace, which are created if needed, on the cluster of the
+// comma-separated hosts of the GOKV_CASSANDRA_HOSTS environment variable. The
+// table is truncated every time. The test is skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	hosts := os.Getenv("GOKV_CASSANDRA_HOSTS")
+	if hosts == "" {
+		tb.Skip("GOKV_CASSANDRA_HOSTS is not set")
+	}
+	ctx := context.Background()
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	session, err := cluster.CreateSession()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(session.Close)
+	err = session.Query(`CREATE KEYSPACE IF NOT EXISTS gokv_test
+		WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`).ExecContext(ctx)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := cassandra.CreateTable(ctx, session, "gokv_test.items"); err != nil {
+		tb.Fatal(err)
+	}
+	return func() store.Store {
+		s := cassandra.New(session, "gokv_test.items")
+		if err := s.Clear(ctx); err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,10 @@
+module github.com/gokv/store/cassandra
+
+go 1.26.7
+
+require (
+	github.com/apache/cassandra-gocql-driver/v2 v2.1.2
+	github.com/gokv/store v0.1.0
+)
+
+require gopkg.in/inf.v0 v0.9.1 // indirect
@@ -0,0 +1,319 @@
+/*
+Package chaostest provides a Store wrapper injecting faults into the
+operations, so that the retry and degradation logic of the consumers can be
+exercised against a flaky store.
+
+The faults are configured per method, or for every method, with a Fault:
+
+	s := chaostest.Wrap(memstore.New(),
+		chaostest.WithFault("", chaostest.Fault{ErrorRate: 0.1}),
+		chaostest.WithFault("Set", chaostest.Fault{PartialRate: 0.2}),
+	)
+
+A failure returns an error without calling the wrapped Store. A partial
+failure calls the wrapped Store, then returns an error all the same: the
+write is applied although reported as failed, or the value is unmarshaled
+although Ok is false. A cancellation race cancels the context of the
+operation after a random delay, while the wrapped Store is working on it.
+Close is never faulty.
+
+The faults are drawn from a pseudo-random source, which can be seeded with
+WithSeed for reproducible runs. They can be changed with SetFault while the
+Store is in use, for example to heal it in the middle of a test.
+*/
+package chaostest // import "github.com/gokv/store/chaostest"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrInjected is the default error of the injected faults.
+var ErrInjected = errors.New("chaostest: injected fault")
+
+// Fault configures the faults of a method. The rates are probabilities,
+// between 0 and 1; ErrorRate and PartialRate add up to the probability of an
+// error.
+type Fault struct {
+	// ErrorRate is the rate of the operations failing without calling the
+	// wrapped Store.
+	ErrorRate float64
+
+	// PartialRate is the rate of the operations failing after calling the
+	// wrapped Store.
+	PartialRate float64
+
+	// CancelRate is the rate of the operations whose context is cancelled
+	// after a random delay, up to CancelDelay.
+	CancelRate  float64
+	CancelDelay time.Duration
+
+	// Err is the injected error, ErrInjected if nil.
+	Err error
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithFault sets the faults of the given method (e.g. "Get" or "GetAll").
+// The faults of the empty method apply to the methods without faults of
+// their own.
+func WithFault(method string, f Fault) Option {
+	return func(s *Store) { s.faults[method] = f }
+}
+
+// WithSeed seeds the source of the faults.
+func WithSeed(seed int64) Option {
+	return func(s *Store) { s.rand = rand.New(rand.NewSource(seed)) }
+}
+
+// Store is a store.Store injecting faults into the operations of the
+// wrapped Store.
+type Store struct {
+	store.Wrapper
+
+	mu       sync.Mutex
+	faults   map[string]Fault
+	rand     *rand.Rand
+	injected map[string]int
+}
+
+// Wrap returns a Store injecting faults into the operations of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	c := &Store{
+		Wrapper:  store.Wrapper{Store: s},
+		faults:   make(map[string]Fault),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		injected: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetFault sets the faults of the given method, as WithFault does. A Fault
+// whose rates are zero removes them.
+func (s *Store) SetFault(method string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.ErrorRate == 0 && f.PartialRate == 0 && f.CancelRate == 0 {
+		delete(s.faults, method)
+		return
+	}
+	s.faults[method] = f
+}
+
+// Injected returns the number of faults injected so far into the given
+// method, of any kind.
+func (s *Store) Injected(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.injected[method]
+}
+
+// outcome is the fate of an operation.
+type outcome struct {
+	fail, partial bool
+	err           error
+	cancelAfter   time.Duration // if positive
+}
+
+// draw returns the outcome of an operation of method.
+func (s *Store) draw(method string) outcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.faults[method]
+	if !ok {
+		f = s.faults[""]
+	}
+	o := outcome{err: f.Err}
+	if o.err == nil {
+		o.err = ErrInjected
+	}
+	switch p := s.rand.Float64(); {
+	case p < f.ErrorRate:
+		o.fail = true
+	case p < f.ErrorRate+f.PartialRate:
+		o.partial = true
+	}
+	if s.rand.Float64() < f.CancelRate {
+		o.cancelAfter = time.Duration(s.rand.Int63n(int64(f.CancelDelay)+1)) + 1
+	}
+	if o.fail || o.partial || o.cancelAfter > 0 {
+		s.injected[method]++
+	}
+	return o
+}
+
+// context returns the context of the operation, cancelled after the delay of
+// the outcome if any, and the function releasing it.
+func (o outcome) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.cancelAfter <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t := time.AfterFunc(o.cancelAfter, cancel)
+	return ctx, func() {
+		t.Stop()
+		cancel()
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	o := s.draw("Get")
+	if o.fail {
+		return false, o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	ok, err := s.Store.Get(ctx, k, v)
+	if o.partial {
+		return false, o.err
+	}
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	o := s.draw("GetAll")
+	if o.fail {
+		return o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	err := s.Store.GetAll(ctx, c)
+	if o.partial {
+		return o.err
+	}
+	return err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	o := s.draw("Add")
+	if o.fail {
+		return "", o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	k, err := s.Store.Add(ctx, v)
+	if o.partial {
+		return "", o.err
+	}
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	o := s.draw("Set")
+	if o.fail {
+		return o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	err := s.Store.Set(ctx, k, v)
+	if o.partial {
+		return o.err
+	}
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	o := s.draw("SetWithTimeout")
+	if o.fail {
+		return o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	err := s.Store.SetWithTimeout(ctx, k, v, timeout)
+	if o.partial {
+		return o.err
+	}
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	o := s.draw("SetWithDeadline")
+	if o.fail {
+		return o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	err := s.Store.SetWithDeadline(ctx, k, v, deadline)
+	if o.partial {
+		return o.err
+	}
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	o := s.draw("Update")
+	if o.fail {
+		return false, o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	ok, err := s.Store.Update(ctx, k, v)
+	if o.partial {
+		return false, o.err
+	}
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	o := s.draw("Delete")
+	if o.fail {
+		return false, o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	ok, err := s.Store.Delete(ctx, k)
+	if o.partial {
+		return false, o.err
+	}
+	return ok, err
+}
+
+// Ping returns a non-nil error if the Store is not healthy, or if a fault is
+// injected.
+func (s *Store) Ping(ctx context.Context) error {
+	o := s.draw("Ping")
+	if o.fail {
+		return o.err
+	}
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	err := s.Store.Ping(ctx)
+	if o.partial {
+		return o.err
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,21 @@
+package chaostest_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/chaostest"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store wrapping an empty memstore without faults.
+func newStore() store.Store {
+	return chaostest.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,163 @@
+/*
+Package checksum provides a Store wrapper detecting the corruption of the
+stored values with a CRC-32C checksum.
+
+The values are marshaled and stored as a JSON envelope holding their JSON
+encoding, in base64, and its checksum in hexadecimal:
+
+	{"crc32c":"0efb95c3","data":"eyJuYW1lIjoiZ29waGVyIn0="}
+
+Get and GetAll verify the checksum before unmarshaling the values, and fail
+with ErrCorrupt if it does not match, so that the silent corruption of file or
+object backends is detected rather than propagated. The value is held in
+base64, so that the backends normalizing the JSON they store leave its bytes
+intact, including those of the invalid UTF-8 sequences that a JSON string
+would replace.
+*/
+package checksum // import "github.com/gokv/store/checksum"
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrCorrupt is returned when a stored value does not match its checksum, or
+// is not a checksum envelope.
+var ErrCorrupt = errors.New("checksum: corrupt value")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// envelope is the stored form of the values.
+type envelope struct {
+	CRC  string `json:"crc32c"`
+	Data []byte `json:"data"`
+}
+
+func sum(data []byte) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc32.Checksum(data, castagnoli))
+	return hex.EncodeToString(b[:])
+}
+
+// Store is a store.Store checksumming the values of the wrapped Store.
+type Store struct {
+	store.Wrapper
+}
+
+// Wrap returns a Store checksumming the values of s.
+func Wrap(s store.Store) *Store {
+	return &Store{Wrapper: store.Wrapper{Store: s}}
+}
+
+// seal returns the envelope of v.
+func seal(v json.Marshaler) (json.RawMessage, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{CRC: sum(data), Data: data})
+}
+
+// verified verifies the checksum of the values before unmarshaling them.
+type verified struct {
+	json.Unmarshaler
+}
+
+func (v verified) UnmarshalJSON(data []byte) error {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil || e.CRC == "" {
+		return ErrCorrupt
+	}
+	if sum(e.Data) != e.CRC {
+		return ErrCorrupt
+	}
+	return v.Unmarshaler.UnmarshalJSON(e.Data)
+}
+
+// collection verifies the items of a Collection.
+type collection struct {
+	store.Collection
+}
+
+func (c collection) New() json.Unmarshaler {
+	return verified{c.Collection.New()}
+}
+
+// Get retrieves a new value by key, verifies its checksum and unmarshals it
+// to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	return s.Store.Get(ctx, k, verified{v})
+}
+
+// GetAll verifies the checksum of every item in the store and unmarshals it
+// to c.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.Store.GetAll(ctx, collection{c})
+}
+
+// Add checksums the given value, assigns it to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := seal(v)
+	if err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, data)
+}
+
+// Set idempotently checksums and assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, err := seal(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, data)
+}
+
+// SetWithTimeout checksums and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after timeout. The
+// lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	data, err := seal(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, data, timeout)
+}
+
+// SetWithDeadline checksums and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := seal(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// Update checksums and assigns the given value to the given key, if it
+// exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := seal(v)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, data)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,100 @@
+package checksum_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/checksum"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store checksumming the values of an empty memstore.
+func newStore() store.Store {
+	return checksum.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+func TestCorrupt(t *testing.T) {
+	for _, tc := range [...]struct {
+		name   string
+		stored string
+	}{
+		{"data", `{"crc32c":"0efb95c3","data":"eyJuYW1lIjoiZ29waGVYIn0="}`},
+		{"checksum", `{"crc32c":"0efb95c4","data":"eyJuYW1lIjoiZ29waGVyIn0="}`},
+		{"base64", `{"crc32c":"0efb95c3","data":"{\"name\":\"gopher\"}"}`},
+		{"no checksum", `{"data":"eyJuYW1lIjoiZ29waGVyIn0="}`},
+		{"no envelope", `{"name":"gopher"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := memstore.New()
+			s := checksum.Wrap(m)
+			if err := m.Set(ctx, "k", json.RawMessage(tc.stored)); err != nil {
+				t.Fatal(err)
+			}
+
+			var v json.RawMessage
+			if _, err := s.Get(ctx, "k", &v); !errors.Is(err, checksum.ErrCorrupt) {
+				t.Errorf("Get: got %v, want %v", err, checksum.ErrCorrupt)
+			}
+			var c items
+			if err := s.GetAll(ctx, &c); !errors.Is(err, checksum.ErrCorrupt) {
+				t.Errorf("GetAll: got %v, want %v", err, checksum.ErrCorrupt)
+			}
+		})
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := checksum.Wrap(m)
+	if err := s.Set(ctx, "k", json.RawMessage(`{"name":"gopher"}`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	var stored json.RawMessage
+	if _, err := m.Get(ctx, "k", &stored); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"crc32c":"0efb95c3","data":"eyJuYW1lIjoiZ29waGVyIn0="}`
+	if string(stored) != want {
+		t.Errorf("stored value: got %s, want %s", stored, want)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	ctx := context.Background()
+	s := checksum.Wrap(memstore.New())
+	value := json.RawMessage("\"\xff\xfe\"")
+	if err := s.Set(ctx, "k", value); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "k", &v); err != nil || !ok {
+		t.Fatalf("Get: %v, %v", ok, err)
+	}
+	if !bytes.Equal(v, value) {
+		t.Errorf("Get: got %q, want %q", v, value)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
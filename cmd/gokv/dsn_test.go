@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range [...]struct {
+		dsn string
+		err string // a part of the error, if any
+	}{
+		{"mem:", ""},
+		{"file:" + filepath.Join(dir, "files"), ""},
+		{"bolt:" + filepath.Join(dir, "bolt.db") + "?bucket=items", ""},
+		{"sqlite:" + filepath.Join(dir, "sqlite.db"), ""},
+
+		{"", "no DSN"},
+		{"mem://%zz", "invalid DSN"},
+		{"mongodb://localhost", `unsupported DSN scheme "mongodb", expected one of bolt, etcd, file, mem,`},
+		{"localhost:6379", `unsupported DSN scheme "localhost"`},
+		{"file:", "file: missing path"},
+		{"bolt:", "bolt: missing path"},
+		{"sqlite:", "sqlite: missing path"},
+		{"redis://localhost/db", "redis: "},
+		{"mysql://localhost/db?parseTime=maybe", "mysql: "},
+	} {
+		t.Run(tc.dsn, func(t *testing.T) {
+			s, err := open(context.Background(), tc.dsn)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("open: %v", err)
+				}
+				if err := s.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				s.Close()
+				t.Fatalf("open: got no error, want %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("open: got %q, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, tc := range [...]struct {
+		dsn, path string
+	}{
+		{"file:data/kv", "data/kv"},
+		{"file:/var/lib/kv", "/var/lib/kv"},
+		{"file:///var/lib/kv", "/var/lib/kv"},
+		{"bolt:kv.db?bucket=items", "kv.db"},
+		{"file://data/kv", "data/kv"},
+		{"file:", ""},
+		{"file://", ""},
+	} {
+		u, err := url.Parse(tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := path(u)
+		if tc.path == "" {
+			if err == nil {
+				t.Errorf("path(%q): got %q, want an error", tc.dsn, p)
+			}
+			continue
+		}
+		if err != nil || p != tc.path {
+			t.Errorf("path(%q): got %q, %v, want %q", tc.dsn, p, err, tc.path)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	for _, tc := range [...]struct {
+		dsn, table, query string
+	}{
+		{"postgres://localhost/db", defaultTable, ""},
+		{"postgres://localhost/db?table=items", "items", ""},
+		{"postgres://localhost/db?sslmode=disable&table=items", "items", "sslmode=disable"},
+		{"mysql://localhost/db?table=&parseTime=true", defaultTable, "parseTime=true"},
+	} {
+		u, err := url.Parse(tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := table(u); got != tc.table || u.RawQuery != tc.query {
+			t.Errorf("table(%q): got %q and the query %q, want %q and %q", tc.dsn, got, u.RawQuery, tc.table, tc.query)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package store
+
+import "encoding/json"
+
+// Codec defines how values are encoded to and decoded from bytes. It
+// decouples a ByteStore from encoding/json, so that other encodings (e.g.
+// protobuf, msgpack or gob) can be used.
+type Codec interface {
+
+	// Marshal returns the encoding of v.
+	// Err is non-nil in case of failure.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data and stores the result in the value pointed to
+	// by v.
+	// Err is non-nil in case of failure.
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON is the Codec backed by encoding/json.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	// v does not need to be a pointer if it unmarshals itself.
+	if u, ok := v.(json.Unmarshaler); ok {
+		return u.UnmarshalJSON(data)
+	}
+	return json.Unmarshal(data, v)
+}
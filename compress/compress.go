@@ -0,0 +1,318 @@
+/*
+Package compress provides a Store wrapper compressing the large values, with
+gzip, zstd or snappy.
+
+The values whose JSON encoding is at least as long as the threshold are
+compressed, and stored as a JSON string holding a header and the compressed
+bytes in base64:
+
+	"\u0000zstd:KLUv/QBY..."
+
+The header is a NUL character, the name of the algorithm and a colon, so
+that Get decompresses the values with the algorithm they were written with,
+whatever the current one. The smaller values are stored verbatim, except for
+the strings starting with a NUL character, which are stored with the "none"
+algorithm so that they are not mistaken for compressed values.
+
+The decompressed values are limited in size, so that a corrupt or hostile
+value cannot exhaust the memory: reading a value decompressing to more than
+the maximum fails with ErrTooLarge.
+*/
+package compress // import "github.com/gokv/store/compress"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm.
+type Algorithm string
+
+// The compression algorithms.
+const (
+	None   Algorithm = "none"
+	Gzip   Algorithm = "gzip"
+	Zstd   Algorithm = "zstd"
+	Snappy Algorithm = "snappy"
+)
+
+// DefaultThreshold is the size of the JSON encoding from which the values are
+// compressed, unless specified otherwise with WithThreshold.
+const DefaultThreshold = 1024
+
+// DefaultMaxSize is the maximum size of the decompressed values, unless
+// specified otherwise with WithMaxSize.
+const DefaultMaxSize = 64 << 20
+
+// ErrTooLarge is returned when a value decompresses to more than the maximum
+// size.
+var ErrTooLarge = errors.New("compress: decompressed value too large")
+
+// header starts the compressed values.
+const header = "\x00"
+
+// The zstd encoder is safe for concurrent use with EncodeAll.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithAlgorithm sets the algorithm compressing the values, Zstd otherwise.
+func WithAlgorithm(a Algorithm) Option {
+	return func(s *Store) { s.algorithm = a }
+}
+
+// WithThreshold sets the size of the JSON encoding from which the values are
+// compressed.
+func WithThreshold(n int) Option {
+	return func(s *Store) { s.threshold = n }
+}
+
+// WithMaxSize sets the maximum size of the decompressed values.
+func WithMaxSize(n int) Option {
+	return func(s *Store) { s.maxSize = n }
+}
+
+// Store is a store.Store compressing the values of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	algorithm Algorithm
+	threshold int
+	maxSize   int
+
+	// zstd is safe for concurrent use with DecodeAll.
+	zstd *zstd.Decoder
+}
+
+// Wrap returns a Store compressing the values of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	c := &Store{
+		Wrapper:   store.Wrapper{Store: s},
+		algorithm: Zstd,
+		threshold: DefaultThreshold,
+		maxSize:   DefaultMaxSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.zstd, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(c.maxSize)))
+	return c
+}
+
+// compress returns the stored form of the JSON encoding data.
+func compress(a Algorithm, data []byte) ([]byte, error) {
+	var b []byte
+	switch a {
+	case None:
+		b = data
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		b = buf.Bytes()
+	case Zstd:
+		b = zstdEncoder.EncodeAll(data, nil)
+	case Snappy:
+		b = snappy.Encode(nil, data)
+	default:
+		return nil, fmt.Errorf("compress: unknown algorithm %q", a)
+	}
+	return json.Marshal(header + string(a) + ":" + base64.StdEncoding.EncodeToString(b))
+}
+
+// decompress returns the JSON encoding of the stored form data.
+func (s *Store) decompress(data []byte) ([]byte, error) {
+	f, ok := framed(data)
+	if !ok {
+		return data, nil
+	}
+	name, payload, ok := strings.Cut(strings.TrimPrefix(f, header), ":")
+	if !ok {
+		return nil, fmt.Errorf("compress: malformed header")
+	}
+	b, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	switch Algorithm(name) {
+	case None:
+		return b, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(r, int64(s.maxSize)+1))
+		if err == nil && len(data) > s.maxSize {
+			return nil, ErrTooLarge
+		}
+		return data, err
+	case Zstd:
+		data, err := s.zstd.DecodeAll(b, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return nil, ErrTooLarge
+		}
+		return data, err
+	case Snappy:
+		n, err := snappy.DecodedLen(b)
+		if err != nil {
+			return nil, err
+		}
+		if n > s.maxSize {
+			return nil, ErrTooLarge
+		}
+		return snappy.Decode(nil, b)
+	}
+	return nil, fmt.Errorf("compress: unknown algorithm %q", name)
+}
+
+// framed returns the content of data if it is a JSON string starting with
+// the header.
+func framed(data []byte) (string, bool) {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 || data[0] != '"' {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || !strings.HasPrefix(s, header) {
+		return "", false
+	}
+	return s, true
+}
+
+// encode returns the stored form of v.
+func (s *Store) encode(v json.Marshaler) (json.RawMessage, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) >= s.threshold {
+		return compress(s.algorithm, data)
+	}
+	if _, ok := framed(data); ok {
+		return compress(None, data)
+	}
+	return data, nil
+}
+
+// decoder decompresses the values before unmarshaling them.
+type decoder struct {
+	json.Unmarshaler
+	s *Store
+}
+
+func (d decoder) UnmarshalJSON(data []byte) error {
+	b, err := d.s.decompress(data)
+	if err != nil {
+		return err
+	}
+	return d.Unmarshaler.UnmarshalJSON(b)
+}
+
+// collection decompresses the items of a Collection.
+type collection struct {
+	store.Collection
+	s *Store
+}
+
+func (c collection) New() json.Unmarshaler {
+	return decoder{c.Collection.New(), c.s}
+}
+
+// Get retrieves a new value by key, decompresses it and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	return s.Store.Get(ctx, k, decoder{v, s})
+}
+
+// GetAll decompresses every item in the store and unmarshals it to c.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.Store.GetAll(ctx, collection{c, s})
+}
+
+// Add compresses the given value, if large enough, assigns it to a new key,
+// and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := s.encode(v)
+	if err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, data)
+}
+
+// Set idempotently compresses, if large enough, and assigns the given value
+// to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, err := s.encode(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, data)
+}
+
+// SetWithTimeout compresses, if large enough, and assigns the given value to
+// the given key, possibly overwriting. The assigned key will clear after
+// timeout. The lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	data, err := s.encode(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, data, timeout)
+}
+
+// SetWithDeadline compresses, if large enough, and assigns the given value to
+// the given key, possibly overwriting. The assigned key will clear after
+// deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := s.encode(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// Update compresses, if large enough, and assigns the given value to the
+// given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := s.encode(v)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, data)
+}
+
+// Close closes the wrapped Store and releases the resources of the zstd
+// decoder.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	s.zstd.Close()
+	return s.Store.Close()
+}
+
+var _ store.Store = (*Store)(nil)
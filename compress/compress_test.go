@@ -0,0 +1,115 @@
+package compress_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/compress"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+var algorithms = []compress.Algorithm{compress.None, compress.Gzip, compress.Zstd, compress.Snappy}
+
+// newStore returns a function wrapping an empty memstore in a Store
+// compressing every value with a.
+func newStore(a compress.Algorithm) func() store.Store {
+	return func() store.Store {
+		return compress.Wrap(memstore.New(), compress.WithAlgorithm(a), compress.WithThreshold(0))
+	}
+}
+
+func TestStore(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(string(a), func(t *testing.T) { storetest.TestStore(t, newStore(a)) })
+	}
+}
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(compress.Zstd)) }
+
+func BenchmarkStore(b *testing.B) {
+	for _, a := range algorithms {
+		b.Run(string(a), func(b *testing.B) { storetest.BenchmarkStore(b, newStore(a)) })
+	}
+}
+
+// large returns a compressible JSON string of n bytes.
+func large(n int) json.RawMessage {
+	return json.RawMessage(`"` + strings.Repeat("a", n-2) + `"`)
+}
+
+func TestThreshold(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := compress.Wrap(m, compress.WithThreshold(100))
+
+	for _, tc := range [...]struct {
+		value      json.RawMessage
+		compressed bool
+	}{
+		{json.RawMessage(`"small"`), false},
+		{json.RawMessage(`"\u0000small"`), true},
+		{large(100), true},
+	} {
+		if err := s.Set(ctx, "k", tc.value); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		var stored json.RawMessage
+		if _, err := m.Get(ctx, "k", &stored); err != nil {
+			t.Fatal(err)
+		}
+		if compressed := !bytes.Equal(stored, tc.value); compressed != tc.compressed {
+			t.Errorf("%.20s: stored as %.30s", tc.value, stored)
+		}
+		var v json.RawMessage
+		if ok, err := s.Get(ctx, "k", &v); err != nil || !ok || !bytes.Equal(v, tc.value) {
+			t.Errorf("Get: got %.20s, %v, %v, want %.20s", v, ok, err, tc.value)
+		}
+	}
+}
+
+func TestChangedAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	value := large(2000)
+	for _, a := range algorithms {
+		if err := compress.Wrap(m, compress.WithAlgorithm(a)).Set(ctx, string(a), value); err != nil {
+			t.Fatalf("Set with %s: %v", a, err)
+		}
+	}
+
+	s := compress.Wrap(m, compress.WithAlgorithm(compress.Gzip))
+	for _, a := range algorithms {
+		var v json.RawMessage
+		if ok, err := s.Get(ctx, string(a), &v); err != nil || !ok || !bytes.Equal(v, value) {
+			t.Errorf("Get of a value compressed with %s: %v, %v", a, ok, err)
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	for _, a := range []compress.Algorithm{compress.Gzip, compress.Zstd, compress.Snappy} {
+		t.Run(string(a), func(t *testing.T) {
+			ctx := context.Background()
+			m := memstore.New()
+			if err := compress.Wrap(m, compress.WithAlgorithm(a)).Set(ctx, "k", large(1<<20)); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+
+			var v json.RawMessage
+			s := compress.Wrap(m, compress.WithMaxSize(1<<20-1))
+			if _, err := s.Get(ctx, "k", &v); !errors.Is(err, compress.ErrTooLarge) {
+				t.Errorf("Get over the maximum size: got %v, want %v", err, compress.ErrTooLarge)
+			}
+			s = compress.Wrap(m, compress.WithMaxSize(1<<20))
+			if ok, err := s.Get(ctx, "k", &v); err != nil || !ok || len(v) != 1<<20 {
+				t.Errorf("Get at the maximum size: got %d bytes, %v, %v", len(v), ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/gokv/store/compress
+
+go 1.26.7
+
+require (
+	github.com/gokv/store v0.1.0
+	github.com/klauspost/compress v1.20.1
+)
@@ -0,0 +1,339 @@
+/*
+Package consul implements store.Store on top of the Consul KV API, for
+configuration and coordination data.
+
+The keys are stored under a common prefix. Consul has no native expiration:
+the deadline of a key is kept in the flags of its KV pair, and the expired
+keys are hidden on read. A key with a lifespan between 10 seconds and 24
+hours is additionally acquired by a session with the same TTL and the
+"delete" behavior, so that Consul removes it once the session is
+invalidated, which happens at most one TTL after the deadline.
+
+The conditional writes use check-and-set on the ModifyIndex of the pairs.
+Update and CompareAndSet keep the expiration of the key.
+*/
+package consul // import "github.com/gokv/store/consul"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/hashicorp/consul/api"
+)
+
+// The bounds of the session TTLs accepted by Consul.
+const (
+	minSessionTTL = 10 * time.Second
+	maxSessionTTL = 24 * time.Hour
+)
+
+// Store is a store.Store backed by the Consul KV.
+type Store struct {
+	c      *api.Client
+	prefix string
+}
+
+// New returns a Store keeping the items in the KV of c, under the given
+// prefix.
+func New(c *api.Client, prefix string) *Store {
+	return &Store{c: c, prefix: prefix}
+}
+
+func (s *Store) key(k string) string {
+	return s.prefix + k
+}
+
+func query(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx)
+}
+
+func write(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+// The flags of a pair hold its deadline in nanoseconds since the Unix
+// epoch, or zero if it does not expire.
+func flags(deadline time.Time) uint64 {
+	if deadline.IsZero() {
+		return 0
+	}
+	return uint64(deadline.UnixNano())
+}
+
+func expired(p *api.KVPair, now time.Time) bool {
+	return p.Flags != 0 && int64(p.Flags) <= now.UnixNano()
+}
+
+// get returns the pair of k, or nil if it was not found or expired.
+func (s *Store) get(ctx context.Context, k string) (*api.KVPair, error) {
+	p, _, err := s.c.KV().Get(s.key(k), query(ctx))
+	if err != nil || p == nil || expired(p, time.Now()) {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	p, err := s.get(ctx, k)
+	if err != nil || p == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(p.Value)
+}
+
+// GetAll unmarshals to c every item under the prefix of the Store, ordered by
+// key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	ps, _, err := s.c.KV().List(s.prefix, query(ctx))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range ps {
+		if expired(p, now) {
+			continue
+		}
+		if err := c.New().UnmarshalJSON(p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		// A zero ModifyIndex only creates the pair.
+		ok, _, err := s.c.KV().CAS(&api.KVPair{Key: s.key(k), Value: value}, write(ctx))
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return k, nil
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		_, err := s.c.KV().Delete(s.key(k), write(ctx))
+		return err
+	}
+
+	p := &api.KVPair{Key: s.key(k), Value: value, Flags: flags(deadline)}
+	cur, _, err := s.c.KV().Get(p.Key, query(ctx))
+	if err != nil {
+		return err
+	}
+	if cur != nil && cur.Session != "" {
+		// Detach the session of the previous expiration, which would
+		// otherwise delete the new value.
+		p.Session = cur.Session
+		if _, _, err := s.c.KV().Release(p, write(ctx)); err != nil {
+			return err
+		}
+		s.c.Session().Destroy(cur.Session, write(ctx))
+		p.Session = ""
+	}
+
+	if ttl := time.Until(deadline); !deadline.IsZero() && ttl >= minSessionTTL && ttl <= maxSessionTTL {
+		id, _, err := s.c.Session().Create(&api.SessionEntry{
+			Name:      "gokv " + p.Key,
+			TTL:       ttl.Round(time.Second).String(),
+			Behavior:  api.SessionBehaviorDelete,
+			LockDelay: time.Millisecond,
+		}, write(ctx))
+		if err != nil {
+			return err
+		}
+		p.Session = id
+		ok, _, err := s.c.KV().Acquire(p, write(ctx))
+		if err != nil || ok {
+			return err
+		}
+		// The key can not be acquired: rely on the flags alone.
+		s.c.Session().Destroy(id, write(ctx))
+		p.Session = ""
+	}
+	_, err = s.c.KV().Put(p, write(ctx))
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.cas(ctx, k, func([]byte) ([]byte, bool) { return value, true })
+}
+
+// cas replaces the value of k with the one returned by fn, using
+// check-and-set on the ModifyIndex and retrying the concurrent writes. Ok is
+// false if k was not found or if fn returned false.
+func (s *Store) cas(ctx context.Context, k string, fn func(cur []byte) ([]byte, bool)) (bool, error) {
+	for {
+		p, err := s.get(ctx, k)
+		if err != nil || p == nil {
+			return false, err
+		}
+		value, ok := fn(p.Value)
+		if !ok {
+			return false, nil
+		}
+		p.Value = value
+		ok, _, err = s.c.KV().CAS(p, write(ctx))
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	for {
+		p, _, err := s.c.KV().Get(s.key(k), query(ctx))
+		if err != nil || p == nil {
+			return false, err
+		}
+		ok, _, err := s.c.KV().DeleteCAS(p, write(ctx))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return !expired(p, time.Now()), nil
+		}
+	}
+}
+
+// Ping returns a non-nil error if the Consul cluster has no leader.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.c.Status().LeaderWithQueryOptions(query(ctx))
+	return err
+}
+
+// Close is a no-op: the Consul client holds no resources.
+func (s *Store) Close() error {
+	return nil
+}
+
+// GetMeta retrieves the metadata of the given key. The version is the
+// ModifyIndex of the pair; Consul records no timestamps.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	p, err := s.get(ctx, k)
+	if err != nil || p == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{Version: strconv.FormatUint(p.ModifyIndex, 10)}, true, nil
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.cas(ctx, k, func(cur []byte) ([]byte, bool) {
+		return value, bytes.Equal(cur, o)
+	})
+}
+
+// Keys returns every key starting with prefix, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	// The flags are needed to hide the expired keys.
+	ps, _, err := s.c.KV().List(s.key(prefix), query(ctx))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ks := []string{}
+	for _, p := range ps {
+		if !expired(p, now) {
+			ks = append(ks, strings.TrimPrefix(p.Key, s.prefix))
+		}
+	}
+	return ks, nil
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	_, err := s.c.KV().DeleteTree(s.key(prefix), write(ctx))
+	return err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+)
@@ -0,0 +1,40 @@
+package consul_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/consul"
+	"github.com/gokv/store/storetest"
+	"github.com/hashicorp/consul/api"
+)
+
+// newStore returns a function connecting an empty Store to the Consul agent
+// at the address of the GOKV_CONSUL_ADDR environment variable, e.g.
+// localhost:8500, under the "gokv-test/" prefix, which is cleared every time.
+// The test is skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	addr := os.Getenv("GOKV_CONSUL_ADDR")
+	if addr == "" {
+		tb.Skip("GOKV_CONSUL_ADDR is not set")
+	}
+	c, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return func() store.Store {
+		s := consul.New(c, "gokv-test/")
+		if err := s.Clear(context.Background()); err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,16 @@
+package store
+
+import "context"
+
+// Counter defines an atomic increment, for implementing rate counters and
+// sequence numbers without racing through Get and Set.
+type Counter interface {
+
+	// Incr atomically adds delta to the integer value of the given key and
+	// returns the result. A missing key is considered to hold zero. A
+	// negative delta decrements the value. The value is stored as a JSON
+	// number, so it can be retrieved with Get as well.
+	// Err is non-nil in case of failure, including when the current value is
+	// not an integer.
+	Incr(ctx context.Context, k string, delta int64) (int64, error)
+}
@@ -0,0 +1,292 @@
+/*
+Package dualwrite provides a Store writing to both an old and a new Store, to
+migrate from one backend to the other behind the same interface.
+
+The reads are served by one of the Stores, the old one by default, and the
+writes go to that Store first: if it fails, the operation fails and the other
+Store is left untouched. The writes are then applied to the other Store, whose
+failures are reported as Divergences instead of being returned. Get reads
+both Stores and reports the values which differ; GetAll reads one Store only.
+
+By default the Divergences are logged with the standard logger. Once the new
+Store has been backfilled and shows no divergence, the reads may be switched
+to it with WithReads(ReadNew), before the old Store is dropped.
+*/
+package dualwrite // import "github.com/gokv/store/dualwrite"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ReadFrom is the Store the reads are served from.
+type ReadFrom int
+
+// The Stores to read from.
+const (
+	// ReadOld serves the reads from the old Store.
+	ReadOld ReadFrom = iota
+
+	// ReadNew serves the reads from the new Store.
+	ReadNew
+)
+
+// Divergence is a difference between the two Stores, seen by an operation.
+type Divergence struct {
+	// Method is the operation which saw the divergence.
+	Method string
+
+	// Key is the key of the operation.
+	Key string
+
+	// OldOk and NewOk are the Ok results of the Stores, or whether Get
+	// found the key.
+	OldOk, NewOk bool
+
+	// Old and New are the values read by Get, nil if the key was not found.
+	Old, New json.RawMessage
+
+	// Err is the failure of the Store the reads are not served from, if any.
+	Err error
+}
+
+func (d *Divergence) String() string {
+	if d.Err != nil {
+		return fmt.Sprintf("dualwrite: %s %q: %v", d.Method, d.Key, d.Err)
+	}
+	if d.Method == "Get" && d.OldOk && d.NewOk {
+		return fmt.Sprintf("dualwrite: %s %q: old value %s, new value %s", d.Method, d.Key, d.Old, d.New)
+	}
+	return fmt.Sprintf("dualwrite: %s %q: found in old %t, in new %t", d.Method, d.Key, d.OldOk, d.NewOk)
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithReads sets the Store the reads are served from.
+func WithReads(from ReadFrom) Option {
+	return func(s *Store) { s.reads = from }
+}
+
+// WithReporter sets the function called with every Divergence, in place of
+// the standard logger. The context is the one of the operation.
+func WithReporter(report func(context.Context, *Divergence)) Option {
+	return func(s *Store) { s.report = report }
+}
+
+// Store is a store.Store writing to an old and a new Store.
+type Store struct {
+	old, new store.Store
+	reads    ReadFrom
+	report   func(context.Context, *Divergence)
+}
+
+// Wrap returns a Store writing to both old and new.
+func Wrap(old, new store.Store, opts ...Option) *Store {
+	s := &Store{
+		old: old,
+		new: new,
+		report: func(_ context.Context, d *Divergence) {
+			log.Print(d)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// stores returns the Store the reads are served from, and the other one.
+func (s *Store) stores() (primary, secondary store.Store) {
+	if s.reads == ReadNew {
+		return s.new, s.old
+	}
+	return s.old, s.new
+}
+
+// diverge reports a Divergence, given the Ok results of the primary and the
+// secondary Stores.
+func (s *Store) diverge(ctx context.Context, d *Divergence, ok, secondaryOk bool) {
+	d.OldOk, d.NewOk = ok, secondaryOk
+	if s.reads == ReadNew {
+		d.OldOk, d.NewOk = secondaryOk, ok
+		d.Old, d.New = d.New, d.Old
+	}
+	s.report(ctx, d)
+}
+
+// compact returns data without the insignificant whitespace, for comparison.
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// Get retrieves a new value by key and unmarshals it to v. The value of the
+// other Store is compared to it.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	primary, secondary := s.stores()
+	var data json.RawMessage
+	ok, err := primary.Get(ctx, k, &data)
+	if err != nil {
+		return false, err
+	}
+
+	var other json.RawMessage
+	otherOk, err := secondary.Get(ctx, k, &other)
+	switch {
+	case err != nil:
+		s.diverge(ctx, &Divergence{Method: "Get", Key: k, Err: err}, ok, otherOk)
+	case ok != otherOk || ok && !bytes.Equal(compact(data), compact(other)):
+		s.diverge(ctx, &Divergence{Method: "Get", Key: k, Old: data, New: other}, ok, otherOk)
+	}
+
+	if !ok {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(data)
+}
+
+// GetAll unmarshals to c every item in the Store the reads are served from.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	primary, _ := s.stores()
+	return primary.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key in the Store the reads are served
+// from, sets it in the other one, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	primary, secondary := s.stores()
+	k, err := primary.Add(ctx, json.RawMessage(data))
+	if err != nil {
+		return "", err
+	}
+	if err := secondary.Set(ctx, k, json.RawMessage(data)); err != nil {
+		s.diverge(ctx, &Divergence{Method: "Add", Key: k, Err: err}, true, false)
+	}
+	return k, nil
+}
+
+// set applies a write with fn to both Stores.
+func (s *Store) set(ctx context.Context, method, k string, v json.Marshaler, fn func(store.Store, json.Marshaler) error) error {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	primary, secondary := s.stores()
+	if err := fn(primary, json.RawMessage(data)); err != nil {
+		return err
+	}
+	if err := fn(secondary, json.RawMessage(data)); err != nil {
+		s.diverge(ctx, &Divergence{Method: method, Key: k, Err: err}, true, false)
+	}
+	return nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.set(ctx, "Set", k, v, func(st store.Store, v json.Marshaler) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	return s.set(ctx, "SetWithTimeout", k, v, func(st store.Store, v json.Marshaler) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.set(ctx, "SetWithDeadline", k, v, func(st store.Store, v json.Marshaler) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+//
+// Ok is the one of the Store the reads are served from. The other Store is
+// updated even if the key was not found in the first one.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	primary, secondary := s.stores()
+	ok, err := primary.Update(ctx, k, json.RawMessage(data))
+	if err != nil {
+		return false, err
+	}
+	switch otherOk, err := secondary.Update(ctx, k, json.RawMessage(data)); {
+	case err != nil:
+		s.diverge(ctx, &Divergence{Method: "Update", Key: k, Err: err}, ok, otherOk)
+	case ok != otherOk:
+		s.diverge(ctx, &Divergence{Method: "Update", Key: k}, ok, otherOk)
+	}
+	return ok, nil
+}
+
+// Delete removes a key and its value from both Stores.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+//
+// Ok is the one of the Store the reads are served from.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	primary, secondary := s.stores()
+	ok, err := primary.Delete(ctx, k)
+	if err != nil {
+		return false, err
+	}
+	switch otherOk, err := secondary.Delete(ctx, k); {
+	case err != nil:
+		s.diverge(ctx, &Divergence{Method: "Delete", Key: k, Err: err}, ok, otherOk)
+	case ok != otherOk:
+		s.diverge(ctx, &Divergence{Method: "Delete", Key: k}, ok, otherOk)
+	}
+	return ok, nil
+}
+
+// Ping returns a non-nil error if the Store the reads are served from is not
+// healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	primary, _ := s.stores()
+	return primary.Ping(ctx)
+}
+
+// Close closes both Stores.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.old.Close()
+	if nerr := s.new.Close(); err == nil {
+		err = nerr
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,117 @@
+package dualwrite_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/dualwrite"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store migrating an empty memstore to another.
+func newStore() store.Store {
+	return dualwrite.Wrap(memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+var errDown = errors.New("down")
+
+// failing returns a Fake failing the writes with errDown.
+func failing() *storetest.Fake {
+	return &storetest.Fake{
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			return errDown
+		},
+	}
+}
+
+// divergences returns a reporter appending the Divergences to ds.
+func divergences(ds *[]*dualwrite.Divergence) dualwrite.Option {
+	return dualwrite.WithReporter(func(_ context.Context, d *dualwrite.Divergence) {
+		*ds = append(*ds, d)
+	})
+}
+
+func TestPrimaryFailure(t *testing.T) {
+	old, new := failing(), &storetest.Fake{}
+	var ds []*dualwrite.Divergence
+	s := dualwrite.Wrap(old, new, divergences(&ds))
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != errDown {
+		t.Errorf("Set: got %v, want %v", err, errDown)
+	}
+	if calls := new.Calls(); len(calls) != 0 {
+		t.Errorf("the new store was written after the failure of the old one: %v", calls)
+	}
+	if len(ds) != 0 {
+		t.Errorf("got divergences: %v", ds)
+	}
+}
+
+func TestSecondaryFailure(t *testing.T) {
+	old, new := &storetest.Fake{}, failing()
+	var ds []*dualwrite.Divergence
+	s := dualwrite.Wrap(old, new, divergences(&ds))
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != nil {
+		t.Errorf("Set: %v", err)
+	}
+	if n := len(new.CallsTo("Set")); n != 1 {
+		t.Errorf("got %d writes to the new store, want 1", n)
+	}
+	if len(ds) != 1 || ds[0].Method != "Set" || ds[0].Key != "k" || ds[0].Err != errDown {
+		t.Errorf("got divergences %v, want the failure of the new store", ds)
+	}
+}
+
+func TestGetDivergence(t *testing.T) {
+	for _, tc := range [...]struct {
+		name  string
+		reads dualwrite.ReadFrom
+		want  string
+	}{
+		{"old", dualwrite.ReadOld, `1`},
+		{"new", dualwrite.ReadNew, `2`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			old, new := memstore.New(), memstore.New()
+			var ds []*dualwrite.Divergence
+			s := dualwrite.Wrap(old, new, dualwrite.WithReads(tc.reads), divergences(&ds))
+			old.Set(ctx, "same", json.RawMessage(`{"a": 1}`))
+			new.Set(ctx, "same", json.RawMessage(`{"a":1}`))
+			old.Set(ctx, "k", json.RawMessage(`1`))
+			new.Set(ctx, "k", json.RawMessage(`2`))
+			old.Set(ctx, "missing", json.RawMessage(`3`))
+
+			var v json.RawMessage
+			if ok, err := s.Get(ctx, "same", &v); err != nil || !ok {
+				t.Fatalf("Get: %v, %v", ok, err)
+			}
+			if len(ds) != 0 {
+				t.Fatalf("got divergences for values differing in whitespace only: %v", ds)
+			}
+
+			if ok, err := s.Get(ctx, "k", &v); err != nil || !ok || string(v) != tc.want {
+				t.Errorf("Get: got %s, %v, %v, want %s", v, ok, err, tc.want)
+			}
+			if len(ds) != 1 || string(ds[0].Old) != `1` || string(ds[0].New) != `2` || !ds[0].OldOk || !ds[0].NewOk {
+				t.Fatalf("got divergences %v, want the values of both stores", ds)
+			}
+
+			s.Get(ctx, "missing", &v)
+			if len(ds) != 2 || !ds[1].OldOk || ds[1].NewOk {
+				t.Errorf("got divergences %v, want the key missing from the new store", ds)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+/*
+Package dynamodb implements store.Store on top of a DynamoDB table.
+
+The table has a string partition key named "k". Each item holds the JSON
+encoding of its value in the "v" attribute, and its expiration in the "ttl"
+attribute, in seconds since the Unix epoch: CreateTable enables the native
+DynamoDB TTL on it. As DynamoDB deletes the expired items lazily, they are
+also hidden on read. The expirations have a one second resolution, rounded
+up so that the keys never clear early.
+
+The reads are strongly consistent. Update and CompareAndSet are conditional
+writes, and keep the expiration of the key.
+*/
+package dynamodb // import "github.com/gokv/store/dynamodb"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gokv/store"
+)
+
+// The names of the attributes.
+const (
+	keyAttr = "k"
+	valAttr = "v"
+	ttlAttr = "ttl"
+)
+
+// Store is a store.Store backed by a DynamoDB table.
+type Store struct {
+	c     *dynamodb.Client
+	table string
+}
+
+// New returns a Store keeping the items in the given table, which must
+// exist; see CreateTable.
+func New(c *dynamodb.Client, table string) *Store {
+	return &Store{c: c, table: table}
+}
+
+// CreateTable creates a table suitable for New, billed per request, waits
+// until it is active, and enables the native TTL on its "ttl" attribute.
+func CreateTable(ctx context.Context, c *dynamodb.Client, table string) error {
+	_, err := c.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(keyAttr), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(keyAttr), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+	w := dynamodb.NewTableExistsWaiter(c)
+	if err := w.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, 5*time.Minute); err != nil {
+		return err
+	}
+	_, err = c.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttr),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// DeleteTable deletes the table and waits until it is gone.
+func DeleteTable(ctx context.Context, c *dynamodb.Client, table string) error {
+	if _, err := c.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(table)}); err != nil {
+		return err
+	}
+	w := dynamodb.NewTableNotExistsWaiter(c)
+	return w.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, 5*time.Minute)
+}
+
+// liveCond is the condition on the existing item of the conditional writes:
+// it exists and has not expired at :now.
+const liveCond = `attribute_exists(#k) AND (attribute_not_exists(#ttl) OR #ttl > :now)`
+
+func liveNames() map[string]string {
+	return map[string]string{"#k": keyAttr, "#ttl": ttlAttr}
+}
+
+func number(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func str(s string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: s}
+}
+
+func key(k string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{keyAttr: str(k)}
+}
+
+// expiresAt returns the "ttl" attribute of deadline, rounded up to the second.
+func expiresAt(deadline time.Time) int64 {
+	e := deadline.Unix()
+	if deadline.Nanosecond() > 0 {
+		e++
+	}
+	return e
+}
+
+// itemTTL returns the "ttl" attribute of item, or zero if it has none.
+func itemTTL(item map[string]types.AttributeValue) int64 {
+	n, ok := item[ttlAttr].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	ttl, _ := strconv.ParseInt(n.Value, 10, 64)
+	return ttl
+}
+
+// live reports whether item exists and has not expired at now.
+func live(item map[string]types.AttributeValue, now time.Time) bool {
+	if item == nil {
+		return false
+	}
+	ttl := itemTTL(item)
+	return ttl == 0 || ttl > now.Unix()
+}
+
+// itemValue returns the "v" attribute of item.
+func itemValue(item map[string]types.AttributeValue) ([]byte, error) {
+	v, ok := item[valAttr].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, errors.New("dynamodb: item without a value")
+	}
+	return []byte(v.Value), nil
+}
+
+// conditionFailed reports whether err is the failure of a conditional write.
+func conditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	out, err := s.c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key(k),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || !live(out.Item, time.Now()) {
+		return false, err
+	}
+	value, err := itemValue(out.Item)
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the table, following the pages of the
+// scan. The order of the items is unspecified.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	p := dynamodb.NewScanPaginator(s.c, &dynamodb.ScanInput{
+		TableName:      aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	now := time.Now()
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if !live(item, now) {
+				continue
+			}
+			value, err := itemValue(item)
+			if err != nil {
+				return err
+			}
+			if err := c.New().UnmarshalJSON(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		_, err := s.c.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     map[string]types.AttributeValue{keyAttr: str(k), valAttr: str(string(value))},
+			ConditionExpression:      aws.String(`attribute_not_exists(#k)`),
+			ExpressionAttributeNames: map[string]string{"#k": keyAttr},
+		})
+		if err == nil {
+			return k, nil
+		}
+		if !conditionFailed(err) {
+			return "", err
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		_, err := s.c.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(s.table), Key: key(k)})
+		return err
+	}
+	item := map[string]types.AttributeValue{keyAttr: str(k), valAttr: str(string(value))}
+	if !deadline.IsZero() {
+		item[ttlAttr] = number(expiresAt(deadline))
+	}
+	_, err = s.c.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, `SET #v = :v`, liveCond, map[string]types.AttributeValue{
+		":v": str(string(value)),
+	})
+}
+
+// updateIf runs the update expression on k if the condition holds. The
+// condition may refer to #k, #v, #ttl and :now.
+func (s *Store) updateIf(ctx context.Context, k, update, cond string, values map[string]types.AttributeValue) (bool, error) {
+	names := liveNames()
+	names["#v"] = valAttr
+	values[":now"] = number(time.Now().Unix())
+	_, err := s.c.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key(k),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	out, err := s.c.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(s.table),
+		Key:          key(k),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return false, err
+	}
+	return live(out.Attributes, time.Now()), nil
+}
+
+// Ping returns a non-nil error if the table can not be described.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.c.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)})
+	return err
+}
+
+// Close is a no-op: the DynamoDB client holds no resources.
+func (s *Store) Close() error {
+	return nil
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	out, err := s.c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      key(k),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String("#k, #ttl"),
+		ExpressionAttributeNames: liveNames(),
+	})
+	now := time.Now()
+	if err != nil || !live(out.Item, now) {
+		return 0, false, err
+	}
+	if ttl := itemTTL(out.Item); ttl != 0 {
+		return time.Unix(ttl, 0).Sub(now), true, nil
+	}
+	return 0, true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return s.Delete(ctx, k)
+	}
+	return s.updateIf(ctx, k, `SET #ttl = :ttl`, liveCond, map[string]types.AttributeValue{
+		":ttl": number(expiresAt(time.Now().Add(timeout))),
+	})
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, `SET #v = :v`, liveCond+` AND #v = :old`, map[string]types.AttributeValue{
+		":v":   str(string(value)),
+		":old": str(string(o)),
+	})
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	out, err := s.c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      key(k),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String("#k, #ttl"),
+		ExpressionAttributeNames: liveNames(),
+	})
+	if err != nil {
+		return false, err
+	}
+	return live(out.Item, time.Now()), nil
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+)
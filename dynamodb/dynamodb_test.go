@@ -0,0 +1,57 @@
+package dynamodb_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gokv/store"
+	"github.com/gokv/store/dynamodb"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store in a new table of the
+// DynamoDB endpoint of the GOKV_DYNAMODB_ENDPOINT environment variable, e.g.
+// DynamoDB Local at http://localhost:8000, with dummy credentials. The tables
+// are deleted when the test completes. The test is skipped if the endpoint is
+// not set.
+func newStore(tb testing.TB) func() store.Store {
+	endpoint := os.Getenv("GOKV_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		tb.Skip("GOKV_DYNAMODB_ENDPOINT is not set")
+	}
+	c := awsdynamodb.New(awsdynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "gokv", SecretAccessKey: "gokv"}, nil
+		}),
+	})
+	return func() store.Store {
+		ctx := context.Background()
+		table := "gokv_test_" + randomHex()
+		if err := dynamodb.CreateTable(ctx, c, table); err != nil {
+			panic(err)
+		}
+		tb.Cleanup(func() { dynamodb.DeleteTable(ctx, c, table) })
+		return dynamodb.New(c, table)
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,257 @@
+/*
+Package encrypt provides a Store wrapper encrypting the values at rest with
+AES-GCM.
+
+The values are marshaled, encrypted, and stored as a JSON envelope holding
+the ID of the encryption key, the key of the item, and the nonce and the
+ciphertext in base64:
+
+	{"kid":"2024-01","key":"users/42","data":"..."}
+
+The key of the item is bound to the ciphertext as additional authenticated
+data: Get fails with ErrDecrypt if the envelope read was written for another
+key, so that the ciphertexts can not be swapped between keys. GetAll, whose
+items carry no key, authenticates every value against the key of its
+envelope instead.
+
+The encryption keys are obtained from a KeyProvider, which allows rotating
+them: the values are encrypted with the current key, and decrypted with the
+key of their envelope. Add assigns random keys itself, as the key of the
+item must be known before encryption.
+*/
+package encrypt // import "github.com/gokv/store/encrypt"
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrDecrypt is returned when a value can not be authenticated: it was
+// tampered with, written for another key, or encrypted with another
+// encryption key.
+var ErrDecrypt = errors.New("encrypt: message authentication failed")
+
+// KeyProvider provides the AES keys, of 16, 24 or 32 bytes.
+type KeyProvider interface {
+
+	// CurrentKey returns the key to encrypt the values with, and its ID.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, to decrypt the values with.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// Keyring is a KeyProvider holding the keys in memory, by ID.
+type Keyring struct {
+	// Current is the ID of the key to encrypt the values with.
+	Current string
+
+	// Keys holds the keys by ID.
+	Keys map[string][]byte
+}
+
+// StaticKey returns a Keyring holding a single key, with the empty ID.
+func StaticKey(key []byte) Keyring {
+	return Keyring{Keys: map[string][]byte{"": key}}
+}
+
+// CurrentKey returns the key whose ID is r.Current.
+func (r Keyring) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := r.Key(ctx, r.Current)
+	return r.Current, key, err
+}
+
+// Key returns the key with the given ID.
+func (r Keyring) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encrypt: unknown key %q", id)
+	}
+	return key, nil
+}
+
+// envelope is the stored form of the values.
+type envelope struct {
+	KeyID string `json:"kid"`
+	Key   string `json:"key"`
+	Data  []byte `json:"data"` // nonce followed by the ciphertext
+}
+
+// Store is a store.Store encrypting the values of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	keys KeyProvider
+}
+
+// Wrap returns a Store encrypting the values of s with the keys of keys.
+func Wrap(s store.Store, keys KeyProvider) *Store {
+	return &Store{Wrapper: store.Wrapper{Store: s}, keys: keys}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the envelope of v, stored at k.
+func (s *Store) seal(ctx context.Context, k string, v json.Marshaler) (json.RawMessage, error) {
+	plain, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	id, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{
+		KeyID: id,
+		Key:   k,
+		Data:  gcm.Seal(nonce, nonce, plain, []byte(k)),
+	})
+}
+
+// open decrypts the envelope data, stored at k, and unmarshals it to v.
+func (s *Store) open(ctx context.Context, k string, data []byte, v json.Unmarshaler) error {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	key, err := s.keys.Key(ctx, e.KeyID)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if len(e.Data) < gcm.NonceSize() {
+		return ErrDecrypt
+	}
+	nonce, ciphertext := e.Data[:gcm.NonceSize()], e.Data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, []byte(k))
+	if err != nil {
+		return ErrDecrypt
+	}
+	return v.UnmarshalJSON(plain)
+}
+
+// Get retrieves a new value by key, decrypts it and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var data json.RawMessage
+	if ok, err := s.Store.Get(ctx, k, &data); err != nil || !ok {
+		return false, err
+	}
+	return true, s.open(ctx, k, data, v)
+}
+
+// opener decrypts the items of GetAll.
+type opener struct {
+	ctx context.Context
+	s   *Store
+	c   store.Collection
+}
+
+func (o opener) New() json.Unmarshaler {
+	return openedValue{o}
+}
+
+type openedValue struct {
+	opener
+}
+
+func (v openedValue) UnmarshalJSON(data []byte) error {
+	var e struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	return v.s.open(v.ctx, e.Key, data, v.c.New())
+}
+
+// GetAll decrypts every item in the store and unmarshals it to c.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.Store.GetAll(ctx, opener{ctx: ctx, s: s, c: c})
+}
+
+// Add encrypts the given value, assigns it to a new random key, and returns
+// the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently encrypts and assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, err := s.seal(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, data)
+}
+
+// SetWithTimeout encrypts and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after timeout. The
+// lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	data, err := s.seal(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, data, timeout)
+}
+
+// SetWithDeadline encrypts and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := s.seal(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// Update encrypts and assigns the given value to the given key, if it
+// exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := s.seal(ctx, k, v)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, data)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,185 @@
+package encrypt_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/encrypt"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// key is the AES-256 key of the tests.
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+// newStore returns a Store encrypting the values of an empty memstore.
+func newStore() store.Store {
+	return encrypt.Wrap(memstore.New(), encrypt.StaticKey(key))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// oldKey is the AES-128 key of the tests, before rotation.
+var oldKey = []byte("fedcba9876543210")
+
+// stored returns the envelope stored at k in m.
+func stored(t *testing.T, m store.Store, k string) map[string]any {
+	t.Helper()
+	var raw json.RawMessage
+	if ok, err := m.Get(context.Background(), k, &raw); err != nil || !ok {
+		t.Fatalf("Get(%q): %v, %v", k, ok, err)
+	}
+	var e map[string]any
+	if err := json.Unmarshal(raw, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// put stores the envelope e at k in m.
+func put(t *testing.T, m store.Store, k string, e map[string]any) {
+	t.Helper()
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(context.Background(), k, json.RawMessage(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	m := memstore.New()
+	s := encrypt.Wrap(m, encrypt.Keyring{Current: "2024-01", Keys: map[string][]byte{"2024-01": key}})
+	if err := s.Set(context.Background(), "users/42", json.RawMessage(`"secret"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	e := stored(t, m, "users/42")
+	if e["kid"] != "2024-01" || e["key"] != "users/42" {
+		t.Errorf("stored envelope: got %v, want the ID of the key and the key of the item", e)
+	}
+	if data, _ := e["data"].(string); data == "" || strings.Contains(data, "secret") {
+		t.Errorf("stored envelope: got the data %q, want the ciphertext", data)
+	}
+}
+
+func TestMoved(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := encrypt.Wrap(m, encrypt.StaticKey(key))
+	if err := s.Set(ctx, "alice", json.RawMessage(`"secret"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	e := stored(t, m, "alice")
+	put(t, m, "mallory", e)
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "mallory", &v); err != encrypt.ErrDecrypt {
+		t.Errorf("Get of a ciphertext moved to another key: got %v, want %v", err, encrypt.ErrDecrypt)
+	}
+
+	e["key"] = "mallory"
+	put(t, m, "mallory", e)
+	if _, err := s.Get(ctx, "mallory", &v); err != encrypt.ErrDecrypt {
+		t.Errorf("Get of a ciphertext moved to another key, with its envelope: got %v, want %v", err, encrypt.ErrDecrypt)
+	}
+	var c items
+	if err := s.GetAll(ctx, &c); err != encrypt.ErrDecrypt {
+		t.Errorf("GetAll with a ciphertext moved to another key: got %v, want %v", err, encrypt.ErrDecrypt)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := encrypt.Wrap(m, encrypt.StaticKey(key))
+	if err := s.Set(ctx, "k", json.RawMessage(`"secret"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	e := stored(t, m, "k")
+	data, err := base64.StdEncoding.DecodeString(e["data"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range [...]struct {
+		name string
+		data []byte
+	}{
+		{"ciphertext", append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^1)},
+		{"nonce", append([]byte{data[0] ^ 1}, data[1:]...)},
+		{"truncated", data[:4]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e["data"] = base64.StdEncoding.EncodeToString(tc.data)
+			put(t, m, "k", e)
+
+			var v json.RawMessage
+			if _, err := s.Get(ctx, "k", &v); err != encrypt.ErrDecrypt {
+				t.Errorf("Get: got %v, want %v", err, encrypt.ErrDecrypt)
+			}
+			var c items
+			if err := s.GetAll(ctx, &c); err != encrypt.ErrDecrypt {
+				t.Errorf("GetAll: got %v, want %v", err, encrypt.ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	before := encrypt.Keyring{Current: "1", Keys: map[string][]byte{"1": oldKey}}
+	if err := encrypt.Wrap(m, before).Set(ctx, "old", json.RawMessage(`"old"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	after := encrypt.Keyring{Current: "2", Keys: map[string][]byte{"1": oldKey, "2": key}}
+	s := encrypt.Wrap(m, after)
+	if err := s.Set(ctx, "new", json.RawMessage(`"new"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if kid := stored(t, m, "new")["kid"]; kid != "2" {
+		t.Errorf("the value was encrypted with the key %v, want the current one", kid)
+	}
+	for _, k := range []string{"old", "new"} {
+		var v json.RawMessage
+		if ok, err := s.Get(ctx, k, &v); err != nil || !ok || string(v) != `"`+k+`"` {
+			t.Errorf("Get(%q) after the rotation: got %s, %v, %v", k, v, ok, err)
+		}
+	}
+	var c items
+	if err := s.GetAll(ctx, &c); err != nil || len(c) != 2 {
+		t.Errorf("GetAll after the rotation: got %d items, %v, want 2", len(c), err)
+	}
+
+	// Once the old key is retired, its values can no longer be read.
+	retired := encrypt.Keyring{Current: "2", Keys: map[string][]byte{"2": key}}
+	var v json.RawMessage
+	if _, err := encrypt.Wrap(m, retired).Get(ctx, "old", &v); err == nil {
+		t.Error("Get of a value encrypted with a retired key: got no error")
+	}
+	// A different key under the same ID fails the authentication.
+	replaced := encrypt.Keyring{Current: "2", Keys: map[string][]byte{"1": key, "2": key}}
+	if _, err := encrypt.Wrap(m, replaced).Get(ctx, "old", &v); err != encrypt.ErrDecrypt {
+		t.Errorf("Get with another key of the same ID: got %v, want %v", err, encrypt.ErrDecrypt)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
@@ -0,0 +1,35 @@
+package store
+
+import "errors"
+
+// The errors implementations are expected to wrap (e.g. with fmt.Errorf and
+// the %w verb), so that consumers can classify failures across backends
+// regardless of the underlying driver errors.
+var (
+	// ErrNotFound reports that a key was not found, for the methods that do
+	// not return an Ok boolean.
+	ErrNotFound = errors.New("store: key not found")
+
+	// ErrConflict reports that a write was rejected because of a concurrent
+	// modification (e.g. a transaction conflict).
+	ErrConflict = errors.New("store: conflict")
+
+	// ErrNotSupported reports that the implementation does not support the
+	// method, or the method with the given arguments.
+	ErrNotSupported = errors.New("store: not supported")
+)
+
+// IsNotFound reports whether err wraps ErrNotFound.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsConflict reports whether err wraps ErrConflict.
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsNotSupported reports whether err wraps ErrNotSupported.
+func IsNotSupported(err error) bool {
+	return errors.Is(err, ErrNotSupported)
+}
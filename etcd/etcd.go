@@ -0,0 +1,338 @@
+/*
+Package etcd implements store.Store on top of etcd, for service-discovery and
+coordination data.
+
+The keys are stored under a common prefix. The expirations are etcd leases,
+one per SetWithTimeout or SetWithDeadline call, with a one second
+resolution: the lifespan is rounded up to the second, and it may be extended
+by etcd to its minimum lease TTL. Update and CompareAndSet keep the lease of
+the key.
+
+The revisions of the keys are surfaced by GetMeta, and the changes are
+notified by the etcd watch API.
+*/
+package etcd // import "github.com/gokv/store/etcd"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gokv/store"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Store is a store.Store backed by etcd.
+type Store struct {
+	c      *clientv3.Client
+	prefix string
+}
+
+// New returns a Store keeping the items in c, under the given prefix. An
+// empty prefix exposes the whole keyspace. Closing the Store closes c.
+func New(c *clientv3.Client, prefix string) *Store {
+	return &Store{c: c, prefix: prefix}
+}
+
+func (s *Store) key(k string) string {
+	return s.prefix + k
+}
+
+// exists is the transaction condition of the writes requiring the key to
+// exist.
+func exists(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+}
+
+// leaseSeconds returns the lease TTL of timeout, rounded up to the second.
+func leaseSeconds(timeout time.Duration) int64 {
+	return int64((timeout + time.Second - 1) / time.Second)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	resp, err := s.c.Get(ctx, s.key(k))
+	if err != nil || len(resp.Kvs) == 0 {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(resp.Kvs[0].Value)
+}
+
+// GetAll unmarshals to c every item under the prefix of the Store, ordered by
+// key, with a single range request.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	resp, err := s.c.Get(ctx, s.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		if err := c.New().UnmarshalJSON(kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		resp, err := s.c.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(s.key(k)), "=", 0)).
+			Then(clientv3.OpPut(s.key(k), string(value))).
+			Commit()
+		if err != nil {
+			return "", err
+		}
+		if resp.Succeeded {
+			return k, nil
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Put(ctx, s.key(k), string(value))
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		_, err := s.c.Delete(ctx, s.key(k))
+		return err
+	}
+	lease, err := s.c.Grant(ctx, leaseSeconds(timeout))
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Put(ctx, s.key(k), string(value), clientv3.WithLease(lease.ID))
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.SetWithTimeout(ctx, k, v, time.Until(deadline))
+}
+
+// Update assigns the given value to the given key, if it exists. The lease of
+// the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.c.Txn(ctx).
+		If(exists(s.key(k))).
+		Then(clientv3.OpPut(s.key(k), string(value), clientv3.WithIgnoreLease())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	resp, err := s.c.Delete(ctx, s.key(k))
+	if err != nil {
+		return false, err
+	}
+	return resp.Deleted > 0, nil
+}
+
+// Ping returns a non-nil error if the etcd cluster can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.c.Get(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	return err
+}
+
+// Close closes the etcd client.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.c.Close()
+}
+
+// GetMeta retrieves the metadata of the given key. The version is the etcd
+// revision of the last write of the key; etcd records no timestamps.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	resp, err := s.c.Get(ctx, s.key(k))
+	if err != nil || len(resp.Kvs) == 0 {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{Version: strconv.FormatInt(resp.Kvs[0].ModRevision, 10)}, true, nil
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The lease of the key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.c.Txn(ctx).
+		If(exists(s.key(k)), clientv3.Compare(clientv3.Value(s.key(k)), "=", string(o))).
+		Then(clientv3.OpPut(s.key(k), string(value), clientv3.WithIgnoreLease())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// GetTTL returns the time left before the lease of the given key expires. A
+// zero duration means that the key has no lease.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	resp, err := s.c.Get(ctx, s.key(k))
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, false, err
+	}
+	id := clientv3.LeaseID(resp.Kvs[0].Lease)
+	if id == clientv3.NoLease {
+		return 0, true, nil
+	}
+	lease, err := s.c.TimeToLive(ctx, id)
+	if err != nil {
+		return 0, false, err
+	}
+	if lease.TTL <= 0 {
+		// The lease expired since the key was read.
+		return 0, false, nil
+	}
+	return time.Duration(lease.TTL) * time.Second, true, nil
+}
+
+// Expire attaches the given key to a new lease of timeout, replacing any
+// previous one.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return s.Delete(ctx, k)
+	}
+	lease, err := s.c.Grant(ctx, leaseSeconds(timeout))
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.c.Txn(ctx).
+		If(exists(s.key(k))).
+		Then(clientv3.OpPut(s.key(k), "", clientv3.WithIgnoreValue(), clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		// Do not leave the unused lease behind.
+		s.c.Revoke(ctx, lease.ID)
+	}
+	return resp.Succeeded, nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	resp, err := s.c.Get(ctx, s.key(k), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// Keys returns every key starting with prefix, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := s.c.Get(ctx, s.key(prefix), clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	ks := make([]string, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		ks[i] = string(kv.Key[len(s.prefix):])
+	}
+	return ks, nil
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (int64, error) {
+	resp, err := s.c.Get(ctx, s.key(prefix), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	_, err := s.c.Delete(ctx, s.key(prefix), clientv3.WithPrefix())
+	return err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Sizer            = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.Watcher          = (*Store)(nil)
+)
@@ -0,0 +1,45 @@
+package etcd_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/etcd"
+	"github.com/gokv/store/storetest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// newStore returns a function connecting an empty Store to the etcd cluster
+// of the comma-separated endpoints of the GOKV_ETCD_ENDPOINTS environment
+// variable, under the "gokv-test/" prefix, which is cleared every time. The
+// test is skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	endpoints := os.Getenv("GOKV_ETCD_ENDPOINTS")
+	if endpoints == "" {
+		tb.Skip("GOKV_ETCD_ENDPOINTS is not set")
+	}
+	return func() store.Store {
+		c, err := clientv3.New(clientv3.Config{
+			Endpoints:   strings.Split(endpoints, ","),
+			DialTimeout: 5 * time.Second,
+		})
+		if err != nil {
+			panic(err)
+		}
+		s := etcd.New(c, "gokv-test/")
+		if err := s.Clear(context.Background()); err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,55 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gokv/store"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Watch notifies the changes to the given key on the returned channel. The
+// expiration of a lease is notified as an EventDelete.
+// Err is non-nil in case of failure.
+func (s *Store) Watch(ctx context.Context, k string) (<-chan store.Event, error) {
+	return s.watch(ctx, s.key(k))
+}
+
+// WatchPrefix notifies the changes to every key starting with prefix on the
+// returned channel.
+// Err is non-nil in case of failure.
+func (s *Store) WatchPrefix(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	return s.watch(ctx, s.key(prefix), clientv3.WithPrefix())
+}
+
+func (s *Store) watch(ctx context.Context, key string, opts ...clientv3.OpOption) (<-chan store.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The watch is cancelled along with ctx, which closes wc.
+	wc := s.c.Watch(clientv3.WithRequireLeader(ctx), key, opts...)
+	events := make(chan store.Event)
+	go func() {
+		defer close(events)
+		for resp := range wc {
+			if resp.Err() != nil {
+				return
+			}
+			for _, ev := range resp.Events {
+				e := store.Event{Key: string(ev.Kv.Key[len(s.prefix):])}
+				if ev.Type == clientv3.EventTypeDelete {
+					e.Type = store.EventDelete
+				} else {
+					e.Type = store.EventSet
+					e.Value = json.RawMessage(ev.Kv.Value)
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
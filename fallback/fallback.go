@@ -0,0 +1,225 @@
+/*
+Package fallback provides a Store chaining several Stores, so that the reads
+survive the failure of the primary one by being served from the next, for
+example a stale replica or a local snapshot.
+
+The reads try each Store in order, until one does not fail: a key not found
+in a Store is not looked up in the next ones, as it may have been deleted.
+The writes follow the WritePolicy of the Store: by default, they go to the
+primary Store only.
+*/
+package fallback // import "github.com/gokv/store/fallback"
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// WritePolicy is the behaviour of the writes of a Store.
+type WritePolicy int
+
+// The write policies.
+const (
+	// WritePrimary writes to the primary Store only.
+	WritePrimary WritePolicy = iota
+
+	// WriteFirst writes to each Store in order, until one does not fail.
+	WriteFirst
+
+	// WriteAll writes to every Store, and fails if any of them fails. Add
+	// assigns the key in the primary Store, and sets it in the others.
+	WriteAll
+)
+
+// Store is a store.Store falling back to the next Stores of the chain.
+type Store struct {
+	stores []store.Store
+
+	// Writes is the write policy, WritePrimary by default. It is not to be
+	// changed once the Store is in use.
+	Writes WritePolicy
+}
+
+// New returns a Store chaining stores, the first of which is the primary.
+// It panics if no Store is given.
+func New(stores ...store.Store) *Store {
+	if len(stores) == 0 {
+		panic("fallback: no store")
+	}
+	return &Store{stores: stores}
+}
+
+// try calls fn with each Store in order, until it does not fail, and returns
+// the first error if they all fail. It gives up when the context is done.
+func try(ctx context.Context, stores []store.Store, fn func(store.Store) error) error {
+	var first error
+	for _, s := range stores {
+		err := fn(s)
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return first
+}
+
+// write calls fn with the Stores of the write policy.
+func (s *Store) write(ctx context.Context, fn func(store.Store) error) error {
+	switch s.Writes {
+	case WriteFirst:
+		return try(ctx, s.stores, fn)
+	case WriteAll:
+		var first error
+		for _, st := range s.stores {
+			if err := fn(st); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	return fn(s.stores[0])
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	err = try(ctx, s.stores, func(st store.Store) (err error) {
+		ok, err = st.Get(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+//
+// The items of a failing Store may have been unmarshaled to c before the
+// next Store is tried.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return try(ctx, s.stores, func(st store.Store) error {
+		return st.GetAll(ctx, c)
+	})
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	var k string
+	switch s.Writes {
+	case WriteFirst:
+		err := try(ctx, s.stores, func(st store.Store) (err error) {
+			k, err = st.Add(ctx, v)
+			return err
+		})
+		return k, err
+	case WriteAll:
+		data, err := v.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		if k, err = s.stores[0].Add(ctx, json.RawMessage(data)); err != nil {
+			return "", err
+		}
+		for _, st := range s.stores[1:] {
+			if serr := st.Set(ctx, k, json.RawMessage(data)); serr != nil && err == nil {
+				err = serr
+			}
+		}
+		return k, err
+	}
+	return s.stores[0].Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.write(ctx, func(st store.Store) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	return s.write(ctx, func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.write(ctx, func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+//
+// Ok is the one of the first Store which did not fail.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	var ok, done bool
+	err := s.write(ctx, func(st store.Store) error {
+		found, err := st.Update(ctx, k, v)
+		if !done {
+			ok = found
+		}
+		done = err == nil || done
+		return err
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+//
+// Ok is the one of the first Store which did not fail.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	var ok, done bool
+	err := s.write(ctx, func(st store.Store) error {
+		found, err := st.Delete(ctx, k)
+		if !done {
+			ok = found
+		}
+		done = err == nil || done
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if none of the Stores is healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	return try(ctx, s.stores, func(st store.Store) error {
+		return st.Ping(ctx)
+	})
+}
+
+// Close closes every Store.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	var first error
+	for _, st := range s.stores {
+		if err := st.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ store.Store = (*Store)(nil)
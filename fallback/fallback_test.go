@@ -0,0 +1,131 @@
+package fallback_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/fallback"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store falling back from an empty memstore to another.
+func newStore() store.Store {
+	return fallback.New(memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+var errDown = errors.New("down")
+
+// down returns a Fake failing the reads and the writes with errDown.
+func down() *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, errDown
+		},
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			return errDown
+		},
+	}
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := down(), memstore.New()
+	s := fallback.New(primary, secondary)
+	if err := secondary.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "k", &v); err != nil || !ok || string(v) != `1` {
+		t.Errorf("Get with a failing primary: got %s, %v, %v, want the value of the secondary", v, ok, err)
+	}
+	if n := len(primary.CallsTo("Get")); n != 1 {
+		t.Errorf("got %d calls to the primary, want 1", n)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	secondary := memstore.New()
+	s := fallback.New(memstore.New(), secondary)
+	if err := secondary.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "k", &v); err != nil || ok {
+		t.Errorf("Get of a key not found in the primary: got %s, %v, %v, want not found", v, ok, err)
+	}
+}
+
+func TestAllFailing(t *testing.T) {
+	s := fallback.New(down(), &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, errors.New("also down")
+		},
+	})
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); err != errDown {
+		t.Errorf("Get with every store failing: got %v, want the error of the primary", err)
+	}
+}
+
+func TestWritePolicy(t *testing.T) {
+	for _, tc := range [...]struct {
+		name      string
+		policy    fallback.WritePolicy
+		err       error
+		primary   int
+		secondary int
+	}{
+		{"primary", fallback.WritePrimary, errDown, 1, 0},
+		{"first", fallback.WriteFirst, nil, 1, 1},
+		{"all", fallback.WriteAll, errDown, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, secondary := down(), &storetest.Fake{}
+			s := fallback.New(primary, secondary)
+			s.Writes = tc.policy
+
+			if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != tc.err {
+				t.Errorf("Set: got %v, want %v", err, tc.err)
+			}
+			if n := len(primary.CallsTo("Set")); n != tc.primary {
+				t.Errorf("got %d writes to the primary, want %d", n, tc.primary)
+			}
+			if n := len(secondary.CallsTo("Set")); n != tc.secondary {
+				t.Errorf("got %d writes to the secondary, want %d", n, tc.secondary)
+			}
+		})
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	primary := &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			cancel()
+			return false, context.Canceled
+		},
+	}
+	secondary := &storetest.Fake{}
+	s := fallback.New(primary, secondary)
+
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); err != context.Canceled {
+		t.Errorf("Get: got %v, want %v", err, context.Canceled)
+	}
+	if calls := secondary.Calls(); len(calls) != 0 {
+		t.Errorf("the secondary was called once the context was done: %v", calls)
+	}
+}
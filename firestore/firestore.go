@@ -0,0 +1,486 @@
+/*
+Package firestore implements store.Store on top of a Cloud Firestore
+collection.
+
+Each key is the ID of a document, which holds the JSON encoding of the value
+as a string in the "v" field, and its expiration in the "expireAt" timestamp
+field. The keys must thus be valid document IDs: non-empty valid UTF-8
+without slashes, at most 1500 bytes, neither "." nor "..", and not of the
+form __.*__.
+
+The expired documents are deleted by a Firestore TTL policy on the
+"expireAt" field of the collection group, which must be configured
+separately, for instance with:
+
+	gcloud firestore fields ttls update expireAt --collection-group=<collection> --enable-ttl
+
+As the TTL policy deletes the documents within a day or so, they are also
+hidden on read. Update, Delete and CompareAndSet run in transactions, and
+Update and CompareAndSet keep the expiration of the key.
+*/
+package firestore // import "github.com/gokv/store/firestore"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gokv/store"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The names of the fields.
+const (
+	valField    = "v"
+	expireField = "expireAt"
+)
+
+var errInvalidKey = errors.New("firestore: invalid document ID")
+
+// Store is a store.Store backed by a Firestore collection.
+type Store struct {
+	c    *firestore.Client
+	coll *firestore.CollectionRef
+}
+
+// New returns a Store keeping the items in the given collection.
+func New(c *firestore.Client, collection string) *Store {
+	return &Store{c: c, coll: c.Collection(collection)}
+}
+
+// doc returns the document of k.
+func (s *Store) doc(k string) (*firestore.DocumentRef, error) {
+	if k == "." || k == ".." || len(k) > 1500 || !utf8.ValidString(k) ||
+		strings.HasPrefix(k, "__") && strings.HasSuffix(k, "__") {
+		return nil, errInvalidKey
+	}
+	// Doc returns nil for the empty IDs and those containing a slash.
+	d := s.coll.Doc(k)
+	if d == nil {
+		return nil, errInvalidKey
+	}
+	return d, nil
+}
+
+// item is the content of a document.
+type item struct {
+	value    string
+	deadline time.Time // zero if the key does not expire
+}
+
+// decode returns the item of snap, or nil if it was not found or expired.
+func decode(snap *firestore.DocumentSnapshot, now time.Time) (*item, error) {
+	if snap == nil || !snap.Exists() {
+		return nil, nil
+	}
+	var it item
+	v, err := snap.DataAt(valField)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if it.value, ok = v.(string); !ok {
+		return nil, errors.New("firestore: the value is not a string")
+	}
+	if d, err := snap.DataAt(expireField); err == nil {
+		if it.deadline, ok = d.(time.Time); ok && !now.Before(it.deadline) {
+			return nil, nil
+		}
+	}
+	return &it, nil
+}
+
+// fields returns the fields of a document holding value, expiring at
+// deadline unless it is zero.
+func fields(value []byte, deadline time.Time) map[string]any {
+	f := map[string]any{valField: string(value)}
+	if !deadline.IsZero() {
+		f[expireField] = deadline
+	}
+	return f
+}
+
+func notFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+// get returns the item of k, or nil if it was not found or expired.
+func (s *Store) get(ctx context.Context, d *firestore.DocumentRef) (*item, error) {
+	snap, err := d.Get(ctx)
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(snap, time.Now())
+}
+
+// update runs fn in a transaction, with the item of k or nil if it was not
+// found or expired.
+func (s *Store) update(ctx context.Context, k string, fn func(tx *firestore.Transaction, d *firestore.DocumentRef, it *item) error) error {
+	d, err := s.doc(k)
+	if err != nil {
+		return err
+	}
+	return s.c.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(d)
+		if err != nil && !notFound(err) {
+			return err
+		}
+		it, err := decode(snap, time.Now())
+		if err != nil {
+			return err
+		}
+		return fn(tx, d, it)
+	})
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	d, err := s.doc(k)
+	if err != nil {
+		return false, err
+	}
+	it, err := s.get(ctx, d)
+	if err != nil || it == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON([]byte(it.value))
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit. The
+// documents are read with a query iterator, the expired ones being skipped
+// on the client.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.scan(ctx, "", s.coll.Query, func(_ string, it *item) (bool, error) {
+		if limit == 0 {
+			return false, nil
+		}
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+		limit--
+		return true, c.New().UnmarshalJSON([]byte(it.value))
+	})
+}
+
+// scan calls fn with the ID and the item of every document of q whose ID
+// starts with prefix and which has not expired, in ascending ID order, until
+// fn returns false.
+func (s *Store) scan(ctx context.Context, prefix string, q firestore.Query, fn func(k string, it *item) (bool, error)) error {
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc)
+	if prefix != "" {
+		q = q.StartAt(prefix)
+	}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	now := time.Now()
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k := snap.Ref.ID
+		if !strings.HasPrefix(k, prefix) {
+			return nil
+		}
+		it, err := decode(snap, now)
+		if err != nil {
+			return err
+		}
+		if it == nil {
+			continue
+		}
+		if more, err := fn(k, it); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// the automatic document IDs of Firestore.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		d := s.coll.NewDoc()
+		_, err := d.Create(ctx, fields(value, time.Time{}))
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return d.ID, nil
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	d, err := s.doc(k)
+	if err != nil {
+		return err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		_, err := d.Delete(ctx)
+		return err
+	}
+	_, err = d.Set(ctx, fields(value, deadline))
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, k, func(tx *firestore.Transaction, d *firestore.DocumentRef, it *item) error {
+		if ok = it != nil; !ok {
+			return nil
+		}
+		return tx.Update(d, []firestore.Update{{Path: valField, Value: string(value)}})
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.update(ctx, k, func(tx *firestore.Transaction, d *firestore.DocumentRef, it *item) error {
+		// The expired documents are deleted too, but not reported.
+		ok = it != nil
+		return tx.Delete(d)
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if Firestore can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	iter := s.coll.Select().Limit(1).Documents(ctx)
+	defer iter.Stop()
+	_, err := iter.Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
+
+// Close is a no-op: the client is owned by the caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	d, err := s.doc(k)
+	if err != nil {
+		return false, err
+	}
+	it, err := s.get(ctx, d)
+	return it != nil, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, s.coll.Query, func(k string, _ *item) (bool, error) {
+		ks = append(ks, k)
+		return true, nil
+	})
+	return ks, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value, with a
+// bulk writer.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	q := s.coll.Select().OrderBy(firestore.DocumentID, firestore.Asc)
+	if prefix != "" {
+		q = q.StartAt(prefix)
+	}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	bw := s.c.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return err
+		}
+		if !strings.HasPrefix(snap.Ref.ID, prefix) {
+			break
+		}
+		job, err := bw.Delete(snap.Ref)
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil && !notFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMeta retrieves the metadata of the given key. The version is the update
+// time of the document, in nanoseconds since the Unix epoch.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	d, err := s.doc(k)
+	if err != nil {
+		return store.Meta{}, false, err
+	}
+	snap, err := d.Get(ctx)
+	if notFound(err) {
+		return store.Meta{}, false, nil
+	}
+	if err != nil {
+		return store.Meta{}, false, err
+	}
+	if it, err := decode(snap, time.Now()); err != nil || it == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{
+		Version:   strconv.FormatInt(snap.UpdateTime.UnixNano(), 10),
+		CreatedAt: snap.CreateTime,
+		UpdatedAt: snap.UpdateTime,
+	}, true, nil
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	d, err := s.doc(k)
+	if err != nil {
+		return 0, false, err
+	}
+	it, err := s.get(ctx, d)
+	if err != nil || it == nil {
+		return 0, false, err
+	}
+	if it.deadline.IsZero() {
+		return 0, true, nil
+	}
+	return time.Until(it.deadline), true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	deadline := time.Now().Add(timeout)
+	err = s.update(ctx, k, func(tx *firestore.Transaction, d *firestore.DocumentRef, it *item) error {
+		if ok = it != nil; !ok {
+			return nil
+		}
+		if timeout <= 0 {
+			return tx.Delete(d)
+		}
+		return tx.Update(d, []firestore.Update{{Path: expireField, Value: deadline}})
+	})
+	return ok, err
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (ok bool, err error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, k, func(tx *firestore.Transaction, d *firestore.DocumentRef, it *item) error {
+		if ok = it != nil && it.value == string(o); !ok {
+			return nil
+		}
+		return tx.Update(d, []firestore.Update{{Path: valField, Value: string(value)}})
+	})
+	return ok, err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,51 @@
+package firestore_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	gcfirestore "cloud.google.com/go/firestore"
+	"github.com/gokv/store"
+	"github.com/gokv/store/firestore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store in a new collection of
+// the project of the GOKV_FIRESTORE_PROJECT environment variable, with the
+// default credentials, or the emulator of FIRESTORE_EMULATOR_HOST. The
+// collections are cleared when the test completes. The test is skipped if the
+// project is not set.
+func newStore(tb testing.TB) func() store.Store {
+	project := os.Getenv("GOKV_FIRESTORE_PROJECT")
+	if project == "" {
+		tb.Skip("GOKV_FIRESTORE_PROJECT is not set")
+	}
+	ctx := context.Background()
+	c, err := gcfirestore.NewClient(ctx, project)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return func() store.Store {
+		s := firestore.New(c, "gokv_test_"+randomHex())
+		tb.Cleanup(func() { s.Clear(ctx) })
+		return s
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
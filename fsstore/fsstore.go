@@ -0,0 +1,583 @@
+/*
+Package fsstore implements store.Store on top of a directory, writing each
+value as a JSON file, for development environments and air-gapped
+deployments.
+
+The file names are the percent-encoded keys, with the ".json" extension; the
+length of the keys is thus limited by the maximum file name length of the
+filesystem, usually 255 bytes. The writes go to a temporary file which is
+then renamed over the value file, so that a file always holds a complete
+value; with Sync, the files and the directory are also synced before the
+writes return.
+
+The expirations are kept in a sidecar index, the ".ttl.json" file of the
+directory, mapping the expiring keys to their deadline. The expired keys are
+hidden, and removed lazily. Update keeps the expiration of the key.
+
+A directory must be used by a single Store at a time.
+*/
+package fsstore // import "github.com/gokv/store/fsstore"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// sweepInterval is the minimum interval between two removals of every
+// expired key.
+const sweepInterval = time.Minute
+
+// indexName is the name of the sidecar expiration index.
+const indexName = ".ttl.json"
+
+var errClosed = errors.New("fsstore: closed")
+
+// Store is a store.Store backed by a directory. The zero value is not
+// usable; use New.
+type Store struct {
+
+	// Sync makes the writes durable: the files and the directory are
+	// synced before the writes return. It must be set before the Store is
+	// used.
+	Sync bool
+
+	dir string
+
+	mu     sync.RWMutex
+	ttl    map[string]time.Time
+	swept  time.Time
+	closed bool
+}
+
+// New returns a Store keeping the values in dir, which is created if
+// needed, and loads its expiration index.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:   dir,
+		ttl:   make(map[string]time.Time),
+		swept: time.Now(),
+	}
+	index, err := os.ReadFile(filepath.Join(dir, indexName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(index, &s.ttl); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) path(k string) string {
+	return filepath.Join(s.dir, fileName(k))
+}
+
+// read runs fn holding the read lock, after checking ctx and the state of the
+// store.
+func (s *Store) read(ctx context.Context, fn func(now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errClosed
+	}
+	return fn(time.Now())
+}
+
+// write runs fn holding the write lock, after checking ctx and the state of
+// the store.
+func (s *Store) write(ctx context.Context, fn func(now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		if err := s.sweep(now); err != nil {
+			return err
+		}
+	}
+	return fn(now)
+}
+
+// sweep removes every expired key. It must be called holding the write lock.
+func (s *Store) sweep(now time.Time) error {
+	var swept bool
+	for k, deadline := range s.ttl {
+		if now.Before(deadline) {
+			continue
+		}
+		if err := os.Remove(s.path(k)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		delete(s.ttl, k)
+		swept = true
+	}
+	s.swept = now
+	if swept {
+		return s.saveIndex()
+	}
+	return nil
+}
+
+func (s *Store) expired(k string, now time.Time) bool {
+	deadline, ok := s.ttl[k]
+	return ok && !now.Before(deadline)
+}
+
+// load returns the value of k, or nil if it was not found or expired. It
+// must be called holding the lock.
+func (s *Store) load(k string, now time.Time) ([]byte, error) {
+	if s.expired(k, now) {
+		return nil, nil
+	}
+	value, err := os.ReadFile(s.path(k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// writeFile atomically replaces the file at path with data.
+func (s *Store) writeFile(path string, data []byte) error {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if s.Sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+// remove deletes the file at path.
+func (s *Store) remove(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+func (s *Store) syncDir() error {
+	if !s.Sync {
+		return nil
+	}
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// saveIndex writes the expiration index. It must be called holding the write
+// lock.
+func (s *Store) saveIndex() error {
+	if len(s.ttl) == 0 {
+		err := s.remove(filepath.Join(s.dir, indexName))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	index, err := json.Marshal(s.ttl)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(filepath.Join(s.dir, indexName), index)
+}
+
+// setTTL records the deadline of k, the zero time meaning no expiration, and
+// saves the index if it changed. It must be called holding the write lock.
+func (s *Store) setTTL(k string, deadline time.Time) error {
+	old, ok := s.ttl[k]
+	switch {
+	case deadline.IsZero() && !ok:
+		return nil
+	case deadline.IsZero():
+		delete(s.ttl, k)
+	case ok && old.Equal(deadline):
+		return nil
+	default:
+		s.ttl[k] = deadline
+	}
+	return s.saveIndex()
+}
+
+// put writes the value of k with the given deadline. It must be called
+// holding the write lock.
+func (s *Store) put(k string, value []byte, deadline time.Time) error {
+	// The value is written first, so that a failure never leaves a stale
+	// deadline on the previous value.
+	if err := s.writeFile(s.path(k), value); err != nil {
+		return err
+	}
+	return s.setTTL(k, deadline)
+}
+
+// sortedKeys returns the keys starting with prefix which have not expired,
+// in ascending order. It must be called holding the lock.
+func (s *Store) sortedKeys(prefix string, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	ks := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		k, err := keyOf(e.Name())
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(k, prefix) && !s.expired(k, now) {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks, nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.read(ctx, func(now time.Time) (err error) {
+		value, err = s.load(k, now)
+		return err
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	var values [][]byte
+	err := s.read(ctx, func(now time.Time) error {
+		ks, err := s.sortedKeys("", now)
+		if err != nil {
+			return err
+		}
+		if offset > len(ks) {
+			offset = len(ks)
+		}
+		if offset > 0 {
+			ks = ks[offset:]
+		}
+		if limit >= 0 && limit < len(ks) {
+			ks = ks[:limit]
+		}
+		for _, k := range ks {
+			value, err := s.load(k, now)
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				values = append(values, value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		for {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			k = hex.EncodeToString(b)
+			cur, err := s.load(k, now)
+			if err != nil {
+				return err
+			}
+			if cur == nil {
+				return s.put(k, value, time.Time{})
+			}
+		}
+	})
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, func(now time.Time) error {
+		if !deadline.IsZero() && !now.Before(deadline) {
+			_, err := s.delete(k, now)
+			return err
+		}
+		return s.put(k, value, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		cur, err := s.load(k, now)
+		if ok = cur != nil; err != nil || !ok {
+			return err
+		}
+		return s.writeFile(s.path(k), value)
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		ok, err = s.delete(k, now)
+		return err
+	})
+	return ok, err
+}
+
+// delete removes the file and the deadline of k. Ok is false if k was not
+// found or expired. It must be called holding the write lock.
+func (s *Store) delete(k string, now time.Time) (bool, error) {
+	ok := !s.expired(k, now)
+	err := s.remove(s.path(k))
+	if errors.Is(err, fs.ErrNotExist) {
+		ok, err = false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ok, s.setTTL(k, time.Time{})
+}
+
+// Ping returns a non-nil error if the directory can not be read.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.read(ctx, func(time.Time) error {
+		_, err := os.Stat(s.dir)
+		return err
+	})
+}
+
+// Close releases the Store. The files are left in the directory.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		if s.expired(k, now) {
+			return nil
+		}
+		_, err := os.Stat(s.path(k))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		ok = err == nil
+		return err
+	})
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) (ks []string, err error) {
+	err = s.read(ctx, func(now time.Time) (err error) {
+		ks, err = s.sortedKeys(prefix, now)
+		return err
+	})
+	return ks, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	return s.write(ctx, func(now time.Time) error {
+		// The expired keys are removed too.
+		ks, err := s.sortedKeys(prefix, time.Time{})
+		if err != nil {
+			return err
+		}
+		for _, k := range ks {
+			if err := os.Remove(s.path(k)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			delete(s.ttl, k)
+		}
+		if err := s.syncDir(); err != nil {
+			return err
+		}
+		return s.saveIndex()
+	})
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (ttl time.Duration, ok bool, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		value, err := s.load(k, now)
+		if ok = value != nil; err != nil || !ok {
+			return err
+		}
+		if deadline, found := s.ttl[k]; found {
+			ttl = deadline.Sub(now)
+		}
+		return nil
+	})
+	return ttl, ok, err
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		value, err := s.load(k, now)
+		if ok = value != nil; err != nil || !ok {
+			return err
+		}
+		if timeout <= 0 {
+			_, err := s.delete(k, now)
+			return err
+		}
+		return s.setTTL(k, now.Add(timeout))
+	})
+	return ok, err
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (ok bool, err error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		cur, err := s.load(k, now)
+		if ok = cur != nil && bytes.Equal(cur, o); err != nil || !ok {
+			return err
+		}
+		return s.writeFile(s.path(k), value)
+	})
+	return ok, err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,27 @@
+package fsstore_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/fsstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function opening an empty Store in a new temporary
+// directory.
+func newStore(tb testing.TB) func() store.Store {
+	return func() store.Store {
+		s, err := fsstore.New(tb.TempDir())
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
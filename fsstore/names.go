@@ -0,0 +1,74 @@
+package fsstore
+
+import (
+	"errors"
+	"strings"
+)
+
+// ext is the extension of the value files.
+const ext = ".json"
+
+// fileName returns the name of the file holding the value of k. The bytes
+// other than the lowercase ASCII letters, the digits, '-' and '_' are
+// percent-encoded with uppercase hexadecimal digits, so that the names are
+// portable and distinct on case-insensitive filesystems.
+func fileName(k string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(k) + len(ext))
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xF])
+	}
+	b.WriteString(ext)
+	return b.String()
+}
+
+var errName = errors.New("fsstore: not a value file")
+
+// keyOf returns the key held in the file with the given name.
+func keyOf(name string) (string, error) {
+	if !strings.HasSuffix(name, ext) {
+		return "", errName
+	}
+	name = strings.TrimSuffix(name, ext)
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", errName
+		}
+		hi, lo := unhex(name[i+1]), unhex(name[i+2])
+		if hi < 0 || lo < 0 {
+			return "", errName
+		}
+		b.WriteByte(byte(hi<<4 | lo))
+		i += 2
+	}
+	// Only the names produced by fileName are value files.
+	k := b.String()
+	if fileName(k) != name+ext {
+		return "", errName
+	}
+	return k, nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
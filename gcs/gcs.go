@@ -0,0 +1,385 @@
+/*
+Package gcs implements store.Store on top of a Google Cloud Storage bucket,
+one object per key, for large and cold values.
+
+The objects are stored under a common prefix, with the JSON encoding of the
+value as their content. The deadline of an expiring object is kept in its
+metadata, so that the expired objects are hidden on read, and in its
+Custom-Time, so that the lifecycle rule installed by PutLifecycle deletes it
+in the following days.
+
+Add, Update and CompareAndSet are conditional on the generation of the
+objects, and retried on concurrent modifications; Update and CompareAndSet
+keep the expiration of the key.
+*/
+package gcs // import "github.com/gokv/store/gcs"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gokv/store"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// deadlineMeta is the metadata holding the deadline of an object, in the
+// RFC 3339 format.
+const deadlineMeta = "gokv-deadline"
+
+// Store is a store.Store backed by a GCS bucket.
+type Store struct {
+	c      *storage.Client
+	b      *storage.BucketHandle
+	prefix string
+}
+
+// New returns a Store keeping the items in the given bucket, under the given
+// prefix. Closing the Store closes c.
+func New(c *storage.Client, bucket, prefix string) *Store {
+	return &Store{c: c, b: c.Bucket(bucket), prefix: prefix}
+}
+
+// PutLifecycle replaces the lifecycle configuration of the bucket with a rule
+// deleting the objects under prefix one day after their Custom-Time, which
+// only the expiring objects have.
+func PutLifecycle(ctx context.Context, c *storage.Client, bucket, prefix string) error {
+	_, err := c.Bucket(bucket).Update(ctx, storage.BucketAttrsToUpdate{
+		Lifecycle: &storage.Lifecycle{Rules: []storage.LifecycleRule{{
+			Action: storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{
+				DaysSinceCustomTime: 1,
+				MatchesPrefix:       []string{prefix},
+			},
+		}}},
+	})
+	return err
+}
+
+func (s *Store) object(k string) *storage.ObjectHandle {
+	return s.b.Object(s.prefix + k)
+}
+
+// conflict reports whether err is the failure of a precondition.
+func conflict(err error) bool {
+	var e *googleapi.Error
+	return errors.As(err, &e) && e.Code == http.StatusPreconditionFailed
+}
+
+// objectDeadline returns the deadline in the metadata of an object, or the
+// zero time.
+func objectDeadline(attrs *storage.ObjectAttrs) time.Time {
+	d, _ := time.Parse(time.RFC3339Nano, attrs.Metadata[deadlineMeta])
+	return d
+}
+
+func expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !deadline.After(now)
+}
+
+// attrs returns the attributes of the object of k, or nil if it was not
+// found or expired.
+func (s *Store) attrs(ctx context.Context, k string) (*storage.ObjectAttrs, error) {
+	attrs, err := s.object(k).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil || expired(objectDeadline(attrs), time.Now()) {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// get reads the content and the attributes of the object of k, or returns
+// nil if it was not found or expired.
+func (s *Store) get(ctx context.Context, k string) ([]byte, *storage.ObjectAttrs, error) {
+	for {
+		attrs, err := s.attrs(ctx, k)
+		if err != nil || attrs == nil {
+			return nil, nil, err
+		}
+		value, err := s.read(ctx, k, attrs.Generation)
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			return value, attrs, err
+		}
+		// Overwritten or deleted since the attributes were read.
+	}
+}
+
+// read reads the content of the given generation of the object of k.
+func (s *Store) read(ctx context.Context, k string, generation int64) ([]byte, error) {
+	r, err := s.object(k).Generation(generation).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// put writes the object of k under the given conditions.
+func (s *Store) put(ctx context.Context, k string, value []byte, deadline time.Time, cond storage.Conditions) error {
+	o := s.object(k)
+	if cond != (storage.Conditions{}) {
+		o = o.If(cond)
+	}
+	w := o.NewWriter(ctx)
+	w.ContentType = "application/json"
+	if !deadline.IsZero() {
+		w.Metadata = map[string]string{deadlineMeta: deadline.UTC().Format(time.RFC3339Nano)}
+		w.CustomTime = deadline
+	}
+	if _, err := w.Write(value); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	value, attrs, err := s.get(ctx, k)
+	if err != nil || attrs == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item under the prefix of the Store, ordered by
+// key. Each object is read as it is listed, so that the items are not all
+// held in memory at once.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	it := s.b.Objects(ctx, &storage.Query{Prefix: s.prefix})
+	now := time.Now()
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if expired(objectDeadline(attrs), now) {
+			continue
+		}
+		k := strings.TrimPrefix(attrs.Name, s.prefix)
+		value, err := s.read(ctx, k, attrs.Generation)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			// Overwritten or deleted since it was listed.
+			var latest *storage.ObjectAttrs
+			if value, latest, err = s.get(ctx, k); err == nil && latest == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		err := s.put(ctx, k, value, time.Time{}, storage.Conditions{DoesNotExist: true})
+		if err == nil {
+			return k, nil
+		}
+		if !conflict(err) {
+			return "", err
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if expired(deadline, time.Now()) {
+		if err := s.object(k).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+		return nil
+	}
+	return s.put(ctx, k, value, deadline, storage.Conditions{})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		attrs, err := s.attrs(ctx, k)
+		if err != nil || attrs == nil {
+			return false, err
+		}
+		err = s.put(ctx, k, value, objectDeadline(attrs), storage.Conditions{GenerationMatch: attrs.Generation})
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	for {
+		attrs, err := s.object(k).Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		err = s.object(k).If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+		if err == nil {
+			return !expired(objectDeadline(attrs), time.Now()), nil
+		}
+		if !errors.Is(err, storage.ErrObjectNotExist) && !conflict(err) {
+			return false, err
+		}
+	}
+}
+
+// Ping returns a non-nil error if the bucket can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.b.Attrs(ctx)
+	return err
+}
+
+// Close closes the GCS client.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.c.Close()
+}
+
+// GetMeta retrieves the metadata of the given key: the generation of the
+// object as its version, its creation time, which is the time of its last
+// write, and its update time, which also changes with its metadata.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	attrs, err := s.attrs(ctx, k)
+	if err != nil || attrs == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{
+		Version:   strconv.FormatInt(attrs.Generation, 10),
+		CreatedAt: attrs.Created,
+		UpdatedAt: attrs.Updated,
+	}, true, nil
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		cur, attrs, err := s.get(ctx, k)
+		if err != nil || attrs == nil || !bytes.Equal(cur, o) {
+			return false, err
+		}
+		err = s.put(ctx, k, value, objectDeadline(attrs), storage.Conditions{GenerationMatch: attrs.Generation})
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	attrs, err := s.attrs(ctx, k)
+	return attrs != nil, err
+}
+
+// Keys returns every key starting with prefix, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	q := &storage.Query{Prefix: s.prefix + prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Metadata"}); err != nil {
+		return nil, err
+	}
+	it := s.b.Objects(ctx, q)
+	now := time.Now()
+	ks := []string{}
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return ks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !expired(objectDeadline(attrs), now) {
+			ks = append(ks, strings.TrimPrefix(attrs.Name, s.prefix))
+		}
+	}
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+)
@@ -0,0 +1,65 @@
+package gcs_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/gokv/store"
+	"github.com/gokv/store/gcs"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store under a new prefix of
+// the bucket of the GOKV_GCS_BUCKET environment variable, with the default
+// credentials, or the emulator of STORAGE_EMULATOR_HOST. The objects are
+// deleted when the test completes. The test is skipped if the bucket is not
+// set.
+func newStore(tb testing.TB) func() store.Store {
+	bucket := os.Getenv("GOKV_GCS_BUCKET")
+	if bucket == "" {
+		tb.Skip("GOKV_GCS_BUCKET is not set")
+	}
+	ctx := context.Background()
+	// The stores close their client: the objects are deleted with another.
+	admin, err := storage.NewClient(ctx)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { admin.Close() })
+	return func() store.Store {
+		c, err := storage.NewClient(ctx)
+		if err != nil {
+			panic(err)
+		}
+		prefix := "gokv-test/" + randomHex() + "/"
+		tb.Cleanup(func() { deleteAll(gcs.New(admin, bucket, prefix)) })
+		return gcs.New(c, bucket, prefix)
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// deleteAll deletes every key of s.
+func deleteAll(s *gcs.Store) {
+	ctx := context.Background()
+	ks, _ := s.Keys(ctx, "")
+	for _, k := range ks {
+		s.Delete(ctx, k)
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
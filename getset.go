@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// GetOrSetter defines an atomic set-if-absent, for lazy initialization and
+// locks.
+type GetOrSetter interface {
+
+	// GetOrSet assigns v to the given key if it does not exist. Otherwise it
+	// retrieves the current value and unmarshals it to current. The same
+	// variable may be passed as both v and current.
+	// Loaded is true if the key existed, and false if v was assigned.
+	// Err is non-nil in case of failure.
+	GetOrSet(ctx context.Context, k string, v json.Marshaler, current json.Unmarshaler) (loaded bool, err error)
+}
+
+// GetAndDeleter defines an atomic read-and-remove, for one-time tokens.
+type GetAndDeleter interface {
+
+	// GetAndDelete retrieves the value of the given key, unmarshals it to v
+	// and removes the key from the store. Concurrent calls on the same key
+	// can not both find it.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	GetAndDelete(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error)
+}
@@ -1 +1,3 @@
 module github.com/gokv/store
+
+go 1.18
@@ -0,0 +1,187 @@
+/*
+Package hedge provides a Store wrapper hedging the reads, to cut their tail
+latency.
+
+Get reads from the wrapped Store and, if the read has not completed after a
+delay, issues a second read, to the wrapped Store or to a replica, and
+returns whichever succeeds first; the other read is then canceled. The delay
+is a percentile of the latencies of the recent reads, the 95th unless
+specified otherwise with WithPercentile, so that only the slowest reads are
+hedged; it is DefaultDelay until enough reads have been observed.
+
+A read failing before the delay is not hedged: its error is returned, as
+hedging is not meant to retry.
+*/
+package hedge // import "github.com/gokv/store/hedge"
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// The default settings of the Store.
+const (
+	DefaultPercentile = 0.95
+	DefaultDelay      = 10 * time.Millisecond
+	DefaultSamples    = 1000
+)
+
+// minSamples is the number of latencies observed before the percentile is
+// used, and the number of latencies between its computations.
+const minSamples = 100
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithPercentile sets the percentile, between 0 and 1, of the latencies of
+// the recent reads after which the reads are hedged, and the number of
+// recent reads considered.
+func WithPercentile(p float64, samples int) Option {
+	return func(s *Store) { s.percentile, s.samples = p, samples }
+}
+
+// WithDelay sets a fixed delay after which the reads are hedged, in place of
+// the percentile.
+func WithDelay(delay time.Duration) Option {
+	return func(s *Store) { s.delay, s.fixed = delay, true }
+}
+
+// WithReplica sets the Store receiving the hedged reads, in place of the
+// wrapped Store.
+func WithReplica(replica store.Store) Option {
+	return func(s *Store) { s.replica = replica }
+}
+
+// Store is a store.Store hedging the reads of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	replica    store.Store
+	percentile float64
+	samples    int
+	fixed      bool
+
+	mu        sync.Mutex
+	delay     time.Duration
+	latencies []time.Duration // ring of the recent latencies
+	next      int             // next index in latencies
+	observed  int             // latencies observed since the last computation
+}
+
+// Wrap returns a Store hedging the reads of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	h := &Store{
+		Wrapper:    store.Wrapper{Store: s},
+		percentile: DefaultPercentile,
+		samples:    DefaultSamples,
+		delay:      DefaultDelay,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Delay returns the current delay after which the reads are hedged.
+func (s *Store) Delay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delay
+}
+
+// observe records the latency of a read, and recomputes the delay every
+// minSamples reads.
+func (s *Store) observe(latency time.Duration) {
+	if s.fixed {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.latencies) < s.samples {
+		s.latencies = append(s.latencies, latency)
+	} else {
+		s.latencies[s.next] = latency
+		s.next = (s.next + 1) % s.samples
+	}
+	if s.observed++; s.observed < minSamples || len(s.latencies) < minSamples {
+		return
+	}
+	s.observed = 0
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(s.percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	s.delay = sorted[i]
+}
+
+// read is the result of one of the reads of Get.
+type read struct {
+	data json.RawMessage
+	ok   bool
+	err  error
+}
+
+// Get retrieves a new value by key and unmarshals it to v, hedging the read
+// if it is slow.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reads := make(chan read, 2)
+	get := func(st store.Store) {
+		var r read
+		r.ok, r.err = st.Get(ctx, k, &r.data)
+		reads <- r
+	}
+
+	start := time.Now()
+	go get(s.Store)
+	timer := time.NewTimer(s.Delay())
+	defer timer.Stop()
+
+	var r read
+	select {
+	case r = <-reads:
+	case <-timer.C:
+		replica := s.replica
+		if replica == nil {
+			replica = s.Store
+		}
+		go get(replica)
+		if r = <-reads; r.err != nil {
+			r = <-reads
+		}
+	}
+	if r.err == nil {
+		// When the hedged read wins, the latency observed is a lower
+		// bound of the latency of the first read.
+		s.observe(time.Since(start))
+	}
+	if r.err != nil || !r.ok {
+		return false, r.err
+	}
+	return true, v.UnmarshalJSON(r.data)
+}
+
+// Close closes the wrapped Store and the replica, if any.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.Store.Close()
+	if s.replica != nil {
+		if rerr := s.replica.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,122 @@
+package hedge_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/hedge"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store hedging the reads of an empty memstore.
+func newStore() store.Store {
+	return hedge.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// value returns a GetFunc reading the JSON value data.
+func value(data string) func(context.Context, string, json.Unmarshaler) (bool, error) {
+	return func(_ context.Context, _ string, v json.Unmarshaler) (bool, error) {
+		return true, v.UnmarshalJSON([]byte(data))
+	}
+}
+
+func TestHedge(t *testing.T) {
+	canceled := make(chan struct{})
+	slow := &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, _ json.Unmarshaler) (bool, error) {
+			<-ctx.Done()
+			close(canceled)
+			return false, ctx.Err()
+		},
+	}
+	replica := &storetest.Fake{GetFunc: value(`"replica"`)}
+	s := hedge.Wrap(slow, hedge.WithDelay(10*time.Millisecond), hedge.WithReplica(replica))
+
+	var v json.RawMessage
+	if ok, err := s.Get(context.Background(), "k", &v); err != nil || !ok || string(v) != `"replica"` {
+		t.Fatalf("Get: got %s, %v, %v, want the value of the replica", v, ok, err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("the slow read was not canceled")
+	}
+}
+
+func TestSameStore(t *testing.T) {
+	var n int32
+	f := &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, v json.Unmarshaler) (bool, error) {
+			if atomic.AddInt32(&n, 1) == 1 {
+				<-ctx.Done()
+				return false, ctx.Err()
+			}
+			return true, v.UnmarshalJSON([]byte(`1`))
+		},
+	}
+	s := hedge.Wrap(f, hedge.WithDelay(10*time.Millisecond))
+
+	var v json.RawMessage
+	if ok, err := s.Get(context.Background(), "k", &v); err != nil || !ok || string(v) != `1` {
+		t.Fatalf("Get: got %s, %v, %v, want the value of the hedged read", v, ok, err)
+	}
+	if n := len(f.CallsTo("Get")); n != 2 {
+		t.Errorf("got %d reads, want 2", n)
+	}
+}
+
+func TestNotHedged(t *testing.T) {
+	errDown := errors.New("down")
+	for _, tc := range [...]struct {
+		name string
+		get  func(context.Context, string, json.Unmarshaler) (bool, error)
+		err  error
+	}{
+		{"fast", value(`1`), nil},
+		{"failing", func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, errDown
+		}, errDown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replica := &storetest.Fake{GetFunc: value(`"replica"`)}
+			s := hedge.Wrap(&storetest.Fake{GetFunc: tc.get}, hedge.WithDelay(time.Second), hedge.WithReplica(replica))
+
+			var v json.RawMessage
+			if _, err := s.Get(context.Background(), "k", &v); err != tc.err {
+				t.Errorf("Get: got %v, want %v", err, tc.err)
+			}
+			if calls := replica.Calls(); len(calls) != 0 {
+				t.Errorf("the read was hedged: %v", calls)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	s := hedge.Wrap(&storetest.Fake{GetFunc: value(`1`)}, hedge.WithPercentile(0.5, 100))
+	if d := s.Delay(); d != hedge.DefaultDelay {
+		t.Fatalf("Delay before any read: got %v, want %v", d, hedge.DefaultDelay)
+	}
+
+	var v json.RawMessage
+	for i := 0; i < 100; i++ {
+		if _, err := s.Get(context.Background(), "k", &v); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if d := s.Delay(); d >= hedge.DefaultDelay {
+		t.Errorf("Delay after 100 fast reads: got %v, want the median of their latencies", d)
+	}
+}
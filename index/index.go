@@ -0,0 +1,338 @@
+/*
+Package index provides a Store wrapper maintaining secondary indexes.
+
+Every time a value is written or removed, the wrapper updates the reverse
+references from the indexed values to the key of the item. For example, an
+"email" index over users maintains the index key "email/foo@bar" with the ID
+of the user as its value.
+
+The index keys are kept in a separate Store, so that they do not show up in
+GetAll. A single backend can host both with store.Namespacer.
+
+The indexes are expected to be unique: if two items share an indexed value,
+the reference points to the last written one. The index is not updated
+atomically with the data; GetByIndex verifies that the referenced item still
+carries the indexed value, so a stale reference reads as not found.
+
+The index keys expire with their item. Update keeps the expiration of the
+item, so it is carried to the index keys when the data Store is a
+store.TTLStore; otherwise the index keys of an updated item outlive it, and
+Prune removes them.
+*/
+package index // import "github.com/gokv/store/index"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Extractor returns the values under which the JSON-encoded item data is
+// indexed. It returns no values if the item is not indexed.
+type Extractor func(data []byte) ([]string, error)
+
+// Store is a store.Store maintaining secondary indexes.
+type Store struct {
+	store.Store
+	indexes    store.Store
+	extractors map[string]Extractor
+}
+
+// New returns a Store keeping the items in data and the index keys in
+// indexes. The map extractors associates every index name with the function
+// extracting the indexed values.
+func New(data, indexes store.Store, extractors map[string]Extractor) *Store {
+	return &Store{
+		Store:      data,
+		indexes:    indexes,
+		extractors: extractors,
+	}
+}
+
+// GetByIndex retrieves the item whose indexed value in the given index is
+// value, and unmarshals it to v.
+// Ok is false if no item was found.
+// Err is non-nil in case of failure.
+func (s *Store) GetByIndex(ctx context.Context, index, value string, v json.Unmarshaler) (bool, error) {
+	extract, ok := s.extractors[index]
+	if !ok {
+		return false, nil
+	}
+
+	var k key
+	if ok, err := s.indexes.Get(ctx, indexKey(index, value), &k); err != nil || !ok {
+		return false, err
+	}
+
+	var data json.RawMessage
+	if ok, err := s.Store.Get(ctx, string(k), &data); err != nil || !ok {
+		return false, err
+	}
+
+	values, err := extract(data)
+	if err != nil {
+		return false, err
+	}
+	if !contains(values, value) {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(data)
+}
+
+// Add assigns the given value to a new key, indexes it and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	k, err := s.Store.Add(ctx, json.RawMessage(data))
+	if err != nil {
+		return "", err
+	}
+	return k, s.reindex(ctx, k, nil, data, s.indexes.Set)
+}
+
+// Set idempotently assigns the given value to the given key and indexes it.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, old, err := s.prepare(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.Set(ctx, k, json.RawMessage(data)); err != nil {
+		return err
+	}
+	return s.reindex(ctx, k, old, data, s.indexes.Set)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting, and indexes it. The assigned key and its index keys will clear
+// after timeout.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting, and indexes it. The assigned key and its index keys will clear
+// after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, old, err := s.prepare(ctx, k, v)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.SetWithDeadline(ctx, k, json.RawMessage(data), deadline); err != nil {
+		return err
+	}
+	return s.reindex(ctx, k, old, data, func(ctx context.Context, k string, v json.Marshaler) error {
+		return s.indexes.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists, and
+// indexes it. If the data Store is a store.TTLStore, the index keys expire
+// with the item.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, old, err := s.prepare(ctx, k, v)
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.Store.Update(ctx, k, json.RawMessage(data))
+	if err != nil || !ok {
+		return ok, err
+	}
+	set := s.indexes.Set
+	if ts, isTTL := s.Store.(store.TTLStore); isTTL {
+		ttl, found, err := ts.GetTTL(ctx, k)
+		if err != nil {
+			return true, err
+		}
+		if found && ttl > 0 {
+			deadline := time.Now().Add(ttl)
+			set = func(ctx context.Context, k string, v json.Marshaler) error {
+				return s.indexes.SetWithDeadline(ctx, k, v, deadline)
+			}
+		}
+	}
+	return true, s.reindex(ctx, k, old, data, set)
+}
+
+// Delete removes a key, its value and its index keys from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	var old json.RawMessage
+	if _, err := s.Store.Get(ctx, k, &old); err != nil {
+		return false, err
+	}
+	ok, err := s.Store.Delete(ctx, k)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, s.reindex(ctx, k, old, nil, s.indexes.Set)
+}
+
+// Prune removes the index keys whose item was removed or no longer carries
+// the indexed value, such as the index keys of the updated items which
+// expired, when the data Store is not a store.TTLStore. It returns the
+// number of removed index keys. The index Store must be a store.KeyLister.
+// Err is non-nil in case of failure.
+func (s *Store) Prune(ctx context.Context) (int, error) {
+	kl, ok := s.indexes.(store.KeyLister)
+	if !ok {
+		return 0, fmt.Errorf("index: Prune: the index store is not a KeyLister: %w", store.ErrNotSupported)
+	}
+	var n int
+	for index, extract := range s.extractors {
+		iks, err := kl.Keys(ctx, indexKey(index, ""))
+		if err != nil {
+			return n, err
+		}
+		for _, ik := range iks {
+			if s.ownedByLonger(index, ik) {
+				continue
+			}
+			var ref key
+			ok, err := s.indexes.Get(ctx, ik, &ref)
+			if err != nil {
+				return n, err
+			}
+			if !ok {
+				continue
+			}
+			var data json.RawMessage
+			found, err := s.Store.Get(ctx, string(ref), &data)
+			if err != nil {
+				return n, err
+			}
+			if found {
+				values, err := extract(data)
+				if err != nil {
+					return n, err
+				}
+				if contains(values, strings.TrimPrefix(ik, indexKey(index, ""))) {
+					continue
+				}
+			}
+			if ok, err := s.indexes.Delete(ctx, ik); err != nil {
+				return n, err
+			} else if ok {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// ownedByLonger reports whether the index key ik belongs to an index whose
+// name extends index, e.g. "name/first" for "name".
+func (s *Store) ownedByLonger(index, ik string) bool {
+	for other := range s.extractors {
+		if len(other) > len(index) && strings.HasPrefix(ik, indexKey(other, "")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close releases the resources associated with both the data and the index
+// stores.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.Store.Close()
+	if ierr := s.indexes.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+type setFunc func(ctx context.Context, k string, v json.Marshaler) error
+
+// prepare marshals v and reads the current value of k. Old is nil if k was
+// not found.
+func (s *Store) prepare(ctx context.Context, k string, v json.Marshaler) (data, old []byte, err error) {
+	if data, err = v.MarshalJSON(); err != nil {
+		return nil, nil, err
+	}
+	var raw json.RawMessage
+	if _, err := s.Store.Get(ctx, k, &raw); err != nil {
+		return nil, nil, err
+	}
+	return data, raw, nil
+}
+
+// reindex removes the index keys of the old value of k which still point to
+// it, and writes the index keys of its new value with set. Either value may
+// be nil.
+func (s *Store) reindex(ctx context.Context, k string, old, data []byte, set setFunc) error {
+	for index, extract := range s.extractors {
+		var oldValues, values []string
+		var err error
+		if old != nil {
+			if oldValues, err = extract(old); err != nil {
+				return err
+			}
+		}
+		if data != nil {
+			if values, err = extract(data); err != nil {
+				return err
+			}
+		}
+
+		for _, value := range oldValues {
+			if contains(values, value) {
+				continue
+			}
+			ik := indexKey(index, value)
+			var ref key
+			ok, err := s.indexes.Get(ctx, ik, &ref)
+			if err != nil {
+				return err
+			}
+			if ok && string(ref) == k {
+				if _, err := s.indexes.Delete(ctx, ik); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, value := range values {
+			if err := set(ctx, indexKey(index, value), key(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func indexKey(index, value string) string {
+	return index + "/" + value
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// key is the value of an index key: the key of the indexed item.
+type key string
+
+func (k key) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(k))
+}
+
+func (k *key) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*string)(k))
+}
@@ -0,0 +1,227 @@
+package index_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/index"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// user is the indexed item of the tests.
+type user struct {
+	Email string `json:"email"`
+}
+
+func (u user) MarshalJSON() ([]byte, error) {
+	type plain user
+	return json.Marshal(plain(u))
+}
+
+func (u *user) UnmarshalJSON(data []byte) error {
+	type plain user
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+// email indexes the items by their "email" field. The other values are not
+// indexed.
+func email(data []byte) ([]string, error) {
+	var u user
+	if json.Unmarshal(data, &u) != nil || u.Email == "" {
+		return nil, nil
+	}
+	return []string{u.Email}, nil
+}
+
+// newIndex returns a Store indexing the emails of data in indexes.
+func newIndex(data, indexes store.Store) *index.Store {
+	return index.New(data, indexes, map[string]index.Extractor{"email": email})
+}
+
+func newStore() store.Store {
+	return newIndex(memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// expectIndexed checks that the email index resolves value to the item of k,
+// or to nothing if k is empty.
+func expectIndexed(t *testing.T, s *index.Store, value, k string) {
+	t.Helper()
+	var u user
+	ok, err := s.GetByIndex(context.Background(), "email", value, &u)
+	if err != nil {
+		t.Fatalf("GetByIndex(%q): %v", value, err)
+	}
+	if k == "" {
+		if ok {
+			t.Errorf("GetByIndex(%q): got %+v, want not found", value, u)
+		}
+		return
+	}
+	if !ok || u.Email != value {
+		t.Errorf("GetByIndex(%q): got %+v, %v, want the item of %q", value, u, ok, k)
+	}
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	ctx := context.Background()
+	indexes := memstore.New()
+	s := newIndex(memstore.New(), indexes)
+	defer s.Close()
+
+	if err := s.Set(ctx, "1", user{Email: "a@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectIndexed(t, s, "a@example.com", "1")
+	expectIndexed(t, s, "b@example.com", "")
+
+	if ok, err := s.Update(ctx, "1", user{Email: "b@example.com"}); err != nil || !ok {
+		t.Fatalf("Update: %v, %v", ok, err)
+	}
+	expectIndexed(t, s, "a@example.com", "")
+	expectIndexed(t, s, "b@example.com", "1")
+	if ok, _ := indexes.Exists(ctx, "email/a@example.com"); ok {
+		t.Error("the index key of the previous value was kept after Update")
+	}
+
+	k, err := s.Add(ctx, user{Email: "c@example.com"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	expectIndexed(t, s, "c@example.com", k)
+
+	if ok, err := s.Delete(ctx, "1"); err != nil || !ok {
+		t.Fatalf("Delete: %v, %v", ok, err)
+	}
+	expectIndexed(t, s, "b@example.com", "")
+	if ok, _ := indexes.Exists(ctx, "email/b@example.com"); ok {
+		t.Error("the index key was kept after Delete")
+	}
+	expectIndexed(t, s, "c@example.com", k)
+
+	var u user
+	if ok, err := s.GetByIndex(ctx, "name", "c@example.com", &u); err != nil || ok {
+		t.Errorf("GetByIndex on an unknown index: got %v, %v, want not found", ok, err)
+	}
+}
+
+func TestSharedValue(t *testing.T) {
+	ctx := context.Background()
+	s := newIndex(memstore.New(), memstore.New())
+	defer s.Close()
+
+	if err := s.Set(ctx, "1", user{Email: "a@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Set(ctx, "2", user{Email: "a@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// The reference points to the last written item. Moving the first one
+	// away must not remove it.
+	if err := s.Set(ctx, "1", user{Email: "b@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectIndexed(t, s, "a@example.com", "2")
+	expectIndexed(t, s, "b@example.com", "1")
+}
+
+func TestStaleReference(t *testing.T) {
+	ctx := context.Background()
+	data := memstore.New()
+	s := newIndex(data, memstore.New())
+	defer s.Close()
+
+	if err := s.Set(ctx, "1", user{Email: "a@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// A write bypassing the wrapper leaves the reference stale.
+	if err := data.Set(ctx, "1", user{Email: "b@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectIndexed(t, s, "a@example.com", "")
+}
+
+func TestUpdateKeepsExpiration(t *testing.T) {
+	ctx := context.Background()
+	indexes := memstore.New()
+	s := newIndex(memstore.New(), indexes)
+	defer s.Close()
+
+	if err := s.SetWithTimeout(ctx, "1", user{Email: "a@example.com"}, time.Hour); err != nil {
+		t.Fatalf("SetWithTimeout: %v", err)
+	}
+	if ok, err := s.Update(ctx, "1", user{Email: "b@example.com"}); err != nil || !ok {
+		t.Fatalf("Update: %v, %v", ok, err)
+	}
+	ttl, ok, err := indexes.GetTTL(ctx, "email/b@example.com")
+	if err != nil || !ok {
+		t.Fatalf("GetTTL: %v, %v", ok, err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Errorf("the index key of the updated item expires in %v, want the remaining hour", ttl)
+	}
+}
+
+// plainStore hides the optional interfaces of the wrapped Store, such as
+// store.TTLStore.
+type plainStore struct {
+	store.Store
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	data, indexes := memstore.New(), memstore.New()
+	s := newIndex(plainStore{data}, indexes)
+	defer s.Close()
+
+	// The data Store is not a TTLStore: the index key of the updated item
+	// does not expire with it.
+	if err := s.SetWithTimeout(ctx, "expiring", user{Email: "a@example.com"}, 50*time.Millisecond); err != nil {
+		t.Fatalf("SetWithTimeout: %v", err)
+	}
+	if ok, err := s.Update(ctx, "expiring", user{Email: "b@example.com"}); err != nil || !ok {
+		t.Fatalf("Update: %v, %v", ok, err)
+	}
+	if err := s.Set(ctx, "moved", user{Email: "c@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := data.Set(ctx, "moved", user{Email: "d@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Set(ctx, "kept", user{Email: "e@example.com"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	n, err := s.Prune(ctx)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Prune: removed %d index keys, want 2", n)
+	}
+	ks, err := indexes.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(ks) != 1 || ks[0] != "email/e@example.com" {
+		t.Errorf("index keys after Prune: got %q, want only the one of the kept item", ks)
+	}
+	expectIndexed(t, s, "e@example.com", "kept")
+}
+
+func TestPruneNotSupported(t *testing.T) {
+	s := newIndex(memstore.New(), &storetest.Fake{})
+	if _, err := s.Prune(context.Background()); !store.IsNotSupported(err) {
+		t.Errorf("Prune without a KeyLister: got %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Iterator walks the items of a store one at a time. It is an alternative to
+// GetAll for stores too large to be collected in a single call.
+//
+// A typical loop resembles to:
+//
+//	it, err := s.Iter(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for {
+//		ok, err := it.Next(ctx)
+//		if err != nil {
+//			return err
+//		}
+//		if !ok {
+//			break
+//		}
+//		// use it.Key() and it.Value(v)
+//	}
+type Iterator interface {
+
+	// Next advances the iterator to the next item.
+	// Ok is false when there are no more items.
+	// Err is non-nil in case of failure.
+	Next(ctx context.Context) (ok bool, err error)
+
+	// Key returns the key of the current item. It must only be called after
+	// a call to Next returned true.
+	Key() string
+
+	// Value unmarshals the value of the current item to v. It must only be
+	// called after a call to Next returned true.
+	// Err is non-nil in case of failure.
+	Value(v json.Unmarshaler) error
+
+	// Close releases the resources associated with the Iterator. It can be
+	// called before the iteration is complete to stop early.
+	// Err is non-nil in case of failure.
+	Close() error
+}
+
+// Iterable defines a method for walking a store incrementally.
+type Iterable interface {
+
+	// Iter returns an Iterator over every item in the store. The order of the
+	// items is implementation-defined.
+	// Err is non-nil in case of failure.
+	Iter(ctx context.Context) (Iterator, error)
+}
+
+// PrefixIterable defines a method for walking the items under a key prefix
+// incrementally. It is the streaming counterpart of KeyLister.
+type PrefixIterable interface {
+
+	// IterPrefix returns an Iterator over every item whose key starts with
+	// prefix. An empty prefix matches every key in the store.
+	// Err is non-nil in case of failure.
+	IterPrefix(ctx context.Context, prefix string) (Iterator, error)
+}
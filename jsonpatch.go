@@ -0,0 +1,445 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operation is an operation of a JSON Patch (RFC 6902) document.
+type Operation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" and "test".
+	Op string `json:"op"`
+
+	// Path is the JSON Pointer (RFC 6901) of the target location.
+	Path string `json:"path"`
+
+	// From is the JSON Pointer of the source location of "move" and
+	// "copy".
+	From string `json:"from,omitempty"`
+
+	// Value is the value of "add", "replace" and "test".
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatcher defines the application of JSON Patch documents to stored
+// values, for fine-grained edits which can be kept as an audit trail.
+type JSONPatcher interface {
+
+	// JSONPatch applies the JSON Patch (RFC 6902) ops to the value of the
+	// given key, atomically, and returns the version of the result, as
+	// reported by GetMeta.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure. It wraps ErrConflict if a "test"
+	// operation failed, in which case the value is left untouched.
+	JSONPatch(ctx context.Context, k string, ops []Operation) (version string, ok bool, err error)
+}
+
+// JSONPatch applies the JSON Patch (RFC 6902) ops to the value of the given
+// key in s, and returns the version of the result. It calls s.JSONPatch if s
+// is a JSONPatcher. Otherwise it reads the value, applies ops and writes the
+// result with CompareAndSet if s is a CompareAndSetter, retrying if the
+// value changed meanwhile, or with Update, which may lose a concurrent
+// write. The version is then read with GetMeta, on a best-effort basis: it
+// is the version of a concurrent write if one happened in between, and it
+// is empty if s is not a MetaGetter.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure. It wraps ErrConflict if a "test"
+// operation failed.
+func JSONPatch(ctx context.Context, s Store, k string, ops []Operation) (string, bool, error) {
+	if p, ok := s.(JSONPatcher); ok {
+		return p.JSONPatch(ctx, k, ops)
+	}
+	cas, isCAS := s.(CompareAndSetter)
+	for attempt := 0; attempt < maxPatchAttempts; attempt++ {
+		var current json.RawMessage
+		if ok, err := s.Get(ctx, k, &current); err != nil || !ok {
+			return "", false, err
+		}
+		v, err := ApplyJSONPatch(current, ops)
+		if err != nil {
+			return "", false, err
+		}
+		var ok bool
+		if isCAS {
+			ok, err = cas.CompareAndSet(ctx, k, current, json.RawMessage(v))
+		} else {
+			ok, err = s.Update(ctx, k, json.RawMessage(v))
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			return patchedVersion(ctx, s, k)
+		}
+		if !isCAS {
+			return "", false, nil
+		}
+	}
+	return "", false, fmt.Errorf("store: JSONPatch: %q kept changing: %w", k, ErrConflict)
+}
+
+// patchedVersion returns the version of the value of k in s, just written
+// by JSONPatch, or an empty string if s is not a MetaGetter.
+func patchedVersion(ctx context.Context, s Store, k string) (string, bool, error) {
+	mg, ok := s.(MetaGetter)
+	if !ok {
+		return "", true, nil
+	}
+	m, ok, err := mg.GetMeta(ctx, k)
+	if err != nil {
+		return "", false, err
+	}
+	return m.Version, ok, nil
+}
+
+// ApplyJSONPatch returns doc with the JSON Patch (RFC 6902) ops applied.
+// The ops are applied in order, and the first failing one fails the whole
+// patch.
+// Err is non-nil if doc is not valid JSON, or if an operation failed. It
+// wraps ErrConflict if a "test" operation failed.
+func ApplyJSONPatch(doc []byte, ops []Operation) ([]byte, error) {
+	d, err := decodeJSON(doc)
+	if err != nil {
+		return nil, fmt.Errorf("store: ApplyJSONPatch: invalid JSON document")
+	}
+	for i, op := range ops {
+		if d, err = applyOperation(d, op); err != nil {
+			return nil, fmt.Errorf("store: ApplyJSONPatch: operation %d (%s %q): %w", i, op.Op, op.Path, err)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(d); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// applyOperation returns doc with op applied. The containers of doc may be
+// modified in place.
+func applyOperation(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		v, err := operationValue(op)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "remove":
+		return removeValue(doc, path)
+	case "replace":
+		v, err := operationValue(op)
+		if err != nil {
+			return nil, err
+		}
+		return replaceValue(doc, path, v)
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if len(path) > len(from) && hasPrefix(path, from) {
+			return nil, errors.New("can not move a value into itself")
+		}
+		v, err := getValue(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if doc, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, v)
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		v, err := getValue(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return addValue(doc, path, cloneJSON(v))
+	case "test":
+		want, err := operationValue(op)
+		if err != nil {
+			return nil, err
+		}
+		v, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(v, want) {
+			return nil, fmt.Errorf("test failed: %w", ErrConflict)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// operationValue returns the decoded value of op.
+func operationValue(op Operation) (any, error) {
+	if op.Value == nil {
+		return nil, errors.New("missing value")
+	}
+	v, err := decodeJSON(op.Value)
+	if err != nil {
+		return nil, errors.New("invalid JSON value")
+	}
+	return v, nil
+}
+
+// decodeJSON decodes data, keeping the numbers as json.Number so that they
+// are encoded back unchanged.
+func decodeJSON(data []byte) (any, error) {
+	if !json.Valid(data) {
+		return nil, errors.New("invalid JSON")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// pointerUnescaper unescapes the reference tokens of a JSON Pointer.
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parsePointer returns the reference tokens of the JSON Pointer p.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		for j := 0; j < len(t); j++ {
+			if t[j] == '~' && (j+1 == len(t) || (t[j+1] != '0' && t[j+1] != '1')) {
+				return nil, fmt.Errorf("invalid JSON pointer %q", p)
+			}
+		}
+		tokens[i] = pointerUnescaper.Replace(t)
+	}
+	return tokens, nil
+}
+
+// hasPrefix reports whether the path begins with prefix.
+func hasPrefix(path, prefix []string) bool {
+	for i, t := range prefix {
+		if path[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex returns the index of the array of length n referenced by
+// token. The index n, referenced by "-", is only valid if end is true.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > n || (i == n && !end) {
+		return 0, fmt.Errorf("array index %q out of bounds", token)
+	}
+	return i, nil
+}
+
+// getValue returns the value at path in doc.
+func getValue(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch c := doc.(type) {
+		case map[string]any:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", t)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("member %q not found", t)
+		}
+	}
+	return doc, nil
+}
+
+// modify returns doc with the container at path[:len(path)-1] replaced by
+// the result of f, given the container and the last token of path.
+func modify(doc any, path []string, f func(c any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = modify(child, path[1:], f); err != nil {
+		return nil, err
+	}
+	switch c := doc.(type) {
+	case map[string]any:
+		c[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(c), false)
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// addValue returns doc with v added at path: inserted in an array, or
+// assigned to a member of an object.
+func addValue(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	return modify(doc, path, func(c any, t string) (any, error) {
+		switch c := c.(type) {
+		case map[string]any:
+			c[t] = v
+			return c, nil
+		case []any:
+			i, err := arrayIndex(t, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = v
+			return c, nil
+		default:
+			return nil, fmt.Errorf("can not add %q to a scalar", t)
+		}
+	})
+}
+
+// removeValue returns doc without the value at path, which must exist.
+func removeValue(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("can not remove the whole document")
+	}
+	return modify(doc, path, func(c any, t string) (any, error) {
+		switch c := c.(type) {
+		case map[string]any:
+			if _, ok := c[t]; !ok {
+				return nil, fmt.Errorf("member %q not found", t)
+			}
+			delete(c, t)
+			return c, nil
+		case []any:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("member %q not found", t)
+		}
+	})
+}
+
+// replaceValue returns doc with the value at path, which must exist,
+// replaced by v.
+func replaceValue(doc any, path []string, v any) (any, error) {
+	if _, err := getValue(doc, path); err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return v, nil
+	}
+	return modify(doc, path, func(c any, t string) (any, error) {
+		switch c := c.(type) {
+		case map[string]any:
+			c[t] = v
+		case []any:
+			i, _ := arrayIndex(t, len(c), false)
+			c[i] = v
+		}
+		return c, nil
+	})
+}
+
+// cloneJSON returns a deep copy of the decoded value v.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for name, x := range v {
+			m[name] = cloneJSON(x)
+		}
+		return m
+	case []any:
+		a := make([]any, len(v))
+		for i, x := range v {
+			a[i] = cloneJSON(x)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// equalJSON reports whether the decoded values a and b are equal, comparing
+// the numbers by value.
+func equalJSON(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, x := range a {
+			y, ok := b[name]
+			if !ok || !equalJSON(x, y) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Package keylock provides a Store wrapper serializing the operations on a key
+within the process, so that a read-modify-write does not lose concurrent
+updates with a backend lacking compare-and-set.
+
+Do runs a function while holding the lock of a key:
+
+	err := s.Do(ctx, "counter", func(st store.Store) error {
+		var n Counter
+		if _, err := st.Get(ctx, "counter", &n); err != nil {
+			return err
+		}
+		n++
+		return st.Set(ctx, "counter", n)
+	})
+
+The writes of the Store take the lock of their key as well, so that they do
+not interleave with a Do on the same key; the reads do not. The locks are
+striped: the keys are hashed to a fixed number of locks, so that different
+keys may share a lock. The function given to Do is therefore called with the
+wrapped Store, and must not call the wrapping Store, which could wait for the
+lock it holds.
+
+The locks only serialize the operations of one process: the processes
+sharing a backend need store.CompareAndSetter or store.Locker instead.
+*/
+package keylock // import "github.com/gokv/store/keylock"
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// DefaultStripes is the default number of locks.
+const DefaultStripes = 256
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithStripes sets the number of locks. More locks mean less contention
+// between different keys, for a little more memory.
+func WithStripes(n int) Option {
+	return func(s *Store) {
+		if n > 0 {
+			s.stripes = n
+		}
+	}
+}
+
+// Store is a store.Store serializing the operations on a key.
+type Store struct {
+	store.Wrapper
+	stripes int
+	locks   []chan struct{} // held when full
+}
+
+// Wrap returns a Store serializing the operations on a key of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	l := &Store{Wrapper: store.Wrapper{Store: s}, stripes: DefaultStripes}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.locks = make([]chan struct{}, l.stripes)
+	for i := range l.locks {
+		l.locks[i] = make(chan struct{}, 1)
+	}
+	return l
+}
+
+// lock takes the lock of k, and returns the function releasing it. It gives
+// up when the context is done.
+func (s *Store) lock(ctx context.Context, k string) (func(), error) {
+	h := fnv.New32a()
+	h.Write([]byte(k))
+	lock := s.locks[h.Sum32()%uint32(len(s.locks))]
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Do calls fn with the wrapped Store while holding the lock of k, and
+// returns its error. The other operations on k wait for fn to return.
+// Err is non-nil if the context is done before the lock is taken.
+func (s *Store) Do(ctx context.Context, k string, fn func(store.Store) error) error {
+	unlock, err := s.lock(ctx, k)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn(s.Store)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.Do(ctx, k, func(st store.Store) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	return s.Do(ctx, k, func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.Do(ctx, k, func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	err = s.Do(ctx, k, func(st store.Store) (err error) {
+		ok, err = st.Update(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.Do(ctx, k, func(st store.Store) (err error) {
+		ok, err = st.Delete(ctx, k)
+		return err
+	})
+	return ok, err
+}
+
+var _ store.Store = (*Store)(nil)
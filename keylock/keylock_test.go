@@ -0,0 +1,101 @@
+package keylock_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/keylock"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store locking the keys of an empty memstore.
+func newStore() store.Store {
+	return keylock.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	s := keylock.Wrap(memstore.New())
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Do(ctx, "counter", func(st store.Store) error {
+				var v json.RawMessage
+				if _, err := st.Get(ctx, "counter", &v); err != nil {
+					return err
+				}
+				c, _ := strconv.Atoi(string(v))
+				return st.Set(ctx, "counter", json.RawMessage(strconv.Itoa(c+1)))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "counter", &v); err != nil || string(v) != strconv.Itoa(n) {
+		t.Errorf("got the counter %s, %v, want %d", v, err, n)
+	}
+}
+
+func TestWriteWaits(t *testing.T) {
+	ctx := context.Background()
+	s := keylock.Wrap(memstore.New())
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	locked, release := make(chan struct{}), make(chan struct{})
+	go s.Do(ctx, "k", func(store.Store) error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+
+	// The reads do not wait for the lock.
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "k", &v); err != nil || !ok {
+		t.Fatalf("Get while the key is locked: %v, %v", ok, err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := s.Set(tctx, "k", json.RawMessage(`2`)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set while the key is locked: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	written := make(chan error)
+	go func() { written <- s.Set(ctx, "k", json.RawMessage(`3`)) }()
+	select {
+	case err := <-written:
+		t.Fatalf("Set did not wait for the lock: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-written; err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := s.Get(ctx, "k", &v); err != nil || string(v) != "3" {
+		t.Errorf("Get: got %s, %v, want 3", v, err)
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import "context"
+
+// KeyLister defines a method for enumerating keys without fetching the
+// values.
+type KeyLister interface {
+
+	// Keys returns every key starting with prefix. An empty prefix matches
+	// every key in the store. The order of the keys is implementation-defined.
+	// Err is non-nil in case of failure.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Exister defines a method for testing the presence of a key without
+// retrieving its value.
+type Exister interface {
+
+	// Exists reports whether the given key is in the store.
+	// Err is non-nil in case of failure.
+	Exists(ctx context.Context, k string) (ok bool, err error)
+}
+
+// Sizer defines methods for querying the cardinality of a store.
+type Sizer interface {
+
+	// Count returns the number of keys in the store.
+	// Err is non-nil in case of failure.
+	Count(ctx context.Context) (int64, error)
+
+	// CountPrefix returns the number of keys starting with prefix.
+	// Err is non-nil in case of failure.
+	CountPrefix(ctx context.Context, prefix string) (int64, error)
+}
+
+// Clearer defines methods for removing many keys at once.
+type Clearer interface {
+
+	// Clear removes every key and value from the store.
+	// Err is non-nil in case of failure.
+	Clear(ctx context.Context) error
+
+	// ClearPrefix removes every key starting with prefix, and its value.
+	// Err is non-nil in case of failure.
+	ClearPrefix(ctx context.Context, prefix string) error
+}
@@ -0,0 +1,214 @@
+/*
+Package latency provides a Store wrapper delaying the operations, so that the
+timeouts and the latency objectives of the consumers can be exercised in
+tests and staging without a slow backend.
+
+Every operation but Close waits for a delay drawn from the Distribution of
+its method before calling the wrapped Store:
+
+	s := latency.Wrap(memstore.New(), latency.Normal(5*time.Millisecond, time.Millisecond),
+		latency.WithMethodDelay("GetAll", latency.Uniform(50*time.Millisecond, 200*time.Millisecond)),
+	)
+
+An operation whose context is done while it waits returns the error of the
+context, without calling the wrapped Store.
+*/
+package latency // import "github.com/gokv/store/latency"
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Distribution returns a delay drawn from r. The negative delays count as
+// zero.
+type Distribution func(r *rand.Rand) time.Duration
+
+// Fixed returns the Distribution of the constant delay d.
+func Fixed(d time.Duration) Distribution {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Uniform returns the Distribution of the delays uniformly spread between min
+// and max.
+func Uniform(min, max time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return min + time.Duration(r.Int63n(int64(max-min)+1))
+	}
+}
+
+// Normal returns the Distribution of the normally distributed delays of the
+// given mean and standard deviation.
+func Normal(mean, stddev time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return mean + time.Duration(r.NormFloat64()*float64(stddev))
+	}
+}
+
+// Exponential returns the Distribution of the exponentially distributed
+// delays of the given mean, whose long tail mimics the occasional slow
+// operations of a real backend.
+func Exponential(mean time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMethodDelay sets the Distribution of the delays of the given method
+// (e.g. "Get" or "GetAll"), in place of the default one.
+func WithMethodDelay(method string, d Distribution) Option {
+	return func(s *Store) { s.methods[method] = d }
+}
+
+// WithSeed seeds the source of the delays.
+func WithSeed(seed int64) Option {
+	return func(s *Store) { s.rand = rand.New(rand.NewSource(seed)) }
+}
+
+// Store is a store.Store delaying the operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	delay   Distribution
+	methods map[string]Distribution
+
+	mu   sync.Mutex // guards rand
+	rand *rand.Rand
+}
+
+// Wrap returns a Store delaying the operations of s by delays drawn from d,
+// unless specified otherwise with WithMethodDelay. A nil d means no delay.
+func Wrap(s store.Store, d Distribution, opts ...Option) *Store {
+	l := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		delay:   d,
+		methods: make(map[string]Distribution),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// wait waits for a delay of method, or for ctx to be done.
+func (s *Store) wait(ctx context.Context, method string) error {
+	d, ok := s.methods[method]
+	if !ok {
+		d = s.delay
+	}
+	if d == nil {
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	delay := d(s.rand)
+	s.mu.Unlock()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := s.wait(ctx, "Get"); err != nil {
+		return false, err
+	}
+	return s.Store.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	if err := s.wait(ctx, "GetAll"); err != nil {
+		return err
+	}
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	if err := s.wait(ctx, "Add"); err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	if err := s.wait(ctx, "Set"); err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called, before the delay.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := s.wait(ctx, "SetWithTimeout"); err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	if err := s.wait(ctx, "SetWithDeadline"); err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	if err := s.wait(ctx, "Update"); err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	if err := s.wait(ctx, "Delete"); err != nil {
+		return false, err
+	}
+	return s.Store.Delete(ctx, k)
+}
+
+// Ping returns a non-nil error if the Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.wait(ctx, "Ping"); err != nil {
+		return err
+	}
+	return s.Store.Ping(ctx)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,22 @@
+package latency_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/latency"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store delaying the operations of an empty memstore.
+func newStore() store.Store {
+	return latency.Wrap(memstore.New(), latency.Fixed(time.Microsecond))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
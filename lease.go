@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Leaser defines a method for granting leases: time-bound sessions to which
+// keys can be attached, for etcd-style session semantics and heartbeat-renewed
+// registrations.
+type Leaser interface {
+
+	// Grant creates a new lease expiring after ttl, unless it is kept alive.
+	// Err is non-nil in case of failure.
+	Grant(ctx context.Context, ttl time.Duration) (Lease, error)
+}
+
+// Lease is a time-bound session. The keys attached to a lease clear when it
+// expires or is revoked.
+type Lease interface {
+
+	// ID returns the identifier of the lease.
+	ID() string
+
+	// Set assigns the given value to the given key, possibly overwriting,
+	// and attaches the key to the lease.
+	// Err is non-nil in case of failure.
+	Set(ctx context.Context, k string, v json.Marshaler) error
+
+	// KeepAlive renews the lease for another ttl, starting when this
+	// function is called. Heartbeats call it periodically, in intervals
+	// shorter than ttl.
+	// Ok is false if the lease had already expired or was revoked.
+	// Err is non-nil in case of failure.
+	KeepAlive(ctx context.Context) (ok bool, err error)
+
+	// Revoke ends the lease, clearing every key attached to it.
+	// Ok is false if the lease had already expired or was revoked.
+	// Err is non-nil in case of failure.
+	Revoke(ctx context.Context) (ok bool, err error)
+}
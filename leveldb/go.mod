@@ -0,0 +1,10 @@
+module github.com/gokv/store/leveldb
+
+go 1.26.7
+
+require (
+	github.com/gokv/store v0.1.0
+	github.com/syndtr/goleveldb v1.0.1-0.20220721030215-126854af5e6d
+)
+
+require github.com/golang/snappy v0.0.4 // indirect
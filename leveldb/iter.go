@@ -0,0 +1,138 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// A cursor walks the items of a snapshot which have not expired, in
+// ascending key order. The deadlines are read with a second iterator over the
+// index, which is sorted in the same order as the items.
+type cursor struct {
+	it     iterator.Iterator
+	ttl    iterator.Iterator
+	hasTTL bool
+	strip  int
+	now    time.Time
+}
+
+func (s *Store) newCursor(snap *leveldb.Snapshot, prefix string, now time.Time) *cursor {
+	p := []byte(s.prefix + prefix)
+	c := &cursor{
+		it:    snap.NewIterator(util.BytesPrefix(p), nil),
+		ttl:   snap.NewIterator(util.BytesPrefix(ttlKey(p)), nil),
+		strip: len(s.prefix),
+		now:   now,
+	}
+	c.hasTTL = c.ttl.Next()
+	return c
+}
+
+// next advances the cursor to the next item. Ok is false when there are no
+// more items, or in case of failure.
+func (c *cursor) next() bool {
+	for c.it.Next() {
+		k := c.it.Key()
+		if bytes.HasPrefix(k, []byte(reserved)) {
+			continue
+		}
+		for c.hasTTL && bytes.Compare(c.ttl.Key()[len(reserved)+1:], k) < 0 {
+			c.hasTTL = c.ttl.Next()
+		}
+		if c.hasTTL && bytes.Equal(c.ttl.Key()[len(reserved)+1:], k) &&
+			expired(int64(binary.BigEndian.Uint64(c.ttl.Value())), c.now) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// key returns the key of the current item, without the store prefix. It is
+// only valid until the next call to next.
+func (c *cursor) key() []byte {
+	return c.it.Key()[c.strip:]
+}
+
+// value returns the value of the current item. It is only valid until the
+// next call to next.
+func (c *cursor) value() []byte {
+	return c.it.Value()
+}
+
+func (c *cursor) err() error {
+	if err := c.it.Error(); err != nil {
+		return err
+	}
+	return c.ttl.Error()
+}
+
+func (c *cursor) release() {
+	c.it.Release()
+	c.ttl.Release()
+}
+
+// Iter returns an Iterator over every item in the store, ordered by key. The
+// Iterator walks a snapshot of the database taken when Iter is called, and
+// must be closed.
+// Err is non-nil in case of failure.
+func (s *Store) Iter(ctx context.Context) (store.Iterator, error) {
+	return s.IterPrefix(ctx, "")
+}
+
+// IterPrefix returns an Iterator over every item whose key starts with
+// prefix, ordered by key. The Iterator walks a snapshot of the database taken
+// when IterPrefix is called, and must be closed.
+// Err is non-nil in case of failure.
+func (s *Store) IterPrefix(ctx context.Context, prefix string) (store.Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &iter{snap: snap, c: s.newCursor(snap, prefix, time.Now())}, nil
+}
+
+type iter struct {
+	snap *leveldb.Snapshot
+	c    *cursor
+}
+
+func (it *iter) Next(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if it.c.next() {
+		return true, nil
+	}
+	return false, it.c.err()
+}
+
+func (it *iter) Key() string {
+	return string(it.c.key())
+}
+
+func (it *iter) Value(v json.Unmarshaler) error {
+	return v.UnmarshalJSON(it.c.value())
+}
+
+func (it *iter) Close() error {
+	it.c.release()
+	it.snap.Release()
+	return nil
+}
+
+var (
+	_ store.Iterable       = (*Store)(nil)
+	_ store.PrefixIterable = (*Store)(nil)
+)
@@ -0,0 +1,89 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	err = s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) error {
+		value, _, err := s.get(snap, dk, now)
+		ok = value != nil
+		return err
+	})
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) error {
+		return s.each(snap, prefix, now, func(k, _ []byte) (bool, error) {
+			ks = append(ks, string(k))
+			return true, nil
+		})
+	})
+	return ks, err
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) error {
+		return s.each(snap, prefix, now, func(_, _ []byte) (bool, error) {
+			n++
+			return true, nil
+		})
+	})
+	return n, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	return s.update(ctx, func(b *leveldb.Batch, now time.Time) error {
+		// The expired keys are removed too, along with their deadline.
+		it := s.db.NewIterator(util.BytesPrefix([]byte(s.prefix+prefix)), nil)
+		defer it.Release()
+		for it.Next() {
+			if bytes.HasPrefix(it.Key(), []byte(reserved)) {
+				continue
+			}
+			if _, err := s.remove(b, it.Key(), now); err != nil {
+				return err
+			}
+		}
+		return it.Error()
+	})
+}
+
+var (
+	_ store.Exister   = (*Store)(nil)
+	_ store.KeyLister = (*Store)(nil)
+	_ store.Sizer     = (*Store)(nil)
+	_ store.Clearer   = (*Store)(nil)
+)
@@ -0,0 +1,459 @@
+/*
+Package leveldb implements store.Store on top of a goleveldb database, for
+the consumers migrating legacy LevelDB data behind the Store interface.
+
+The values are kept verbatim under their key, optionally prefixed, so that a
+database already holding JSON values is served as is. LevelDB has no native
+expiration: the deadlines are kept in an index in a reserved key range, the
+expired keys are hidden on read, and a sweep goroutine removes them
+periodically.
+
+The keys of the reserved range, starting with the bytes "\xffgokv.ttl\x00",
+can not be assigned. Update keeps the expiration of the key.
+*/
+package leveldb // import "github.com/gokv/store/leveldb"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// keys, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+// reserved is the start of the keys of the expiration index. It is followed
+// by 'k' and a key for the deadline of that key, and by 'x', the deadline and
+// the key for the entries walked by the sweeps.
+const reserved = "\xffgokv.ttl\x00"
+
+var errReserved = errors.New("leveldb: key in the reserved range")
+
+// Store is a store.Store backed by a goleveldb database.
+type Store struct {
+	db     *leveldb.DB
+	prefix string
+
+	// mu serializes the writes, which read the current state of the keys.
+	mu sync.Mutex
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store keeping the items in db, each key prefixed with prefix.
+// Closing the Store closes db.
+func New(db *leveldb.DB, prefix string) *Store {
+	return NewWithSweepInterval(db, prefix, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired keys.
+func NewWithSweepInterval(db *leveldb.DB, prefix string, interval time.Duration) *Store {
+	s := &Store{
+		db:     db,
+		prefix: prefix,
+		stop:   make(chan struct{}),
+	}
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_ = s.sweep(now)
+		}
+	}
+}
+
+// sweep removes every key expired at now.
+func (s *Store) sweep(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b leveldb.Batch
+	it := s.db.NewIterator(util.BytesPrefix([]byte(reserved+"x")), nil)
+	for it.Next() {
+		deadline, k := splitExpiryKey(it.Key())
+		if deadline > now.UnixNano() {
+			break
+		}
+		d, err := s.deadline(s.db, k)
+		if err != nil {
+			it.Release()
+			return err
+		}
+		if d == deadline {
+			b.Delete(k)
+			b.Delete(ttlKey(k))
+		}
+		b.Delete(it.Key())
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return s.db.Write(&b, nil)
+}
+
+// The keys of the deadlines are 'k' and the key in the reserved range. Their
+// value is the deadline in nanoseconds since the Unix epoch, as a big-endian
+// int64.
+func ttlKey(k []byte) []byte {
+	return append([]byte(reserved+"k"), k...)
+}
+
+// The keys of the entries walked by the sweeps are 'x', the deadline as a
+// big-endian int64 and the key in the reserved range, so that the iterator
+// walks them by expiration.
+func expiryKey(deadline int64, k []byte) []byte {
+	xk := make([]byte, len(reserved)+9+len(k))
+	n := copy(xk, reserved+"x")
+	binary.BigEndian.PutUint64(xk[n:], uint64(deadline))
+	copy(xk[n+8:], k)
+	return xk
+}
+
+func splitExpiryKey(xk []byte) (deadline int64, k []byte) {
+	xk = xk[len(reserved)+1:]
+	return int64(binary.BigEndian.Uint64(xk)), append([]byte(nil), xk[8:]...)
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	if n := t.UnixNano(); n != 0 {
+		return n
+	}
+	// Zero means no expiration.
+	return 1
+}
+
+func expired(deadline int64, now time.Time) bool {
+	return deadline != 0 && deadline <= now.UnixNano()
+}
+
+// key returns the database key of k.
+func (s *Store) key(k string) ([]byte, error) {
+	dk := []byte(s.prefix + k)
+	if bytes.HasPrefix(dk, []byte(reserved)) {
+		return nil, errReserved
+	}
+	return dk, nil
+}
+
+// deadline returns the deadline of the database key k, zero if it does
+// not expire.
+func (s *Store) deadline(r getter, k []byte) (int64, error) {
+	d, err := r.Get(ttlKey(k), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(d)), nil
+}
+
+// getter is implemented by leveldb.DB and leveldb.Snapshot.
+type getter interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+// get returns the value and the deadline of the database key k. The value
+// is nil if k was not found or expired.
+func (s *Store) get(r getter, k []byte, now time.Time) (value []byte, deadline int64, err error) {
+	value, err = r.Get(k, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if deadline, err = s.deadline(r, k); err != nil || expired(deadline, now) {
+		return nil, 0, err
+	}
+	return value, deadline, nil
+}
+
+// put adds to b the writes assigning value to the database key k, with the
+// given deadline. It must be called holding mu.
+func (s *Store) put(b *leveldb.Batch, k, value []byte, deadline int64) error {
+	old, err := s.deadline(s.db, k)
+	if err != nil {
+		return err
+	}
+	if old != 0 && old != deadline {
+		b.Delete(expiryKey(old, k))
+	}
+	if deadline == 0 {
+		if old != 0 {
+			b.Delete(ttlKey(k))
+		}
+	} else {
+		d := make([]byte, 8)
+		binary.BigEndian.PutUint64(d, uint64(deadline))
+		b.Put(ttlKey(k), d)
+		b.Put(expiryKey(deadline, k), nil)
+	}
+	b.Put(k, value)
+	return nil
+}
+
+// remove adds to b the writes deleting the database key k. Ok is false if k
+// was not found or expired. It must be called holding mu.
+func (s *Store) remove(b *leveldb.Batch, k []byte, now time.Time) (bool, error) {
+	ok, err := s.db.Has(k, nil)
+	if err != nil || !ok {
+		return false, err
+	}
+	deadline, err := s.deadline(s.db, k)
+	if err != nil {
+		return false, err
+	}
+	if deadline != 0 {
+		b.Delete(ttlKey(k))
+		b.Delete(expiryKey(deadline, k))
+	}
+	b.Delete(k)
+	return !expired(deadline, now), nil
+}
+
+// view runs fn on a snapshot of the database.
+func (s *Store) view(ctx context.Context, fn func(snap *leveldb.Snapshot, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	return fn(snap, time.Now())
+}
+
+// update runs fn holding mu, and writes the batch it filled.
+func (s *Store) update(ctx context.Context, fn func(b *leveldb.Batch, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b leveldb.Batch
+	if err := fn(&b, time.Now()); err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return s.db.Write(&b, nil)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	var value []byte
+	err = s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) (err error) {
+		value, _, err = s.get(snap, dk, now)
+		return err
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key. The items
+// are read from a snapshot of the database with an iterator.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) error {
+		return s.each(snap, "", now, func(_, value []byte) (bool, error) {
+			if limit == 0 {
+				return false, nil
+			}
+			if offset > 0 {
+				offset--
+				return true, nil
+			}
+			limit--
+			return true, c.New().UnmarshalJSON(value)
+		})
+	})
+}
+
+// each calls fn with the key, without the store prefix, and the value of
+// every item of snap whose key starts with prefix and which has not expired,
+// in ascending key order, until fn returns false. The arguments of fn are
+// only valid until it returns.
+func (s *Store) each(snap *leveldb.Snapshot, prefix string, now time.Time, fn func(k, value []byte) (bool, error)) error {
+	c := s.newCursor(snap, prefix, now)
+	defer c.release()
+	for c.next() {
+		more, err := fn(c.key(), c.value())
+		if err != nil || !more {
+			return err
+		}
+	}
+	return c.err()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.update(ctx, func(b *leveldb.Batch, now time.Time) error {
+		for {
+			r := make([]byte, 16)
+			if _, err := rand.Read(r); err != nil {
+				return err
+			}
+			k = hex.EncodeToString(r)
+			dk, err := s.key(k)
+			if err != nil {
+				return err
+			}
+			cur, _, err := s.get(s.db, dk, now)
+			if err != nil {
+				return err
+			}
+			if cur == nil {
+				return s.put(b, dk, value, 0)
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.set(ctx, k, v, unixNano(time.Now().Add(timeout)))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.set(ctx, k, v, unixNano(deadline))
+}
+
+func (s *Store) set(ctx context.Context, k string, v json.Marshaler, deadline int64) error {
+	dk, err := s.key(k)
+	if err != nil {
+		return err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, func(b *leveldb.Batch, _ time.Time) error {
+		return s.put(b, dk, value, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(b *leveldb.Batch, now time.Time) error {
+		cur, deadline, err := s.get(s.db, dk, now)
+		if ok = cur != nil; err != nil || !ok {
+			return err
+		}
+		return s.put(b, dk, value, deadline)
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(b *leveldb.Batch, now time.Time) error {
+		ok, err = s.remove(b, dk, now)
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if the database is closed or can not be read.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.view(ctx, func(*leveldb.Snapshot, time.Time) error {
+		return nil
+	})
+}
+
+// Close stops the sweep goroutine and closes the database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	return s.db.Close()
+}
+
+var (
+	_ store.Store = (*Store)(nil)
+	_ store.Pager = (*Store)(nil)
+)
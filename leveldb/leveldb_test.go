@@ -0,0 +1,27 @@
+package leveldb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/leveldb"
+	"github.com/gokv/store/storetest"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// newStore opens an empty Store in a new in-memory database.
+func newStore() store.Store {
+	db, err := goleveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return leveldb.NewWithSweepInterval(db, "", 100*time.Millisecond)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,56 @@
+package leveldb
+
+import (
+	"context"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (ttl time.Duration, ok bool, err error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return 0, false, err
+	}
+	err = s.view(ctx, func(snap *leveldb.Snapshot, now time.Time) error {
+		value, deadline, err := s.get(snap, dk, now)
+		if ok = value != nil; err != nil || !ok {
+			return err
+		}
+		if deadline != 0 {
+			ttl = time.Unix(0, deadline).Sub(now)
+		}
+		return nil
+	})
+	return ttl, ok, err
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	dk, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(b *leveldb.Batch, now time.Time) error {
+		value, _, err := s.get(s.db, dk, now)
+		if ok = value != nil; err != nil || !ok {
+			return err
+		}
+		if timeout <= 0 {
+			_, err := s.remove(b, dk, now)
+			return err
+		}
+		return s.put(b, dk, value, unixNano(now.Add(timeout)))
+	})
+	return ok, err
+}
+
+var _ store.TTLStore = (*Store)(nil)
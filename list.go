@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ListStore defines methods for keys holding a list of values, for
+// queue-like and feed-like use cases which would otherwise
+// read-modify-write a whole JSON array.
+//
+// The list positions are zero-based. Negative positions count from the end
+// of the list: -1 is the last element, -2 the one before, and so on. The
+// ranges include both start and stop; the positions out of the list are
+// ignored.
+type ListStore interface {
+
+	// Append adds the given values at the end of the list held by the given
+	// key, and returns the new length of the list. A missing key is
+	// considered to hold an empty list.
+	// Err is non-nil in case of failure.
+	Append(ctx context.Context, k string, vs ...json.Marshaler) (length int, err error)
+
+	// Range unmarshals to c the elements of the list held by the given key,
+	// from position start to position stop.
+	// Err is non-nil in case of failure.
+	Range(ctx context.Context, k string, start, stop int, c Collection) error
+
+	// Trim removes from the list held by the given key every element not
+	// between position start and position stop.
+	// Err is non-nil in case of failure.
+	Trim(ctx context.Context, k string, start, stop int) error
+}
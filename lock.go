@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Locker defines a method for acquiring named distributed locks.
+type Locker interface {
+
+	// Lock blocks until the lock with the given name is acquired, or until
+	// ctx is done. The lock is released after ttl, unless it is released
+	// earlier with Unlock.
+	// Err is non-nil in case of failure, or if ctx is done before the lock is
+	// acquired.
+	Lock(ctx context.Context, name string, ttl time.Duration) (Unlocker, error)
+}
+
+// Unlocker releases a lock acquired with a Locker.
+type Unlocker interface {
+
+	// Unlock releases the lock.
+	// Ok is false if the lock had expired and was acquired by someone else in
+	// the meantime.
+	// Err is non-nil in case of failure.
+	Unlock(ctx context.Context) (ok bool, err error)
+}
+
+// LockStore groups the methods NewLocker builds upon.
+type LockStore interface {
+	Store
+	GetOrSetter
+	CompareAndSetter
+}
+
+// NewLocker returns a Locker keeping the locks in s, under keys named after
+// the locks. While a lock is held by someone else, Lock retries every retry
+// interval.
+//
+// A lock key is created with GetOrSet, or taken over with CompareAndSet
+// once expired, and then assigned the lock deadline with SetWithDeadline,
+// so that s clears the keys of the locks which are never released. The
+// deadline is also recorded in the stored value and checked against the
+// local clock, so the clocks of the lock users are expected to be
+// reasonably synchronized. Unlock marks the lock as released with
+// CompareAndSet, then deletes its key.
+func NewLocker(s LockStore, retry time.Duration) Locker {
+	return locker{s: s, retry: retry}
+}
+
+type locker struct {
+	s     LockStore
+	retry time.Duration
+}
+
+func (l locker) Lock(ctx context.Context, name string, ttl time.Duration) (Unlocker, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		deadline := time.Now().Add(ttl)
+		mine, err := json.Marshal(lockState{
+			Token:    token,
+			Deadline: deadline,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var current json.RawMessage
+		loaded, err := l.s.GetOrSet(ctx, name, json.RawMessage(mine), &current)
+		if err != nil {
+			return nil, err
+		}
+		acquired := !loaded
+		if loaded {
+			var cs lockState
+			if err := json.Unmarshal(current, &cs); err != nil {
+				return nil, err
+			}
+			if time.Now().After(cs.Deadline) {
+				if acquired, err = l.s.CompareAndSet(ctx, name, current, json.RawMessage(mine)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if acquired {
+			// Nobody else writes the key before the deadline.
+			if err := l.s.SetWithDeadline(ctx, name, json.RawMessage(mine), deadline); err != nil {
+				return nil, err
+			}
+			return unlocker{s: l.s, name: name, token: token, deadline: deadline, state: mine}, nil
+		}
+
+		t := time.NewTimer(l.retry)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+type unlocker struct {
+	s        LockStore
+	name     string
+	token    string
+	deadline time.Time
+
+	// state is the stored value of the lock, as written when acquired.
+	state json.RawMessage
+}
+
+// Unlock replaces the value of the lock with a tombstone, if it is still
+// held, then deletes the key. The tombstone keeps the lock deadline, so
+// that the lock is not taken over between the two calls.
+func (u unlocker) Unlock(ctx context.Context) (bool, error) {
+	released, err := json.Marshal(lockState{
+		Token:    u.token,
+		Deadline: u.deadline,
+		Released: true,
+	})
+	if err != nil {
+		return false, err
+	}
+	ok, err := u.s.CompareAndSet(ctx, u.name, u.state, json.RawMessage(released))
+	if err != nil || !ok {
+		return false, err
+	}
+	if time.Now().After(u.deadline) {
+		// The lock may already be taken over: the tombstone clears with
+		// its deadline.
+		return true, nil
+	}
+	if _, err := u.s.Delete(ctx, u.name); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// lockState is the value of a lock key.
+type lockState struct {
+	Token    string    `json:"token"`
+	Deadline time.Time `json:"deadline"`
+
+	// Released is true for the tombstone written by Unlock before the key
+	// is deleted.
+	Released bool `json:"released,omitempty"`
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,7 @@
+module github.com/gokv/store/memcache
+
+go 1.26.7
+
+require github.com/gokv/store v0.1.0
+
+require github.com/bradfitz/gomemcache v0.0.0-20260422231931-4d751bb6e37c
@@ -0,0 +1,231 @@
+/*
+Package memcache implements store.Store on top of memcached, with the
+gomemcache client.
+
+The expirations map to the native memcached expirations, with a one second
+resolution, rounded up so that the keys never clear early. The deadline of
+each item is also kept in its flags, so that Update can keep the expiration
+of the key: Update is a check-and-set retried on concurrent writes.
+
+Memcached can not enumerate its keys: GetAll returns an error wrapping
+store.ErrNotSupported. The keys must be valid memcached keys, at most 250
+bytes without spaces nor control characters; the other keys are rejected
+with an error. As memcached is a cache, the items may also be evicted before
+their deadline.
+*/
+package memcache // import "github.com/gokv/store/memcache"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	gomemcache "github.com/bradfitz/gomemcache/memcache"
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store backed by memcached.
+type Store struct {
+	c *gomemcache.Client
+}
+
+// New returns a Store using c. Closing the Store closes c.
+func New(c *gomemcache.Client) *Store {
+	return &Store{c: c}
+}
+
+// expiration returns the memcached expiration of deadline, as an absolute
+// Unix timestamp rounded up to the second, or zero for no expiration.
+func expiration(deadline time.Time) int32 {
+	if deadline.IsZero() {
+		return 0
+	}
+	e := deadline.Unix()
+	if deadline.Nanosecond() > 0 {
+		e++
+	}
+	return int32(e)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	item, err := s.c.Get(k)
+	if err == gomemcache.ErrCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(item.Value)
+}
+
+// GetAll is not supported by memcached.
+// Err wraps store.ErrNotSupported.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return fmt.Errorf("memcache: GetAll: %w", store.ErrNotSupported)
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		err := s.c.Add(&gomemcache.Item{Key: k, Value: value})
+		if err == nil {
+			return k, nil
+		}
+		if err != gomemcache.ErrNotStored {
+			return "", err
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		if err := s.c.Delete(k); err != nil && err != gomemcache.ErrCacheMiss {
+			return err
+		}
+		return nil
+	}
+	e := expiration(deadline)
+	return s.c.Set(&gomemcache.Item{Key: k, Value: value, Flags: uint32(e), Expiration: e})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		item, err := s.c.Get(k)
+		if err == gomemcache.ErrCacheMiss {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		item.Value = value
+		item.Expiration = int32(item.Flags)
+		switch err := s.c.CompareAndSwap(item); {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, gomemcache.ErrNotStored):
+			// The key was deleted or expired since it was read.
+			return false, nil
+		case !errors.Is(err, gomemcache.ErrCASConflict):
+			return false, err
+		}
+	}
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	err := s.c.Delete(k)
+	if err == gomemcache.ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Ping returns a non-nil error if a memcached server can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.c.Ping()
+}
+
+// Close closes the connections of the client.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.c.Close()
+}
+
+// GetMulti retrieves the values of the given keys with a single request per
+// server, and unmarshals each of them to the element of vs with the same
+// index.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMulti(ctx context.Context, ks []string, vs []json.Unmarshaler) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	items, err := s.c.GetMulti(ks)
+	if err != nil {
+		return nil, err
+	}
+	ok := make([]bool, len(ks))
+	for i, k := range ks {
+		item, found := items[k]
+		if !found {
+			continue
+		}
+		if err := vs[i].UnmarshalJSON(item.Value); err != nil {
+			return nil, err
+		}
+		ok[i] = true
+	}
+	return ok, nil
+}
+
+var (
+	_ store.Store       = (*Store)(nil)
+	_ store.MultiGetter = (*Store)(nil)
+)
@@ -0,0 +1,35 @@
+package memcache_test
+
+import (
+	"os"
+	"testing"
+
+	gomemcache "github.com/bradfitz/gomemcache/memcache"
+	"github.com/gokv/store"
+	"github.com/gokv/store/memcache"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function connecting an empty Store to the memcached
+// server at the address of the GOKV_MEMCACHE_ADDR environment variable, e.g.
+// localhost:11211, which is flushed every time. The test is skipped if it is
+// not set.
+func newStore(tb testing.TB) func() store.Store {
+	addr := os.Getenv("GOKV_MEMCACHE_ADDR")
+	if addr == "" {
+		tb.Skip("GOKV_MEMCACHE_ADDR is not set")
+	}
+	return func() store.Store {
+		c := gomemcache.New(addr)
+		if err := c.DeleteAll(); err != nil {
+			panic(err)
+		}
+		return memcache.New(c)
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
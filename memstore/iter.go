@@ -0,0 +1,67 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Iter returns an Iterator over every item in the store, ordered by key. The
+// Iterator walks a snapshot of the store taken when Iter is called.
+// Err is non-nil in case of failure.
+func (s *Store) Iter(ctx context.Context) (store.Iterator, error) {
+	return s.IterPrefix(ctx, "")
+}
+
+// IterPrefix returns an Iterator over every item whose key starts with
+// prefix, ordered by key. The Iterator walks a snapshot of the store taken
+// when IterPrefix is called.
+// Err is non-nil in case of failure.
+func (s *Store) IterPrefix(ctx context.Context, prefix string) (store.Iterator, error) {
+	it := &iterator{pos: -1}
+	err := s.read(ctx, func(now time.Time) error {
+		it.keys = s.sortedKeys(prefix, now)
+		it.values = make([][]byte, len(it.keys))
+		for i, k := range it.keys {
+			it.values[i] = s.items[k].value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+type iterator struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+func (it *iterator) Next(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if it.pos+1 >= len(it.keys) {
+		it.pos = len(it.keys)
+		return false, nil
+	}
+	it.pos++
+	return true, nil
+}
+
+func (it *iterator) Key() string {
+	return it.keys[it.pos]
+}
+
+func (it *iterator) Value(v json.Unmarshaler) error {
+	return unmarshal(it.values[it.pos], v)
+}
+
+func (it *iterator) Close() error {
+	it.keys, it.values = nil, nil
+	return nil
+}
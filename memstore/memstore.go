@@ -0,0 +1,680 @@
+/*
+Package memstore provides a concurrency-safe, in-memory implementation of
+store.Store.
+
+Besides the Store methods, it implements most of the optional interfaces
+defined in package store. It serves both as a reference for the
+implementations and as a drop-in store for unit tests.
+
+The values are kept in their JSON encoding. The expired keys are removed
+lazily; they are never returned.
+
+Set, SetWithTimeout and SetWithDeadline replace the expiration of the key,
+while Update and Incr keep it.
+*/
+package memstore // import "github.com/gokv/store/memstore"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// sweepInterval is the minimum interval between two removals of every
+// expired key.
+const sweepInterval = time.Minute
+
+var errClosed = errors.New("memstore: closed")
+
+// Store is an in-memory store.Store. The zero value is not usable; use New.
+type Store struct {
+	mu      sync.RWMutex
+	items   map[string]item
+	version uint64
+	swept   time.Time
+	closed  bool
+}
+
+type item struct {
+	value     []byte
+	deadline  time.Time // zero if the key does not expire
+	version   uint64
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+func (it item) expired(now time.Time) bool {
+	return !it.deadline.IsZero() && !now.Before(it.deadline)
+}
+
+// New returns an empty Store.
+func New() *Store {
+	return &Store{
+		items: make(map[string]item),
+		swept: time.Now(),
+	}
+}
+
+// read runs fn holding the read lock, after checking ctx and the state of the
+// store.
+func (s *Store) read(ctx context.Context, fn func(now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errClosed
+	}
+	return fn(time.Now())
+}
+
+// write runs fn holding the write lock, after checking ctx and the state of
+// the store.
+func (s *Store) write(ctx context.Context, fn func(now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		s.sweep(now)
+	}
+	return fn(now)
+}
+
+// sweep removes every expired key. It must be called holding the write lock.
+func (s *Store) sweep(now time.Time) {
+	for k, it := range s.items {
+		if it.expired(now) {
+			delete(s.items, k)
+		}
+	}
+	s.swept = now
+}
+
+// lookup returns the item of the given key, if it is found and has not
+// expired. It must be called holding the lock.
+func (s *Store) lookup(k string, now time.Time) (item, bool) {
+	it, ok := s.items[k]
+	if !ok || it.expired(now) {
+		return item{}, false
+	}
+	return it, true
+}
+
+// put assigns value to the given key. It must be called holding the write
+// lock.
+func (s *Store) put(k string, value []byte, deadline time.Time, now time.Time) {
+	s.version++
+	it, ok := s.lookup(k, now)
+	if !ok {
+		it.createdAt = now
+	}
+	it.value = value
+	it.deadline = deadline
+	it.version = s.version
+	it.updatedAt = now
+	s.items[k] = it
+}
+
+// sortedKeys returns the keys starting with prefix which have not expired,
+// in ascending order. It must be called holding the lock.
+func (s *Store) sortedKeys(prefix string, now time.Time) []string {
+	ks := make([]string, 0, len(s.items))
+	for k, it := range s.items {
+		if strings.HasPrefix(k, prefix) && !it.expired(now) {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// unmarshal passes a copy of the stored value to v, so that v can retain
+// it.
+func unmarshal(value []byte, v json.Unmarshaler) error {
+	return v.UnmarshalJSON(append([]byte(nil), value...))
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	var value []byte
+	err = s.read(ctx, func(now time.Time) error {
+		var it item
+		it, ok = s.lookup(k, now)
+		value = it.value
+		return nil
+	})
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, unmarshal(value, v)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	var values [][]byte
+	err := s.read(ctx, func(now time.Time) error {
+		ks := s.sortedKeys("", now)
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > len(ks) {
+			offset = len(ks)
+		}
+		ks = ks[offset:]
+		if limit >= 0 && limit < len(ks) {
+			ks = ks[:limit]
+		}
+		values = make([][]byte, len(ks))
+		for i, k := range ks {
+			values[i] = s.items[k].value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := unmarshal(value, c.New()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		for {
+			if k, err = newKey(); err != nil {
+				return err
+			}
+			if _, ok := s.lookup(k, now); !ok {
+				break
+			}
+		}
+		s.put(k, value, time.Time{}, now)
+		return nil
+	})
+	return k, err
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline. A zero deadline
+// means that the key does not expire.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, func(now time.Time) error {
+		s.put(k, value, deadline, now)
+		return nil
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		var it item
+		if it, ok = s.lookup(k, now); ok {
+			s.put(k, value, it.deadline, now)
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		_, ok = s.lookup(k, now)
+		delete(s.items, k)
+		return nil
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if the Store is closed or if ctx is done.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.read(ctx, func(time.Time) error { return nil })
+}
+
+// Close discards every item. Any further operation returns an error.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = nil
+	s.closed = true
+	return nil
+}
+
+// GetMulti retrieves the values of the given keys and unmarshals each of
+// them to the element of vs with the same index.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMulti(ctx context.Context, ks []string, vs []json.Unmarshaler) (ok []bool, err error) {
+	if len(ks) != len(vs) {
+		return nil, fmt.Errorf("memstore: %d keys for %d values", len(ks), len(vs))
+	}
+	values := make([][]byte, len(ks))
+	ok = make([]bool, len(ks))
+	err = s.read(ctx, func(now time.Time) error {
+		for i, k := range ks {
+			var it item
+			it, ok[i] = s.lookup(k, now)
+			values[i] = it.value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range ks {
+		if ok[i] {
+			if err := unmarshal(values[i], vs[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ok, nil
+}
+
+// SetMulti atomically assigns each element of vs to the key of ks with the
+// same index.
+// Err is non-nil in case of failure.
+func (s *Store) SetMulti(ctx context.Context, ks []string, vs []json.Marshaler) error {
+	if len(ks) != len(vs) {
+		return fmt.Errorf("memstore: %d keys for %d values", len(ks), len(vs))
+	}
+	values := make([][]byte, len(vs))
+	for i, v := range vs {
+		var err error
+		if values[i], err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	return s.write(ctx, func(now time.Time) error {
+		for i, k := range ks {
+			s.put(k, values[i], time.Time{}, now)
+		}
+		return nil
+	})
+}
+
+// DeleteMulti atomically removes the given keys and their values from the
+// store.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) DeleteMulti(ctx context.Context, ks []string) (ok []bool, err error) {
+	ok = make([]bool, len(ks))
+	err = s.write(ctx, func(now time.Time) error {
+		for i, k := range ks {
+			_, ok[i] = s.lookup(k, now)
+			delete(s.items, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ok, nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		_, ok = s.lookup(k, now)
+		return nil
+	})
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) (ks []string, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		ks = s.sortedKeys(prefix, now)
+		return nil
+	})
+	return ks, err
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		for k, it := range s.items {
+			if strings.HasPrefix(k, prefix) && !it.expired(now) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.write(ctx, func(time.Time) error {
+		s.items = make(map[string]item)
+		return nil
+	})
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	return s.write(ctx, func(time.Time) error {
+		for k := range s.items {
+			if strings.HasPrefix(k, prefix) {
+				delete(s.items, k)
+			}
+		}
+		return nil
+	})
+}
+
+// Query unmarshals to c every item in the store matching f, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) Query(ctx context.Context, f store.Filter, c store.Collection) error {
+	var values [][]byte
+	err := s.read(ctx, func(now time.Time) error {
+		for _, k := range s.sortedKeys("", now) {
+			value := s.items[k].value
+			ok, err := f.Match(value)
+			if err != nil {
+				return err
+			}
+			if ok {
+				values = append(values, value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := unmarshal(value, c.New()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old. The expiration of the key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (ok bool, err error) {
+	oldValue, err := json.Marshal(old)
+	if err != nil {
+		return false, err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	err = s.write(ctx, func(now time.Time) error {
+		it, found := s.lookup(k, now)
+		if ok = found && bytes.Equal(it.value, oldValue); ok {
+			s.put(k, value, it.deadline, now)
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// Patch applies the JSON Merge Patch (RFC 7396) patch to the value of the
+// given key, atomically. The expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Patch(ctx context.Context, k string, patch json.RawMessage) (ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		it, found := s.lookup(k, now)
+		if !found {
+			return nil
+		}
+		value, err := store.MergePatch(it.value, patch)
+		if err != nil {
+			return err
+		}
+		s.put(k, value, it.deadline, now)
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+// JSONPatch applies the JSON Patch (RFC 6902) ops to the value of the given
+// key, atomically, and returns the version of the result. The expiration of
+// the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure. It wraps store.ErrConflict if a "test"
+// operation failed.
+func (s *Store) JSONPatch(ctx context.Context, k string, ops []store.Operation) (version string, ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		it, found := s.lookup(k, now)
+		if !found {
+			return nil
+		}
+		value, err := store.ApplyJSONPatch(it.value, ops)
+		if err != nil {
+			return err
+		}
+		s.put(k, value, it.deadline, now)
+		version, ok = strconv.FormatUint(s.version, 10), true
+		return nil
+	})
+	return version, ok, err
+}
+
+// GetOrSet assigns v to the given key if it does not exist. Otherwise it
+// retrieves the current value and unmarshals it to current.
+// Loaded is true if the key existed, and false if v was assigned.
+// Err is non-nil in case of failure.
+func (s *Store) GetOrSet(ctx context.Context, k string, v json.Marshaler, current json.Unmarshaler) (loaded bool, err error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	var existing []byte
+	err = s.write(ctx, func(now time.Time) error {
+		var it item
+		if it, loaded = s.lookup(k, now); loaded {
+			existing = it.value
+		} else {
+			s.put(k, value, time.Time{}, now)
+		}
+		return nil
+	})
+	if err != nil || !loaded {
+		return false, err
+	}
+	return true, unmarshal(existing, current)
+}
+
+// GetAndDelete retrieves the value of the given key, unmarshals it to v and
+// removes the key from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetAndDelete(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	var value []byte
+	err = s.write(ctx, func(now time.Time) error {
+		var it item
+		it, ok = s.lookup(k, now)
+		value = it.value
+		delete(s.items, k)
+		return nil
+	})
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, unmarshal(value, v)
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (ttl time.Duration, ok bool, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		var it item
+		if it, ok = s.lookup(k, now); ok && !it.deadline.IsZero() {
+			ttl = it.deadline.Sub(now)
+		}
+		return nil
+	})
+	return ttl, ok, err
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		var it item
+		if it, ok = s.lookup(k, now); ok {
+			it.deadline = now.Add(timeout)
+			s.items[k] = it
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// Incr atomically adds delta to the integer value of the given key and
+// returns the result. A missing key is considered to hold zero. The
+// expiration of the key is kept.
+// Err is non-nil in case of failure, including when the current value is
+// not an integer.
+func (s *Store) Incr(ctx context.Context, k string, delta int64) (n int64, err error) {
+	err = s.write(ctx, func(now time.Time) error {
+		it, ok := s.lookup(k, now)
+		if ok {
+			var err error
+			if n, err = strconv.ParseInt(string(it.value), 10, 64); err != nil {
+				return fmt.Errorf("memstore: value of %q is not an integer", k)
+			}
+		}
+		n += delta
+		s.put(k, strconv.AppendInt(nil, n, 10), it.deadline, now)
+		return nil
+	})
+	return n, err
+}
+
+// GetMeta retrieves the metadata of the given key. The version is a
+// decimal number, increasing with every write to the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (m store.Meta, ok bool, err error) {
+	err = s.read(ctx, func(now time.Time) error {
+		var it item
+		if it, ok = s.lookup(k, now); ok {
+			m = store.Meta{
+				Version:   strconv.FormatUint(it.version, 10),
+				CreatedAt: it.createdAt,
+				UpdatedAt: it.updatedAt,
+			}
+		}
+		return nil
+	})
+	return m, ok, err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Batch            = (*Store)(nil)
+	_ store.Iterable         = (*Store)(nil)
+	_ store.PrefixIterable   = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Querier          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.Sizer            = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Patcher          = (*Store)(nil)
+	_ store.JSONPatcher      = (*Store)(nil)
+	_ store.GetOrSetter      = (*Store)(nil)
+	_ store.GetAndDeleter    = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.Counter          = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+)
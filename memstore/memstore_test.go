@@ -0,0 +1,25 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+)
+
+func newStore() store.Store {
+	return memstore.New()
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, newStore)
+}
+
+func FuzzStore(f *testing.F) {
+	storetest.FuzzStore(f, newStore)
+}
+
+func BenchmarkStore(b *testing.B) {
+	storetest.BenchmarkStore(b, newStore)
+}
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Meta holds the metadata of an item. The fields an implementation cannot
+// provide are left to their zero value.
+type Meta struct {
+
+	// Version is an opaque token which changes every time the value is
+	// written (e.g. a revision number or an etag).
+	Version string
+
+	// CreatedAt is the time at which the key was first assigned.
+	CreatedAt time.Time
+
+	// UpdatedAt is the time at which the value was last written.
+	UpdatedAt time.Time
+}
+
+// MetaGetter defines a method for reading the metadata of an item without
+// embedding it in the value.
+type MetaGetter interface {
+
+	// GetMeta retrieves the metadata of the given key.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	GetMeta(ctx context.Context, k string) (m Meta, ok bool, err error)
+}
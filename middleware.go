@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Middleware wraps a Store to add a cross-cutting behaviour (e.g. logging,
+// metrics or retries).
+type Middleware func(Store) Store
+
+// Chain returns a Middleware applying every given Middleware, the first one
+// being the outermost.
+func Chain(mws ...Middleware) Middleware {
+	return func(s Store) Store {
+		for i := len(mws) - 1; i >= 0; i-- {
+			s = mws[i](s)
+		}
+		return s
+	}
+}
+
+// Wrapper delegates every Store method to the wrapped Store. It is meant to
+// be embedded in the wrapper types, which then only define the methods
+// they alter.
+type Wrapper struct {
+	Store Store
+}
+
+// Unwrap returns the wrapped Store.
+func (w Wrapper) Unwrap() Store {
+	return w.Store
+}
+
+// Get calls Get on the wrapped Store.
+func (w Wrapper) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	return w.Store.Get(ctx, k, v)
+}
+
+// GetAll calls GetAll on the wrapped Store.
+func (w Wrapper) GetAll(ctx context.Context, c Collection) error {
+	return w.Store.GetAll(ctx, c)
+}
+
+// Add calls Add on the wrapped Store.
+func (w Wrapper) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	return w.Store.Add(ctx, v)
+}
+
+// Set calls Set on the wrapped Store.
+func (w Wrapper) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return w.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout calls SetWithTimeout on the wrapped Store.
+func (w Wrapper) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return w.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline calls SetWithDeadline on the wrapped Store.
+func (w Wrapper) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return w.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update calls Update on the wrapped Store.
+func (w Wrapper) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	return w.Store.Update(ctx, k, v)
+}
+
+// Delete calls Delete on the wrapped Store.
+func (w Wrapper) Delete(ctx context.Context, k string) (bool, error) {
+	return w.Store.Delete(ctx, k)
+}
+
+// Ping calls Ping on the wrapped Store.
+func (w Wrapper) Ping(ctx context.Context) error {
+	return w.Store.Ping(ctx)
+}
+
+// Close calls Close on the wrapped Store.
+func (w Wrapper) Close() error {
+	return w.Store.Close()
+}
@@ -0,0 +1,579 @@
+/*
+Package mongo implements store.Store on top of a MongoDB collection.
+
+Each key is the _id of a document, which holds the value in the "v" field,
+converted from JSON to BSON as relaxed extended JSON, so that the server can
+query, index and patch it, and the expiration in the "expireAt" date field.
+The values are retrieved as equivalent JSON rather than byte for byte: the
+numbers may be reformatted, and the objects which are extended JSON type
+wrappers, like {"$oid": ...}, are stored as the corresponding BSON types.
+WithStringValues stores the JSON encoding as a string instead. The TTL
+index created by EnsureIndexes lets the server delete the expired documents;
+as it only runs about once a minute, they are also hidden on read. The
+expirations have a millisecond resolution, rounded up so that the keys never
+clear early.
+
+Update and CompareAndSet are findOneAndReplace commands without upsert,
+conditioned on the current value and expiration, so that they report whether
+the key was found and keep its expiration. Patch is an update pipeline
+merging the patch on the server.
+*/
+package mongo // import "github.com/gokv/store/mongo"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Store is a store.Store backed by a MongoDB collection.
+type Store struct {
+	coll    *mongo.Collection
+	strings bool
+}
+
+// An Option configures a Store.
+type Option func(*Store)
+
+// WithStringValues stores the JSON encoding of the values as strings, so
+// that they are retrieved byte for byte. The server can then neither query
+// nor patch them. A collection must always be used with the same option.
+func WithStringValues() Option {
+	return func(s *Store) { s.strings = true }
+}
+
+// New returns a Store keeping the items in coll. See EnsureIndexes.
+func New(coll *mongo.Collection, opts ...Option) *Store {
+	s := &Store{coll: coll}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// EnsureIndexes creates the TTL index of coll on "expireAt", unless it
+// exists.
+func EnsureIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// document is the stored form of an item.
+type document struct {
+	K        string        `bson:"_id"`
+	V        bson.RawValue `bson:"v"`
+	ExpireAt *time.Time    `bson:"expireAt,omitempty"`
+}
+
+// encode returns the stored form of the JSON encoding of a value.
+func (s *Store) encode(value []byte) (bson.RawValue, error) {
+	if !json.Valid(value) {
+		return bson.RawValue{}, errors.New("mongo: invalid JSON value")
+	}
+	var doc bson.Raw
+	var err error
+	if s.strings {
+		doc, err = bson.Marshal(bson.D{{Key: "v", Value: string(value)}})
+	} else {
+		// Extended JSON is only parsed as a document: the value is
+		// wrapped in one.
+		err = bson.UnmarshalExtJSON(append(append([]byte(`{"v":`), value...), '}'), false, &doc)
+	}
+	if err != nil {
+		return bson.RawValue{}, fmt.Errorf("mongo: encoding value: %w", err)
+	}
+	return doc.Lookup("v"), nil
+}
+
+// decode returns the JSON encoding of a stored value.
+func (s *Store) decode(v bson.RawValue) ([]byte, error) {
+	if s.strings {
+		str, ok := v.StringValueOK()
+		if !ok {
+			return nil, fmt.Errorf("mongo: stored value of type %s is not a string", v.Type)
+		}
+		return []byte(str), nil
+	}
+	b, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("mongo: decoding value: %w", err)
+	}
+	var doc struct {
+		V json.RawMessage `json:"v"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("mongo: decoding value: %w", err)
+	}
+	return doc.V, nil
+}
+
+// expireAt returns the "expireAt" field of deadline, rounded up to the
+// millisecond, or nil if deadline is zero.
+func expireAt(deadline time.Time) *time.Time {
+	if deadline.IsZero() {
+		return nil
+	}
+	t := deadline.Truncate(time.Millisecond)
+	if t.Before(deadline) {
+		t = t.Add(time.Millisecond)
+	}
+	return &t
+}
+
+// notExpired is the filter element matching the documents which have not
+// expired.
+func notExpired() bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: "expireAt", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "expireAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}}},
+	}}
+}
+
+// live returns the filter matching k if it has not expired.
+func live(k string) bson.D {
+	return bson.D{{Key: "_id", Value: k}, notExpired()}
+}
+
+// find returns the document of k, or nil if it was not found or expired.
+func (s *Store) find(ctx context.Context, k string) (*document, error) {
+	var d document
+	err := s.coll.FindOne(ctx, live(k)).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// replaceIf assigns to k the value returned by next for its document,
+// keeping its expiration, if k is found and next reports true. The
+// replacement is conditioned on the document read, and retried if it
+// changed meanwhile.
+func (s *Store) replaceIf(ctx context.Context, k string, next func(cur *document) (value bson.RawValue, ok bool, err error)) (bool, error) {
+	for {
+		cur, err := s.find(ctx, k)
+		if err != nil || cur == nil {
+			return false, err
+		}
+		value, ok, err := next(cur)
+		if err != nil || !ok {
+			return false, err
+		}
+		// An equality filter on "v" would also match the arrays holding
+		// the value: it is compared with an expression.
+		filter := bson.D{{Key: "_id", Value: k}, {Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{
+			"$v", bson.D{{Key: "$literal", Value: cur.V}},
+		}}}}}
+		if cur.ExpireAt == nil {
+			filter = append(filter, bson.E{Key: "expireAt", Value: bson.D{{Key: "$exists", Value: false}}})
+		} else {
+			filter = append(filter, bson.E{Key: "expireAt", Value: *cur.ExpireAt})
+		}
+		err = s.coll.FindOneAndReplace(ctx, filter,
+			document{K: k, V: value, ExpireAt: cur.ExpireAt},
+			options.FindOneAndReplace().SetUpsert(false),
+		).Err()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	d, err := s.find(ctx, k)
+	if err != nil || d == nil {
+		return false, err
+	}
+	value, err := s.decode(d.V)
+	if err != nil {
+		return true, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	if limit == 0 {
+		return nil
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := s.coll.Find(ctx, bson.D{notExpired()}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var d document
+		if err := cur.Decode(&d); err != nil {
+			return err
+		}
+		value, err := s.decode(d.V)
+		if err != nil {
+			return err
+		}
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	value, err := s.encode(b)
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		_, err := s.coll.InsertOne(ctx, document{K: k, V: value})
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return k, nil
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	value, err := s.encode(b)
+	if err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		_, err := s.coll.DeleteOne(ctx, bson.D{{Key: "_id", Value: k}})
+		return err
+	}
+	_, err = s.coll.ReplaceOne(ctx, bson.D{{Key: "_id", Value: k}},
+		document{K: k, V: value, ExpireAt: expireAt(deadline)},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := s.encode(b)
+	if err != nil {
+		return false, err
+	}
+	return s.replaceIf(ctx, k, func(*document) (bson.RawValue, bool, error) {
+		return value, true, nil
+	})
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	// The expired documents are deleted too, but not reported.
+	var d document
+	err := s.coll.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: k}}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return d.ExpireAt == nil || d.ExpireAt.After(time.Now()), nil
+}
+
+// Ping returns a non-nil error if the server can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.coll.Database().Client().Ping(ctx, nil)
+}
+
+// Close is a no-op: the client is owned by the caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	n, err := s.coll.CountDocuments(ctx, live(k), options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, bson.D{notExpired()}, func(k string) { ks = append(ks, k) })
+	return ks, err
+}
+
+// scan calls fn with every key starting with prefix whose document also
+// matches filter, in ascending order. The keys are read from _id, walked
+// from prefix until the first key without it.
+func (s *Store) scan(ctx context.Context, prefix string, filter bson.D, fn func(k string)) error {
+	filter = append(bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: prefix}}}}, filter...)
+	cur, err := s.coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var d struct {
+			K string `bson:"_id"`
+		}
+		if err := cur.Decode(&d); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(d.K, prefix) {
+			break
+		}
+		fn(d.K)
+	}
+	return cur.Err()
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	_, err := s.coll.DeleteMany(ctx, bson.D{})
+	return err
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	var ks []string
+	if err := s.scan(ctx, prefix, nil, func(k string) { ks = append(ks, k) }); err != nil {
+		return err
+	}
+	if len(ks) == 0 {
+		return nil
+	}
+	_, err := s.coll.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ks}}}})
+	return err
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	d, err := s.find(ctx, k)
+	if err != nil || d == nil {
+		return 0, false, err
+	}
+	if d.ExpireAt == nil {
+		return 0, true, nil
+	}
+	return time.Until(*d.ExpireAt), true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		res, err := s.coll.DeleteOne(ctx, live(k))
+		if err != nil {
+			return false, err
+		}
+		return res.DeletedCount > 0, nil
+	}
+	res, err := s.coll.UpdateOne(ctx, live(k), bson.D{
+		{Key: "$set", Value: bson.D{{Key: "expireAt", Value: expireAt(time.Now().Add(timeout))}}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old, compared as BSON; with WithStringValues, the JSON encodings
+// are compared byte for byte. The expiration of the key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	b, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	o, err := s.encode(b)
+	if err != nil {
+		return false, err
+	}
+	if b, err = v.MarshalJSON(); err != nil {
+		return false, err
+	}
+	value, err := s.encode(b)
+	if err != nil {
+		return false, err
+	}
+	return s.replaceIf(ctx, k, func(cur *document) (bson.RawValue, bool, error) {
+		return value, cur.V.Equal(o), nil
+	})
+}
+
+// Patch applies the JSON Merge Patch (RFC 7396) patch to the value of the
+// given key. The patch is merged by the server, with an update pipeline
+// built from the members of patch, which needs MongoDB 5.0. With
+// WithStringValues, it is merged by the client instead, and the replacement
+// is conditioned on the value read, like CompareAndSet, so that no
+// concurrent write is lost. The expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Patch(ctx context.Context, k string, patch json.RawMessage) (bool, error) {
+	if s.strings {
+		return s.replaceIf(ctx, k, func(cur *document) (bson.RawValue, bool, error) {
+			b, err := s.decode(cur.V)
+			if err != nil {
+				return bson.RawValue{}, false, err
+			}
+			if b, err = store.MergePatch(b, patch); err != nil {
+				return bson.RawValue{}, false, err
+			}
+			value, err := s.encode(b)
+			return value, err == nil, err
+		})
+	}
+	p, err := s.encode(patch)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.coll.UpdateOne(ctx, live(k), mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "v", Value: mergeExpr("$v", p, 0)}}}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// mergeExpr returns the aggregation expression applying the merge patch p to
+// the value of target, at the given depth of the patch. The members are set
+// and removed with $setField and $unsetField, so that their names may hold
+// dots or dollars.
+func mergeExpr(target any, p bson.RawValue, depth int) any {
+	members, ok := p.DocumentOK()
+	if !ok {
+		return bson.D{{Key: "$literal", Value: p}}
+	}
+	elems, _ := members.Elements()
+
+	// The target is bound to a variable, replaced by an empty object if it
+	// is not one, and each member is looked up in it.
+	name := fmt.Sprintf("o%d", depth)
+	obj := "$$" + name
+	var expr any = obj
+	for _, e := range elems {
+		field := bson.D{{Key: "$literal", Value: e.Key()}}
+		if e.Value().Type == bson.TypeNull {
+			expr = bson.D{{Key: "$unsetField", Value: bson.D{
+				{Key: "field", Value: field},
+				{Key: "input", Value: expr},
+			}}}
+			continue
+		}
+		member := bson.D{{Key: "$getField", Value: bson.D{
+			{Key: "field", Value: field},
+			{Key: "input", Value: obj},
+		}}}
+		expr = bson.D{{Key: "$setField", Value: bson.D{
+			{Key: "field", Value: field},
+			{Key: "input", Value: expr},
+			{Key: "value", Value: mergeExpr(member, e.Value(), depth+1)},
+		}}}
+	}
+	return bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{{Key: name, Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: target}}, "object"}}},
+			target,
+			bson.D{{Key: "$literal", Value: bson.D{}}},
+		}}}}}},
+		{Key: "in", Value: expr},
+	}}}
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Patcher          = (*Store)(nil)
+)
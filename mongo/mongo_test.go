@@ -0,0 +1,58 @@
+package mongo_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/mongo"
+	"github.com/gokv/store/storetest"
+	mongodriver "go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// newStore returns a function creating an empty Store with opts in a new
+// collection of the gokv_test database, on the server of the GOKV_MONGO_URI
+// environment variable, e.g. mongodb://localhost:27017. The collections are
+// dropped when the test completes. The test is skipped if it is not set.
+func newStore(tb testing.TB, opts ...mongo.Option) func() store.Store {
+	uri := os.Getenv("GOKV_MONGO_URI")
+	if uri == "" {
+		tb.Skip("GOKV_MONGO_URI is not set")
+	}
+	ctx := context.Background()
+	c, err := mongodriver.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Disconnect(ctx) })
+	return func() store.Store {
+		coll := c.Database("gokv_test").Collection("items_" + randomHex())
+		if err := mongo.EnsureIndexes(ctx, coll); err != nil {
+			panic(err)
+		}
+		tb.Cleanup(func() { coll.Drop(ctx) })
+		return mongo.New(coll, opts...)
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func TestStoreStringValues(t *testing.T) {
+	storetest.TestStore(t, newStore(t, mongo.WithStringValues()))
+}
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,430 @@
+/*
+Package mysql implements store.Store on top of a MySQL or MariaDB table,
+storing the values in a JSON column.
+
+The package uses database/sql and does not import a driver: the consumers
+open the *sql.DB with the driver of their choice (e.g.
+github.com/go-sql-driver/mysql). The connections are pooled by the *sql.DB,
+which is configured with SetMaxOpenConns, SetMaxIdleConns and
+SetConnMaxLifetime; Stats reports the state of the pool.
+
+The expirations are kept in a deadline column, in nanoseconds since the
+Unix epoch as measured by the clock of the client. The expired rows are
+hidden from every statement, and a sweep goroutine deletes them
+periodically.
+
+Update and CompareAndSet keep the expiration of the key.
+*/
+package mysql // import "github.com/gokv/store/mysql"
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// rows, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+// Store is a store.Store backed by a MySQL table.
+type Store struct {
+	db    *sql.DB
+	table string // quoted
+
+	get, set, add, del, sweep *sql.Stmt
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store keeping the items in the given table of db. The table
+// is created if needed. Closing the Store closes db.
+func New(ctx context.Context, db *sql.DB, table string) (*Store, error) {
+	return NewWithSweepInterval(ctx, db, table, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired rows.
+func NewWithSweepInterval(ctx context.Context, db *sql.DB, table string, interval time.Duration) (*Store, error) {
+	s := &Store{
+		db:    db,
+		table: quoteIdent(table),
+		stop:  make(chan struct{}),
+	}
+	if err := s.bootstrap(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.prepare(ctx); err != nil {
+		s.closeStmts()
+		return nil, err
+	}
+
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s, nil
+}
+
+// quoteIdent quotes a MySQL identifier.
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// live is the condition selecting the rows which have not expired at the
+// time passed as parameter.
+const live = `(deadline IS NULL OR deadline > ?)`
+
+func (s *Store) bootstrap(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		k        VARBINARY(3072) NOT NULL PRIMARY KEY,
+		v        JSON NOT NULL,
+		deadline BIGINT NULL,
+		INDEX (deadline)
+	)`)
+	return err
+}
+
+func (s *Store) prepare(ctx context.Context) error {
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, `SELECT v FROM ` + s.table + ` WHERE k = ? AND ` + live},
+		{&s.set, `INSERT INTO ` + s.table + ` (k, v, deadline) VALUES (?, CAST(? AS JSON), ?)
+			ON DUPLICATE KEY UPDATE v = VALUES(v), deadline = VALUES(deadline)`},
+		{&s.add, `INSERT INTO ` + s.table + ` (k, v) VALUES (?, CAST(? AS JSON))`},
+		{&s.del, `DELETE FROM ` + s.table + ` WHERE k = ? AND ` + live},
+		{&s.sweep, `DELETE FROM ` + s.table + ` WHERE deadline <= ?`},
+	} {
+		stmt, err := s.db.PrepareContext(ctx, p.query)
+		if err != nil {
+			return err
+		}
+		*p.stmt = stmt
+	}
+	return nil
+}
+
+func (s *Store) closeStmts() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{s.get, s.set, s.add, s.del, s.sweep} {
+		if stmt == nil {
+			continue
+		}
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_, _ = s.sweep.Exec(now.UnixNano())
+		}
+	}
+}
+
+// deadlineArg returns the value of the deadline column: NULL for the zero
+// time.
+func deadlineArg(deadline time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: deadline.UnixNano(), Valid: !deadline.IsZero()}
+}
+
+// rowsAffected returns whether res affected at least one row.
+func rowsAffected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.get.QueryRowContext(ctx, k, time.Now().UnixNano()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the table, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key, in byte-wise order. A negative limit
+// means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	if offset < 0 {
+		offset = 0
+	}
+	// MySQL has no syntax for an offset without a limit.
+	l := int64(limit)
+	if limit < 0 {
+		l = math.MaxInt64
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT v FROM `+s.table+` WHERE `+live+
+		` ORDER BY k LIMIT ? OFFSET ?`, time.Now().UnixNano(), l, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	if _, err := s.add.ExecContext(ctx, k, string(value)); err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.set.ExecContext(ctx, k, string(value), deadlineArg(deadline))
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, string(value), ``)
+}
+
+// updateIf assigns value to k if it is live and matches the extra condition,
+// with its parameters. As MySQL only counts the rows actually changed, the
+// row is locked and checked first.
+func (s *Store) updateIf(ctx context.Context, k, value, cond string, args ...any) (ok bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !ok {
+			tx.Rollback()
+		}
+	}()
+
+	var one int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM `+s.table+` WHERE k = ? AND `+live+cond+` FOR UPDATE`,
+		append([]any{k, time.Now().UnixNano()}, args...)...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE `+s.table+` SET v = CAST(? AS JSON) WHERE k = ?`, value, k); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	return rowsAffected(s.del.ExecContext(ctx, k, time.Now().UnixNano()))
+}
+
+// Ping returns a non-nil error if the database can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close stops the sweep goroutine, and closes the prepared statements and the
+// database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	err := s.closeStmts()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old. The comparison is made by MySQL on the JSON values, so that
+// two encodings of the same document are equal. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, string(value), ` AND v = CAST(? AS JSON)`, string(o))
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+s.table+` WHERE k = ? AND `+live+`)`,
+		k, time.Now().UnixNano()).Scan(&ok)
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in byte-wise order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT k FROM `+s.table+
+		` WHERE k LIKE ? ESCAPE '!' AND `+live+` ORDER BY k`, likePrefix(prefix), time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ks := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, rows.Err()
+}
+
+// likePrefix returns the LIKE pattern, escaped with '!', matching the strings
+// starting with prefix.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	return r.Replace(prefix) + "%"
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (n int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+` WHERE `+live,
+		time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+` WHERE k LIKE ? ESCAPE '!' AND `+live,
+		likePrefix(prefix), time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table)
+	return err
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE k LIKE ? ESCAPE '!'`, likePrefix(prefix))
+	return err
+}
+
+// Stats returns the number of items in the table and the state of the
+// connection pool.
+// Err is non-nil in case of failure.
+func (s *Store) Stats(ctx context.Context) (store.Stats, error) {
+	n, err := s.Count(ctx)
+	if err != nil {
+		return store.Stats{}, err
+	}
+	pool := s.db.Stats()
+	return store.Stats{
+		Items:     n,
+		OpenConns: pool.OpenConnections,
+		IdleConns: pool.Idle,
+	}, nil
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Sizer            = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.StatsProvider    = (*Store)(nil)
+)
@@ -0,0 +1,12 @@
+package store
+
+// Namespacer defines a method for partitioning a single store into isolated
+// logical stores (e.g. Bolt buckets, Redis key prefixes or SQL tables).
+type Namespacer interface {
+
+	// Namespace returns a Store whose keys are isolated from the keys of any
+	// other namespace, and from the keys of the parent store. Calling
+	// Namespace twice with the same name returns stores sharing the same
+	// keys. Closing the returned Store does not close the parent store.
+	Namespace(name string) Store
+}
@@ -0,0 +1,481 @@
+/*
+Package natskv implements store.Store on top of a NATS JetStream key-value
+bucket.
+
+The keys must be valid NATS KV keys: letters, digits and the "-/_=." bytes,
+without leading, trailing or consecutive dots. The values are kept verbatim.
+
+The expirations are the per-message TTLs of NATS 2.11, which the bucket must
+allow; see CreateBucket. The TTLs have a one second resolution, rounded up
+so that the keys never clear early, and the expired keys are hidden on read
+until the server removes them. Update and CompareAndSet are publications
+conditioned on the revision read, and keep the expiration of the key.
+
+The revision numbers are exposed by GetMeta, and the watchers of the bucket
+by Watch and WatchPrefix.
+*/
+package natskv // import "github.com/gokv/store/natskv"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Store is a store.Store backed by a JetStream key-value bucket.
+type Store struct {
+	js     jetstream.JetStream
+	kv     jetstream.KeyValue
+	stream jetstream.Stream
+	prefix string // subject prefix of the keys
+}
+
+// New returns a Store keeping the items in the given bucket, which must
+// exist; see CreateBucket.
+func New(ctx context.Context, js jetstream.JetStream, bucket string) (*Store, error) {
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := js.Stream(ctx, "KV_"+bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{js: js, kv: kv, stream: stream, prefix: "$KV." + bucket + "."}, nil
+}
+
+// CreateBucket creates a bucket suitable for New, keeping a single revision
+// per key and allowing the per-key TTLs. The expirations are notified to the
+// watchers by markers, kept for markerTTL.
+func CreateBucket(ctx context.Context, js jetstream.JetStream, bucket string, markerTTL time.Duration) error {
+	_, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:         bucket,
+		LimitMarkerTTL: markerTTL,
+	})
+	return err
+}
+
+var validKey = regexp.MustCompile(`^[-/_=.a-zA-Z0-9]+$`)
+
+// subject returns the subject of k.
+func (s *Store) subject(k string) (string, error) {
+	if !validKey.MatchString(k) || k[0] == '.' || k[len(k)-1] == '.' || strings.Contains(k, "..") {
+		return "", jetstream.ErrInvalidKey
+	}
+	return s.prefix + k, nil
+}
+
+// entry is the last message of a key.
+type entry struct {
+	value    []byte
+	revision uint64
+	updated  time.Time
+	deadline time.Time // zero if the key does not expire
+}
+
+// get returns the entry of the given subject, or nil if it was not found,
+// deleted or expired.
+func (s *Store) get(ctx context.Context, subject string) (*entry, error) {
+	m, err := s.stream.GetLastMsgForSubject(ctx, subject)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Header.Get("KV-Operation") != "" || m.Header.Get(jetstream.MarkerReasonHeader) != "" {
+		return nil, nil
+	}
+	e := &entry{value: m.Data, revision: m.Sequence, updated: m.Time}
+	if ttl, ok := parseTTL(m.Header.Get(jetstream.MsgTTLHeader)); ok {
+		e.deadline = m.Time.Add(ttl)
+		if !time.Now().Before(e.deadline) {
+			return nil, nil
+		}
+	}
+	return e, nil
+}
+
+// parseTTL parses the value of the Nats-TTL header, either a duration or a
+// number of seconds. Ok is false if the message does not expire.
+func parseTTL(h string) (time.Duration, bool) {
+	if h == "" || h == "never" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(h); err == nil {
+		return d, true
+	}
+	if n, err := strconv.ParseInt(h, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+	return 0, false
+}
+
+// ttl returns the TTL of deadline, rounded up to the second.
+func ttl(deadline time.Time) time.Duration {
+	return time.Duration(math.Ceil(time.Until(deadline).Seconds())) * time.Second
+}
+
+// conflict reports whether err is the failure of a publication conditioned
+// on the revision of the key.
+func conflict(err error) bool {
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence ||
+			apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequenceConstant
+	}
+	return errors.Is(err, jetstream.ErrKeyRevisionMismatch)
+}
+
+// publishIf publishes value to the given subject, if its last revision is
+// revision, with the given deadline.
+func (s *Store) publishIf(ctx context.Context, subject string, value []byte, revision uint64, deadline time.Time) error {
+	opts := []jetstream.PublishOpt{jetstream.WithExpectLastSequencePerSubject(revision)}
+	if !deadline.IsZero() {
+		d := ttl(deadline)
+		if d <= 0 {
+			// The key is about to expire; it is deleted instead.
+			return s.kv.Delete(ctx, subject[len(s.prefix):], jetstream.LastRevision(revision))
+		}
+		opts = append(opts, jetstream.WithMsgTTL(d))
+	}
+	_, err := s.js.Publish(ctx, subject, value, opts...)
+	return err
+}
+
+// updateIf assigns value to k, keeping its expiration, if k is found and
+// match reports true for its current value. The publication is conditioned
+// on the revision read, and retried if it changed meanwhile.
+func (s *Store) updateIf(ctx context.Context, k string, value []byte, match func(cur []byte) bool) (bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return false, err
+	}
+	for {
+		e, err := s.get(ctx, subject)
+		if err != nil || e == nil || !match(e.value) {
+			return false, err
+		}
+		err = s.publishIf(ctx, subject, value, e.revision, e.deadline)
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return false, err
+	}
+	e, err := s.get(ctx, subject)
+	if err != nil || e == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(e.value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit. The
+// keys of the bucket are listed, and their values read one by one.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	ks, err := s.listKeys(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		if limit == 0 {
+			return nil
+		}
+		e, err := s.get(ctx, s.prefix+k)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if err := c.New().UnmarshalJSON(e.value); err != nil {
+			return err
+		}
+		limit--
+	}
+	return nil
+}
+
+// listKeys returns the keys of the bucket starting with prefix, in ascending
+// order, including the expired keys which the server has not removed yet.
+func (s *Store) listKeys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	kl, err := s.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer kl.Stop()
+	var ks []string
+	for k := range kl.Keys() {
+		if strings.HasPrefix(k, prefix) {
+			ks = append(ks, k)
+		}
+	}
+	// The lister stops silently when ctx is done.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ks)
+	return ks, nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		_, err := s.kv.Create(ctx, k, value)
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return k, nil
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	subject, err := s.subject(k)
+	if err != nil {
+		return err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var opts []jetstream.PublishOpt
+	if !deadline.IsZero() {
+		d := ttl(deadline)
+		if d <= 0 {
+			return s.kv.Delete(ctx, k)
+		}
+		opts = append(opts, jetstream.WithMsgTTL(d))
+	}
+	_, err = s.js.Publish(ctx, subject, value, opts...)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, value, func([]byte) bool { return true })
+}
+
+// Delete removes a key and its value from the store. A delete marker is left
+// in the bucket, as for the watchers.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return false, err
+	}
+	for {
+		e, err := s.get(ctx, subject)
+		if err != nil || e == nil {
+			return false, err
+		}
+		err = s.kv.Delete(ctx, k, jetstream.LastRevision(e.revision))
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Ping returns a non-nil error if the bucket can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.kv.Status(ctx)
+	return err
+}
+
+// Close is a no-op: the connection is owned by the caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return false, err
+	}
+	e, err := s.get(ctx, subject)
+	return e != nil, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks, err := s.listKeys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	live := []string{}
+	for _, k := range ks {
+		e, err := s.get(ctx, s.prefix+k)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			live = append(live, k)
+		}
+	}
+	return live, nil
+}
+
+// GetMeta retrieves the metadata of the given key. The version is the
+// revision of the key; the creation time is not provided.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return store.Meta{}, false, err
+	}
+	e, err := s.get(ctx, subject)
+	if err != nil || e == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{
+		Version:   strconv.FormatUint(e.revision, 10),
+		UpdatedAt: e.updated,
+	}, true, nil
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return 0, false, err
+	}
+	e, err := s.get(ctx, subject)
+	if err != nil || e == nil {
+		return 0, false, err
+	}
+	if e.deadline.IsZero() {
+		return 0, true, nil
+	}
+	return time.Until(e.deadline), true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called. The value is
+// published again with the new TTL.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	subject, err := s.subject(k)
+	if err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		e, err := s.get(ctx, subject)
+		if err != nil || e == nil {
+			return false, err
+		}
+		err = s.publishIf(ctx, subject, e.value, e.revision, deadline)
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.updateIf(ctx, k, value, func(cur []byte) bool {
+		return string(cur) == string(o)
+	})
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,63 @@
+package natskv_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/natskv"
+	"github.com/gokv/store/storetest"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// newStore returns a function creating an empty Store in a new bucket of the
+// JetStream enabled NATS server of the GOKV_NATS_URL environment variable,
+// e.g. nats://localhost:4222. The buckets are deleted when the test
+// completes. The test is skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	url := os.Getenv("GOKV_NATS_URL")
+	if url == "" {
+		tb.Skip("GOKV_NATS_URL is not set")
+	}
+	nc, err := nats.Connect(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return func() store.Store {
+		ctx := context.Background()
+		bucket := "gokv_test_" + randomHex()
+		if err := natskv.CreateBucket(ctx, js, bucket, time.Minute); err != nil {
+			panic(err)
+		}
+		tb.Cleanup(func() { js.DeleteKeyValue(ctx, bucket) })
+		s, err := natskv.New(ctx, js, bucket)
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,75 @@
+package natskv
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gokv/store"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Watch notifies the changes to the given key on the returned channel. The
+// expirations are notified as an EventDelete if the bucket keeps markers.
+// Err is non-nil in case of failure.
+func (s *Store) Watch(ctx context.Context, k string) (<-chan store.Event, error) {
+	if _, err := s.subject(k); err != nil {
+		return nil, err
+	}
+	w, err := s.kv.Watch(ctx, k, jetstream.UpdatesOnly())
+	if err != nil {
+		return nil, err
+	}
+	return s.watch(ctx, w, func(string) bool { return true }), nil
+}
+
+// WatchPrefix notifies the changes to every key starting with prefix on the
+// returned channel. The whole bucket is watched, and the events filtered.
+// Err is non-nil in case of failure.
+func (s *Store) WatchPrefix(ctx context.Context, prefix string) (<-chan store.Event, error) {
+	w, err := s.kv.WatchAll(ctx, jetstream.UpdatesOnly())
+	if err != nil {
+		return nil, err
+	}
+	return s.watch(ctx, w, func(k string) bool { return strings.HasPrefix(k, prefix) }), nil
+}
+
+func (s *Store) watch(ctx context.Context, w jetstream.KeyWatcher, match func(k string) bool) <-chan store.Event {
+	events := make(chan store.Event)
+	go func() {
+		defer close(events)
+		defer w.Stop()
+		for {
+			var kve jetstream.KeyValueEntry
+			var ok bool
+			select {
+			case kve, ok = <-w.Updates():
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			// The watcher is initialized by a nil entry.
+			if kve == nil {
+				continue
+			}
+			if !match(kve.Key()) {
+				continue
+			}
+			e := store.Event{Key: kve.Key(), Type: store.EventDelete}
+			if kve.Operation() == jetstream.KeyValuePut {
+				e.Type = store.EventSet
+				e.Value = json.RawMessage(kve.Value())
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+var _ store.Watcher = (*Store)(nil)
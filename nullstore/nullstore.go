@@ -0,0 +1,95 @@
+/*
+Package nullstore provides a store.Store discarding everything written to it.
+
+The writes succeed without storing anything, and the reads find nothing. It
+stands in for a Store where none is needed, for example on the code paths
+disabled by a feature flag, or as a sink in benchmarks. The values are not
+marshaled.
+*/
+package nullstore // import "github.com/gokv/store/nullstore"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store discarding the writes. The zero value is ready to
+// use.
+type Store struct{}
+
+// New returns a Store.
+func New() *Store {
+	return &Store{}
+}
+
+// Get finds nothing.
+// Err is non-nil if the context is done.
+func (*Store) Get(ctx context.Context, _ string, _ json.Unmarshaler) (bool, error) {
+	return false, ctx.Err()
+}
+
+// GetAll unmarshals nothing to c.
+// Err is non-nil if the context is done.
+func (*Store) GetAll(ctx context.Context, _ store.Collection) error {
+	return ctx.Err()
+}
+
+// Add discards the given value, and returns a new random key.
+// Err is non-nil in case of failure.
+func (*Store) Add(ctx context.Context, _ json.Marshaler) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Set discards the given value.
+// Err is non-nil if the context is done.
+func (*Store) Set(ctx context.Context, _ string, _ json.Marshaler) error {
+	return ctx.Err()
+}
+
+// SetWithTimeout discards the given value.
+// Err is non-nil if the context is done.
+func (*Store) SetWithTimeout(ctx context.Context, _ string, _ json.Marshaler, _ time.Duration) error {
+	return ctx.Err()
+}
+
+// SetWithDeadline discards the given value.
+// Err is non-nil if the context is done.
+func (*Store) SetWithDeadline(ctx context.Context, _ string, _ json.Marshaler, _ time.Time) error {
+	return ctx.Err()
+}
+
+// Update finds nothing to update.
+// Err is non-nil if the context is done.
+func (*Store) Update(ctx context.Context, _ string, _ json.Marshaler) (bool, error) {
+	return false, ctx.Err()
+}
+
+// Delete finds nothing to delete.
+// Err is non-nil if the context is done.
+func (*Store) Delete(ctx context.Context, _ string) (bool, error) {
+	return false, ctx.Err()
+}
+
+// Ping returns a non-nil error if the context is done.
+func (*Store) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close does nothing.
+func (*Store) Close() error {
+	return nil
+}
+
+var _ store.Store = (*Store)(nil)
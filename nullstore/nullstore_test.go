@@ -0,0 +1,88 @@
+package nullstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store/nullstore"
+)
+
+// values is a store.Collection of raw JSON values.
+type values []*json.RawMessage
+
+func (c *values) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// TestStore checks that the writes succeed and that the reads find nothing.
+// The conformance suite does not apply, as it reads what it writes.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := nullstore.New()
+	v := json.RawMessage(`"one"`)
+
+	if err := s.Set(ctx, "a", v); err != nil {
+		t.Errorf("Set: %v", err)
+	}
+	if err := s.SetWithTimeout(ctx, "a", v, time.Minute); err != nil {
+		t.Errorf("SetWithTimeout: %v", err)
+	}
+	if err := s.SetWithDeadline(ctx, "a", v, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("SetWithDeadline: %v", err)
+	}
+	k1, err := s.Add(ctx, v)
+	if err != nil {
+		t.Errorf("Add: %v", err)
+	}
+	k2, err := s.Add(ctx, v)
+	if err != nil {
+		t.Errorf("Add: %v", err)
+	}
+	if k1 == "" || k1 == k2 {
+		t.Errorf("Add: got the keys %q and %q, want distinct keys", k1, k2)
+	}
+
+	var got json.RawMessage
+	if ok, err := s.Get(ctx, "a", &got); ok || err != nil {
+		t.Errorf("Get: got %v, %v, want not found", ok, err)
+	}
+	var c values
+	if err := s.GetAll(ctx, &c); err != nil || len(c) != 0 {
+		t.Errorf("GetAll: got %d items, %v, want none", len(c), err)
+	}
+	if ok, err := s.Update(ctx, "a", v); ok || err != nil {
+		t.Errorf("Update: got %v, %v, want not found", ok, err)
+	}
+	if ok, err := s.Delete(ctx, "a"); ok || err != nil {
+		t.Errorf("Delete: got %v, %v, want not found", ok, err)
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+// TestContextCancelled checks that the operations fail once the context is
+// done.
+func TestContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := nullstore.New()
+	if err := s.Set(ctx, "a", json.RawMessage(`1`)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Set: got %v, want context.Canceled", err)
+	}
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "a", &v); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get: got %v, want context.Canceled", err)
+	}
+	if _, err := s.Add(ctx, v); !errors.Is(err, context.Canceled) {
+		t.Errorf("Add: got %v, want context.Canceled", err)
+	}
+}
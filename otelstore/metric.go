@@ -0,0 +1,223 @@
+package otelstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// The names of the instruments of MetricStore.
+const (
+	DurationName = "db.client.operation.duration"
+	PayloadName  = "gokv.payload.size"
+	ErrorsName   = "gokv.errors"
+)
+
+// WithMeterProvider sets the provider of the meter of WrapMetrics, the global
+// one otherwise.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) { c.meterProvider = provider }
+}
+
+// MetricStore is a store.Store recording metrics about the operations of the
+// wrapped Store as OpenTelemetry instruments: the duration of the operations,
+// the size of the JSON payloads read and written, and the number of errors.
+// The measurements carry the db.system and db.operation attributes.
+type MetricStore struct {
+	store.Wrapper
+	attrs    []attribute.KeyValue
+	duration metric.Float64Histogram
+	payload  metric.Int64Histogram
+	errs     metric.Int64Counter
+}
+
+// WrapMetrics returns a MetricStore recording metrics about the operations of
+// s.
+func WrapMetrics(s store.Store, opts ...Option) (*MetricStore, error) {
+	c := config{meterProvider: otel.GetMeterProvider(), system: "gokv"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	meter := c.meterProvider.Meter(ScopeName)
+	m := &MetricStore{
+		Wrapper: store.Wrapper{Store: s},
+		attrs:   append([]attribute.KeyValue{SystemKey.String(c.system)}, c.attrs...),
+	}
+	var err error
+	if m.duration, err = meter.Float64Histogram(DurationName,
+		metric.WithDescription("Duration of the store operations."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if m.payload, err = meter.Int64Histogram(PayloadName,
+		metric.WithDescription("Size of the JSON payloads read and written."),
+		metric.WithUnit("By"),
+	); err != nil {
+		return nil, err
+	}
+	if m.errs, err = meter.Int64Counter(ErrorsName,
+		metric.WithDescription("Number of failed store operations."),
+		metric.WithUnit("{error}"),
+	); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// record records an operation of the given method started at start, with a
+// payload of size bytes unless it is negative.
+func (s *MetricStore) record(ctx context.Context, method string, start time.Time, size int, err error) {
+	attrs := make([]attribute.KeyValue, 0, len(s.attrs)+1)
+	attrs = append(append(attrs, s.attrs...), OperationKey.String(method))
+	opt := metric.WithAttributeSet(attribute.NewSet(attrs...))
+	s.duration.Record(ctx, time.Since(start).Seconds(), opt)
+	if size >= 0 {
+		s.payload.Record(ctx, int64(size), opt)
+	}
+	if err != nil {
+		s.errs.Add(ctx, 1, opt)
+	}
+}
+
+// sized counts the bytes unmarshaled to a value.
+type sized struct {
+	json.Unmarshaler
+	n *int
+}
+
+func (v sized) UnmarshalJSON(data []byte) error {
+	*v.n += len(data)
+	return v.Unmarshaler.UnmarshalJSON(data)
+}
+
+// sizedCollection counts the bytes unmarshaled to the items of a Collection.
+type sizedCollection struct {
+	store.Collection
+	n *int
+}
+
+func (c sizedCollection) New() json.Unmarshaler {
+	return sized{c.Collection.New(), c.n}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *MetricStore) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	start, n := time.Now(), 0
+	ok, err := s.Store.Get(ctx, k, sized{v, &n})
+	if !ok {
+		n = -1
+	}
+	s.record(ctx, "Get", start, n, err)
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *MetricStore) GetAll(ctx context.Context, c store.Collection) error {
+	start, n := time.Now(), 0
+	err := s.Store.GetAll(ctx, sizedCollection{c, &n})
+	s.record(ctx, "GetAll", start, n, err)
+	return err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *MetricStore) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	start := time.Now()
+	data, err := v.MarshalJSON()
+	if err != nil {
+		s.record(ctx, "Add", start, -1, err)
+		return "", err
+	}
+	k, err := s.Store.Add(ctx, json.RawMessage(data))
+	s.record(ctx, "Add", start, len(data), err)
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *MetricStore) Set(ctx context.Context, k string, v json.Marshaler) error {
+	start := time.Now()
+	data, err := v.MarshalJSON()
+	if err != nil {
+		s.record(ctx, "Set", start, -1, err)
+		return err
+	}
+	err = s.Store.Set(ctx, k, json.RawMessage(data))
+	s.record(ctx, "Set", start, len(data), err)
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *MetricStore) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	start := time.Now()
+	data, err := v.MarshalJSON()
+	if err != nil {
+		s.record(ctx, "SetWithTimeout", start, -1, err)
+		return err
+	}
+	err = s.Store.SetWithTimeout(ctx, k, json.RawMessage(data), timeout)
+	s.record(ctx, "SetWithTimeout", start, len(data), err)
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *MetricStore) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	start := time.Now()
+	data, err := v.MarshalJSON()
+	if err != nil {
+		s.record(ctx, "SetWithDeadline", start, -1, err)
+		return err
+	}
+	err = s.Store.SetWithDeadline(ctx, k, json.RawMessage(data), deadline)
+	s.record(ctx, "SetWithDeadline", start, len(data), err)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *MetricStore) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	start := time.Now()
+	data, err := v.MarshalJSON()
+	if err != nil {
+		s.record(ctx, "Update", start, -1, err)
+		return false, err
+	}
+	ok, err := s.Store.Update(ctx, k, json.RawMessage(data))
+	s.record(ctx, "Update", start, len(data), err)
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *MetricStore) Delete(ctx context.Context, k string) (bool, error) {
+	start := time.Now()
+	ok, err := s.Store.Delete(ctx, k)
+	s.record(ctx, "Delete", start, -1, err)
+	return ok, err
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *MetricStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.Store.Ping(ctx)
+	s.record(ctx, "Ping", start, -1, err)
+	return err
+}
+
+var _ store.Store = (*MetricStore)(nil)
@@ -0,0 +1,208 @@
+/*
+Package otelstore provides a Store wrapper tracing the operations with
+OpenTelemetry.
+
+Every operation starts a client span, child of the span of the context, named
+after the method (e.g. "gokv.Get"). The spans carry the db.system and
+db.operation attributes, and the hash of the key as gokv.key.hash, so that
+the keys themselves do not leak to the tracing backend. The Ok result of Get,
+Update and Delete is recorded as gokv.found. The errors are recorded on the
+span, whose status is then set to Error.
+
+WrapMetrics records metrics about the operations instead, with the
+OpenTelemetry metric API: see MetricStore. The two wrappers can be combined.
+*/
+package otelstore // import "github.com/gokv/store/otelstore"
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the tracer and the meter.
+const ScopeName = "github.com/gokv/store/otelstore"
+
+// The attributes of the spans and the measurements.
+const (
+	SystemKey    = attribute.Key("db.system")
+	OperationKey = attribute.Key("db.operation")
+	KeyHashKey   = attribute.Key("gokv.key.hash")
+	FoundKey     = attribute.Key("gokv.found")
+)
+
+// Option configures a Store.
+type Option func(*config)
+
+type config struct {
+	provider      trace.TracerProvider
+	meterProvider metric.MeterProvider
+	system        string
+	attrs         []attribute.KeyValue
+}
+
+// WithTracerProvider sets the provider of the tracer of Wrap, the global one
+// otherwise.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) { c.provider = provider }
+}
+
+// WithSystem sets the db.system attribute (e.g. "redis") of the spans and the
+// measurements, "gokv" otherwise.
+func WithSystem(system string) Option {
+	return func(c *config) { c.system = system }
+}
+
+// WithAttributes adds attributes to every span and measurement.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(c *config) { c.attrs = append(c.attrs, attrs...) }
+}
+
+// Store is a store.Store tracing the operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+// Wrap returns a Store tracing the operations of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	c := config{provider: otel.GetTracerProvider(), system: "gokv"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &Store{
+		Wrapper: store.Wrapper{Store: s},
+		tracer:  c.provider.Tracer(ScopeName),
+		attrs:   append([]attribute.KeyValue{SystemKey.String(c.system)}, c.attrs...),
+	}
+}
+
+// KeyHash returns the hash of k recorded in the spans: the first 8 bytes of
+// its SHA-256, in hexadecimal.
+func KeyHash(k string) string {
+	h := sha256.Sum256([]byte(k))
+	return hex.EncodeToString(h[:8])
+}
+
+// start starts the span of an operation of the given method.
+func (s *Store) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "gokv."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.attrs...),
+		trace.WithAttributes(OperationKey.String(method)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// end records err, if non-nil, and ends span.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	ctx, span := s.start(ctx, "Get", KeyHashKey.String(KeyHash(k)))
+	ok, err := s.Store.Get(ctx, k, v)
+	span.SetAttributes(FoundKey.Bool(ok))
+	end(span, err)
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	ctx, span := s.start(ctx, "GetAll")
+	err := s.Store.GetAll(ctx, c)
+	end(span, err)
+	return err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	ctx, span := s.start(ctx, "Add")
+	k, err := s.Store.Add(ctx, v)
+	if err == nil {
+		span.SetAttributes(KeyHashKey.String(KeyHash(k)))
+	}
+	end(span, err)
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	ctx, span := s.start(ctx, "Set", KeyHashKey.String(KeyHash(k)))
+	err := s.Store.Set(ctx, k, v)
+	end(span, err)
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	ctx, span := s.start(ctx, "SetWithTimeout", KeyHashKey.String(KeyHash(k)))
+	err := s.Store.SetWithTimeout(ctx, k, v, timeout)
+	end(span, err)
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	ctx, span := s.start(ctx, "SetWithDeadline", KeyHashKey.String(KeyHash(k)))
+	err := s.Store.SetWithDeadline(ctx, k, v, deadline)
+	end(span, err)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	ctx, span := s.start(ctx, "Update", KeyHashKey.String(KeyHash(k)))
+	ok, err := s.Store.Update(ctx, k, v)
+	span.SetAttributes(FoundKey.Bool(ok))
+	end(span, err)
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	ctx, span := s.start(ctx, "Delete", KeyHashKey.String(KeyHash(k)))
+	ok, err := s.Store.Delete(ctx, k)
+	span.SetAttributes(FoundKey.Bool(ok))
+	end(span, err)
+	return ok, err
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.Store.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
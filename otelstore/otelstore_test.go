@@ -0,0 +1,38 @@
+package otelstore_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/otelstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store tracing the operations of an empty memstore with
+// the global tracer provider.
+func newStore() store.Store {
+	return otelstore.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// newMetricStore returns a MetricStore recording the metrics of an empty
+// memstore with the global meter provider.
+func newMetricStore() store.Store {
+	s, err := otelstore.WrapMetrics(memstore.New())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestMetricStore(t *testing.T) { storetest.TestStore(t, newMetricStore) }
+
+func FuzzMetricStore(f *testing.F) { storetest.FuzzStore(f, newMetricStore) }
+
+func BenchmarkMetricStore(b *testing.B) { storetest.BenchmarkStore(b, newMetricStore) }
@@ -0,0 +1,16 @@
+package store
+
+import "context"
+
+// Pager defines a method for fetching the items of a store one page at a
+// time, as an alternative to GetAll.
+type Pager interface {
+
+	// GetPage unmarshals to c at most limit items, skipping the first offset
+	// ones. The items are ordered by key, in ascending byte-wise
+	// lexicographic order, so that consecutive pages neither overlap nor skip
+	// items as long as the store is not modified in between. A page shorter
+	// than limit is the last one.
+	// Err is non-nil in case of failure.
+	GetPage(ctx context.Context, c Collection, offset, limit int) error
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Patcher defines a partial update of a stored JSON document, for changing
+// some fields without a read-modify-write race.
+type Patcher interface {
+
+	// Patch applies the JSON Merge Patch (RFC 7396) patch to the value of
+	// the given key, atomically.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	Patch(ctx context.Context, k string, patch json.RawMessage) (ok bool, err error)
+}
+
+// maxPatchAttempts is the number of CompareAndSet attempts of Patch before
+// it gives up with ErrConflict.
+const maxPatchAttempts = 16
+
+// Patch applies the JSON Merge Patch (RFC 7396) patch to the value of the
+// given key in s. It calls s.Patch if s is a Patcher. Otherwise it reads
+// the value, merges the patch and writes the result with CompareAndSet if s
+// is a CompareAndSetter, retrying if the value changed meanwhile, or with
+// Update, which may lose a concurrent write.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure, or if patch is not valid JSON.
+func Patch(ctx context.Context, s Store, k string, patch json.RawMessage) (bool, error) {
+	if !json.Valid(patch) {
+		return false, fmt.Errorf("store: Patch: invalid JSON patch")
+	}
+	if p, ok := s.(Patcher); ok {
+		return p.Patch(ctx, k, patch)
+	}
+	cas, isCAS := s.(CompareAndSetter)
+	for attempt := 0; attempt < maxPatchAttempts; attempt++ {
+		var current json.RawMessage
+		if ok, err := s.Get(ctx, k, &current); err != nil || !ok {
+			return false, err
+		}
+		v, err := MergePatch(current, patch)
+		if err != nil {
+			return false, err
+		}
+		if !isCAS {
+			return s.Update(ctx, k, json.RawMessage(v))
+		}
+		ok, err := cas.CompareAndSet(ctx, k, current, json.RawMessage(v))
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, fmt.Errorf("store: Patch: %q kept changing: %w", k, ErrConflict)
+}
+
+// MergePatch returns doc with the JSON Merge Patch (RFC 7396) patch applied.
+// The members of doc left untouched by patch keep their encoding.
+// Err is non-nil if doc or patch is not valid JSON.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &p); err != nil || p == nil {
+		// Not an object: the patch replaces the document.
+		if !json.Valid(patch) {
+			return nil, fmt.Errorf("store: MergePatch: invalid JSON patch")
+		}
+		return patch, nil
+	}
+
+	var d map[string]json.RawMessage
+	if len(bytes.TrimSpace(doc)) > 0 {
+		if !json.Valid(doc) {
+			return nil, fmt.Errorf("store: MergePatch: invalid JSON document")
+		}
+		json.Unmarshal(doc, &d)
+	}
+	if d == nil {
+		// Not an object: the patch applies to an empty one.
+		d = make(map[string]json.RawMessage, len(p))
+	}
+	for name, v := range p {
+		if isNull(v) {
+			delete(d, name)
+			continue
+		}
+		merged, err := MergePatch(d[name], v)
+		if err != nil {
+			return nil, err
+		}
+		d[name] = merged
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(d); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// isNull reports whether v is the JSON null.
+func isNull(v json.RawMessage) bool {
+	return string(bytes.TrimSpace(v)) == "null"
+}
@@ -0,0 +1,84 @@
+package pebble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/gokv/store"
+)
+
+// GetMulti retrieves the values of the given keys and unmarshals each of
+// them to the element of vs with the same index.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMulti(ctx context.Context, ks []string, vs []json.Unmarshaler) (ok []bool, err error) {
+	if len(ks) != len(vs) {
+		return nil, fmt.Errorf("pebble: %d keys for %d values", len(ks), len(vs))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ok = make([]bool, len(ks))
+	now := time.Now()
+	for i, k := range ks {
+		r, err := s.get(k, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok[i] = r != nil; ok[i] {
+			if err := vs[i].UnmarshalJSON(recordValue(r)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ok, nil
+}
+
+// SetMulti atomically assigns each element of vs to the key of ks with the
+// same index, in a single batch written blindly.
+// Err is non-nil in case of failure.
+func (s *Store) SetMulti(ctx context.Context, ks []string, vs []json.Marshaler) error {
+	if len(ks) != len(vs) {
+		return fmt.Errorf("pebble: %d keys for %d values", len(ks), len(vs))
+	}
+	values := make([][]byte, len(vs))
+	for i, v := range vs {
+		var err error
+		if values[i], err = v.MarshalJSON(); err != nil {
+			return err
+		}
+	}
+	return s.blind(ctx, func(b *pebble.Batch) error {
+		for i, k := range ks {
+			if err := put(b, k, values[i], 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteMulti atomically removes the given keys and their values from the
+// store.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) DeleteMulti(ctx context.Context, ks []string) (ok []bool, err error) {
+	ok = make([]bool, len(ks))
+	err = s.update(ctx, func(b *pebble.Batch, now time.Time) (err error) {
+		for i, k := range ks {
+			if ok[i], err = s.remove(b, k, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ok, nil
+}
+
+var _ store.Batch = (*Store)(nil)
@@ -0,0 +1,95 @@
+package pebble
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/gokv/store"
+)
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r, err := s.get(k, time.Now())
+	return r != nil, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, func(k, _ []byte) (bool, error) {
+		ks = append(ks, string(k))
+		return true, nil
+	})
+	return ks, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.update(ctx, func(b *pebble.Batch, _ time.Time) error {
+		if err := b.DeleteRange([]byte{valuePrefix}, []byte{valuePrefix + 1}, nil); err != nil {
+			return err
+		}
+		return b.DeleteRange([]byte{expiryPrefix}, []byte{expiryPrefix + 1}, nil)
+	})
+}
+
+// ClearPrefix removes every key starting with prefix, and its value, with a
+// range deletion. The index entries of the keys are left to the sweeps.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	lower := valueKey(prefix)
+	return s.update(ctx, func(b *pebble.Batch, _ time.Time) error {
+		return b.DeleteRange(lower, upperBound(lower), nil)
+	})
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	now := time.Now()
+	r, err := s.get(k, now)
+	if err != nil || r == nil {
+		return 0, false, err
+	}
+	if d := recordDeadline(r); d != 0 {
+		return time.Unix(0, d).Sub(now), true, nil
+	}
+	return 0, true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error) {
+	err = s.update(ctx, func(b *pebble.Batch, now time.Time) error {
+		r, err := s.get(k, now)
+		if ok = r != nil; err != nil || !ok {
+			return err
+		}
+		if timeout <= 0 {
+			return b.Delete(valueKey(k), nil)
+		}
+		return put(b, k, recordValue(r), unixNano(now.Add(timeout)))
+	})
+	return ok, err
+}
+
+var (
+	_ store.Exister   = (*Store)(nil)
+	_ store.KeyLister = (*Store)(nil)
+	_ store.Clearer   = (*Store)(nil)
+	_ store.TTLStore  = (*Store)(nil)
+)
@@ -0,0 +1,474 @@
+/*
+Package pebble implements store.Store on top of a Pebble database, the LSM
+engine of CockroachDB, for embedded deployments with a high write throughput.
+
+The values are kept under the 'v' byte followed by the key, each prefixed
+with its expiration. Pebble has no native expiration: the expired keys are
+hidden on read, and a sweep goroutine removes them periodically, walking an
+index under the 'x' byte which sorts the keys by expiration.
+
+Set and SetMulti write blindly, without reading the previous value: the
+stale index entries they leave are discarded by the sweeps. The concurrent
+blind writes are committed together by the Pebble commit pipeline, while
+the other writes, which read the current value, are serialized.
+
+GetAll is a range scan of the values. Update keeps the expiration of the
+key.
+*/
+package pebble // import "github.com/gokv/store/pebble"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/gokv/store"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// keys, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+// The first byte of the database keys separates the values from the
+// expiration index.
+const (
+	valuePrefix  = 'v'
+	expiryPrefix = 'x'
+)
+
+// Store is a store.Store backed by a Pebble database.
+type Store struct {
+
+	// Sync makes every write durable before it returns, at the cost of the
+	// write throughput. Otherwise the writes are only handed to the
+	// write-ahead log, which survives a crash of the process but not of the
+	// machine. It must be set before the Store is used.
+	Sync bool
+
+	db *pebble.DB
+
+	// mu is held for reading by the blind writes, and for writing by the
+	// writes which read the current value.
+	mu sync.RWMutex
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store keeping the items in db. Closing the Store closes db.
+func New(db *pebble.DB) *Store {
+	return NewWithSweepInterval(db, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired keys.
+func NewWithSweepInterval(db *pebble.DB, interval time.Duration) *Store {
+	s := &Store{
+		db:   db,
+		stop: make(chan struct{}),
+	}
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_ = s.sweep(now)
+		}
+	}
+}
+
+// sweep removes every key expired at now.
+func (s *Store) sweep(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, err := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte{expiryPrefix},
+		UpperBound: []byte{expiryPrefix + 1},
+	})
+	if err != nil {
+		return err
+	}
+	b := s.db.NewBatch()
+	defer b.Close()
+	for ok := it.First(); ok; ok = it.Next() {
+		deadline, k := splitExpiryKey(it.Key())
+		if deadline > now.UnixNano() {
+			break
+		}
+		// The entry is stale if the key was assigned again meanwhile.
+		r, err := s.record(k)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		if r != nil && recordDeadline(r) == deadline {
+			if err := b.Delete(k, nil); err != nil {
+				it.Close()
+				return err
+			}
+		}
+		if err := b.Delete(it.Key(), nil); err != nil {
+			it.Close()
+			return err
+		}
+	}
+	if err := it.Close(); err != nil {
+		return err
+	}
+	if b.Empty() {
+		return nil
+	}
+	return b.Commit(s.writeOptions())
+}
+
+func (s *Store) writeOptions() *pebble.WriteOptions {
+	if s.Sync {
+		return pebble.Sync
+	}
+	return pebble.NoSync
+}
+
+// A record is the stored form of a value: the expiration deadline in
+// nanoseconds since the Unix epoch (zero if the key does not expire), as a
+// big-endian int64, followed by the JSON encoding of the value.
+func newRecord(deadline int64, value []byte) []byte {
+	r := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(r, uint64(deadline))
+	copy(r[8:], value)
+	return r
+}
+
+func recordDeadline(r []byte) int64 {
+	return int64(binary.BigEndian.Uint64(r))
+}
+
+func recordValue(r []byte) []byte {
+	return r[8:]
+}
+
+func expired(r []byte, now time.Time) bool {
+	d := recordDeadline(r)
+	return d != 0 && d <= now.UnixNano()
+}
+
+func valueKey(k string) []byte {
+	return append([]byte{valuePrefix}, k...)
+}
+
+// The keys of the expiration index are the 'x' byte, the deadline as a
+// big-endian int64 and the database key of the value, so that the iterator
+// walks them by expiration.
+func expiryKey(deadline int64, vk []byte) []byte {
+	xk := make([]byte, 9+len(vk))
+	xk[0] = expiryPrefix
+	binary.BigEndian.PutUint64(xk[1:], uint64(deadline))
+	copy(xk[9:], vk)
+	return xk
+}
+
+func splitExpiryKey(xk []byte) (deadline int64, vk []byte) {
+	return int64(binary.BigEndian.Uint64(xk[1:])), append([]byte(nil), xk[9:]...)
+}
+
+// upperBound returns the smallest key greater than every key starting with
+// p, which must not be made of 0xff bytes only.
+func upperBound(p []byte) []byte {
+	end := append([]byte(nil), p...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	panic("pebble: no upper bound")
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	if n := t.UnixNano(); n != 0 {
+		return n
+	}
+	// Zero means no expiration.
+	return 1
+}
+
+// record returns a copy of the record of the database key vk, or nil if it
+// was not found.
+func (s *Store) record(vk []byte) ([]byte, error) {
+	r, closer, err := s.db.Get(vk)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte(nil), r...), nil
+}
+
+// get returns the record of k, or nil if it was not found or expired.
+func (s *Store) get(k string, now time.Time) ([]byte, error) {
+	r, err := s.record(valueKey(k))
+	if err != nil || r == nil || expired(r, now) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// put adds to b the writes assigning value to k with the given deadline.
+func put(b *pebble.Batch, k string, value []byte, deadline int64) error {
+	vk := valueKey(k)
+	if deadline != 0 {
+		if err := b.Set(expiryKey(deadline, vk), nil, nil); err != nil {
+			return err
+		}
+	}
+	return b.Set(vk, newRecord(deadline, value), nil)
+}
+
+// blind runs fn holding mu for reading, and commits the batch it filled.
+func (s *Store) blind(ctx context.Context, fn func(b *pebble.Batch) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.commit(fn)
+}
+
+// update runs fn holding mu, and commits the batch it filled.
+func (s *Store) update(ctx context.Context, fn func(b *pebble.Batch, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commit(func(b *pebble.Batch) error {
+		return fn(b, time.Now())
+	})
+}
+
+func (s *Store) commit(fn func(b *pebble.Batch) error) error {
+	b := s.db.NewBatch()
+	defer b.Close()
+	if err := fn(b); err != nil {
+		return err
+	}
+	if b.Empty() {
+		return nil
+	}
+	return b.Commit(s.writeOptions())
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r, err := s.get(k, time.Now())
+	if err != nil || r == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(recordValue(r))
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key, with a
+// range scan.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.scan(ctx, "", func(_, r []byte) (bool, error) {
+		if limit == 0 {
+			return false, nil
+		}
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+		limit--
+		return true, c.New().UnmarshalJSON(recordValue(r))
+	})
+}
+
+// scan calls fn with the key and the record of every item whose key starts
+// with prefix and which has not expired, in ascending key order, until fn
+// returns false. The arguments of fn are only valid until it returns.
+func (s *Store) scan(ctx context.Context, prefix string, fn func(k, r []byte) (bool, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	lower := valueKey(prefix)
+	it, err := s.db.NewIterWithContext(ctx, &pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: upperBound(lower),
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for ok := it.First(); ok; ok = it.Next() {
+		r, err := it.ValueAndErr()
+		if err != nil {
+			it.Close()
+			return err
+		}
+		if expired(r, now) {
+			continue
+		}
+		more, err := fn(it.Key()[1:], r)
+		if err != nil || !more {
+			it.Close()
+			return err
+		}
+	}
+	return it.Close()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.update(ctx, func(b *pebble.Batch, now time.Time) error {
+		for {
+			r := make([]byte, 16)
+			if _, err := rand.Read(r); err != nil {
+				return err
+			}
+			k = hex.EncodeToString(r)
+			cur, err := s.get(k, now)
+			if err != nil {
+				return err
+			}
+			if cur == nil {
+				return put(b, k, value, 0)
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.set(ctx, k, v, 0)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.set(ctx, k, v, unixNano(time.Now().Add(timeout)))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.set(ctx, k, v, unixNano(deadline))
+}
+
+func (s *Store) set(ctx context.Context, k string, v json.Marshaler, deadline int64) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.blind(ctx, func(b *pebble.Batch) error {
+		return put(b, k, value, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.update(ctx, func(b *pebble.Batch, now time.Time) error {
+		r, err := s.get(k, now)
+		if ok = r != nil; err != nil || !ok {
+			return err
+		}
+		return b.Set(valueKey(k), newRecord(recordDeadline(r), value), nil)
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.update(ctx, func(b *pebble.Batch, now time.Time) (err error) {
+		ok, err = s.remove(b, k, now)
+		return err
+	})
+	return ok, err
+}
+
+// remove adds to b the deletion of k. Ok is false if k was not found or
+// expired. The index entry of k is left to the sweeps.
+func (s *Store) remove(b *pebble.Batch, k string, now time.Time) (bool, error) {
+	r, err := s.record(valueKey(k))
+	if err != nil || r == nil {
+		return false, err
+	}
+	return !expired(r, now), b.Delete(valueKey(k), nil)
+}
+
+// Ping returns a non-nil error if the database can not be read.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.record(valueKey(""))
+	return err
+}
+
+// Close stops the sweep goroutine and closes the database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	return s.db.Close()
+}
+
+var (
+	_ store.Store = (*Store)(nil)
+	_ store.Pager = (*Store)(nil)
+)
@@ -0,0 +1,27 @@
+package pebble_test
+
+import (
+	"testing"
+	"time"
+
+	pebbledb "github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/vfs"
+	"github.com/gokv/store"
+	"github.com/gokv/store/pebble"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore opens an empty Store in a new in-memory database.
+func newStore() store.Store {
+	db, err := pebbledb.Open("", &pebbledb.Options{FS: vfs.NewMem()})
+	if err != nil {
+		panic(err)
+	}
+	return pebble.NewWithSweepInterval(db, 100*time.Millisecond)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,460 @@
+/*
+Package postgres implements store.Store on top of a PostgreSQL table, storing
+the values in a JSONB column.
+
+The package uses database/sql and does not import a driver: the consumers
+open the *sql.DB with the driver of their choice (e.g. pgx or lib/pq). The
+JSON documents are sent as text parameters, which every driver supports.
+
+The expirations are kept in a deadline column. The expired rows are hidden
+from every statement, and removed by Sweep.
+
+Add generates UUID keys with gen_random_uuid, which requires
+PostgreSQL 13 or the pgcrypto extension. Patch applies the JSON Merge Patch
+in the database, with a gokv_merge_patch PL/pgSQL function created by New.
+Update, CompareAndSet and Patch keep the expiration of the key.
+*/
+package postgres // import "github.com/gokv/store/postgres"
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store backed by a PostgreSQL table.
+type Store struct {
+	db    *sql.DB
+	table string // quoted
+
+	get, add, set, update, del, cas, patch *sql.Stmt
+}
+
+// New returns a Store keeping the items in the given table of db. The table
+// is created if needed. Closing the Store closes db.
+func New(ctx context.Context, db *sql.DB, table string) (*Store, error) {
+	s := &Store{db: db, table: quoteIdent(table)}
+	if err := s.bootstrap(ctx, table); err != nil {
+		return nil, err
+	}
+	if err := s.prepare(ctx); err != nil {
+		s.closeStmts()
+		return nil, err
+	}
+	return s, nil
+}
+
+// quoteIdent quotes a PostgreSQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// live is the condition selecting the rows which have not expired.
+const live = `(deadline IS NULL OR deadline > now())`
+
+func (s *Store) bootstrap(ctx context.Context, table string) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		key      text PRIMARY KEY,
+		value    jsonb NOT NULL,
+		deadline timestamptz
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+quoteIdent(table+"_deadline_idx")+
+		` ON `+s.table+` (deadline) WHERE deadline IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, mergePatchFunc)
+	return err
+}
+
+// mergePatchFunc creates the gokv_merge_patch function, applying a JSON
+// Merge Patch (RFC 7396) to a JSONB document, for Patch.
+const mergePatchFunc = `CREATE OR REPLACE FUNCTION gokv_merge_patch(target jsonb, patch jsonb)
+RETURNS jsonb LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE
+	k text;
+	v jsonb;
+BEGIN
+	IF jsonb_typeof(patch) IS DISTINCT FROM 'object' THEN
+		RETURN patch;
+	END IF;
+	IF jsonb_typeof(target) IS DISTINCT FROM 'object' THEN
+		target := '{}';
+	END IF;
+	FOR k, v IN SELECT * FROM jsonb_each(patch) LOOP
+		IF jsonb_typeof(v) = 'null' THEN
+			target := target - k;
+		ELSE
+			target := jsonb_set(target, ARRAY[k], gokv_merge_patch(target -> k, v));
+		END IF;
+	END LOOP;
+	RETURN target;
+END
+$$`
+
+func (s *Store) prepare(ctx context.Context) error {
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, `SELECT value FROM ` + s.table + ` WHERE key = $1 AND ` + live},
+		{&s.add, `INSERT INTO ` + s.table + ` (key, value) VALUES (gen_random_uuid()::text, $1::jsonb) RETURNING key`},
+		{&s.set, `INSERT INTO ` + s.table + ` (key, value, deadline) VALUES ($1, $2::jsonb, $3)
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, deadline = EXCLUDED.deadline`},
+		{&s.update, `UPDATE ` + s.table + ` SET value = $2::jsonb WHERE key = $1 AND ` + live},
+		{&s.del, `DELETE FROM ` + s.table + ` WHERE key = $1 RETURNING ` + live},
+		{&s.cas, `UPDATE ` + s.table + ` SET value = $3::jsonb WHERE key = $1 AND value = $2::jsonb AND ` + live},
+		{&s.patch, `UPDATE ` + s.table + ` SET value = gokv_merge_patch(value, $2::jsonb) WHERE key = $1 AND ` + live},
+	} {
+		stmt, err := s.db.PrepareContext(ctx, p.query)
+		if err != nil {
+			return err
+		}
+		*p.stmt = stmt
+	}
+	return nil
+}
+
+func (s *Store) closeStmts() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{s.get, s.add, s.set, s.update, s.del, s.cas, s.patch} {
+		if stmt == nil {
+			continue
+		}
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// deadlineArg returns the value of the deadline column: NULL for the zero
+// time.
+func deadlineArg(deadline time.Time) sql.NullTime {
+	return sql.NullTime{Time: deadline, Valid: !deadline.IsZero()}
+}
+
+// rowsAffected returns whether res affected at least one row.
+func rowsAffected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.get.QueryRowContext(ctx, k).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the table, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key, in byte-wise order. A negative limit
+// means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	if offset < 0 {
+		offset = 0
+	}
+	// LIMIT NULL is no limit.
+	l := sql.NullInt64{Int64: int64(limit), Valid: limit >= 0}
+	return s.collect(ctx, c, `SELECT value FROM `+s.table+` WHERE `+live+
+		` ORDER BY key COLLATE "C" OFFSET $1 LIMIT $2`, offset, l)
+}
+
+// collect unmarshals to c the values returned by the query.
+func (s *Store) collect(ctx context.Context, c store.Collection, query string, args ...any) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Add assigns the given value to a new UUID key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	err = s.add.QueryRowContext(ctx, string(value)).Scan(&k)
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.set.ExecContext(ctx, k, string(value), deadlineArg(deadline))
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected(s.update.ExecContext(ctx, k, string(value)))
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.del.QueryRowContext(ctx, k).Scan(&ok)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return ok, err
+}
+
+// Ping returns a non-nil error if the database can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the prepared statements and the database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.closeStmts()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Sweep removes the expired rows, and returns their number. The expired
+// rows are hidden anyway: Sweep only reclaims their storage, and is meant to
+// be called periodically.
+// Err is non-nil in case of failure.
+func (s *Store) Sweep(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE deadline <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old. The comparison is made by PostgreSQL on the JSONB values, so
+// that two encodings of the same document are equal. The expiration of the
+// key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected(s.cas.ExecContext(ctx, k, string(o), string(value)))
+}
+
+// Patch applies the JSON Merge Patch (RFC 7396) patch to the value of the
+// given key, in a single UPDATE calling the gokv_merge_patch function. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Patch(ctx context.Context, k string, patch json.RawMessage) (bool, error) {
+	return rowsAffected(s.patch.ExecContext(ctx, k, string(patch)))
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+s.table+` WHERE key = $1 AND `+live+`)`, k).Scan(&ok)
+	return ok, err
+}
+
+// Keys returns every key starting with prefix, in byte-wise order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key FROM `+s.table+
+		` WHERE key LIKE $1 AND `+live+` ORDER BY key COLLATE "C"`, likePrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ks := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, rows.Err()
+}
+
+// likePrefix returns the LIKE pattern matching the strings starting with
+// prefix.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (n int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+` WHERE `+live).Scan(&n)
+	return n, err
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+
+		` WHERE key LIKE $1 AND `+live, likePrefix(prefix)).Scan(&n)
+	return n, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table)
+	return err
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE key LIKE $1`, likePrefix(prefix))
+	return err
+}
+
+// Query unmarshals to c every item in the store matching f, ordered by key.
+// The filter is translated to a WHERE clause over the JSONB column.
+// Err is non-nil in case of failure.
+func (s *Store) Query(ctx context.Context, f store.Filter, c store.Collection) error {
+	where, args, err := whereClause(f)
+	if err != nil {
+		return err
+	}
+	return s.collect(ctx, c, `SELECT value FROM `+s.table+` WHERE `+live+where+` ORDER BY key COLLATE "C"`, args...)
+}
+
+// whereClause translates f to SQL conditions, each prefixed with AND.
+func whereClause(f store.Filter) (string, []any, error) {
+	var b strings.Builder
+	var args []any
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	for _, cond := range f {
+		value, err := json.Marshal(cond.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		path := param(textArray(strings.Split(cond.Field, ".")))
+		field := `(value #> ` + path + `::text[])`
+		if cond.Op == store.OpEq {
+			b.WriteString(` AND ` + field + ` = ` + param(string(value)) + `::jsonb`)
+			continue
+		}
+
+		var op string
+		switch cond.Op {
+		case store.OpLt:
+			op = "<"
+		case store.OpLte:
+			op = "<="
+		case store.OpGt:
+			op = ">"
+		case store.OpGte:
+			op = ">="
+		default:
+			return "", nil, errors.New("postgres: unknown operator in condition on " + cond.Field)
+		}
+		var n json.Number
+		if err := json.Unmarshal(value, &n); err != nil {
+			return "", nil, errors.New("postgres: non-numeric value in condition on " + cond.Field)
+		}
+		b.WriteString(` AND CASE WHEN jsonb_typeof(` + field + `) = 'number' THEN (value #>> ` + path +
+			`::text[])::numeric ` + op + ` ` + param(n.String()) + `::numeric END`)
+	}
+	return b.String(), args, nil
+}
+
+// textArray returns the PostgreSQL array literal of ss.
+func textArray(ss []string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = `"` + r.Replace(s) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Patcher          = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Sizer            = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.Querier          = (*Store)(nil)
+)
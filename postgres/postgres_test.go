@@ -0,0 +1,62 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/postgres"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function opening an empty Store in the gokv_test table
+// of the database of the GOKV_POSTGRES_DSN environment variable, which is
+// cleared every time. The database is opened with the driver named by
+// GOKV_POSTGRES_DRIVER, "pgx" by default, which the package does not import:
+// it must be registered by another file of the test. The test is skipped if
+// the DSN is not set or the driver is not registered.
+func newStore(tb testing.TB) func() store.Store {
+	dsn := os.Getenv("GOKV_POSTGRES_DSN")
+	if dsn == "" {
+		tb.Skip("GOKV_POSTGRES_DSN is not set")
+	}
+	driver := os.Getenv("GOKV_POSTGRES_DRIVER")
+	if driver == "" {
+		driver = "pgx"
+	}
+	if !registered(driver) {
+		tb.Skipf("the %q driver is not registered", driver)
+	}
+	return func() store.Store {
+		ctx := context.Background()
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			panic(err)
+		}
+		s, err := postgres.New(ctx, db, "gokv_test")
+		if err != nil {
+			panic(err)
+		}
+		if err := s.Clear(ctx); err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func registered(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
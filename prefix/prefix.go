@@ -0,0 +1,263 @@
+/*
+Package prefix provides a Store wrapper prepending a prefix to every key, so
+that several tenants can share one backend without seeing each other's keys.
+
+The prefix is prepended to the keys given to the wrapped Store, and stripped
+from the keys it returns. GetAll only collects the items under the prefix:
+it requires the wrapped Store to implement store.PrefixIterable or
+store.KeyLister, and fails with store.ErrNotSupported otherwise. Add assigns
+random keys itself, as the keys assigned by the wrapped Store would be out
+of the prefix.
+
+The Store also implements store.KeyLister, store.Exister, store.Sizer,
+store.Clearer, store.Iterable and store.PrefixIterable, scoped to the
+prefix, when the wrapped Store implements them. Prefixes are best ended with
+a separator, such as "tenant1/", so that no prefix is the beginning of
+another.
+*/
+package prefix // import "github.com/gokv/store/prefix"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Store is a store.Store prepending a prefix to the keys of the wrapped
+// Store.
+type Store struct {
+	store.Wrapper
+	prefix string
+}
+
+// New returns a Store prepending prefix to the keys of s.
+func New(s store.Store, prefix string) *Store {
+	return &Store{Wrapper: store.Wrapper{Store: s}, prefix: prefix}
+}
+
+// Prefix returns the prefix of the keys.
+func (s *Store) Prefix() string {
+	return s.prefix
+}
+
+func (s *Store) key(k string) string {
+	return s.prefix + k
+}
+
+func notSupported(method string) error {
+	return fmt.Errorf("prefix: %s: %w", method, store.ErrNotSupported)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	return s.Store.Get(ctx, s.key(k), v)
+}
+
+// GetAll unmarshals to c every item under the prefix.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	if it, ok := s.Store.(store.PrefixIterable); ok {
+		iter, err := it.IterPrefix(ctx, s.prefix)
+		if err != nil {
+			return err
+		}
+		err = collect(ctx, iter, c)
+		if cerr := iter.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+
+	kl, ok := s.Store.(store.KeyLister)
+	if !ok {
+		return notSupported("GetAll")
+	}
+	ks, err := kl.Keys(ctx, s.prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		var v json.RawMessage
+		ok, err := s.Store.Get(ctx, k, &v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Deleted since it was listed.
+			continue
+		}
+		if err := c.New().UnmarshalJSON(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect unmarshals to c every item of iter.
+func collect(ctx context.Context, iter store.Iterator, c store.Collection) error {
+	for {
+		ok, err := iter.Next(ctx)
+		if err != nil || !ok {
+			return err
+		}
+		if err := iter.Value(c.New()); err != nil {
+			return err
+		}
+	}
+}
+
+// Add assigns the given value to a new random key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.Store.Set(ctx, s.key(k), v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.Store.SetWithTimeout(ctx, s.key(k), v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.Store.SetWithDeadline(ctx, s.key(k), v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	return s.Store.Update(ctx, s.key(k), v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	return s.Store.Delete(ctx, s.key(k))
+}
+
+// Keys returns every key under the prefix starting with p, without the
+// prefix.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, p string) ([]string, error) {
+	kl, ok := s.Store.(store.KeyLister)
+	if !ok {
+		return nil, notSupported("Keys")
+	}
+	ks, err := kl.Keys(ctx, s.key(p))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range ks {
+		ks[i] = strings.TrimPrefix(k, s.prefix)
+	}
+	return ks, nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	e, ok := s.Store.(store.Exister)
+	if !ok {
+		return false, notSupported("Exists")
+	}
+	return e.Exists(ctx, s.key(k))
+}
+
+// Count returns the number of keys under the prefix.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	return s.CountPrefix(ctx, "")
+}
+
+// CountPrefix returns the number of keys under the prefix starting with p.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, p string) (int64, error) {
+	sz, ok := s.Store.(store.Sizer)
+	if !ok {
+		return 0, notSupported("CountPrefix")
+	}
+	return sz.CountPrefix(ctx, s.key(p))
+}
+
+// Clear removes every key under the prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key under the prefix starting with p, and its
+// value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, p string) error {
+	cl, ok := s.Store.(store.Clearer)
+	if !ok {
+		return notSupported("ClearPrefix")
+	}
+	return cl.ClearPrefix(ctx, s.key(p))
+}
+
+// Iter returns an Iterator over every item under the prefix.
+// Err is non-nil in case of failure.
+func (s *Store) Iter(ctx context.Context) (store.Iterator, error) {
+	return s.IterPrefix(ctx, "")
+}
+
+// IterPrefix returns an Iterator over every item under the prefix whose key
+// starts with p. The keys of the Iterator are without the prefix.
+// Err is non-nil in case of failure.
+func (s *Store) IterPrefix(ctx context.Context, p string) (store.Iterator, error) {
+	it, ok := s.Store.(store.PrefixIterable)
+	if !ok {
+		return nil, notSupported("IterPrefix")
+	}
+	iter, err := it.IterPrefix(ctx, s.key(p))
+	if err != nil {
+		return nil, err
+	}
+	return iterator{iter, s.prefix}, nil
+}
+
+// iterator strips the prefix from the keys of an Iterator.
+type iterator struct {
+	store.Iterator
+	prefix string
+}
+
+func (it iterator) Key() string {
+	return strings.TrimPrefix(it.Iterator.Key(), it.prefix)
+}
+
+var (
+	_ store.Store          = (*Store)(nil)
+	_ store.KeyLister      = (*Store)(nil)
+	_ store.Exister        = (*Store)(nil)
+	_ store.Sizer          = (*Store)(nil)
+	_ store.Clearer        = (*Store)(nil)
+	_ store.Iterable       = (*Store)(nil)
+	_ store.PrefixIterable = (*Store)(nil)
+)
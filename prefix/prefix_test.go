@@ -0,0 +1,145 @@
+package prefix_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/prefix"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store namespacing the keys of an empty memstore.
+func newStore() store.Store {
+	return prefix.New(memstore.New(), "ns/")
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+// listed is a store.Store and a store.KeyLister only, so that GetAll lists
+// the keys rather than iterate.
+type listed struct {
+	store.Store
+}
+
+func (s listed) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.Store.(store.KeyLister).Keys(ctx, prefix)
+}
+
+// tenants returns two Stores with the prefixes "a/" and "b/" over m, holding
+// "a" and "b" at "k", and m holding "other" at "a".
+func tenants(t *testing.T, m store.Store) (a, b *prefix.Store) {
+	t.Helper()
+	ctx := context.Background()
+	a, b = prefix.New(m, "a/"), prefix.New(m, "b/")
+	for _, kv := range [...]struct {
+		s    store.Store
+		k, v string
+	}{{a, "k", `"a"`}, {b, "k", `"b"`}, {m, "a", `"other"`}} {
+		if err := kv.s.Set(ctx, kv.k, json.RawMessage(kv.v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a, b
+}
+
+func TestIsolation(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	a, b := tenants(t, m)
+
+	expectValue(t, a, "k", `"a"`)
+	expectValue(t, b, "k", `"b"`)
+	expectValue(t, m, "a/k", `"a"`)
+	expectValue(t, a, "a", "")
+
+	if ks, err := a.Keys(ctx, ""); err != nil || len(ks) != 1 || ks[0] != "k" {
+		t.Errorf("Keys: got %q, %v, want the keys of the tenant without the prefix", ks, err)
+	}
+	if n, err := a.Count(ctx); err != nil || n != 1 {
+		t.Errorf("Count: got %d, %v, want 1", n, err)
+	}
+	var c items
+	if err := a.GetAll(ctx, &c); err != nil || len(c) != 1 || string(*c[0]) != `"a"` {
+		t.Errorf("GetAll: got %d items, %v, want the item of the tenant", len(c), err)
+	}
+	iter, err := a.Iter(ctx)
+	if err != nil {
+		t.Fatalf("Iter: %v", err)
+	}
+	if ok, err := iter.Next(ctx); err != nil || !ok || iter.Key() != "k" {
+		t.Errorf("Iter: got the key %q, %v, %v, want k", iter.Key(), ok, err)
+	}
+	if ok, err := iter.Next(ctx); err != nil || ok {
+		t.Errorf("Iter: got the key %q after the last item of the tenant", iter.Key())
+	}
+	iter.Close()
+
+	if ok, err := a.Delete(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Delete: %v, %v", ok, err)
+	}
+	expectValue(t, b, "k", `"b"`)
+	if err := b.Clear(ctx); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	expectValue(t, m, "a", `"other"`)
+}
+
+func TestGetAllListed(t *testing.T) {
+	a, _ := tenants(t, listed{memstore.New()})
+	var c items
+	if err := a.GetAll(context.Background(), &c); err != nil || len(c) != 1 || string(*c[0]) != `"a"` {
+		t.Errorf("GetAll: got %d items, %v, want the item of the tenant", len(c), err)
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	ctx := context.Background()
+	s := prefix.New(new(storetest.Fake), "a/")
+	var c items
+	if err := s.GetAll(ctx, &c); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("GetAll: got %v, want %v", err, store.ErrNotSupported)
+	}
+	if _, err := s.Keys(ctx, ""); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("Keys: got %v, want %v", err, store.ErrNotSupported)
+	}
+	if err := s.Clear(ctx); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("Clear: got %v, want %v", err, store.ErrNotSupported)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
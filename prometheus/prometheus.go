@@ -0,0 +1,272 @@
+/*
+Package prometheus provides a Store wrapper exporting Prometheus metrics.
+
+For every operation, the wrapper increments the operations counter and
+observes the duration in the latency histogram; the failed operations also
+increment the errors counter. With the default namespace, the metrics are:
+
+	gokv_store_operations_total{store, method, outcome}
+	gokv_store_errors_total{store, method}
+	gokv_store_operation_duration_seconds{store, method, outcome}
+
+The outcome is "ok", "not_found" when the Ok result of the method is false,
+or "error". The label set is configurable with WithLabels.
+
+Several Stores may share a Registerer: the collectors registered by the
+first one are reused by the next ones, as long as they have the same label
+set.
+*/
+package prometheus // import "github.com/gokv/store/prometheus"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gokv/store"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Label is a label of the metrics.
+type Label string
+
+// The labels of the metrics.
+const (
+	LabelStore   Label = "store"
+	LabelMethod  Label = "method"
+	LabelOutcome Label = "outcome"
+)
+
+// The outcomes of the operations.
+const (
+	OutcomeOK       = "ok"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Option configures a Store.
+type Option func(*config)
+
+type config struct {
+	name      string
+	namespace string
+	labels    []Label
+	buckets   []float64
+}
+
+// WithName sets the value of the store label, "default" otherwise.
+func WithName(name string) Option {
+	return func(c *config) { c.name = name }
+}
+
+// WithNamespace sets the namespace of the metrics, "gokv" otherwise.
+func WithNamespace(namespace string) Option {
+	return func(c *config) { c.namespace = namespace }
+}
+
+// WithLabels sets the labels of the metrics, every label otherwise. The
+// outcome label is never set on the errors counter.
+func WithLabels(labels ...Label) Option {
+	return func(c *config) { c.labels = labels }
+}
+
+// WithBuckets sets the buckets of the latency histogram, in seconds,
+// prometheus.DefBuckets otherwise.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) { c.buckets = buckets }
+}
+
+// Store is a store.Store exporting metrics about the operations of the
+// wrapped Store.
+type Store struct {
+	store.Wrapper
+	name     string
+	labels   []Label
+	ops      *prom.CounterVec
+	errs     *prom.CounterVec
+	duration *prom.HistogramVec
+}
+
+// New returns a Store exporting metrics about the operations of s, whose
+// collectors are registered with reg.
+func New(s store.Store, reg prom.Registerer, opts ...Option) (*Store, error) {
+	c := config{
+		name:      "default",
+		namespace: "gokv",
+		labels:    []Label{LabelStore, LabelMethod, LabelOutcome},
+		buckets:   prom.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	var names, errNames []string
+	for _, l := range c.labels {
+		names = append(names, string(l))
+		if l != LabelOutcome {
+			errNames = append(errNames, string(l))
+		}
+	}
+	ops := prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "store",
+		Name:      "operations_total",
+		Help:      "Number of store operations.",
+	}, names)
+	errs := prom.NewCounterVec(prom.CounterOpts{
+		Namespace: c.namespace,
+		Subsystem: "store",
+		Name:      "errors_total",
+		Help:      "Number of failed store operations.",
+	}, errNames)
+	duration := prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: c.namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the store operations.",
+		Buckets:   c.buckets,
+	}, names)
+
+	m := &Store{Wrapper: store.Wrapper{Store: s}, name: c.name, labels: c.labels}
+	var err error
+	if m.ops, err = register(reg, ops); err != nil {
+		return nil, err
+	}
+	if m.errs, err = register(reg, errs); err != nil {
+		return nil, err
+	}
+	if m.duration, err = register(reg, duration); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers c with reg, or returns the collector already
+// registered in its place.
+func register[C prom.Collector](reg prom.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	var are prom.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// observe records an operation of the given method, started at start.
+func (s *Store) observe(method string, start time.Time, ok bool, err error) {
+	outcome := OutcomeOK
+	switch {
+	case err != nil:
+		outcome = OutcomeError
+	case !ok:
+		outcome = OutcomeNotFound
+	}
+	values := make([]string, 0, len(s.labels))
+	errValues := make([]string, 0, len(s.labels))
+	for _, l := range s.labels {
+		switch l {
+		case LabelStore:
+			values, errValues = append(values, s.name), append(errValues, s.name)
+		case LabelMethod:
+			values, errValues = append(values, method), append(errValues, method)
+		case LabelOutcome:
+			values = append(values, outcome)
+		}
+	}
+	s.ops.WithLabelValues(values...).Inc()
+	s.duration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.errs.WithLabelValues(errValues...).Inc()
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	start := time.Now()
+	ok, err := s.Store.Get(ctx, k, v)
+	s.observe("Get", start, ok, err)
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	start := time.Now()
+	err := s.Store.GetAll(ctx, c)
+	s.observe("GetAll", start, true, err)
+	return err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	start := time.Now()
+	k, err := s.Store.Add(ctx, v)
+	s.observe("Add", start, true, err)
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	start := time.Now()
+	err := s.Store.Set(ctx, k, v)
+	s.observe("Set", start, true, err)
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	start := time.Now()
+	err := s.Store.SetWithTimeout(ctx, k, v, timeout)
+	s.observe("SetWithTimeout", start, true, err)
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	start := time.Now()
+	err := s.Store.SetWithDeadline(ctx, k, v, deadline)
+	s.observe("SetWithDeadline", start, true, err)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	start := time.Now()
+	ok, err := s.Store.Update(ctx, k, v)
+	s.observe("Update", start, ok, err)
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	start := time.Now()
+	ok, err := s.Store.Delete(ctx, k)
+	s.observe("Delete", start, ok, err)
+	return ok, err
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.Store.Ping(ctx)
+	s.observe("Ping", start, true, err)
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,27 @@
+package prometheus_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/prometheus"
+	"github.com/gokv/store/storetest"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// newStore returns a Store recording the metrics of an empty memstore in a
+// new registry.
+func newStore() store.Store {
+	s, err := prometheus.New(memstore.New(), prom.NewRegistry())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Querier defines a method for fetching the items matching a Filter, so
+// that implementations can push the filtering down to the persistence (e.g.
+// SQL WHERE clauses or Mongo queries).
+type Querier interface {
+
+	// Query unmarshals to c every item in the store matching f.
+	// Err is non-nil in case of failure.
+	Query(ctx context.Context, f Filter, c Collection) error
+}
+
+// Op is the comparison operator of a Condition.
+type Op int
+
+const (
+	// OpEq matches the field values equal to the condition value.
+	OpEq Op = iota + 1
+
+	// OpLt, OpLte, OpGt and OpGte match the numeric field values
+	// respectively lower than, lower than or equal to, greater than, and
+	// greater than or equal to the numeric condition value.
+	OpLt
+	OpLte
+	OpGt
+	OpGte
+)
+
+// Condition compares a field of the stored JSON document with a value.
+type Condition struct {
+
+	// Field is the dot-separated path of the compared field in the JSON
+	// document (e.g. "address.city").
+	Field string
+
+	Op Op
+
+	// Value is compared to the field after being JSON-encoded.
+	Value any
+}
+
+// Eq returns the Condition matching the documents whose field equals v.
+func Eq(field string, v any) Condition {
+	return Condition{Field: field, Op: OpEq, Value: v}
+}
+
+// Lt returns the Condition matching the documents whose numeric field is
+// lower than v.
+func Lt(field string, v any) Condition {
+	return Condition{Field: field, Op: OpLt, Value: v}
+}
+
+// Lte returns the Condition matching the documents whose numeric field is
+// lower than or equal to v.
+func Lte(field string, v any) Condition {
+	return Condition{Field: field, Op: OpLte, Value: v}
+}
+
+// Gt returns the Condition matching the documents whose numeric field is
+// greater than v.
+func Gt(field string, v any) Condition {
+	return Condition{Field: field, Op: OpGt, Value: v}
+}
+
+// Gte returns the Condition matching the documents whose numeric field is
+// greater than or equal to v.
+func Gte(field string, v any) Condition {
+	return Condition{Field: field, Op: OpGte, Value: v}
+}
+
+// Filter matches the documents satisfying all of its conditions. An empty
+// Filter matches every document.
+type Filter []Condition
+
+// Match reports whether the JSON document doc satisfies f. Implementations
+// without a native query language can use it to filter in memory.
+// Err is non-nil if doc or a condition value cannot be decoded, or if a
+// condition has an unknown operator.
+func (f Filter) Match(doc []byte) (bool, error) {
+	var d any
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return false, err
+	}
+	for _, c := range f {
+		ok, err := c.match(d)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (c Condition) match(doc any) (bool, error) {
+	b, err := json.Marshal(c.Value)
+	if err != nil {
+		return false, err
+	}
+	var want any
+	if err := json.Unmarshal(b, &want); err != nil {
+		return false, err
+	}
+
+	got, ok := lookupField(doc, c.Field)
+	if !ok {
+		return false, nil
+	}
+
+	if c.Op == OpEq {
+		return reflect.DeepEqual(got, want), nil
+	}
+
+	x, ok := got.(float64)
+	if !ok {
+		return false, nil
+	}
+	y, ok := want.(float64)
+	if !ok {
+		return false, fmt.Errorf("store: condition on %q: non-numeric value %s", c.Field, b)
+	}
+	switch c.Op {
+	case OpLt:
+		return x < y, nil
+	case OpLte:
+		return x <= y, nil
+	case OpGt:
+		return x > y, nil
+	case OpGte:
+		return x >= y, nil
+	default:
+		return false, fmt.Errorf("store: condition on %q: unknown operator %d", c.Field, c.Op)
+	}
+}
+
+// lookupField walks the decoded JSON document along the dot-separated path.
+func lookupField(doc any, path string) (any, bool) {
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
@@ -0,0 +1,379 @@
+/*
+Package quorum provides a Store replicating the items across several Stores,
+possibly of different kinds, with quorum reads and writes.
+
+The writes are sent to every Store, and succeed once W of them acknowledged
+them; the remaining writes go on in the background. The reads are sent to
+every Store, and return once R of them answered, with the most recent value:
+every value is stored in an envelope holding its key and the time of its
+write. With R + W greater than the number of Stores, a read sees the last
+successful write.
+
+Delete writes a tombstone, so that a Store which missed the deletion does not
+bring the item back; the tombstones clear after TombstoneTTL, which is to
+exceed the time a Store may miss writes. Update updates the Stores holding
+the key, keeping their expiration.
+*/
+package quorum // import "github.com/gokv/store/quorum"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrQuorum is returned when too many Stores failed for the quorum to be
+// reached. The error of the first failing Store is wrapped along.
+var ErrQuorum = errors.New("quorum: quorum not reached")
+
+// DefaultTombstoneTTL is the default lifespan of the tombstones.
+const DefaultTombstoneTTL = 24 * time.Hour
+
+// envelope is the stored form of the values.
+type envelope struct {
+	Key     string          `json:"k"`
+	Time    int64           `json:"ts"` // of the write, in nanoseconds since the epoch
+	Deleted bool            `json:"del,omitempty"`
+	Value   json.RawMessage `json:"v,omitempty"`
+}
+
+// newer reports whether e is more recent than old.
+func (e envelope) newer(old envelope) bool {
+	if e.Time != old.Time {
+		return e.Time > old.Time
+	}
+	// A tie is broken in favour of the deletion, then of the greater value.
+	if e.Deleted != old.Deleted {
+		return e.Deleted
+	}
+	return string(e.Value) > string(old.Value)
+}
+
+// Store is a store.Store replicating the items across Stores.
+type Store struct {
+	stores []store.Store
+	w, r   int
+
+	// TombstoneTTL is the lifespan of the tombstones, DefaultTombstoneTTL by
+	// default. It is not to be changed once the Store is in use.
+	TombstoneTTL time.Duration
+}
+
+// New returns a Store writing to w out of stores, and reading from r of
+// them. A non-positive w or r means a majority of the Stores.
+// It panics if no Store is given.
+func New(w, r int, stores ...store.Store) *Store {
+	if len(stores) == 0 {
+		panic("quorum: no store")
+	}
+	majority := len(stores)/2 + 1
+	if w <= 0 || w > len(stores) {
+		w = majority
+	}
+	if r <= 0 || r > len(stores) {
+		r = majority
+	}
+	return &Store{stores: stores, w: w, r: r, TombstoneTTL: DefaultTombstoneTTL}
+}
+
+// detached is a context carrying the values of its parent, but not its
+// cancellation, for the writes going on after the quorum is reached.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// detach returns a context carrying the values and the deadline of ctx, but
+// not its cancellation.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	d := detached{ctx}
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(d, deadline)
+	}
+	return context.WithCancel(d)
+}
+
+// result is the result of an operation on one Store.
+type result struct {
+	ok  bool
+	env envelope
+	err error
+}
+
+// quorum calls fn concurrently with every Store, and returns the results of
+// the first n successful calls. The error wraps ErrQuorum if fewer than n
+// calls can succeed, and is the error of ctx if it is done first.
+func (s *Store) quorum(ctx context.Context, n int, fn func(context.Context, store.Store) result) ([]result, error) {
+	results := make(chan result, len(s.stores))
+	for _, st := range s.stores {
+		go func(st store.Store) { results <- fn(ctx, st) }(st)
+	}
+	var (
+		acks  []result
+		first error
+	)
+	for failed := 0; len(acks) < n; {
+		var res result
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case res = <-results:
+		}
+		if res.err == nil {
+			acks = append(acks, res)
+			continue
+		}
+		if first == nil {
+			first = res.err
+		}
+		if failed++; failed > len(s.stores)-n {
+			return nil, fmt.Errorf("%w: %d of %d stores failed: %v", ErrQuorum, failed, len(s.stores), first)
+		}
+	}
+	return acks, nil
+}
+
+// read returns the most recent envelope of k out of r Stores.
+func (s *Store) read(ctx context.Context, k string) (envelope, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	acks, err := s.quorum(ctx, s.r, func(ctx context.Context, st store.Store) (res result) {
+		var data json.RawMessage
+		if res.ok, res.err = st.Get(ctx, k, &data); res.err != nil || !res.ok {
+			return res
+		}
+		res.err = json.Unmarshal(data, &res.env)
+		return res
+	})
+	if err != nil {
+		return envelope{}, false, err
+	}
+	var latest envelope
+	var found bool
+	for _, res := range acks {
+		if res.ok && (!found || res.env.newer(latest)) {
+			latest, found = res.env, true
+		}
+	}
+	return latest, found && !latest.Deleted, nil
+}
+
+// write calls fn concurrently with every Store, and returns the results of
+// the first w successful calls. The calls go on in the background, without
+// the cancellation of ctx, once the quorum is reached or ctx is done.
+func (s *Store) write(ctx context.Context, fn func(context.Context, store.Store) result) ([]result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	wctx, cancel := detach(ctx)
+	var wg sync.WaitGroup
+	wg.Add(len(s.stores))
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+	return s.quorum(ctx, s.w, func(_ context.Context, st store.Store) result {
+		defer wg.Done()
+		return fn(wctx, st)
+	})
+}
+
+// put writes e to w Stores, to clear after deadline unless it is zero.
+func (s *Store) put(ctx context.Context, e envelope, deadline time.Time) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.write(ctx, func(ctx context.Context, st store.Store) (res result) {
+		if deadline.IsZero() {
+			res.err = st.Set(ctx, e.Key, json.RawMessage(data))
+		} else {
+			res.err = st.SetWithDeadline(ctx, e.Key, json.RawMessage(data), deadline)
+		}
+		return res
+	})
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	e, ok, err := s.read(ctx, k)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(e.Value)
+}
+
+// envelopes collects the items of GetAll.
+type envelopes []*envelope
+
+func (c *envelopes) New() json.Unmarshaler {
+	e := new(envelope)
+	*c = append(*c, e)
+	return (*envelopeJSON)(e)
+}
+
+// envelopeJSON unmarshals to an envelope.
+type envelopeJSON envelope
+
+func (e *envelopeJSON) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*envelope)(e))
+}
+
+// GetAll unmarshals to c every item in r Stores, in the order of the keys.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	items := make(chan envelopes, len(s.stores))
+	_, err := s.quorum(ctx, s.r, func(ctx context.Context, st store.Store) (res result) {
+		var es envelopes
+		if res.err = st.GetAll(ctx, &es); res.err == nil {
+			items <- es
+		}
+		return res
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]envelope)
+	for i := 0; i < s.r; i++ {
+		for _, e := range <-items {
+			if old, ok := latest[e.Key]; !ok || e.newer(old) {
+				latest[e.Key] = *e
+			}
+		}
+	}
+	ks := make([]string, 0, len(latest))
+	for k, e := range latest {
+		if !e.Deleted {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		if err := c.New().UnmarshalJSON(latest[k].Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new random key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, envelope{Key: k, Time: time.Now().UnixNano(), Value: data}, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	if _, ok, err := s.read(ctx, k); err != nil || !ok {
+		return false, err
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	e, err := json.Marshal(envelope{Key: k, Time: time.Now().UnixNano(), Value: data})
+	if err != nil {
+		return false, err
+	}
+	acks, err := s.write(ctx, func(ctx context.Context, st store.Store) (res result) {
+		res.ok, res.err = st.Update(ctx, k, json.RawMessage(e))
+		return res
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, res := range acks {
+		if res.ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Delete removes a key and its value from the store, writing a tombstone.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	_, ok, err := s.read(ctx, k)
+	if err != nil {
+		return false, err
+	}
+	e := envelope{Key: k, Time: time.Now().UnixNano(), Deleted: true}
+	return ok, s.put(ctx, e, time.Now().Add(s.TombstoneTTL))
+}
+
+// Ping returns a non-nil error if too few Stores are healthy for the reads
+// or the writes to reach the quorum.
+func (s *Store) Ping(ctx context.Context) error {
+	n := s.w
+	if s.r > n {
+		n = s.r
+	}
+	_, err := s.quorum(ctx, n, func(ctx context.Context, st store.Store) result {
+		return result{err: st.Ping(ctx)}
+	})
+	return err
+}
+
+// Close closes every Store.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	var first error
+	for _, st := range s.stores {
+		if err := st.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ store.Store = (*Store)(nil)
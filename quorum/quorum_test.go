@@ -0,0 +1,193 @@
+package quorum_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/quorum"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store replicating the keys on three empty memstores,
+// with write and read quorums of two.
+func newStore() store.Store {
+	return quorum.New(2, 2, memstore.New(), memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+var errDown = errors.New("down")
+
+// down returns a Fake failing every operation with errDown.
+func down() *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, errDown
+		},
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			return errDown
+		},
+		SetWithDeadlineFunc: func(context.Context, string, json.Marshaler, time.Time) error {
+			return errDown
+		},
+	}
+}
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+func TestNoStore(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("New without Store did not panic")
+		}
+	}()
+	quorum.New(0, 0)
+}
+
+func TestQuorum(t *testing.T) {
+	ctx := context.Background()
+	s := quorum.New(2, 2, memstore.New(), memstore.New(), down())
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set with one failing store out of three: %v", err)
+	}
+	expectValue(t, s, "k", `1`)
+}
+
+func TestErrQuorum(t *testing.T) {
+	ctx := context.Background()
+	s := quorum.New(2, 2, memstore.New(), down(), down())
+
+	err := s.Set(ctx, "k", json.RawMessage(`1`))
+	if !errors.Is(err, quorum.ErrQuorum) {
+		t.Errorf("Set with two failing stores out of three: got %v, want %v", err, quorum.ErrQuorum)
+	}
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); !errors.Is(err, quorum.ErrQuorum) {
+		t.Errorf("Get with two failing stores out of three: got %v, want %v", err, quorum.ErrQuorum)
+	}
+	if err == nil || !strings.Contains(err.Error(), errDown.Error()) {
+		t.Errorf("the error of the failing stores is not reported: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hung := &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			<-release
+			return false, nil
+		},
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			<-release
+			return nil
+		},
+	}
+	s := quorum.New(2, 2, memstore.New(), hung, hung)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get with hung stores: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set with hung stores: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestStaleReplica(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := memstore.New(), memstore.New(), memstore.New()
+	s := quorum.New(3, 2, a, b, c)
+
+	if err := s.Set(ctx, "k", json.RawMessage(`"old"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// a misses the new value.
+	if err := quorum.New(2, 2, b, c).Set(ctx, "k", json.RawMessage(`"new"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectValue(t, s, "k", `"new"`)
+	expectValue(t, quorum.New(2, 2, a, b), "k", `"new"`)
+
+	// Setting the key again brings a up to date.
+	if err := s.Set(ctx, "k", json.RawMessage(`"newer"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectValue(t, quorum.New(1, 1, a), "k", `"newer"`)
+}
+
+func TestTombstone(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := memstore.New(), memstore.New(), memstore.New()
+	s := quorum.New(3, 2, a, b, c)
+	s.TombstoneTTL = time.Hour
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// a misses the deletion.
+	deleter := quorum.New(2, 2, b, c)
+	deleter.TombstoneTTL = time.Hour
+	if ok, err := deleter.Delete(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Delete: %v, %v", ok, err)
+	}
+	expectValue(t, s, "k", "")
+	expectValue(t, quorum.New(2, 2, a, b), "k", "")
+	var all items
+	if err := s.GetAll(ctx, &all); err != nil || len(all) != 0 {
+		t.Errorf("GetAll: got %d items, %v, want none", len(all), err)
+	}
+	if ok, err := s.Update(ctx, "k", json.RawMessage(`2`)); err != nil || ok {
+		t.Errorf("Update of a deleted key: got %v, %v, want not found", ok, err)
+	}
+
+	ttl, ok, err := b.GetTTL(ctx, "k")
+	if err != nil || !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("the tombstone expires in %v, %v, %v, want the TombstoneTTL", ttl, ok, err)
+	}
+
+	if err := s.Set(ctx, "k", json.RawMessage(`3`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectValue(t, s, "k", `3`)
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
@@ -0,0 +1,364 @@
+/*
+Package quota provides a Store wrapper enforcing size limits and budgets on
+the writes, so that one misbehaving client or tenant cannot fill a shared
+backend.
+
+The writes whose key is too long or whose value is too large fail with a
+*QuotaError, before they reach the wrapped Store. Optionally, the keys under
+a prefix share a Budget of keys and of bytes, the sum of the lengths of their
+values: a write which would exceed the budget of any prefix of its key fails
+with a *QuotaError as well.
+
+The usage of the budgets is accounted for by the Store, from the writes and
+the deletions it sees: it starts empty, unless loaded from the wrapped Store
+with Load, and it does not see the keys expiring, which count until they are
+overwritten or deleted. It is an estimate for the Store of a single process,
+not a hard limit across processes. Add assigns random keys itself, so that
+their budgets are known before they are written.
+*/
+package quota // import "github.com/gokv/store/quota"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrQuota is wrapped by the errors of the writes exceeding a limit.
+var ErrQuota = errors.New("quota: quota exceeded")
+
+// Limit is a limit of the Store.
+type Limit string
+
+// The limits.
+const (
+	KeyLength Limit = "key length"
+	ValueSize Limit = "value size"
+	Keys      Limit = "keys"
+	Bytes     Limit = "bytes"
+)
+
+// QuotaError is returned by the writes exceeding a limit.
+type QuotaError struct {
+	// Limit is the exceeded limit.
+	Limit Limit `json:"limit"`
+
+	// Key is the key of the write.
+	Key string `json:"key"`
+
+	// Prefix is the prefix of the exceeded Budget, for Keys and Bytes.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Max is the limit, and Value the value which would exceed it.
+	Max   int64 `json:"max"`
+	Value int64 `json:"value"`
+}
+
+func (e *QuotaError) Error() string {
+	if e.Limit == Keys || e.Limit == Bytes {
+		return fmt.Sprintf("quota: key %q: %s under prefix %q would be %d, over %d", e.Key, e.Limit, e.Prefix, e.Value, e.Max)
+	}
+	return fmt.Sprintf("quota: key %q: %s %d over %d", e.Key, e.Limit, e.Value, e.Max)
+}
+
+// Unwrap returns ErrQuota.
+func (e *QuotaError) Unwrap() error {
+	return ErrQuota
+}
+
+// Budget is the budget of the keys under a prefix. A non-positive field
+// means no limit.
+type Budget struct {
+	// MaxKeys is the maximum number of keys.
+	MaxKeys int64
+
+	// MaxBytes is the maximum sum of the lengths of the values.
+	MaxBytes int64
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMaxKeyLength sets the maximum length of the keys, in bytes.
+func WithMaxKeyLength(n int) Option {
+	return func(s *Store) { s.maxKey = n }
+}
+
+// WithMaxValueSize sets the maximum length of the JSON values, in bytes.
+func WithMaxValueSize(n int) Option {
+	return func(s *Store) { s.maxValue = n }
+}
+
+// WithBudget sets the budget of the keys starting with prefix. The empty
+// prefix sets the budget of the whole Store.
+func WithBudget(prefix string, b Budget) Option {
+	return func(s *Store) { s.budgets[prefix] = &usage{Budget: b} }
+}
+
+// usage is the accounted usage of a budget.
+type usage struct {
+	Budget
+	keys, bytes int64
+}
+
+// Store is a store.Store enforcing limits on the writes to the wrapped
+// Store.
+type Store struct {
+	store.Wrapper
+	maxKey, maxValue int
+
+	mu      sync.Mutex
+	budgets map[string]*usage
+	sizes   map[string]int64 // of the accounted keys
+}
+
+// Wrap returns a Store enforcing limits on the writes to s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	q := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		budgets: make(map[string]*usage),
+		sizes:   make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Usage returns the accounted number of keys and bytes under the budget of
+// prefix. Ok is false if prefix has no budget.
+func (s *Store) Usage(prefix string) (keys, bytes int64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.budgets[prefix]
+	if !ok {
+		return 0, 0, false
+	}
+	return u.keys, u.bytes, true
+}
+
+// Load replaces the accounted usage of the budgets by the keys and the values
+// of the wrapped Store, which must implement store.KeyLister.
+// Err is non-nil in case of failure.
+func (s *Store) Load(ctx context.Context) error {
+	kl, ok := s.Store.(store.KeyLister)
+	if !ok {
+		return fmt.Errorf("quota: Load: Keys: %w", store.ErrNotSupported)
+	}
+	sizes := make(map[string]int64)
+	for prefix := range s.budgets {
+		ks, err := kl.Keys(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, k := range ks {
+			if _, ok := sizes[k]; ok {
+				continue
+			}
+			var v json.RawMessage
+			ok, err := s.Store.Get(ctx, k, &v)
+			if err != nil {
+				return err
+			}
+			if ok {
+				sizes[k] = int64(len(v))
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.budgets {
+		u.keys, u.bytes = 0, 0
+	}
+	s.sizes = sizes
+	for k, size := range sizes {
+		s.account(k, 0, false, size, true)
+	}
+	return nil
+}
+
+// account moves the usage of k from old to new, in every budget of k. The
+// booleans report whether k exists before and after.
+func (s *Store) account(k string, old int64, existed bool, new int64, exists bool) {
+	for prefix, u := range s.budgets {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if existed {
+			u.keys--
+			u.bytes -= old
+		}
+		if exists {
+			u.keys++
+			u.bytes += new
+		}
+	}
+}
+
+// reserve checks the write of data to k against the limits, and accounts for
+// it. The returned function reverts the accounting, if the write fails.
+func (s *Store) reserve(k string, data []byte) (func(), error) {
+	if s.maxKey > 0 && len(k) > s.maxKey {
+		return nil, &QuotaError{Limit: KeyLength, Key: k, Max: int64(s.maxKey), Value: int64(len(k))}
+	}
+	if s.maxValue > 0 && len(data) > s.maxValue {
+		return nil, &QuotaError{Limit: ValueSize, Key: k, Max: int64(s.maxValue), Value: int64(len(data))}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, existed := s.sizes[k]
+	size := int64(len(data))
+	for prefix, u := range s.budgets {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		keys, bytes := u.keys, u.bytes+size
+		if !existed {
+			keys++
+		} else {
+			bytes -= old
+		}
+		// A write not growing the usage is allowed over the budget, so that
+		// a budget found exceeded by Load can be shrunk back.
+		if u.MaxKeys > 0 && keys > u.MaxKeys && keys > u.keys {
+			return nil, &QuotaError{Limit: Keys, Key: k, Prefix: prefix, Max: u.MaxKeys, Value: keys}
+		}
+		if u.MaxBytes > 0 && bytes > u.MaxBytes && bytes > u.bytes {
+			return nil, &QuotaError{Limit: Bytes, Key: k, Prefix: prefix, Max: u.MaxBytes, Value: bytes}
+		}
+	}
+	if !s.budgeted(k) {
+		return func() {}, nil
+	}
+
+	s.account(k, old, existed, size, true)
+	s.sizes[k] = size
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.sizes[k] != size {
+			// Overwritten since.
+			return
+		}
+		s.account(k, size, true, old, existed)
+		if existed {
+			s.sizes[k] = old
+		} else {
+			delete(s.sizes, k)
+		}
+	}, nil
+}
+
+// budgeted reports whether k is under a budget.
+func (s *Store) budgeted(k string) bool {
+	for prefix := range s.budgets {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// set marshals v, and calls write with it within the limits.
+func (s *Store) set(k string, v json.Marshaler, write func(json.Marshaler) error) error {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	revert, err := s.reserve(k, data)
+	if err != nil {
+		return err
+	}
+	if err := write(json.RawMessage(data)); err != nil {
+		revert()
+		return err
+	}
+	return nil
+}
+
+// Add assigns the given value to a new random key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.set(k, v, func(v json.Marshaler) error {
+		return s.Store.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.set(k, v, func(v json.Marshaler) error {
+		return s.Store.SetWithTimeout(ctx, k, v, timeout)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.set(k, v, func(v json.Marshaler) error {
+		return s.Store.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// errMissing reverts the accounting of an Update whose key was not found.
+var errMissing = errors.New("quota: key not found")
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	err = s.set(k, v, func(v json.Marshaler) (err error) {
+		if ok, err = s.Store.Update(ctx, k, v); err == nil && !ok {
+			// Nothing was written: revert the accounting.
+			err = errMissing
+		}
+		return err
+	})
+	if err == errMissing {
+		return false, nil
+	}
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	ok, err := s.Store.Delete(ctx, k)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, existed := s.sizes[k]; existed {
+		s.account(k, old, true, 0, false)
+		delete(s.sizes, k)
+	}
+	return ok, nil
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,22 @@
+package quota_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/quota"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store enforcing the default quotas on an empty
+// memstore.
+func newStore() store.Store {
+	return quota.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
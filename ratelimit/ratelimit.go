@@ -0,0 +1,202 @@
+/*
+Package ratelimit provides a Store wrapper throttling the operations, so that
+a shared backend is not overwhelmed by a single client.
+
+The operations are limited by token buckets: a global one, shared by every
+method, and optionally one per method. By default, an operation over the
+limits waits for its turn, as long as the context allows; with WithReject, it
+fails immediately with ErrLimited instead. Ping and Close are not limited.
+*/
+package ratelimit // import "github.com/gokv/store/ratelimit"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrLimited is returned by the operations over the limits, when they are
+// rejected or when their turn would come after the deadline of the context.
+var ErrLimited = errors.New("ratelimit: rate limit exceeded")
+
+// Limit is the limit of a token bucket.
+type Limit struct {
+	// Rate is the number of operations per second. A non-positive rate means
+	// no limit.
+	Rate float64
+
+	// Burst is the maximum number of operations performed at once, at least
+	// one.
+	Burst int
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMethodLimit sets the limit of the given method (e.g. "Get" or
+// "GetAll"), on top of the global limit.
+func WithMethodLimit(method string, l Limit) Option {
+	return func(s *Store) {
+		if l.Rate > 0 {
+			s.methods[method] = newBucket(l)
+		}
+	}
+}
+
+// WithReject makes the operations over the limits fail with ErrLimited,
+// rather than wait.
+func WithReject() Option {
+	return func(s *Store) { s.reject = true }
+}
+
+// Store is a store.Store throttling the operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	global  *bucket // nil if there is no global limit
+	methods map[string]*bucket
+	reject  bool
+}
+
+// Wrap returns a Store throttling the operations of s to the global limit.
+func Wrap(s store.Store, global Limit, opts ...Option) *Store {
+	r := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		methods: make(map[string]*bucket),
+	}
+	if global.Rate > 0 {
+		r.global = newBucket(global)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// wait waits for the turn of an operation of the given method.
+func (s *Store) wait(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var taken []*bucket
+	var d time.Duration
+	now := time.Now()
+	for _, b := range [...]*bucket{s.global, s.methods[method]} {
+		if b == nil {
+			continue
+		}
+		bd, ok := b.reserve(now, !s.reject)
+		if !ok {
+			cancel(taken)
+			return ErrLimited
+		}
+		taken = append(taken, b)
+		if bd > d {
+			d = bd
+		}
+	}
+	if d == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(d)) {
+		cancel(taken)
+		return ErrLimited
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		cancel(taken)
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func cancel(taken []*bucket) {
+	for _, b := range taken {
+		b.cancel()
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := s.wait(ctx, "Get"); err != nil {
+		return false, err
+	}
+	return s.Store.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	if err := s.wait(ctx, "GetAll"); err != nil {
+		return err
+	}
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	if err := s.wait(ctx, "Add"); err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	if err := s.wait(ctx, "Set"); err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when the operation is performed, after its turn.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	if err := s.wait(ctx, "SetWithTimeout"); err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	if err := s.wait(ctx, "SetWithDeadline"); err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	if err := s.wait(ctx, "Update"); err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	if err := s.wait(ctx, "Delete"); err != nil {
+		return false, err
+	}
+	return s.Store.Delete(ctx, k)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,115 @@
+package ratelimit_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/ratelimit"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store limiting the rate of the operations of an empty
+// memstore, with a limit high enough not to slow the tests down.
+func newStore() store.Store {
+	return ratelimit.Wrap(memstore.New(), ratelimit.Limit{Rate: 1e6, Burst: 1000})
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+func TestWait(t *testing.T) {
+	f := &storetest.Fake{}
+	s := ratelimit.Wrap(f, ratelimit.Limit{Rate: 10, Burst: 1})
+	ctx := context.Background()
+
+	var v json.RawMessage
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := s.Get(ctx, "k", &v); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("3 operations at 10 per second with a burst of 1 took %v, want about 200ms", d)
+	}
+	if n := len(f.CallsTo("Get")); n != 3 {
+		t.Errorf("got %d calls to the wrapped Store, want 3", n)
+	}
+
+	// Ping is not limited.
+	start = time.Now()
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("Ping waited %v", d)
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	f := &storetest.Fake{}
+	s := ratelimit.Wrap(f, ratelimit.Limit{Rate: 1, Burst: 1})
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Set(ctx, "k", json.RawMessage(`2`)); err != ratelimit.ErrLimited {
+		t.Errorf("Set whose turn comes after the deadline: got %v, want %v", err, ratelimit.ErrLimited)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("Set whose turn comes after the deadline waited %v", d)
+	}
+	if n := len(f.CallsTo("Set")); n != 1 {
+		t.Errorf("got %d calls to the wrapped Store, want 1", n)
+	}
+}
+
+func TestReject(t *testing.T) {
+	f := &storetest.Fake{}
+	s := ratelimit.Wrap(f, ratelimit.Limit{Rate: 1, Burst: 2}, ratelimit.WithReject())
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Delete(ctx, "k"); err != nil {
+			t.Fatalf("Delete within the burst: %v", err)
+		}
+	}
+	if _, err := s.Delete(ctx, "k"); err != ratelimit.ErrLimited {
+		t.Errorf("Delete over the burst: got %v, want %v", err, ratelimit.ErrLimited)
+	}
+	if n := len(f.CallsTo("Delete")); n != 2 {
+		t.Errorf("got %d calls to the wrapped Store, want 2", n)
+	}
+}
+
+func TestMethodLimit(t *testing.T) {
+	f := &storetest.Fake{}
+	s := ratelimit.Wrap(f, ratelimit.Limit{},
+		ratelimit.WithMethodLimit("GetAll", ratelimit.Limit{Rate: 1, Burst: 1}),
+		ratelimit.WithReject(),
+	)
+	ctx := context.Background()
+
+	if err := s.GetAll(ctx, nil); err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if err := s.GetAll(ctx, nil); err != ratelimit.ErrLimited {
+		t.Errorf("GetAll over its limit: got %v, want %v", err, ratelimit.ErrLimited)
+	}
+	var v json.RawMessage
+	for i := 0; i < 10; i++ {
+		if _, err := s.Get(ctx, "k", &v); err != nil {
+			t.Fatalf("Get without a limit: %v", err)
+		}
+	}
+}
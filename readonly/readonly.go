@@ -0,0 +1,87 @@
+/*
+Package readonly provides a read-only view of a Store, to hand to the
+components which must not modify it.
+
+The reads are passed to the wrapped Store, and the writes fail with
+ErrReadOnly. Close does not close the wrapped Store, which belongs to the
+owner of the view. Unlike the other wrappers, the view does not embed
+store.Wrapper, so that the wrapped Store can not be reached with Unwrap.
+*/
+package readonly // import "github.com/gokv/store/readonly"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrReadOnly is returned by the writes. It wraps store.ErrNotSupported.
+var ErrReadOnly = fmt.Errorf("readonly: read-only store: %w", store.ErrNotSupported)
+
+// Store is a read-only store.Store.
+type Store struct {
+	s store.Store
+}
+
+// Wrap returns a read-only view of s.
+func Wrap(s store.Store) *Store {
+	return &Store{s: s}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	return s.s.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.s.GetAll(ctx, c)
+}
+
+// Add fails with ErrReadOnly.
+func (s *Store) Add(context.Context, json.Marshaler) (string, error) {
+	return "", ErrReadOnly
+}
+
+// Set fails with ErrReadOnly.
+func (s *Store) Set(context.Context, string, json.Marshaler) error {
+	return ErrReadOnly
+}
+
+// SetWithTimeout fails with ErrReadOnly.
+func (s *Store) SetWithTimeout(context.Context, string, json.Marshaler, time.Duration) error {
+	return ErrReadOnly
+}
+
+// SetWithDeadline fails with ErrReadOnly.
+func (s *Store) SetWithDeadline(context.Context, string, json.Marshaler, time.Time) error {
+	return ErrReadOnly
+}
+
+// Update fails with ErrReadOnly.
+func (s *Store) Update(context.Context, string, json.Marshaler) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Delete fails with ErrReadOnly.
+func (s *Store) Delete(context.Context, string) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.s.Ping(ctx)
+}
+
+// Close does nothing: the wrapped Store is not closed.
+func (s *Store) Close() error {
+	return nil
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,87 @@
+package readonly_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/readonly"
+)
+
+// values is a store.Collection of raw JSON values.
+type values []*json.RawMessage
+
+func (c *values) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// TestStore checks that the reads reach the wrapped Store, and that the
+// writes fail with ErrReadOnly without modifying it. The conformance suite
+// does not apply, as it writes.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	if err := m.Set(ctx, "a", json.RawMessage(`"one"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s := readonly.Wrap(m)
+
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "a", &v); err != nil || !ok || string(v) != `"one"` {
+		t.Errorf("Get: got %s, %v, %v", v, ok, err)
+	}
+	var c values
+	if err := s.GetAll(ctx, &c); err != nil || len(c) != 1 {
+		t.Errorf("GetAll: got %d items, %v", len(c), err)
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	two := json.RawMessage(`"two"`)
+	writes := map[string]func() error{
+		"Add": func() error {
+			_, err := s.Add(ctx, two)
+			return err
+		},
+		"Set": func() error { return s.Set(ctx, "a", two) },
+		"SetWithTimeout": func() error {
+			return s.SetWithTimeout(ctx, "a", two, time.Minute)
+		},
+		"SetWithDeadline": func() error {
+			return s.SetWithDeadline(ctx, "a", two, time.Now().Add(time.Minute))
+		},
+		"Update": func() error {
+			_, err := s.Update(ctx, "a", two)
+			return err
+		},
+		"Delete": func() error {
+			_, err := s.Delete(ctx, "a")
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, readonly.ErrReadOnly) || !store.IsNotSupported(err) {
+			t.Errorf("%s: got %v, want ErrReadOnly", name, err)
+		}
+	}
+	if ok, err := m.Get(ctx, "a", &v); err != nil || !ok || string(v) != `"one"` {
+		t.Errorf("Get from the wrapped Store after the writes: got %s, %v, %v", v, ok, err)
+	}
+	if n, err := m.Count(ctx); err != nil || n != 1 {
+		t.Errorf("Count of the wrapped Store after the writes: got %d, %v", n, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := m.Ping(ctx); err != nil {
+		t.Errorf("Ping of the wrapped Store after Close: %v", err)
+	}
+}
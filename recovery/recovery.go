@@ -0,0 +1,162 @@
+/*
+Package recovery provides a Store wrapper recovering the panics of the
+operations, so that one bad value or implementation bug does not crash the
+whole program.
+
+A panic in an operation of the wrapped Store, including in the MarshalJSON
+and UnmarshalJSON methods of the values, is recovered and returned as a
+*PanicError, carrying the stack trace of the panic. The panics may also be
+reported, e.g. to an error tracker, with WithReporter.
+
+As the panicking operation may have left the wrapped Store in an
+inconsistent state, the panics are better fixed than tolerated.
+*/
+package recovery // import "github.com/gokv/store/recovery"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// PanicError is returned by the operations which panicked.
+type PanicError struct {
+	// Method is the method which panicked.
+	Method string
+
+	// Value is the value passed to panic.
+	Value any
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovery: panic in %s: %v", e.Method, e.Value)
+}
+
+// Unwrap returns the value passed to panic, if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithReporter sets a function called with every recovered panic, before it
+// is returned. The context is the one of the operation, or
+// context.Background for Close.
+func WithReporter(report func(context.Context, *PanicError)) Option {
+	return func(s *Store) { s.report = report }
+}
+
+// Store is a store.Store recovering the panics of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	report func(context.Context, *PanicError)
+}
+
+// Wrap returns a Store recovering the panics of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	r := &Store{Wrapper: store.Wrapper{Store: s}}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// recover sets *err to a *PanicError if the operation of the given method
+// panicked. It must be deferred.
+func (s *Store) recover(ctx context.Context, method string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	e := &PanicError{Method: method, Value: v, Stack: debug.Stack()}
+	if s.report != nil {
+		s.report(ctx, e)
+	}
+	*err = e
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	defer s.recover(ctx, "Get", &err)
+	return s.Store.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) (err error) {
+	defer s.recover(ctx, "GetAll", &err)
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	defer s.recover(ctx, "Add", &err)
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) (err error) {
+	defer s.recover(ctx, "Set", &err)
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) (err error) {
+	defer s.recover(ctx, "SetWithTimeout", &err)
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) (err error) {
+	defer s.recover(ctx, "SetWithDeadline", &err)
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	defer s.recover(ctx, "Update", &err)
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	defer s.recover(ctx, "Delete", &err)
+	return s.Store.Delete(ctx, k)
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) (err error) {
+	defer s.recover(ctx, "Ping", &err)
+	return s.Store.Ping(ctx)
+}
+
+// Close closes the wrapped Store.
+// Err is non-nil in case of failure.
+func (s *Store) Close() (err error) {
+	defer s.recover(context.Background(), "Close", &err)
+	return s.Store.Close()
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,81 @@
+package recovery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/recovery"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store recovering from the panics of an empty memstore.
+func newStore() store.Store {
+	return recovery.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// panicking panics on MarshalJSON and UnmarshalJSON.
+type panicking struct{}
+
+func (panicking) MarshalJSON() ([]byte, error) { panic("bad value") }
+func (panicking) UnmarshalJSON([]byte) error   { panic("bad value") }
+
+func TestPanic(t *testing.T) {
+	ctx := context.Background()
+	errBug := errors.New("bug")
+	fake := &storetest.Fake{
+		DeleteFunc: func(context.Context, string) (bool, error) { panic(errBug) },
+		CloseFunc:  func() error { panic("close") },
+	}
+	var reported []*recovery.PanicError
+	s := recovery.Wrap(fake, recovery.WithReporter(func(_ context.Context, e *recovery.PanicError) {
+		reported = append(reported, e)
+	}))
+
+	_, err := s.Delete(ctx, "k")
+	var perr *recovery.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Delete: got %v, want a *PanicError", err)
+	}
+	if perr.Method != "Delete" || !errors.Is(err, errBug) || !bytes.Contains(perr.Stack, []byte("recovery_test")) {
+		t.Errorf("Delete: got the method %q, the error %v and the stack:\n%s", perr.Method, err, perr.Stack)
+	}
+	if err := s.Close(); !errors.As(err, &perr) || perr.Method != "Close" || perr.Value != "close" {
+		t.Errorf("Close: got %v, want a *PanicError", err)
+	}
+	if len(reported) != 2 {
+		t.Errorf("got %d panics reported, want 2", len(reported))
+	}
+}
+
+func TestPanickingValue(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := recovery.Wrap(m)
+	if err := m.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var perr *recovery.PanicError
+	if err := s.Set(ctx, "k", panicking{}); !errors.As(err, &perr) || perr.Method != "Set" {
+		t.Errorf("Set of a panicking value: got %v, want a *PanicError", err)
+	}
+	if _, err := s.Get(ctx, "k", panicking{}); !errors.As(err, &perr) || perr.Method != "Get" {
+		t.Errorf("Get to a panicking value: got %v, want a *PanicError", err)
+	}
+	// The Store is still usable.
+	var v json.RawMessage
+	if ok, err := s.Get(ctx, "k", &v); err != nil || !ok || string(v) != "1" {
+		t.Errorf("Get after the panics: got %s, %v, %v", v, ok, err)
+	}
+}
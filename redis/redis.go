@@ -0,0 +1,526 @@
+/*
+Package redis implements store.Store on top of Redis, with the go-redis
+client.
+
+The values are stored as Redis strings holding their JSON encoding. The
+expirations are native: SetWithTimeout maps to SET with PX, and
+SetWithDeadline to SET followed by PEXPIREAT in a transaction. Update and
+CompareAndSet keep the expiration of the key.
+
+GetAll and Keys walk the keyspace with SCAN, so they see every key of the
+database: the database is expected to be dedicated to the Store.
+
+With a *goredis.ClusterClient, GetAll and Keys scan every master node, and
+the multi-key commands are split by hash slot. SetMulti is then only atomic
+for the keys of a same slot, e.g. the keys sharing a {hash tag}.
+*/
+package redis // import "github.com/gokv/store/redis"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// scanCount is the COUNT hint of the SCAN calls, and the size of the MGET
+// batches of GetAll.
+const scanCount = 1000
+
+// Store is a store.Store backed by Redis.
+type Store struct {
+	c goredis.UniversalClient
+
+	// KeyGen returns the candidate keys for Add. It defaults to random
+	// hexadecimal strings. Add retries with a new key if the candidate key
+	// already exists.
+	KeyGen func() (string, error)
+}
+
+// New returns a Store using c, which is either a *goredis.Client, possibly
+// with failover, or a *goredis.ClusterClient. A *goredis.Ring is not
+// supported, as GetAll, Keys and the multi-key commands would only involve
+// one of its shards. Closing the Store closes c.
+func New(c goredis.UniversalClient) *Store {
+	return &Store{
+		c:      c,
+		KeyGen: randomKey,
+	}
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	b, err := s.c.Get(ctx, k).Bytes()
+	if err == goredis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(b)
+}
+
+// GetAll unmarshals to c every item in the database.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.scan(ctx, "*", func(ks []string) error {
+		vs, err := s.mget(ctx, ks)
+		if err != nil {
+			return err
+		}
+		for _, v := range vs {
+			// The keys deleted or expired since the SCAN are nil.
+			if v, ok := v.(string); ok {
+				if err := c.New().UnmarshalJSON([]byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// scan calls fn with every batch of keys matching the glob pattern. With a
+// cluster, every master node is scanned concurrently, and the calls to fn
+// are serialized.
+func (s *Store) scan(ctx context.Context, match string, fn func(ks []string) error) error {
+	cc, ok := s.c.(*goredis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, s.c, match, fn)
+	}
+	var mu sync.Mutex
+	return cc.ForEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+		return scanNode(ctx, node, match, func(ks []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(ks)
+		})
+	})
+}
+
+// scanNode calls fn with every batch of keys of c matching the glob
+// pattern.
+func scanNode(ctx context.Context, c goredis.Cmdable, match string, fn func(ks []string) error) error {
+	var cursor uint64
+	for {
+		ks, next, err := c.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(ks) > 0 {
+			if err := fn(ks); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// bySlot returns the indexes of ks grouped by hash slot with a cluster, or
+// a single group of every index otherwise.
+func (s *Store) bySlot(ks []string) [][]int {
+	if _, ok := s.c.(*goredis.ClusterClient); !ok {
+		group := make([]int, len(ks))
+		for i := range ks {
+			group[i] = i
+		}
+		return [][]int{group}
+	}
+	var groups [][]int
+	slots := make(map[int]int)
+	for i, k := range ks {
+		slot := keySlot(k)
+		g, ok := slots[slot]
+		if !ok {
+			g = len(groups)
+			slots[slot] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// mget returns the values of ks with MGET, issued once per hash slot in a
+// single pipeline with a cluster. The values of the missing keys are nil.
+func (s *Store) mget(ctx context.Context, ks []string) ([]any, error) {
+	groups := s.bySlot(ks)
+	if len(groups) == 1 {
+		return s.c.MGet(ctx, ks...).Result()
+	}
+	cmds := make([]*goredis.SliceCmd, len(groups))
+	_, err := s.c.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for i, g := range groups {
+			cmds[i] = p.MGet(ctx, pick(ks, g)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]any, len(ks))
+	for i, g := range groups {
+		for j, v := range cmds[i].Val() {
+			vs[g[j]] = v
+		}
+	}
+	return vs, nil
+}
+
+// pick returns the keys of ks at the given indexes.
+func pick(ks []string, indexes []int) []string {
+	picked := make([]string, len(indexes))
+	for i, j := range indexes {
+		picked[i] = ks[j]
+	}
+	return picked
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		k, err := s.KeyGen()
+		if err != nil {
+			return "", err
+		}
+		ok, err := s.c.SetNX(ctx, k, b, 0).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return k, nil
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.c.Set(ctx, k, b, 0).Err()
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		// A zero expiration means no expiration to go-redis.
+		return s.c.Del(ctx, k).Err()
+	}
+	return s.c.Set(ctx, k, b, timeout).Err()
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.c.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+		p.Set(ctx, k, b, 0)
+		p.PExpireAt(ctx, k, deadline)
+		return nil
+	})
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	err = s.c.SetArgs(ctx, k, b, goredis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err == goredis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	n, err := s.c.Del(ctx, k).Result()
+	return n > 0, err
+}
+
+// Ping returns a non-nil error if the Redis server can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.c.Ping(ctx).Err()
+}
+
+// Close closes the Redis client.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.c.Close()
+}
+
+// GetMulti retrieves the values of the given keys with MGET and unmarshals
+// each of them to the element of vs with the same index.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMulti(ctx context.Context, ks []string, vs []json.Unmarshaler) ([]bool, error) {
+	if len(ks) != len(vs) {
+		return nil, fmt.Errorf("redis: %d keys for %d values", len(ks), len(vs))
+	}
+	ok := make([]bool, len(ks))
+	if len(ks) == 0 {
+		return ok, nil
+	}
+	values, err := s.mget(ctx, ks)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value, found := value.(string); found {
+			ok[i] = true
+			if err := vs[i].UnmarshalJSON([]byte(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ok, nil
+}
+
+// SetMulti assigns each element of vs to the key of ks with the same index,
+// atomically with MSET. With a cluster, MSET is issued once per hash slot in
+// a single pipeline, so that the atomicity only holds within a slot.
+// Err is non-nil in case of failure.
+func (s *Store) SetMulti(ctx context.Context, ks []string, vs []json.Marshaler) error {
+	if len(ks) != len(vs) {
+		return fmt.Errorf("redis: %d keys for %d values", len(ks), len(vs))
+	}
+	if len(ks) == 0 {
+		return nil
+	}
+	values := make([][]byte, len(vs))
+	for i, v := range vs {
+		b, err := v.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		values[i] = b
+	}
+	mset := func(c goredis.Cmdable, g []int) error {
+		pairs := make([]any, 0, 2*len(g))
+		for _, i := range g {
+			pairs = append(pairs, ks[i], values[i])
+		}
+		return c.MSet(ctx, pairs...).Err()
+	}
+	groups := s.bySlot(ks)
+	if len(groups) == 1 {
+		return mset(s.c, groups[0])
+	}
+	_, err := s.c.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for _, g := range groups {
+			mset(p, g)
+		}
+		return nil
+	})
+	return err
+}
+
+// DeleteMulti removes the given keys and their values from the store, in a
+// single pipeline.
+// Ok[i] is false if the key ks[i] was not found.
+// Err is non-nil in case of failure.
+func (s *Store) DeleteMulti(ctx context.Context, ks []string) ([]bool, error) {
+	cmds := make([]*goredis.IntCmd, len(ks))
+	_, err := s.c.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for i, k := range ks {
+			cmds[i] = p.Del(ctx, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok := make([]bool, len(ks))
+	for i, cmd := range cmds {
+		ok[i] = cmd.Val() > 0
+	}
+	return ok, nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	n, err := s.c.Exists(ctx, k).Result()
+	return n > 0, err
+}
+
+// Keys returns every key starting with prefix, in no particular order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := s.scan(ctx, escapeGlob(prefix)+"*", func(ks []string) error {
+		keys = append(keys, ks...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dedup(keys), nil
+}
+
+// escapeGlob escapes the special characters of the Redis glob patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '^', '-', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dedup removes the duplicates SCAN may return.
+func dedup(ks []string) []string {
+	seen := make(map[string]struct{}, len(ks))
+	unique := ks[:0]
+	for _, k := range ks {
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
+
+// Incr atomically adds delta to the integer value of the given key with
+// INCRBY, and returns the result. The expiration of the key is kept.
+// Err is non-nil in case of failure, including when the current value is
+// not an integer.
+func (s *Store) Incr(ctx context.Context, k string, delta int64) (int64, error) {
+	return s.c.IncrBy(ctx, k, delta).Result()
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	ttl, err := s.c.PTTL(ctx, k).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	switch ttl {
+	case -2:
+		return 0, false, nil
+	case -1:
+		return 0, true, nil
+	}
+	return ttl, true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return s.Delete(ctx, k)
+	}
+	return s.c.PExpire(ctx, k, timeout).Result()
+}
+
+// GetAndDelete retrieves the value of the given key with GETDEL, unmarshals
+// it to v and removes the key from the store. It requires Redis 6.2.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetAndDelete(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	b, err := s.c.GetDel(ctx, k).Bytes()
+	if err == goredis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(b)
+}
+
+// compareAndSet replaces the value of KEYS[1] with ARGV[2] if it is ARGV[1],
+// keeping its expiration.
+var compareAndSet = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old. The comparison is made by a Lua script, on the JSON
+// encodings. The expiration of the key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	n, err := compareAndSet.Run(ctx, s.c, []string{k}, o, b).Int()
+	if err != nil && !errors.Is(err, goredis.Nil) {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Batch            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Counter          = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.GetAndDeleter    = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,39 @@
+package redis_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/redis"
+	"github.com/gokv/store/storetest"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// newStore returns a function connecting an empty Store to the database of
+// the GOKV_REDIS_URL environment variable, e.g. redis://localhost:6379/15,
+// which is flushed every time. The test is skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	url := os.Getenv("GOKV_REDIS_URL")
+	if url == "" {
+		tb.Skip("GOKV_REDIS_URL is not set")
+	}
+	opt, err := goredis.ParseURL(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return func() store.Store {
+		c := goredis.NewClient(opt)
+		if err := c.FlushDB(context.Background()).Err(); err != nil {
+			panic(err)
+		}
+		return redis.New(c)
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,35 @@
+package redis
+
+import "strings"
+
+// slotCount is the number of hash slots of a Redis cluster.
+const slotCount = 16384
+
+// keySlot returns the hash slot of k in a Redis cluster: the CRC16 of its
+// hash tag, the part between the first "{" and the next "}" if not empty, or
+// of the whole key otherwise.
+func keySlot(k string) int {
+	if i := strings.IndexByte(k, '{'); i >= 0 {
+		if j := strings.IndexByte(k[i+1:], '}'); j > 0 {
+			k = k[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(k) % slotCount)
+}
+
+// crc16 returns the CRC16-CCITT (XMODEM) checksum of s, as used by the
+// Redis cluster key distribution.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
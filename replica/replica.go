@@ -0,0 +1,223 @@
+/*
+Package replica provides a Store wrapper sending the writes to a primary
+Store and spreading the reads across its read replicas.
+
+Get and GetAll are sent to the replicas in turn, and to the primary when a
+replica fails. As the replicas may lag behind the primary, a context may be
+made sticky with Sticky: the reads with a sticky context go to the primary
+for a while after a write with the same context, so that a request reads its
+own writes.
+*/
+package replica // import "github.com/gokv/store/replica"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// DefaultStickiness is the duration during which the reads with a sticky
+// context go to the primary after a write, unless specified otherwise with
+// WithStickiness.
+const DefaultStickiness = 5 * time.Second
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithStickiness sets the duration during which the reads with a sticky
+// context go to the primary after a write. It is to exceed the replication
+// lag.
+func WithStickiness(d time.Duration) Option {
+	return func(s *Store) { s.stickiness = d }
+}
+
+// session records the last write of a sticky context.
+type session struct {
+	mu    sync.Mutex
+	write time.Time
+}
+
+type sessionKey struct{}
+
+// Sticky returns a copy of ctx whose reads go to the primary after a write,
+// as do the reads of the contexts derived from it.
+func Sticky(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, new(session))
+}
+
+func sessionOf(ctx context.Context) *session {
+	sess, _ := ctx.Value(sessionKey{}).(*session)
+	return sess
+}
+
+// Store is a store.Store spreading the reads across replicas.
+type Store struct {
+	store.Wrapper
+	replicas   []store.Store
+	stickiness time.Duration
+	next       uint64
+}
+
+// Wrap returns a Store writing to primary, and reading from replicas.
+// Without replicas, the reads go to primary.
+func Wrap(primary store.Store, replicas []store.Store, opts ...Option) *Store {
+	s := &Store{
+		Wrapper:    store.Wrapper{Store: primary},
+		replicas:   replicas,
+		stickiness: DefaultStickiness,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// replica returns the Store to read from with ctx, and whether it is a
+// replica.
+func (s *Store) replica(ctx context.Context) (store.Store, bool) {
+	if len(s.replicas) == 0 {
+		return s.Store, false
+	}
+	if sess := sessionOf(ctx); sess != nil {
+		sess.mu.Lock()
+		recent := !sess.write.IsZero() && time.Since(sess.write) < s.stickiness
+		sess.mu.Unlock()
+		if recent {
+			return s.Store, false
+		}
+	}
+	i := atomic.AddUint64(&s.next, 1)
+	return s.replicas[i%uint64(len(s.replicas))], true
+}
+
+// wrote records a write with ctx.
+func wrote(ctx context.Context) {
+	if sess := sessionOf(ctx); sess != nil {
+		sess.mu.Lock()
+		sess.write = time.Now()
+		sess.mu.Unlock()
+	}
+}
+
+// fallback reports whether a read failing with err is to be sent to the
+// primary.
+func fallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return !store.IsNotSupported(err)
+}
+
+// Get retrieves a new value by key and unmarshals it to v, from a replica if
+// possible.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	st, replica := s.replica(ctx)
+	ok, err := st.Get(ctx, k, v)
+	if err != nil && replica && fallback(ctx, err) {
+		return s.Store.Get(ctx, k, v)
+	}
+	return ok, err
+}
+
+// raws collects the items read from a replica, so that the items of a
+// failing replica are not added to the Collection of the caller.
+type raws []*json.RawMessage
+
+func (c *raws) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// GetAll unmarshals to c every item in the store, from a replica if
+// possible.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	st, replica := s.replica(ctx)
+	if !replica {
+		return st.GetAll(ctx, c)
+	}
+	var items raws
+	if err := st.GetAll(ctx, &items); err != nil {
+		if !fallback(ctx, err) {
+			return err
+		}
+		return s.Store.GetAll(ctx, c)
+	}
+	for _, v := range items {
+		if err := c.New().UnmarshalJSON(*v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key in the primary, and returns the
+// key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	defer wrote(ctx)
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key in the primary.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	defer wrote(ctx)
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key in the primary,
+// possibly overwriting. The assigned key will clear after timeout. The
+// lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	defer wrote(ctx)
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key in the primary,
+// possibly overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	defer wrote(ctx)
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key in the primary, if it
+// exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	defer wrote(ctx)
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the primary.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	defer wrote(ctx)
+	return s.Store.Delete(ctx, k)
+}
+
+// Close closes the primary and the replicas.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	err := s.Store.Close()
+	for _, r := range s.replicas {
+		if rerr := r.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
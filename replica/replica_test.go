@@ -0,0 +1,117 @@
+package replica_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/replica"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store whose primary and replica are the same empty
+// memstore, so that the replication is immediate.
+func newStore() store.Store {
+	m := memstore.New()
+	return replica.Wrap(m, []store.Store{m})
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// reads returns the number of Get calls received by each Fake.
+func reads(fakes ...*storetest.Fake) []int {
+	n := make([]int, len(fakes))
+	for i, f := range fakes {
+		n[i] = len(f.CallsTo("Get"))
+	}
+	return n
+}
+
+func TestSpread(t *testing.T) {
+	primary, r1, r2 := &storetest.Fake{}, &storetest.Fake{}, &storetest.Fake{}
+	s := replica.Wrap(primary, []store.Store{r1, r2})
+
+	var v json.RawMessage
+	for i := 0; i < 4; i++ {
+		if _, err := s.Get(context.Background(), "k", &v); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if got := reads(primary, r1, r2); got[0] != 0 || got[1] != 2 || got[2] != 2 {
+		t.Errorf("got %v reads from the primary and the replicas, want [0 2 2]", got)
+	}
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if n := len(primary.CallsTo("Set")); n != 1 {
+		t.Errorf("got %d writes to the primary, want 1", n)
+	}
+	if n := len(r1.CallsTo("Set")) + len(r2.CallsTo("Set")); n != 0 {
+		t.Errorf("got %d writes to the replicas, want none", n)
+	}
+}
+
+func TestFailingReplica(t *testing.T) {
+	primary := &storetest.Fake{}
+	failing := &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, errors.New("down")
+		},
+	}
+	s := replica.Wrap(primary, []store.Store{failing})
+
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); err != nil {
+		t.Fatalf("Get with a failing replica: %v", err)
+	}
+	if got := reads(primary, failing); got[0] != 1 || got[1] != 1 {
+		t.Errorf("got %v reads from the primary and the replica, want [1 1]", got)
+	}
+
+	// The errors not due to the replica are returned as is.
+	primary.Reset()
+	failing.GetFunc = func(context.Context, string, json.Unmarshaler) (bool, error) {
+		return false, context.DeadlineExceeded
+	}
+	if _, err := s.Get(context.Background(), "k", &v); err != context.DeadlineExceeded {
+		t.Errorf("Get: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := len(primary.CallsTo("Get")); n != 0 {
+		t.Errorf("got %d reads from the primary, want none", n)
+	}
+}
+
+func TestSticky(t *testing.T) {
+	primary, r := &storetest.Fake{}, &storetest.Fake{}
+	s := replica.Wrap(primary, []store.Store{r}, replica.WithStickiness(50*time.Millisecond))
+
+	ctx := replica.Sticky(context.Background())
+	var v json.RawMessage
+	s.Get(ctx, "k", &v)
+	if got := reads(primary, r); got[0] != 0 || got[1] != 1 {
+		t.Fatalf("read before any write: got %v reads from the primary and the replica, want [0 1]", got)
+	}
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s.Get(ctx, "k", &v)
+	s.Get(context.Background(), "k", &v)
+	if got := reads(primary, r); got[0] != 1 || got[1] != 2 {
+		t.Fatalf("reads after a write: got %v reads from the primary and the replica, want [1 2]", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	s.Get(ctx, "k", &v)
+	if got := reads(primary, r); got[0] != 1 || got[1] != 3 {
+		t.Errorf("read after the stickiness: got %v reads from the primary and the replica, want [1 3]", got)
+	}
+}
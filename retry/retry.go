@@ -0,0 +1,225 @@
+/*
+Package retry provides a Store wrapper retrying the idempotent operations on
+transient errors, with a jittered exponential backoff.
+
+Get, GetAll, Set, SetWithTimeout, SetWithDeadline, Update, Delete and Ping
+are retried; Add, which is not idempotent, and Close are not. The Ok result
+of a retried Update or Delete reflects the last attempt: a Delete whose
+first attempt removed the key before failing reports that the key was not
+found. The lifespan of a retried SetWithTimeout starts with the last
+attempt.
+
+No attempt is made once the context is done, nor when its deadline would
+expire during the backoff: the last error is returned instead.
+*/
+package retry // import "github.com/gokv/store/retry"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// The default settings of the Store.
+const (
+	DefaultMaxAttempts = 4
+	DefaultBaseDelay   = 50 * time.Millisecond
+	DefaultMaxDelay    = 2 * time.Second
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMaxAttempts sets the maximum number of attempts of an operation,
+// including the first one.
+func WithMaxAttempts(n int) Option {
+	return func(s *Store) { s.attempts = n }
+}
+
+// WithBackoff sets the delay before the first retry, doubled after every
+// retry up to max. The delays are jittered by up to one half.
+func WithBackoff(base, max time.Duration) Option {
+	return func(s *Store) { s.base, s.max = base, max }
+}
+
+// WithClassifier sets the function reporting whether an error is transient,
+// in place of Transient.
+func WithClassifier(retryable func(error) bool) Option {
+	return func(s *Store) { s.retryable = retryable }
+}
+
+// Transient is the default classifier. It reports whether err may be
+// transient: every error is, except the context errors and those wrapping
+// store.ErrNotFound or store.ErrNotSupported.
+func Transient(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case store.IsNotFound(err), store.IsNotSupported(err):
+		return false
+	}
+	return true
+}
+
+// Store is a store.Store retrying the operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	attempts  int
+	base, max time.Duration
+	retryable func(error) bool
+}
+
+// Wrap returns a Store retrying the idempotent operations of s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	r := &Store{
+		Wrapper:   store.Wrapper{Store: s},
+		attempts:  DefaultMaxAttempts,
+		base:      DefaultBaseDelay,
+		max:       DefaultMaxDelay,
+		retryable: Transient,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// delay returns the backoff before the given retry, starting at 1.
+func (s *Store) delay(retry int) time.Duration {
+	d := s.base
+	for i := 1; i < retry && d < s.max; i++ {
+		d *= 2
+	}
+	if d > s.max {
+		d = s.max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do calls fn until it succeeds, fails with an error which is not transient,
+// or the attempts are exhausted.
+func (s *Store) do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.attempts || !s.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		d := s.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		ok, err = s.Store.Get(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// raws collects the items of the attempts of GetAll, so that the items of
+// the failed ones are not added to the Collection of the caller.
+type raws []*json.RawMessage
+
+func (c *raws) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	var items raws
+	err := s.do(ctx, func() error {
+		items = items[:0]
+		return s.Store.GetAll(ctx, &items)
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range items {
+		if err := c.New().UnmarshalJSON(*v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.do(ctx, func() error {
+		return s.Store.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when the last attempt is made.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.do(ctx, func() error {
+		return s.Store.SetWithTimeout(ctx, k, v, timeout)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.do(ctx, func() error {
+		return s.Store.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		ok, err = s.Store.Update(ctx, k, v)
+		return err
+	})
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		ok, err = s.Store.Delete(ctx, k)
+		return err
+	})
+	return ok, err
+}
+
+// Ping returns a non-nil error if the wrapped Store is still not healthy
+// after the retries.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.do(ctx, func() error {
+		return s.Store.Ping(ctx)
+	})
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,146 @@
+package retry_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/retry"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store retrying the operations of an empty memstore.
+func newStore() store.Store {
+	return retry.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+var errTransient = errors.New("transient")
+
+// flaky returns a Fake whose Set fails with errTransient the given number of
+// times before succeeding.
+func flaky(failures int) *storetest.Fake {
+	var n int32
+	return &storetest.Fake{
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			if atomic.AddInt32(&n, 1) <= int32(failures) {
+				return errTransient
+			}
+			return nil
+		},
+		AddFunc: func(context.Context, json.Marshaler) (string, error) {
+			return "", errTransient
+		},
+	}
+}
+
+func TestRetry(t *testing.T) {
+	f := flaky(2)
+	s := retry.Wrap(f, retry.WithBackoff(time.Millisecond, time.Millisecond))
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if n := len(f.CallsTo("Set")); n != 3 {
+		t.Errorf("Set: got %d attempts, want 3", n)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	f := flaky(10)
+	s := retry.Wrap(f, retry.WithMaxAttempts(3), retry.WithBackoff(time.Millisecond, time.Millisecond))
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != errTransient {
+		t.Fatalf("Set: got %v, want %v", err, errTransient)
+	}
+	if n := len(f.CallsTo("Set")); n != 3 {
+		t.Errorf("Set: got %d attempts, want 3", n)
+	}
+}
+
+func TestNotRetried(t *testing.T) {
+	f := &storetest.Fake{
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			return fmt.Errorf("set: %w", store.ErrNotSupported)
+		},
+		AddFunc: func(context.Context, json.Marshaler) (string, error) {
+			return "", errTransient
+		},
+	}
+	s := retry.Wrap(f, retry.WithBackoff(time.Millisecond, time.Millisecond))
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); !store.IsNotSupported(err) {
+		t.Errorf("Set: got %v, want ErrNotSupported", err)
+	}
+	if n := len(f.CallsTo("Set")); n != 1 {
+		t.Errorf("Set failing with ErrNotSupported: got %d attempts, want 1", n)
+	}
+	if _, err := s.Add(context.Background(), json.RawMessage(`1`)); err != errTransient {
+		t.Errorf("Add: got %v, want %v", err, errTransient)
+	}
+	if n := len(f.CallsTo("Add")); n != 1 {
+		t.Errorf("Add: got %d attempts, want 1", n)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	f := flaky(10)
+	s := retry.Wrap(f, retry.WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != errTransient {
+		t.Fatalf("Set: got %v, want %v", err, errTransient)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Set waited %v for a backoff exceeding the deadline", d)
+	}
+	if n := len(f.CallsTo("Set")); n != 1 {
+		t.Errorf("Set: got %d attempts, want 1", n)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+func TestGetAllAttempts(t *testing.T) {
+	var n int32
+	f := &storetest.Fake{
+		GetAllFunc: func(ctx context.Context, c store.Collection) error {
+			if err := c.New().UnmarshalJSON([]byte(`1`)); err != nil {
+				return err
+			}
+			if atomic.AddInt32(&n, 1) == 1 {
+				return errTransient
+			}
+			return c.New().UnmarshalJSON([]byte(`2`))
+		},
+	}
+	s := retry.Wrap(f, retry.WithBackoff(time.Millisecond, time.Millisecond))
+
+	var c items
+	if err := s.GetAll(context.Background(), &c); err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(c) != 2 || string(*c[0]) != `1` || string(*c[1]) != `2` {
+		t.Errorf("GetAll: got %d items, want only the 2 of the last attempt", len(c))
+	}
+}
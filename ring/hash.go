@@ -0,0 +1,64 @@
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// point is a virtual node of a hash ring.
+type point struct {
+	hash uint64
+	node string
+}
+
+// hashRing maps the keys to the nodes owning them. It is immutable.
+type hashRing struct {
+	points []point // sorted by hash
+}
+
+// newHashRing returns a ring placing replicas virtual nodes per unit of
+// weight of every node.
+func newHashRing(weights map[string]int, replicas int) *hashRing {
+	r := new(hashRing)
+	for name, w := range weights {
+		for i := 0; i < w*replicas; i++ {
+			r.points = append(r.points, point{hash: hash(name + "#" + strconv.Itoa(i)), node: name})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].node < r.points[j].node
+	})
+	return r
+}
+
+// owner returns the node owning k, the first clockwise from its hash. Ok is
+// false if the ring is empty.
+func (r *hashRing) owner(k string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	h := hash(k)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node, true
+}
+
+// hash returns the 64-bit FNV-1a hash of s, mixed with the finalizer of
+// SplitMix64 for a better spread of the close strings.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,551 @@
+/*
+Package ring provides a Store distributing the keys across weighted nodes
+with consistent hashing, whose nodes can be added and removed at runtime.
+
+Every node is placed on a hash ring as many times as its weight, times the
+number of replicas given with WithReplicas; a key belongs to the first node
+found clockwise from its hash. Adding or removing a node moves only the keys
+between it and its neighbours.
+
+After a change of the nodes, the keys are still found where they were: until
+they are migrated by Rebalance, the reads and the updates look up every
+previous owner of the key after its current one, and Delete removes the key
+from all of them. The writes go to the current owner, and remove the key from
+the previous ones. Rebalance moves the keys to their current owner, except
+those written or deleted since the change, and requires the nodes to
+implement store.KeyLister; it runs in the background with
+WithRebalanceInterval. The removed nodes are closed once their keys are
+migrated. Rebalance fails with ErrNoNode while the ring has no node, so that
+the keys of the removed nodes are not lost.
+
+A key updated in its previous owner while it is being migrated may lose the
+write. Until the rebalance completes, GetAll may return an item twice.
+*/
+package ring // import "github.com/gokv/store/ring"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrNoNode is returned by the writes and Rebalance when the ring has no node,
+// and by RemoveNode when the node is not in the ring.
+var ErrNoNode = errors.New("ring: no such node")
+
+// DefaultReplicas is the number of virtual nodes per unit of weight, unless
+// specified otherwise with WithReplicas.
+const DefaultReplicas = 100
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithReplicas sets the number of virtual nodes per unit of weight.
+func WithReplicas(n int) Option {
+	return func(s *Store) { s.replicas = n }
+}
+
+// WithRebalanceInterval runs Rebalance in the background at the given
+// interval, while keys may have to be migrated.
+func WithRebalanceInterval(interval time.Duration) Option {
+	return func(s *Store) { s.interval = interval }
+}
+
+// node is a node of the ring.
+type node struct {
+	store  store.Store
+	weight int
+}
+
+// Store is a store.Store distributing the keys across the nodes of a hash
+// ring.
+type Store struct {
+	replicas int
+	interval time.Duration
+
+	// rebalancing is held during the rebalances.
+	rebalancing sync.Mutex
+
+	mu      sync.RWMutex
+	nodes   map[string]node
+	retired map[string]store.Store // the removed nodes not rebalanced yet
+	rings   []*hashRing            // the current ring, then the previous ones not rebalanced yet
+	written map[string]*hashRing   // the keys written since the change, with the ring they were written with
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store with no node.
+func New(opts ...Option) *Store {
+	s := &Store{
+		replicas: DefaultReplicas,
+		nodes:    make(map[string]node),
+		retired:  make(map[string]store.Store),
+		rings:    []*hashRing{new(hashRing)},
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.interval > 0 {
+		s.done.Add(1)
+		go s.rebalanceEvery(s.interval)
+	}
+	return s
+}
+
+func (s *Store) rebalanceEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			// A failed rebalance is retried at the next tick.
+			_ = s.Rebalance(context.Background())
+		}
+	}
+}
+
+// reshape makes a ring of the current nodes the current ring. It must be
+// called holding mu.
+func (s *Store) reshape() {
+	weights := make(map[string]int, len(s.nodes))
+	for name, n := range s.nodes {
+		weights[name] = n.weight
+	}
+	s.rings = append([]*hashRing{newHashRing(weights, s.replicas)}, s.rings...)
+}
+
+// AddNode adds the node name, holding its keys in st, to the ring with the
+// given weight.
+// Err is non-nil if the node is already in the ring, or is being removed.
+func (s *Store) AddNode(name string, st store.Store, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("ring: node %q: invalid weight %d", name, weight)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nodes[name]; ok {
+		return fmt.Errorf("ring: node %q already exists", name)
+	}
+	if _, ok := s.retired[name]; ok {
+		return fmt.Errorf("ring: node %q is being removed", name)
+	}
+	s.nodes[name] = node{store: st, weight: weight}
+	s.reshape()
+	return nil
+}
+
+// RemoveNode removes the node name from the ring. Its keys are still found
+// until they are migrated by Rebalance, which then closes it.
+// Err is non-nil if the node is not in the ring.
+func (s *Store) RemoveNode(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrNoNode, name)
+	}
+	delete(s.nodes, name)
+	s.retired[name] = n.store
+	s.reshape()
+	return nil
+}
+
+// Nodes returns the names of the nodes of the ring, in lexical order.
+func (s *Store) Nodes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.nodes))
+	for name := range s.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// store returns the Store of the node name. It must be called holding mu.
+func (s *Store) store(name string) store.Store {
+	if n, ok := s.nodes[name]; ok {
+		return n.store
+	}
+	return s.retired[name]
+}
+
+// owners returns the Stores of the current owner of k, then of its previous
+// owners.
+func (s *Store) owners(k string) []store.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lookup(k)
+}
+
+// lookup returns the Stores of the current owner of k, then of its previous
+// owners. It must be called holding mu.
+func (s *Store) lookup(k string) []store.Store {
+	var names []string
+	var stores []store.Store
+next:
+	for _, r := range s.rings {
+		name, ok := r.owner(k)
+		if !ok {
+			continue
+		}
+		for _, seen := range names {
+			if seen == name {
+				continue next
+			}
+		}
+		names = append(names, name)
+		stores = append(stores, s.store(name))
+	}
+	return stores
+}
+
+// touch records that k is written or deleted, while a rebalance is pending,
+// and returns the Stores of its current owner, then of its previous owners.
+// Current is false if the ring has no node: the Stores are the previous
+// owners only.
+func (s *Store) touch(k string) (stores []store.Store, current bool) {
+	s.mu.RLock()
+	if len(s.rings) == 1 {
+		defer s.mu.RUnlock()
+		_, current = s.rings[0].owner(k)
+		return s.lookup(k), current
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.rings) > 1 {
+		if s.written == nil {
+			s.written = make(map[string]*hashRing)
+		}
+		s.written[k] = s.rings[0]
+	}
+	_, current = s.rings[0].owner(k)
+	return s.lookup(k), current
+}
+
+// write calls fn with the Store of the current owner of k, then removes k
+// from its previous owners, so that a stale copy is not read back once k
+// expires in its current owner.
+func (s *Store) write(ctx context.Context, k string, fn func(store.Store) error) error {
+	stores, current := s.touch(k)
+	if !current {
+		return ErrNoNode
+	}
+	if err := fn(stores[0]); err != nil {
+		return err
+	}
+	for _, st := range stores[1:] {
+		if _, err := st.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writtenWith reports whether k was written or deleted with the ring r.
+func (s *Store) writtenWith(k string, r *hashRing) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.written[k] == r
+}
+
+// all returns the Stores of every node, including the removed nodes not
+// rebalanced yet, by name.
+func (s *Store) all() map[string]store.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stores := make(map[string]store.Store, len(s.nodes)+len(s.retired))
+	for name, n := range s.nodes {
+		stores[name] = n.store
+	}
+	for name, st := range s.retired {
+		stores[name] = st
+	}
+	return stores
+}
+
+// sorted returns the names of stores, in lexical order.
+func sorted(stores map[string]store.Store) []string {
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rebalance moves the keys to their current owner, then closes the removed
+// nodes. It returns at the first failure, and is to be called again then.
+// Err is non-nil in case of failure, if a node is not a store.KeyLister, or
+// if the ring has no node to move the keys to.
+func (s *Store) Rebalance(ctx context.Context) error {
+	s.rebalancing.Lock()
+	defer s.rebalancing.Unlock()
+
+	s.mu.RLock()
+	pending, current := len(s.rings) > 1, s.rings[0]
+	s.mu.RUnlock()
+	if !pending {
+		return nil
+	}
+	if len(current.points) == 0 {
+		return ErrNoNode
+	}
+
+	stores := s.all()
+	for _, name := range sorted(stores) {
+		kl, ok := stores[name].(store.KeyLister)
+		if !ok {
+			return fmt.Errorf("ring: node %q: Keys: %w", name, store.ErrNotSupported)
+		}
+		ks, err := kl.Keys(ctx, "")
+		if err != nil {
+			return fmt.Errorf("ring: node %q: %w", name, err)
+		}
+		for _, k := range ks {
+			owner, _ := current.owner(k)
+			if owner == name {
+				continue
+			}
+			s.mu.RLock()
+			dst := s.store(owner)
+			s.mu.RUnlock()
+			if err := s.migrate(ctx, k, current, stores[name], dst); err != nil {
+				return fmt.Errorf("ring: migrating %q from node %q to %q: %w", k, name, owner, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	var removed []store.Store
+	if s.rings[0] == current {
+		s.rings = s.rings[:1]
+		for _, name := range sorted(s.retired) {
+			removed = append(removed, s.retired[name])
+		}
+		s.retired = make(map[string]store.Store)
+		s.written = nil
+	}
+	s.mu.Unlock()
+
+	var first error
+	for _, st := range removed {
+		if err := st.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// migrate moves k from src to dst, its owner in the ring r, unless dst holds
+// it already. The copy of src is dropped if k was written or deleted with r:
+// it is stale.
+func (s *Store) migrate(ctx context.Context, k string, r *hashRing, src, dst store.Store) error {
+	var data json.RawMessage
+	if ok, err := src.Get(ctx, k, &data); err != nil || !ok {
+		return err
+	}
+	var deadline time.Time
+	if t, ok := src.(store.TTLStore); ok {
+		ttl, ok, err := t.GetTTL(ctx, k)
+		if err != nil || !ok {
+			return err
+		}
+		if ttl > 0 {
+			deadline = time.Now().Add(ttl)
+		}
+	}
+
+	if s.writtenWith(k, r) {
+		_, err := src.Delete(ctx, k)
+		return err
+	}
+	var exists bool
+	var err error
+	if e, ok := dst.(store.Exister); ok {
+		exists, err = e.Exists(ctx, k)
+	} else {
+		exists, err = dst.Get(ctx, k, new(json.RawMessage))
+	}
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if deadline.IsZero() {
+			err = dst.Set(ctx, k, data)
+		} else {
+			err = dst.SetWithDeadline(ctx, k, data, deadline)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = src.Delete(ctx, k)
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	for _, st := range s.owners(k) {
+		if ok, err := st.Get(ctx, k, v); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// raws collects the items of a node, so that no item is added to the
+// Collection of the caller if a node fails.
+type raws []*json.RawMessage
+
+func (c *raws) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// GetAll unmarshals to c every item of every node, including the removed
+// nodes not rebalanced yet. The nodes are read concurrently.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	stores := s.all()
+	names := sorted(stores)
+	items := make([]raws, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, st store.Store) {
+			defer wg.Done()
+			errs[i] = st.GetAll(ctx, &items[i])
+		}(i, stores[name])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for _, node := range items {
+		for _, v := range node {
+			if err := c.New().UnmarshalJSON(*v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new random key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.write(ctx, k, func(st store.Store) error {
+		return st.Set(ctx, k, v)
+	})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.write(ctx, k, func(st store.Store) error {
+		return st.SetWithTimeout(ctx, k, v, timeout)
+	})
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return s.write(ctx, k, func(st store.Store) error {
+		return st.SetWithDeadline(ctx, k, v, deadline)
+	})
+}
+
+// Update assigns the given value to the given key, if it exists, in the
+// newest owner holding it.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	for _, st := range s.owners(k) {
+		if ok, err := st.Update(ctx, k, v); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Delete removes a key and its value from its current and previous owners.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	var found bool
+	stores, _ := s.touch(k)
+	for _, st := range stores {
+		ok, err := st.Delete(ctx, k)
+		if err != nil {
+			return found, err
+		}
+		found = found || ok
+	}
+	return found, nil
+}
+
+// Ping returns a non-nil error if any node is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	stores := s.all()
+	for _, name := range sorted(stores) {
+		if err := stores[name].Ping(ctx); err != nil {
+			return fmt.Errorf("ring: node %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the background rebalance, if any, then closes every node,
+// including the removed nodes not rebalanced yet.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	stores := s.all()
+	var first error
+	for _, name := range sorted(stores) {
+		if err := stores[name].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,301 @@
+package ring_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/ring"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store spreading the keys across a ring of three empty
+// memstores.
+func newStore() store.Store {
+	s := ring.New()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := s.AddNode(name, memstore.New(), 1); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// ownerOf returns the node owning k in a ring of the given nodes of weight 1.
+func ownerOf(k string, names ...string) string {
+	s := ring.New()
+	defer s.Close()
+	nodes := make(map[string]*memstore.Store)
+	for _, name := range names {
+		nodes[name] = memstore.New()
+		if err := s.AddNode(name, nodes[name], 1); err != nil {
+			panic(err)
+		}
+	}
+	if err := s.Set(context.Background(), k, json.RawMessage(`1`)); err != nil {
+		panic(err)
+	}
+	for name, m := range nodes {
+		if ok, _ := m.Exists(context.Background(), k); ok {
+			return name
+		}
+	}
+	panic("no owner")
+}
+
+// moving returns a key owned by from in a ring of the nodes before, and by to
+// once the node changes are applied.
+func moving(from, to string, before, after []string) string {
+	for i := 0; ; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if ownerOf(k, before...) == from && ownerOf(k, after...) == to {
+			return k
+		}
+	}
+}
+
+// keys returns the keys of m, or nil if it is closed.
+func keys(m *memstore.Store) []string {
+	ks, err := m.Keys(context.Background(), "")
+	if err != nil {
+		return nil
+	}
+	return ks
+}
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := memstore.New(), memstore.New(), memstore.New()
+	s := ring.New(ring.WithReplicas(10))
+	defer s.Close()
+	s.AddNode("a", a, 1)
+	s.AddNode("b", b, 1)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		if err := s.Set(ctx, k, json.RawMessage(k)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if err := s.AddNode("c", c, 1); err != nil {
+		t.Fatalf("AddNode: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		expectValue(t, s, strconv.Itoa(i), strconv.Itoa(i))
+	}
+	if len(keys(c)) != 0 {
+		t.Fatalf("keys were moved to the new node before Rebalance")
+	}
+
+	if err := s.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+	if len(keys(c)) == 0 {
+		t.Errorf("no key was moved to the new node")
+	}
+	if total := len(keys(a)) + len(keys(b)) + len(keys(c)); total != n {
+		t.Errorf("got %d keys in the nodes after Rebalance, want %d", total, n)
+	}
+	for i := 0; i < n; i++ {
+		expectValue(t, s, strconv.Itoa(i), strconv.Itoa(i))
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	ctx := context.Background()
+	a, b := memstore.New(), memstore.New()
+	s := ring.New(ring.WithReplicas(10))
+	defer s.Close()
+	s.AddNode("a", a, 1)
+	s.AddNode("b", b, 1)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		if err := s.SetWithTimeout(ctx, k, json.RawMessage(k), time.Hour); err != nil {
+			t.Fatalf("SetWithTimeout: %v", err)
+		}
+	}
+	if err := s.RemoveNode("b"); err != nil {
+		t.Fatalf("RemoveNode: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		expectValue(t, s, strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	if err := s.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+	if got := len(keys(a)); got != n {
+		t.Errorf("got %d keys in the remaining node, want %d", got, n)
+	}
+	if err := b.Ping(ctx); err == nil {
+		t.Errorf("the removed node was not closed")
+	}
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		expectValue(t, s, k, k)
+		if ttl, ok, err := a.GetTTL(ctx, k); err != nil || !ok || ttl <= 0 {
+			t.Fatalf("the expiration of %q was not migrated: %v, %v, %v", k, ttl, ok, err)
+		}
+	}
+}
+
+func TestEmptyRing(t *testing.T) {
+	ctx := context.Background()
+	a := memstore.New()
+	s := ring.New()
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); !errors.Is(err, ring.ErrNoNode) {
+		t.Fatalf("Set without node: got %v, want %v", err, ring.ErrNoNode)
+	}
+	s.AddNode("a", a, 1)
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s.RemoveNode("a")
+
+	if err := s.Rebalance(ctx); !errors.Is(err, ring.ErrNoNode) {
+		t.Fatalf("Rebalance without node: got %v, want %v", err, ring.ErrNoNode)
+	}
+	if err := a.Ping(ctx); err != nil {
+		t.Fatalf("the removed node was closed without a node to move its keys to: %v", err)
+	}
+	expectValue(t, s, "k", "1")
+
+	s.AddNode("b", memstore.New(), 1)
+	if err := s.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+	expectValue(t, s, "k", "1")
+	if err := a.Ping(ctx); err == nil {
+		t.Errorf("the removed node was not closed")
+	}
+}
+
+func TestWritePendingRebalance(t *testing.T) {
+	ctx := context.Background()
+	k := moving("a", "b", []string{"a"}, []string{"a", "b"})
+	a, b := memstore.New(), memstore.New()
+	s := ring.New()
+	defer s.Close()
+	s.AddNode("a", a, 1)
+
+	if err := s.Set(ctx, k, json.RawMessage(`"old"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s.AddNode("b", b, 1)
+	if err := s.SetWithTimeout(ctx, k, json.RawMessage(`"new"`), 50*time.Millisecond); err != nil {
+		t.Fatalf("SetWithTimeout: %v", err)
+	}
+	if ok, _ := a.Exists(ctx, k); ok {
+		t.Errorf("the previous owner kept the key written to the current one")
+	}
+	expectValue(t, s, k, `"new"`)
+
+	time.Sleep(100 * time.Millisecond)
+	expectValue(t, s, k, "")
+	if err := s.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+	expectValue(t, s, k, "")
+}
+
+func TestMigrateWritten(t *testing.T) {
+	ctx := context.Background()
+	k := moving("a", "b", []string{"a"}, []string{"a", "b"})
+	for _, tc := range [...]struct {
+		name  string
+		write func(s *ring.Store) error
+	}{
+		{"expired", func(s *ring.Store) error {
+			return s.SetWithTimeout(ctx, k, json.RawMessage(`"new"`), time.Millisecond)
+		}},
+		{"deleted", func(s *ring.Store) error {
+			_, err := s.Delete(ctx, k)
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := memstore.New()
+			s := ring.New()
+			defer s.Close()
+			s.AddNode("a", a, 1)
+			s.AddNode("b", memstore.New(), 1)
+			if err := tc.write(s); err != nil {
+				t.Fatal(err)
+			}
+			// A stale copy left in the previous owner, as by a migration
+			// racing with the write, is not migrated over it.
+			if err := a.Set(ctx, k, json.RawMessage(`"old"`)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if err := s.Rebalance(ctx); err != nil {
+				t.Fatalf("Rebalance: %v", err)
+			}
+			expectValue(t, s, k, "")
+			if ok, _ := a.Exists(ctx, k); ok {
+				t.Errorf("the stale copy was kept in the previous owner")
+			}
+		})
+	}
+}
+
+func TestRebalanceInterval(t *testing.T) {
+	ctx := context.Background()
+	a, b := memstore.New(), memstore.New()
+	s := ring.New(ring.WithRebalanceInterval(10 * time.Millisecond))
+	defer s.Close()
+	s.AddNode("a", a, 1)
+	for i := 0; i < 10; i++ {
+		if err := s.Set(ctx, strconv.Itoa(i), json.RawMessage(`1`)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	s.AddNode("b", b, 1)
+	s.RemoveNode("a")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(keys(b)) != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the keys were not moved in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,409 @@
+/*
+Package s3 implements store.Store on top of an Amazon S3 bucket, one object
+per key, for large and cold values.
+
+The objects are stored under a common prefix, with the JSON encoding of the
+value as their content. The deadline of an expiring object is kept in its
+metadata, so that the expired objects are hidden on read. S3 lifecycle rules
+can only expire objects by whole days since their creation: the expiring
+objects are also tagged with their lifespan in days, rounded up, and
+PutLifecycle installs the rules deleting them. The objects living longer
+than MaxLifecycleDays are hidden once expired, but never deleted.
+
+Add, Update and CompareAndSet are conditional writes, retried on concurrent
+modifications; Update and CompareAndSet keep the expiration of the key.
+*/
+package s3 // import "github.com/gokv/store/s3"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/gokv/store"
+)
+
+// MaxLifecycleDays is the longest lifespan, in days, covered by the rules of
+// PutLifecycle.
+const MaxLifecycleDays = 365
+
+const (
+	// deadlineMeta is the metadata holding the deadline of an object, in
+	// the RFC 3339 format.
+	deadlineMeta = "gokv-deadline"
+
+	// expireTag is the tag holding the lifespan of an object in days.
+	expireTag = "gokv-expire-days"
+)
+
+// Store is a store.Store backed by an S3 bucket.
+type Store struct {
+	c      *s3.Client
+	bucket string
+	prefix string
+}
+
+// New returns a Store keeping the items in the given bucket, under the given
+// prefix.
+func New(c *s3.Client, bucket, prefix string) *Store {
+	return &Store{c: c, bucket: bucket, prefix: prefix}
+}
+
+// PutLifecycle replaces the lifecycle configuration of the bucket with the
+// rules deleting the expired objects under prefix, one per lifespan in days
+// up to MaxLifecycleDays.
+func PutLifecycle(ctx context.Context, c *s3.Client, bucket, prefix string) error {
+	rules := make([]types.LifecycleRule, MaxLifecycleDays)
+	for i := range rules {
+		days := strconv.Itoa(i + 1)
+		rules[i] = types.LifecycleRule{
+			ID:     aws.String("gokv-expire-" + days),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{
+				And: &types.LifecycleRuleAndOperator{
+					Prefix: aws.String(prefix),
+					Tags:   []types.Tag{{Key: aws.String(expireTag), Value: aws.String(days)}},
+				},
+			},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(int32(i + 1))},
+		}
+	}
+	_, err := c.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+func (s *Store) key(k string) *string {
+	return aws.String(s.prefix + k)
+}
+
+func errorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
+func notFound(err error) bool {
+	c := errorCode(err)
+	return c == "NoSuchKey" || c == "NotFound"
+}
+
+// conflict reports whether err is the failure of a conditional write.
+func conflict(err error) bool {
+	c := errorCode(err)
+	return c == "PreconditionFailed" || c == "ConditionalRequestConflict"
+}
+
+// objectDeadline returns the deadline in the metadata of an object, or the
+// zero time.
+func objectDeadline(meta map[string]string) time.Time {
+	d, _ := time.Parse(time.RFC3339Nano, meta[deadlineMeta])
+	return d
+}
+
+func expired(deadline, now time.Time) bool {
+	return !deadline.IsZero() && !deadline.After(now)
+}
+
+// object is the state of an object read by get.
+type object struct {
+	value    []byte
+	etag     *string
+	deadline time.Time
+	modified time.Time
+}
+
+// get reads the object of k, or returns nil if it was not found or expired.
+func (s *Store) get(ctx context.Context, k string) (*object, error) {
+	out, err := s.c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: s.key(k)})
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	o := &object{etag: out.ETag, deadline: objectDeadline(out.Metadata)}
+	if out.LastModified != nil {
+		o.modified = *out.LastModified
+	}
+	if expired(o.deadline, time.Now()) {
+		return nil, nil
+	}
+	if o.value, err = io.ReadAll(out.Body); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// head reads the metadata of the object of k, or returns nil if it was not
+// found or expired.
+func (s *Store) head(ctx context.Context, k string) (*object, error) {
+	out, err := s.c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: s.key(k)})
+	if notFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := &object{etag: out.ETag, deadline: objectDeadline(out.Metadata)}
+	if out.LastModified != nil {
+		o.modified = *out.LastModified
+	}
+	if expired(o.deadline, time.Now()) {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// put writes the object of k. The write is conditional on the ETag of the
+// current object if ifMatch is not nil, or on its absence if ifNoneMatch is
+// "*".
+func (s *Store) put(ctx context.Context, k string, value []byte, deadline time.Time, ifMatch, ifNoneMatch *string) error {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         s.key(k),
+		Body:        bytes.NewReader(value),
+		ContentType: aws.String("application/json"),
+		IfMatch:     ifMatch,
+		IfNoneMatch: ifNoneMatch,
+	}
+	if !deadline.IsZero() {
+		in.Metadata = map[string]string{deadlineMeta: deadline.UTC().Format(time.RFC3339Nano)}
+		days := int((time.Until(deadline) + 24*time.Hour - 1) / (24 * time.Hour))
+		if days < 1 {
+			days = 1
+		}
+		if days <= MaxLifecycleDays {
+			in.Tagging = aws.String(url.Values{expireTag: {strconv.Itoa(days)}}.Encode())
+		}
+	}
+	_, err := s.c.PutObject(ctx, in)
+	return err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	o, err := s.get(ctx, k)
+	if err != nil || o == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(o.value)
+}
+
+// GetAll unmarshals to c every item under the prefix of the Store, ordered by
+// key, following the pages of ListObjectsV2. Each object is read with its own
+// request.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	ks, err := s.Keys(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		o, err := s.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			// Deleted or expired since it was listed.
+			continue
+		}
+		if err := c.New().UnmarshalJSON(o.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		err := s.put(ctx, k, value, time.Time{}, nil, aws.String("*"))
+		if err == nil {
+			return k, nil
+		}
+		if !conflict(err) {
+			return "", err
+		}
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if expired(deadline, time.Now()) {
+		_, err := s.c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: s.key(k)})
+		return err
+	}
+	return s.put(ctx, k, value, deadline, nil, nil)
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		o, err := s.head(ctx, k)
+		if err != nil || o == nil {
+			return false, err
+		}
+		err = s.put(ctx, k, value, o.deadline, o.etag, nil)
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	// S3 deletes the missing objects successfully.
+	o, err := s.head(ctx, k)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: s.key(k)})
+	return o != nil && err == nil, err
+}
+
+// Ping returns a non-nil error if the bucket can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.c.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	return err
+}
+
+// Close is a no-op: the S3 client holds no resources.
+func (s *Store) Close() error {
+	return nil
+}
+
+// GetMeta retrieves the metadata of the given key: the ETag of the object as
+// its version, and its last modification time. S3 records no creation time
+// besides the one of the last write.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	o, err := s.head(ctx, k)
+	if err != nil || o == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{Version: aws.ToString(o.etag), UpdatedAt: o.modified}, true, nil
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	ov, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	for {
+		o, err := s.get(ctx, k)
+		if err != nil || o == nil || !bytes.Equal(o.value, ov) {
+			return false, err
+		}
+		err = s.put(ctx, k, value, o.deadline, o.etag, nil)
+		if !conflict(err) {
+			return err == nil, err
+		}
+	}
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	o, err := s.head(ctx, k)
+	return o != nil, err
+}
+
+// Keys returns every key starting with prefix, ordered by key. The listing
+// does not include the metadata: the keys expired but not yet deleted by the
+// lifecycle rules are returned too.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	p := s3.NewListObjectsV2Paginator(s.c, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: s.key(prefix),
+	})
+	ks := []string{}
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			ks = append(ks, strings.TrimPrefix(aws.ToString(obj.Key), s.prefix))
+		}
+	}
+	return ks, nil
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+)
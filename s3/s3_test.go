@@ -0,0 +1,67 @@
+package s3_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gokv/store"
+	"github.com/gokv/store/s3"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function creating an empty Store under a new prefix of
+// the bucket of the GOKV_S3_BUCKET environment variable, at the S3 endpoint
+// of GOKV_S3_ENDPOINT, e.g. MinIO at http://localhost:9000, with path-style
+// addressing and the credentials of AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY. The objects are deleted when the test completes. The
+// test is skipped if the endpoint or the bucket is not set.
+func newStore(tb testing.TB) func() store.Store {
+	endpoint, bucket := os.Getenv("GOKV_S3_ENDPOINT"), os.Getenv("GOKV_S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		tb.Skip("GOKV_S3_ENDPOINT or GOKV_S3_BUCKET is not set")
+	}
+	c := awss3.New(awss3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			}, nil
+		}),
+	})
+	return func() store.Store {
+		s := s3.New(c, bucket, "gokv-test/"+randomHex()+"/")
+		tb.Cleanup(func() { deleteAll(s) })
+		return s
+	}
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// deleteAll deletes every key of s.
+func deleteAll(s *s3.Store) {
+	ctx := context.Background()
+	ks, _ := s.Keys(ctx, "")
+	for _, k := range ks {
+		s.Delete(ctx, k)
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,10 @@
+module github.com/gokv/store/schema
+
+go 1.26.7
+
+require (
+	github.com/gokv/store v0.1.0
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
+)
+
+require golang.org/x/text v0.14.0 // indirect
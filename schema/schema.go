@@ -0,0 +1,233 @@
+/*
+Package schema provides a Store wrapper validating the values against JSON
+Schemas, per key prefix.
+
+Every Set, SetWithTimeout, SetWithDeadline and Update validates the value
+against the schema of the longest prefix of its key, and fails with a
+*ValidationError, without writing, if the value is invalid. The values whose
+key matches no prefix are written without validation. Add, whose key is
+assigned by the wrapped Store, validates the values against the schema of the
+empty prefix, if any.
+
+The schemas are compiled with github.com/santhosh-tekuri/jsonschema, which
+supports the drafts 4 to 2020-12. It is a separate module.
+*/
+package schema // import "github.com/gokv/store/schema"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Compile compiles the JSON Schema doc, whose $ref are resolved relative to
+// the file URL "schema.json".
+func Compile(doc []byte) (*jsonschema.Schema, error) {
+	v, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("schema.json", v); err != nil {
+		return nil, err
+	}
+	return c.Compile("schema.json")
+}
+
+// Violation is a failed assertion of a schema.
+type Violation struct {
+	// InstanceLocation is the JSON Pointer to the invalid part of the value.
+	InstanceLocation string `json:"instanceLocation"`
+
+	// KeywordLocation is the JSON Pointer to the failed keyword of the schema.
+	KeywordLocation string `json:"keywordLocation"`
+
+	// Message describes the violation.
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a value is invalid against the schema of
+// its key.
+type ValidationError struct {
+	// Key is the key of the value, empty for Add.
+	Key string `json:"key"`
+
+	// Prefix is the key prefix of the schema.
+	Prefix string `json:"prefix"`
+
+	// Violations lists the failed assertions.
+	Violations []Violation `json:"violations"`
+
+	err error
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("schema: invalid value for key %q", e.Key)
+	if len(e.Violations) > 0 {
+		v := e.Violations[0]
+		msg += fmt.Sprintf(": at %q: %s", v.InstanceLocation, v.Message)
+	}
+	if len(e.Violations) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Violations)-1)
+	}
+	return msg
+}
+
+// Unwrap returns the *jsonschema.ValidationError reporting the violations.
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithSchema validates the values whose key starts with prefix against sch.
+func WithSchema(prefix string, sch *jsonschema.Schema) Option {
+	return func(s *Store) { s.schemas[prefix] = sch }
+}
+
+// Store is a store.Store validating the values written to the wrapped Store.
+type Store struct {
+	store.Wrapper
+	schemas map[string]*jsonschema.Schema
+}
+
+// Wrap returns a Store validating the values written to s.
+func Wrap(s store.Store, opts ...Option) *Store {
+	v := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		schemas: make(map[string]*jsonschema.Schema),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// schema returns the schema of the longest prefix of k, and the prefix.
+func (s *Store) schema(k string) (*jsonschema.Schema, string, bool) {
+	var (
+		found  *jsonschema.Schema
+		prefix string
+	)
+	for p, sch := range s.schemas {
+		if strings.HasPrefix(k, p) && (found == nil || len(p) > len(prefix)) {
+			found, prefix = sch, p
+		}
+	}
+	return found, prefix, found != nil
+}
+
+// validate returns the JSON encoding of v, stored at k, if it is valid.
+func (s *Store) validate(k string, v json.Marshaler) (json.RawMessage, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	sch, prefix, ok := s.schema(k)
+	if !ok {
+		return data, nil
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := sch.Validate(inst); err != nil {
+		verr, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return nil, err
+		}
+		return nil, &ValidationError{
+			Key:        k,
+			Prefix:     prefix,
+			Violations: violations(verr),
+			err:        verr,
+		}
+	}
+	return data, nil
+}
+
+// violations flattens the failed assertions of err.
+func violations(err *jsonschema.ValidationError) []Violation {
+	out := err.BasicOutput()
+	units := out.Errors
+	if len(units) == 0 {
+		units = []jsonschema.OutputUnit{*out}
+	}
+	vs := make([]Violation, 0, len(units))
+	for _, u := range units {
+		if u.Error == nil {
+			continue
+		}
+		vs = append(vs, Violation{
+			InstanceLocation: u.InstanceLocation,
+			KeywordLocation:  u.KeywordLocation,
+			Message:          u.Error.String(),
+		})
+	}
+	return vs
+}
+
+// Add validates the given value against the schema of the empty prefix,
+// assigns it to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := s.validate("", v)
+	if err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, data)
+}
+
+// Set idempotently validates and assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, err := s.validate(k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, data)
+}
+
+// SetWithTimeout validates and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after timeout. The
+// lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	data, err := s.validate(k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, data, timeout)
+}
+
+// SetWithDeadline validates and assigns the given value to the given key,
+// possibly overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := s.validate(k, v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// Update validates and assigns the given value to the given key, if it
+// exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := s.validate(k, v)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, data)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,131 @@
+package schema_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/schema"
+	"github.com/gokv/store/storetest"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// newStore returns a Store validating the values written to an empty
+// memstore against a schema accepting any value.
+func newStore() store.Store {
+	sch, err := schema.Compile([]byte(`{}`))
+	if err != nil {
+		panic(err)
+	}
+	return schema.Wrap(memstore.New(), schema.WithSchema("", sch))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// compile compiles the JSON Schema doc, or panics.
+func compile(doc string) *jsonschema.Schema {
+	sch, err := schema.Compile([]byte(doc))
+	if err != nil {
+		panic(err)
+	}
+	return sch
+}
+
+var (
+	anyValue = compile(`{}`)
+	user     = compile(`{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "age": {"type": "integer", "minimum": 0}},
+		"required": ["name"]
+	}`)
+	admin = compile(`{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "role": {"const": "admin"}},
+		"required": ["name", "role"]
+	}`)
+)
+
+func TestInvalid(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := schema.Wrap(m, schema.WithSchema("", user))
+	if err := m.Set(ctx, "k", json.RawMessage(`{"name":"gopher"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := json.RawMessage(`{"age":-1}`)
+	for _, tc := range [...]struct {
+		name string
+		key  string
+		op   func() error
+	}{
+		{"Set", "k", func() error { return s.Set(ctx, "k", invalid) }},
+		{"SetWithTimeout", "k", func() error { return s.SetWithTimeout(ctx, "k", invalid, time.Hour) }},
+		{"SetWithDeadline", "k", func() error { return s.SetWithDeadline(ctx, "k", invalid, time.Now().Add(time.Hour)) }},
+		{"Add", "", func() error { _, err := s.Add(ctx, invalid); return err }},
+		{"Update", "k", func() error { _, err := s.Update(ctx, "k", invalid); return err }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var verr *schema.ValidationError
+			if err := tc.op(); !errors.As(err, &verr) {
+				t.Fatalf("got %v, want a *ValidationError", err)
+			}
+			if verr.Key != tc.key || verr.Prefix != "" {
+				t.Errorf("got the key %q and the prefix %q, want %q and the empty prefix", verr.Key, verr.Prefix, tc.key)
+			}
+			// The two failed assertions are reported.
+			if len(verr.Violations) < 2 {
+				t.Errorf("got the violations %+v, want the missing name and the negative age", verr.Violations)
+			}
+		})
+	}
+
+	var v json.RawMessage
+	if ok, err := m.Get(ctx, "k", &v); err != nil || !ok || string(v) != `{"name":"gopher"}` {
+		t.Errorf("the invalid values were written: got %s, %v, %v", v, ok, err)
+	}
+	if ks, err := m.Keys(ctx, ""); err != nil || len(ks) != 1 {
+		t.Errorf("the invalid values were added: got the keys %v, %v", ks, err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	ctx := context.Background()
+	s := schema.Wrap(memstore.New(),
+		schema.WithSchema("users/", user),
+		schema.WithSchema("users/admins/", admin),
+		schema.WithSchema("config/", anyValue),
+	)
+
+	for _, tc := range [...]struct {
+		key    string
+		value  string
+		prefix string // of the schema rejecting the value, if any
+	}{
+		{"users/1", `{"name":"gopher"}`, ""},
+		{"users/1", `{"name":1}`, "users/"},
+		{"users/admins/1", `{"name":"gopher","role":"admin"}`, ""},
+		{"users/admins/1", `{"name":"gopher"}`, "users/admins/"},
+		{"config/1", `42`, ""},
+		{"other", `"any"`, ""},
+	} {
+		err := s.Set(ctx, tc.key, json.RawMessage(tc.value))
+		var verr *schema.ValidationError
+		switch {
+		case tc.prefix == "" && err != nil:
+			t.Errorf("Set(%q, %s): %v", tc.key, tc.value, err)
+		case tc.prefix != "" && !errors.As(err, &verr):
+			t.Errorf("Set(%q, %s): got %v, want a *ValidationError", tc.key, tc.value, err)
+		case tc.prefix != "" && verr.Prefix != tc.prefix:
+			t.Errorf("Set(%q, %s): rejected by the schema of %q, want %q", tc.key, tc.value, verr.Prefix, tc.prefix)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Package shadow provides a Store wrapper serving the reads from the wrapped
+Store while issuing them, in the background, to a candidate Store, so that a
+new backend can be validated with the production traffic before the cutover.
+
+Every Get and GetAll is answered by the wrapped Store, then repeated against
+the candidate in a separate goroutine, and the two results are compared: the
+comparison does not delay the caller, and the failures of the candidate are
+not returned. GetAll compares the values regardless of their order.
+
+The outcomes are counted, see Counts, and a sample of the mismatches is
+passed to the reporter with both values, by default to the standard logger.
+The writes go to the wrapped Store only: the candidate is to be fed some
+other way, for example by package dualwrite or by the replication of the
+backend.
+*/
+package shadow // import "github.com/gokv/store/shadow"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// The default settings of the Store.
+const (
+	DefaultSampleRate  = 0.01
+	DefaultTimeout     = time.Second
+	DefaultMaxInflight = 100
+)
+
+// Mismatch is a read whose result differs between the wrapped Store and the
+// candidate.
+type Mismatch struct {
+	// Method is the read, Get or GetAll.
+	Method string
+
+	// Key is the key of Get, empty for GetAll.
+	Key string
+
+	// Ok and CandidateOk report whether Get found the key in the Stores.
+	Ok, CandidateOk bool
+
+	// Value and Candidate are the results of the Stores: the values of Get,
+	// nil if the key was not found, or the JSON arrays of the values of
+	// GetAll.
+	Value, Candidate json.RawMessage
+}
+
+func (m *Mismatch) String() string {
+	if m.Method == "Get" {
+		if m.Ok != m.CandidateOk {
+			return fmt.Sprintf("shadow: Get %q: found %t, in candidate %t", m.Key, m.Ok, m.CandidateOk)
+		}
+		return fmt.Sprintf("shadow: Get %q: value %s, in candidate %s", m.Key, m.Value, m.Candidate)
+	}
+	return fmt.Sprintf("shadow: %s: values %s, in candidate %s", m.Method, m.Value, m.Candidate)
+}
+
+// Counts are the outcomes of the shadow reads.
+type Counts struct {
+	// Matches and Mismatches count the compared reads.
+	Matches, Mismatches uint64
+
+	// Errors counts the reads failing in the candidate.
+	Errors uint64
+
+	// Skipped counts the reads not repeated against the candidate because
+	// too many shadow reads were in flight.
+	Skipped uint64
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithSampleRate sets the fraction, between 0 and 1, of the mismatches
+// passed to the reporter.
+func WithSampleRate(rate float64) Option {
+	return func(s *Store) { s.rate = rate }
+}
+
+// WithReporter sets the function called with the sampled mismatches, in
+// place of the standard logger. It is called concurrently, from the
+// goroutines of the shadow reads.
+func WithReporter(report func(*Mismatch)) Option {
+	return func(s *Store) { s.report = report }
+}
+
+// WithTimeout sets the timeout of the shadow reads. They do not share the
+// cancellation of the reads of the caller.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Store) { s.timeout = timeout }
+}
+
+// WithMaxInflight sets the maximum number of concurrent shadow reads.
+func WithMaxInflight(n int) Option {
+	return func(s *Store) { s.max = n }
+}
+
+// Store is a store.Store comparing the reads of the wrapped Store to a
+// candidate.
+type Store struct {
+	store.Wrapper
+	candidate store.Store
+	rate      float64
+	report    func(*Mismatch)
+	timeout   time.Duration
+	max       int
+
+	inflight int64
+	wg       sync.WaitGroup
+
+	matches, mismatches, errors, skipped uint64
+}
+
+// Wrap returns a Store serving the reads from s, and comparing them to the
+// reads of candidate.
+func Wrap(s, candidate store.Store, opts ...Option) *Store {
+	sh := &Store{
+		Wrapper:   store.Wrapper{Store: s},
+		candidate: candidate,
+		rate:      DefaultSampleRate,
+		report:    func(m *Mismatch) { log.Print(m) },
+		timeout:   DefaultTimeout,
+		max:       DefaultMaxInflight,
+	}
+	for _, opt := range opts {
+		opt(sh)
+	}
+	return sh
+}
+
+// Counts returns the outcomes of the shadow reads so far.
+func (s *Store) Counts() Counts {
+	return Counts{
+		Matches:    atomic.LoadUint64(&s.matches),
+		Mismatches: atomic.LoadUint64(&s.mismatches),
+		Errors:     atomic.LoadUint64(&s.errors),
+		Skipped:    atomic.LoadUint64(&s.skipped),
+	}
+}
+
+// detached is a context carrying the values of its parent, but not its
+// cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// shadow calls read in the background with the candidate, unless too many
+// shadow reads are in flight. Read returns the mismatch, if any.
+func (s *Store) shadow(ctx context.Context, read func(context.Context) (*Mismatch, error)) {
+	if atomic.AddInt64(&s.inflight, 1) > int64(s.max) {
+		atomic.AddInt64(&s.inflight, -1)
+		atomic.AddUint64(&s.skipped, 1)
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer atomic.AddInt64(&s.inflight, -1)
+		ctx, cancel := context.WithTimeout(detached{ctx}, s.timeout)
+		defer cancel()
+		m, err := read(ctx)
+		switch {
+		case err != nil:
+			atomic.AddUint64(&s.errors, 1)
+		case m == nil:
+			atomic.AddUint64(&s.matches, 1)
+		default:
+			atomic.AddUint64(&s.mismatches, 1)
+			if rand.Float64() < s.rate {
+				s.report(m)
+			}
+		}
+	}()
+}
+
+// compact returns data without the insignificant whitespace, for comparison.
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// Get retrieves a new value by key from the wrapped Store and unmarshals it
+// to v, then compares it to the value of the candidate.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var data json.RawMessage
+	ok, err := s.Store.Get(ctx, k, &data)
+	if err != nil {
+		return false, err
+	}
+	s.shadow(ctx, func(ctx context.Context) (*Mismatch, error) {
+		var candidate json.RawMessage
+		candidateOk, err := s.candidate.Get(ctx, k, &candidate)
+		if err != nil {
+			return nil, err
+		}
+		if ok == candidateOk && (!ok || bytes.Equal(compact(data), compact(candidate))) {
+			return nil, nil
+		}
+		return &Mismatch{Method: "Get", Key: k, Ok: ok, CandidateOk: candidateOk, Value: data, Candidate: candidate}, nil
+	})
+	if !ok {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(data)
+}
+
+// raws collects the items of GetAll, to be compared.
+type raws []*json.RawMessage
+
+func (c *raws) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// sorted returns the compacted items, sorted.
+func (c raws) sorted() []string {
+	items := make([]string, len(c))
+	for i, v := range c {
+		items[i] = string(compact(*v))
+	}
+	sort.Strings(items)
+	return items
+}
+
+// array returns the items as a JSON array.
+func (c raws) array() json.RawMessage {
+	data, _ := json.Marshal(c)
+	return data
+}
+
+// GetAll unmarshals to c every item in the wrapped Store, then compares them
+// to the items of the candidate.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	var items raws
+	if err := s.Store.GetAll(ctx, &items); err != nil {
+		return err
+	}
+	s.shadow(ctx, func(ctx context.Context) (*Mismatch, error) {
+		var candidate raws
+		if err := s.candidate.GetAll(ctx, &candidate); err != nil {
+			return nil, err
+		}
+		want, got := items.sorted(), candidate.sorted()
+		if len(want) == len(got) {
+			equal := true
+			for i := range want {
+				if want[i] != got[i] {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				return nil, nil
+			}
+		}
+		return &Mismatch{Method: "GetAll", Value: items.array(), Candidate: candidate.array()}, nil
+	})
+	for _, v := range items {
+		if err := c.New().UnmarshalJSON(*v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close waits for the shadow reads in flight, then closes the wrapped Store
+// and the candidate.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	s.wg.Wait()
+	err := s.Store.Close()
+	if cerr := s.candidate.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,22 @@
+package shadow_test
+
+import (
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/shadow"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store shadowing the reads of an empty memstore on
+// another.
+func newStore() store.Store {
+	return shadow.Wrap(memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,206 @@
+/*
+Package shard provides a Store routing the keys across several Stores, so
+that a dataset too big for one backend still looks like one Store.
+
+Every key belongs to the Store picked by the picker given to New, for example
+Hash. GetAll reads every shard concurrently, and unmarshals their items to
+the Collection in the order of the shards, once they all succeeded. Add
+assigns random keys itself, as the shard of a key must be known before it is
+written.
+
+The picker must not change while the Stores hold data: package ring
+provides a Store whose nodes can change.
+*/
+package shard // import "github.com/gokv/store/shard"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Hash returns a picker spreading the keys across n shards with their 32-bit
+// FNV-1a hash.
+func Hash(n int) func(key string) int {
+	return func(k string) int {
+		h := fnv.New32a()
+		h.Write([]byte(k))
+		return int(h.Sum32() % uint32(n))
+	}
+}
+
+// Store is a store.Store routing the keys across shards.
+type Store struct {
+	picker func(key string) int
+	stores []store.Store
+}
+
+// New returns a Store routing every key to stores[picker(key)].
+func New(picker func(key string) int, stores ...store.Store) *Store {
+	return &Store{picker: picker, stores: stores}
+}
+
+// shard returns the Store of k.
+func (s *Store) shard(k string) (store.Store, error) {
+	i := s.picker(k)
+	if i < 0 || i >= len(s.stores) {
+		return nil, fmt.Errorf("shard: key %q picked shard %d out of %d", k, i, len(s.stores))
+	}
+	return s.stores[i], nil
+}
+
+// each calls fn concurrently with every shard, and returns the first error
+// in the order of the shards.
+func (s *Store) each(fn func(i int, st store.Store) error) error {
+	errs := make([]error, len(s.stores))
+	var wg sync.WaitGroup
+	for i, st := range s.stores {
+		wg.Add(1)
+		go func(i int, st store.Store) {
+			defer wg.Done()
+			errs[i] = fn(i, st)
+		}(i, st)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	st, err := s.shard(k)
+	if err != nil {
+		return false, err
+	}
+	return st.Get(ctx, k, v)
+}
+
+// raws collects the items of a shard, so that no item is added to the
+// Collection of the caller if a shard fails.
+type raws []*json.RawMessage
+
+func (c *raws) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
+
+// GetAll unmarshals to c every item of every shard.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	items := make([]raws, len(s.stores))
+	err := s.each(func(i int, st store.Store) error {
+		return st.GetAll(ctx, &items[i])
+	})
+	if err != nil {
+		return err
+	}
+	for _, shard := range items {
+		for _, v := range shard {
+			if err := c.New().UnmarshalJSON(*v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new random key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	return k, s.Set(ctx, k, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	st, err := s.shard(k)
+	if err != nil {
+		return err
+	}
+	return st.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	st, err := s.shard(k)
+	if err != nil {
+		return err
+	}
+	return st.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	st, err := s.shard(k)
+	if err != nil {
+		return err
+	}
+	return st.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	st, err := s.shard(k)
+	if err != nil {
+		return false, err
+	}
+	return st.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	st, err := s.shard(k)
+	if err != nil {
+		return false, err
+	}
+	return st.Delete(ctx, k)
+}
+
+// Ping returns a non-nil error if any shard is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.each(func(_ int, st store.Store) error {
+		return st.Ping(ctx)
+	})
+}
+
+// Close closes every shard.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	var first error
+	for _, st := range s.stores {
+		if err := st.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,103 @@
+package shard_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/shard"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store spreading the keys across three empty memstores.
+func newStore() store.Store {
+	return shard.New(shard.Hash(3), memstore.New(), memstore.New(), memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	shards := []*memstore.Store{memstore.New(), memstore.New(), memstore.New()}
+	pick := shard.Hash(len(shards))
+	s := shard.New(pick, shards[0], shards[1], shards[2])
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		if err := s.Set(ctx, strconv.Itoa(i), json.RawMessage(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	for i, m := range shards {
+		ks, err := m.Keys(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ks) == 0 {
+			t.Errorf("shard %d got no key", i)
+		}
+		for _, k := range ks {
+			if pick(k) != i {
+				t.Errorf("the key %q of shard %d belongs to shard %d", k, i, pick(k))
+			}
+		}
+	}
+	var c items
+	if err := s.GetAll(ctx, &c); err != nil || len(c) != n {
+		t.Errorf("GetAll: got %d items, %v, want %d", len(c), err, n)
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	ctx := context.Background()
+	s := shard.New(func(string) int { return 2 }, memstore.New(), memstore.New())
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err == nil {
+		t.Error("Set to a shard out of range: got no error")
+	}
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); err == nil {
+		t.Error("Get from a shard out of range: got no error")
+	}
+}
+
+func TestFailedShard(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("down")
+	m := memstore.New()
+	if err := m.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	down := &storetest.Fake{
+		GetAllFunc: func(context.Context, store.Collection) error { return errDown },
+		PingFunc:   func(context.Context) error { return errDown },
+	}
+	s := shard.New(shard.Hash(2), m, down)
+
+	var c items
+	if err := s.GetAll(ctx, &c); err != errDown {
+		t.Errorf("GetAll with a failed shard: got %v, want %v", err, errDown)
+	}
+	if len(c) != 0 {
+		t.Errorf("GetAll with a failed shard: got %d items, want none", len(c))
+	}
+	if err := s.Ping(ctx); err != errDown {
+		t.Errorf("Ping with a failed shard: got %v, want %v", err, errDown)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
@@ -0,0 +1,17 @@
+package store
+
+import "context"
+
+// Shutdowner defines a bounded alternative to Close, for implementations
+// holding pending writes or pooled connections.
+type Shutdowner interface {
+
+	// Shutdown flushes the pending writes and releases the resources
+	// associated with the store. If ctx is done before the shutdown
+	// completes, the remaining resources are released without waiting and
+	// the pending writes may be lost.
+	// Any further operation may cause panic.
+	// Err is non-nil in case of failure, or if ctx is done before the
+	// shutdown completes.
+	Shutdown(ctx context.Context) error
+}
@@ -0,0 +1,8 @@
+module github.com/gokv/store/singleflight
+
+go 1.26.7
+
+require (
+	github.com/gokv/store v0.1.0
+	golang.org/x/sync v0.23.0
+)
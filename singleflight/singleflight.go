@@ -0,0 +1,118 @@
+/*
+Package singleflight provides a Store wrapper coalescing the concurrent Get
+calls for the same key, with golang.org/x/sync/singleflight.
+
+While a Get is in flight, the other Get calls for the same key wait for its
+result rather than reaching the wrapped Store, so that a miss on a hot key
+does not turn into a thundering herd. Every caller unmarshals its own copy of
+the value.
+
+The shared read is not canceled with the context of the first caller, so that
+the other callers still get its result; it is still bound to the deadline of
+that context, if any. A caller whose context is done stops waiting and
+returns the error of its context.
+
+The writes to a key make the subsequent Get calls start a new read, rather
+than join the one in flight, which could return the previous value.
+*/
+package singleflight // import "github.com/gokv/store/singleflight"
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+	sf "golang.org/x/sync/singleflight"
+)
+
+// Store is a store.Store coalescing the concurrent reads of the same key.
+type Store struct {
+	store.Wrapper
+	group sf.Group
+}
+
+// Wrap returns a Store coalescing the concurrent reads of s.
+func Wrap(s store.Store) *Store {
+	return &Store{Wrapper: store.Wrapper{Store: s}}
+}
+
+// result is the result of a shared read.
+type result struct {
+	data json.RawMessage
+	ok   bool
+}
+
+// Get retrieves a new value by key and unmarshals it to v, sharing the read
+// with the concurrent calls for the same key.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	ch := s.group.DoChan(k, func() (any, error) {
+		shared := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			shared, cancel = context.WithDeadline(shared, deadline)
+			defer cancel()
+		}
+		var r result
+		var err error
+		r.ok, err = s.Store.Get(shared, k, &r.data)
+		return r, err
+	})
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return false, res.Err
+		}
+		r := res.Val.(result)
+		if !r.ok {
+			return false, nil
+		}
+		return true, v.UnmarshalJSON(append([]byte(nil), r.data...))
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	defer s.group.Forget(k)
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	defer s.group.Forget(k)
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	defer s.group.Forget(k)
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	defer s.group.Forget(k)
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	defer s.group.Forget(k)
+	return s.Store.Delete(ctx, k)
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,140 @@
+package singleflight_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/singleflight"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store coalescing the reads of an empty memstore.
+func newStore() store.Store {
+	return singleflight.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// blocking returns a Fake whose Get calls signal started, then return value
+// once release is closed, with the error of their context.
+func blocking(value string) (fake *storetest.Fake, started chan struct{}, release chan struct{}) {
+	started, release = make(chan struct{}, 10), make(chan struct{})
+	fake = &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, v json.Unmarshaler) (bool, error) {
+			started <- struct{}{}
+			<-release
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			return true, v.UnmarshalJSON([]byte(value))
+		},
+	}
+	return fake, started, release
+}
+
+func TestCoalesced(t *testing.T) {
+	fake, started, release := blocking(`"value"`)
+	s := singleflight.Wrap(fake)
+
+	const n = 10
+	values := make([]json.RawMessage, n)
+	errs := make(chan error, n)
+	for i := range values {
+		go func(v *json.RawMessage) {
+			ok, err := s.Get(context.Background(), "k", v)
+			if err == nil && !ok {
+				err = errors.New("not found")
+			}
+			errs <- err
+		}(&values[i])
+	}
+	<-started
+	// The other calls join the read in flight meanwhile.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for range values {
+		if err := <-errs; err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+
+	if got := len(fake.CallsTo("Get")); got != 1 {
+		t.Errorf("got %d reads of the wrapped Store, want 1", got)
+	}
+	values[0][1] = 'X'
+	for _, v := range values[1:] {
+		if string(v) != `"value"` {
+			t.Fatalf("Get: got %s, want every caller to get its own copy", v)
+		}
+	}
+}
+
+func TestCanceledCaller(t *testing.T) {
+	fake, started, release := blocking(`1`)
+	s := singleflight.Wrap(fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		var v json.RawMessage
+		_, err := s.Get(ctx, "k", &v)
+		first <- err
+	}()
+	<-started
+	second := make(chan error)
+	go func() {
+		var v json.RawMessage
+		_, err := s.Get(context.Background(), "k", &v)
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get of the canceled caller: got %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("Get of the other caller: %v", err)
+	}
+	if got := len(fake.CallsTo("Get")); got != 1 {
+		t.Errorf("got %d reads of the wrapped Store, want 1", got)
+	}
+}
+
+func TestWriteDuringRead(t *testing.T) {
+	ctx := context.Background()
+	fake, started, release := blocking(`"old"`)
+	s := singleflight.Wrap(fake)
+
+	done := make(chan struct{})
+	go func() {
+		var v json.RawMessage
+		s.Get(ctx, "k", &v)
+		close(done)
+	}()
+	<-started
+	if err := s.Set(ctx, "k", json.RawMessage(`"new"`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	go func() {
+		var v json.RawMessage
+		s.Get(ctx, "k", &v)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Error("the Get after the Set joined the read in flight")
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,5 @@
+module github.com/gokv/store/slogstore
+
+go 1.26.7
+
+require github.com/gokv/store v0.1.0
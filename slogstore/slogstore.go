@@ -0,0 +1,224 @@
+/*
+Package slogstore provides a Store wrapper logging the operations with
+log/slog.
+
+Every operation is logged with the message "gokv." followed by the method
+(e.g. "gokv.Get"), and the attributes:
+
+	method    the method
+	key       the key, or its hash with WithHashedKeys
+	duration  the duration of the operation
+	found     the Ok result of Get, Update and Delete
+	error     the error, if any
+
+The successful operations are logged at the debug level, and the failed ones
+at the error level, unless specified otherwise with WithLevels. The
+successful reads may be sampled with WithReadSampling, to keep the volume of
+the logs down; the failures are always logged.
+
+It is a separate module, as log/slog requires Go 1.21.
+*/
+package slogstore // import "github.com/gokv/store/slogstore"
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithLevels sets the levels of the successful and of the failed operations.
+func WithLevels(ok, failed slog.Level) Option {
+	return func(s *Store) { s.okLevel, s.errLevel = ok, failed }
+}
+
+// WithHashedKeys logs the hash of the keys instead of the keys: the first 8
+// bytes of their SHA-256, in hexadecimal.
+func WithHashedKeys() Option {
+	return func(s *Store) { s.hashed = true }
+}
+
+// WithReadSampling logs only one successful Get or GetAll out of n.
+func WithReadSampling(n int) Option {
+	return func(s *Store) { s.sampling = uint64(n) }
+}
+
+// Store is a store.Store logging the operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	logger   *slog.Logger
+	okLevel  slog.Level
+	errLevel slog.Level
+	hashed   bool
+	sampling uint64
+	reads    atomic.Uint64
+}
+
+// Wrap returns a Store logging the operations of s to logger.
+func Wrap(s store.Store, logger *slog.Logger, opts ...Option) *Store {
+	l := &Store{
+		Wrapper:  store.Wrapper{Store: s},
+		logger:   logger,
+		okLevel:  slog.LevelDebug,
+		errLevel: slog.LevelError,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// op is an operation being logged.
+type op struct {
+	method string
+	start  time.Time
+	read   bool
+	attrs  []slog.Attr
+}
+
+func (s *Store) begin(method string, read bool) *op {
+	return &op{method: method, start: time.Now(), read: read}
+}
+
+// key adds the key to the attributes of the operation.
+func (o *op) key(s *Store, k string) *op {
+	if s.hashed {
+		h := sha256.Sum256([]byte(k))
+		o.attrs = append(o.attrs, slog.String("key", hex.EncodeToString(h[:8])))
+	} else {
+		o.attrs = append(o.attrs, slog.String("key", k))
+	}
+	return o
+}
+
+// log logs the operation, with its Ok result if found is non-nil.
+func (s *Store) log(ctx context.Context, o *op, found *bool, err error) {
+	level := s.okLevel
+	if err != nil {
+		level = s.errLevel
+	} else if o.read && s.sampling > 1 && s.reads.Add(1)%s.sampling != 1 {
+		return
+	}
+	if !s.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(o.attrs)+4)
+	attrs = append(attrs, slog.String("method", o.method))
+	attrs = append(attrs, o.attrs...)
+	attrs = append(attrs, slog.Duration("duration", time.Since(o.start)))
+	if found != nil {
+		attrs = append(attrs, slog.Bool("found", *found))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	s.logger.LogAttrs(ctx, level, "gokv."+o.method, attrs...)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	o := s.begin("Get", true).key(s, k)
+	ok, err := s.Store.Get(ctx, k, v)
+	s.log(ctx, o, &ok, err)
+	return ok, err
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	o := s.begin("GetAll", true)
+	err := s.Store.GetAll(ctx, c)
+	s.log(ctx, o, nil, err)
+	return err
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	o := s.begin("Add", false)
+	k, err := s.Store.Add(ctx, v)
+	if err == nil {
+		o.key(s, k)
+	}
+	s.log(ctx, o, nil, err)
+	return k, err
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	o := s.begin("Set", false).key(s, k)
+	err := s.Store.Set(ctx, k, v)
+	s.log(ctx, o, nil, err)
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	o := s.begin("SetWithTimeout", false).key(s, k)
+	err := s.Store.SetWithTimeout(ctx, k, v, timeout)
+	s.log(ctx, o, nil, err)
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	o := s.begin("SetWithDeadline", false).key(s, k)
+	err := s.Store.SetWithDeadline(ctx, k, v, deadline)
+	s.log(ctx, o, nil, err)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	o := s.begin("Update", false).key(s, k)
+	ok, err := s.Store.Update(ctx, k, v)
+	s.log(ctx, o, &ok, err)
+	return ok, err
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	o := s.begin("Delete", false).key(s, k)
+	ok, err := s.Store.Delete(ctx, k)
+	s.log(ctx, o, &ok, err)
+	return ok, err
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	o := s.begin("Ping", false)
+	err := s.Store.Ping(ctx)
+	s.log(ctx, o, nil, err)
+	return err
+}
+
+// Close closes the wrapped Store.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	o := s.begin("Close", false)
+	err := s.Store.Close()
+	s.log(context.Background(), o, nil, err)
+	return err
+}
+
+var _ store.Store = (*Store)(nil)
@@ -0,0 +1,24 @@
+package slogstore_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/slogstore"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store logging the operations of an empty memstore to a
+// discarded text log.
+func newStore() store.Store {
+	return slogstore.Wrap(memstore.New(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
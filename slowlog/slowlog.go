@@ -0,0 +1,169 @@
+/*
+Package slowlog provides a Store wrapper reporting the operations slower than
+a threshold, so that the hot spots and the pathological keys of a backend can
+be found in production.
+
+Every operation but Close is timed; the ones exceeding the threshold of their
+method are passed, with their key and error, to the hook, by default the
+standard logger. The fast operations cost two readings of the clock, and
+are not reported.
+*/
+package slowlog // import "github.com/gokv/store/slowlog"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// SlowOp is an operation exceeding the threshold of its method.
+type SlowOp struct {
+	// Method is the method of the operation.
+	Method string
+
+	// Key is the key of the operation, empty for GetAll and Ping. It is the
+	// assigned key for Add, if it succeeded.
+	Key string
+
+	// Duration is the duration of the operation.
+	Duration time.Duration
+
+	// Err is the error returned by the wrapped Store, if any.
+	Err error
+}
+
+func (op *SlowOp) String() string {
+	s := fmt.Sprintf("slowlog: slow %s", op.Method)
+	if op.Key != "" {
+		s += fmt.Sprintf(" %q", op.Key)
+	}
+	s += fmt.Sprintf(" took %v", op.Duration)
+	if op.Err != nil {
+		s += fmt.Sprintf(": %v", op.Err)
+	}
+	return s
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMethodThreshold sets the threshold of the given method (e.g. "Get" or
+// "GetAll"), in place of the default one.
+func WithMethodThreshold(method string, threshold time.Duration) Option {
+	return func(s *Store) { s.methods[method] = threshold }
+}
+
+// WithHook sets the function called with the slow operations, in place of
+// the standard logger. The context is the one of the operation.
+func WithHook(hook func(context.Context, *SlowOp)) Option {
+	return func(s *Store) { s.hook = hook }
+}
+
+// Store is a store.Store reporting the slow operations of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	threshold time.Duration
+	methods   map[string]time.Duration
+	hook      func(context.Context, *SlowOp)
+}
+
+// Wrap returns a Store reporting the operations of s slower than threshold,
+// unless specified otherwise with WithMethodThreshold.
+func Wrap(s store.Store, threshold time.Duration, opts ...Option) *Store {
+	sl := &Store{
+		Wrapper:   store.Wrapper{Store: s},
+		threshold: threshold,
+		methods:   make(map[string]time.Duration),
+		hook:      func(_ context.Context, op *SlowOp) { log.Print(op) },
+	}
+	for _, opt := range opts {
+		opt(sl)
+	}
+	return sl
+}
+
+// observe reports the operation started at start, if it is slow.
+func (s *Store) observe(ctx context.Context, method, k string, start time.Time, err error) {
+	d := time.Since(start)
+	threshold, ok := s.methods[method]
+	if !ok {
+		threshold = s.threshold
+	}
+	if d >= threshold {
+		s.hook(ctx, &SlowOp{Method: method, Key: k, Duration: d, Err: err})
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error) {
+	defer func(start time.Time) { s.observe(ctx, "Get", k, start, err) }(time.Now())
+	return s.Store.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "GetAll", "", start, err) }(time.Now())
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (k string, err error) {
+	defer func(start time.Time) { s.observe(ctx, "Add", k, start, err) }(time.Now())
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "Set", k, start, err) }(time.Now())
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "SetWithTimeout", k, start, err) }(time.Now())
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "SetWithDeadline", k, start, err) }(time.Now())
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (ok bool, err error) {
+	defer func(start time.Time) { s.observe(ctx, "Update", k, start, err) }(time.Now())
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (ok bool, err error) {
+	defer func(start time.Time) { s.observe(ctx, "Delete", k, start, err) }(time.Now())
+	return s.Store.Delete(ctx, k)
+}
+
+// Ping returns a non-nil error if the Store is not healthy.
+func (s *Store) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "Ping", "", start, err) }(time.Now())
+	return s.Store.Ping(ctx)
+}
+
+var _ store.Store = (*Store)(nil)
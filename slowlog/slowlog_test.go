@@ -0,0 +1,24 @@
+package slowlog_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/slowlog"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store reporting the slow operations of an empty
+// memstore to a no-op hook.
+func newStore() store.Store {
+	return slowlog.Wrap(memstore.New(), time.Second, slowlog.WithHook(func(context.Context, *slowlog.SlowOp) {}))
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
@@ -0,0 +1,241 @@
+/*
+Package softdelete provides a Store wrapper whose Delete writes a tombstone
+instead of removing the value, so that an accidental deletion can be undone.
+
+The values are stored in an envelope recording whether, and when, they were
+deleted. Delete marks the value as deleted; the reads and Update then treat
+the key as not found, until Restore brings the value back or Purge removes
+it for good. With WithRetention, the tombstones clear after a while, as a
+Purge would. A Set of a deleted key overwrites its tombstone.
+
+Update and Delete read the envelope before writing it: they are not atomic,
+and may overwrite a concurrent write of the same key.
+*/
+package softdelete // import "github.com/gokv/store/softdelete"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// ErrInvalid is returned when a stored value is not a softdelete envelope.
+var ErrInvalid = errors.New("softdelete: invalid envelope")
+
+// envelope is the stored form of the values.
+type envelope struct {
+	Deleted *time.Time      `json:"deleted,omitempty"`
+	Value   json.RawMessage `json:"v"`
+}
+
+func (e *envelope) UnmarshalJSON(data []byte) error {
+	type plain envelope
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil || e.Value == nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// wrap returns the envelope of the live value v.
+func wrap(v json.Marshaler) (json.RawMessage, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Value: data})
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithRetention sets the lifespan of the tombstones, forever by default.
+func WithRetention(d time.Duration) Option {
+	return func(s *Store) { s.retention = d }
+}
+
+// Store is a store.Store keeping the deleted values as tombstones.
+type Store struct {
+	store.Wrapper
+	retention time.Duration
+}
+
+// Wrap returns a Store keeping the values deleted from s as tombstones.
+func Wrap(s store.Store, opts ...Option) *Store {
+	sd := &Store{Wrapper: store.Wrapper{Store: s}}
+	for _, opt := range opts {
+		opt(sd)
+	}
+	return sd
+}
+
+// get returns the envelope of k.
+func (s *Store) get(ctx context.Context, k string) (envelope, bool, error) {
+	var e envelope
+	ok, err := s.Store.Get(ctx, k, &e)
+	if err != nil || !ok {
+		return envelope{}, false, err
+	}
+	return e, true, nil
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found or is deleted.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	e, ok, err := s.get(ctx, k)
+	if err != nil || !ok || e.Deleted != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(e.Value)
+}
+
+// live unmarshals the live values to a fresh item of a Collection.
+type live struct {
+	c store.Collection
+}
+
+func (l live) UnmarshalJSON(data []byte) error {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	if e.Deleted != nil {
+		return nil
+	}
+	return l.c.New().UnmarshalJSON(e.Value)
+}
+
+// collection skips the deleted items of a Collection.
+type collection struct {
+	store.Collection
+}
+
+func (c collection) New() json.Unmarshaler {
+	return live{c.Collection}
+}
+
+// GetAll unmarshals to c every item in the store which is not deleted.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.Store.GetAll(ctx, collection{c})
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := wrap(v)
+	if err != nil {
+		return "", err
+	}
+	return s.Store.Add(ctx, data)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	data, err := wrap(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, k, data)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	data, err := wrap(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithTimeout(ctx, k, data, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	data, err := wrap(v)
+	if err != nil {
+		return err
+	}
+	return s.Store.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists and is not
+// deleted.
+// Ok is false if the key was not found or is deleted.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	e, ok, err := s.get(ctx, k)
+	if err != nil || !ok || e.Deleted != nil {
+		return false, err
+	}
+	data, err := wrap(v)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.Update(ctx, k, data)
+}
+
+// Delete marks a key and its value as deleted, keeping them as a tombstone.
+// Ok is false if the key was not found or is already deleted.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	e, ok, err := s.get(ctx, k)
+	if err != nil || !ok || e.Deleted != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	e.Deleted = &now
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	if s.retention > 0 {
+		return true, s.Store.SetWithTimeout(ctx, k, json.RawMessage(data), s.retention)
+	}
+	return true, s.Store.Set(ctx, k, json.RawMessage(data))
+}
+
+// Deleted returns the time the given key was deleted.
+// Ok is false if the key was not found or is not deleted.
+// Err is non-nil in case of failure.
+func (s *Store) Deleted(ctx context.Context, k string) (time.Time, bool, error) {
+	e, ok, err := s.get(ctx, k)
+	if err != nil || !ok || e.Deleted == nil {
+		return time.Time{}, false, err
+	}
+	return *e.Deleted, true, nil
+}
+
+// Restore brings back the deleted value of the given key. The restored key
+// does not expire.
+// Ok is false if the key was not found or is not deleted.
+// Err is non-nil in case of failure.
+func (s *Store) Restore(ctx context.Context, k string) (bool, error) {
+	e, ok, err := s.get(ctx, k)
+	if err != nil || !ok || e.Deleted == nil {
+		return false, err
+	}
+	e.Deleted = nil
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	return true, s.Store.Set(ctx, k, json.RawMessage(data))
+}
+
+// Purge removes a key and its value from the wrapped Store, whether it is
+// deleted or not.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Purge(ctx context.Context, k string) (bool, error) {
+	return s.Store.Delete(ctx, k)
+}
+
+var _ store.Store = (*Store)(nil)
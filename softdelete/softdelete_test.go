@@ -0,0 +1,159 @@
+package softdelete_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/softdelete"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a Store soft-deleting the keys of an empty memstore.
+func newStore() store.Store {
+	return softdelete.Wrap(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+func TestDeleteRestore(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := softdelete.Wrap(m)
+	for _, k := range []string{"deleted", "live"} {
+		if err := s.Set(ctx, k, json.RawMessage(`"`+k+`"`)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	before := time.Now()
+	if ok, err := s.Delete(ctx, "deleted"); err != nil || !ok {
+		t.Fatalf("Delete: %v, %v", ok, err)
+	}
+	expectValue(t, s, "deleted", "")
+	if ok, _ := m.Exists(ctx, "deleted"); !ok {
+		t.Fatal("the deleted value was removed")
+	}
+	if when, ok, err := s.Deleted(ctx, "deleted"); err != nil || !ok || when.Before(before.Add(-time.Second)) {
+		t.Errorf("Deleted: got %v, %v, %v", when, ok, err)
+	}
+	if _, ok, err := s.Deleted(ctx, "live"); err != nil || ok {
+		t.Errorf("Deleted of a live key: got %v, %v, want not deleted", ok, err)
+	}
+	if ok, err := s.Delete(ctx, "deleted"); err != nil || ok {
+		t.Errorf("Delete of a deleted key: got %v, %v, want not found", ok, err)
+	}
+	if ok, err := s.Update(ctx, "deleted", json.RawMessage(`1`)); err != nil || ok {
+		t.Errorf("Update of a deleted key: got %v, %v, want not found", ok, err)
+	}
+	var c items
+	if err := s.GetAll(ctx, &c); err != nil || len(c) != 1 || string(*c[0]) != `"live"` {
+		t.Errorf("GetAll: got %d items, %v, want the live one", len(c), err)
+	}
+
+	if ok, err := s.Restore(ctx, "deleted"); err != nil || !ok {
+		t.Fatalf("Restore: %v, %v", ok, err)
+	}
+	expectValue(t, s, "deleted", `"deleted"`)
+	if ok, err := s.Restore(ctx, "live"); err != nil || ok {
+		t.Errorf("Restore of a live key: got %v, %v, want not deleted", ok, err)
+	}
+}
+
+func TestSetDeleted(t *testing.T) {
+	ctx := context.Background()
+	s := softdelete.Wrap(memstore.New())
+	s.Set(ctx, "k", json.RawMessage(`1`))
+	s.Delete(ctx, "k")
+
+	if err := s.Set(ctx, "k", json.RawMessage(`2`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectValue(t, s, "k", "2")
+	if _, ok, _ := s.Deleted(ctx, "k"); ok {
+		t.Error("the tombstone was kept by Set")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := softdelete.Wrap(m)
+	s.Set(ctx, "k", json.RawMessage(`1`))
+	s.Delete(ctx, "k")
+
+	if ok, err := s.Purge(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Purge: %v, %v", ok, err)
+	}
+	if ok, _ := m.Exists(ctx, "k"); ok {
+		t.Error("the purged key is still stored")
+	}
+	if ok, err := s.Restore(ctx, "k"); err != nil || ok {
+		t.Errorf("Restore of a purged key: got %v, %v, want not found", ok, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := softdelete.Wrap(m, softdelete.WithRetention(time.Hour))
+	s.Set(ctx, "k", json.RawMessage(`1`))
+	s.Delete(ctx, "k")
+
+	if ttl, ok, err := m.GetTTL(ctx, "k"); err != nil || !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("the tombstone expires in %v, %v, %v, want the retention", ttl, ok, err)
+	}
+	s.Restore(ctx, "k")
+	if ttl, ok, err := m.GetTTL(ctx, "k"); err != nil || !ok || ttl != 0 {
+		t.Errorf("the restored key expires in %v, %v, %v, want never", ttl, ok, err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := softdelete.Wrap(m)
+	m.Set(ctx, "k", json.RawMessage(`{"name":"gopher"}`))
+
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); !errors.Is(err, softdelete.ErrInvalid) {
+		t.Errorf("Get of a plain value: got %v, want %v", err, softdelete.ErrInvalid)
+	}
+}
+
+// items is a store.Collection of raw values.
+type items []*json.RawMessage
+
+func (c *items) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
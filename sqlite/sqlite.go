@@ -0,0 +1,431 @@
+/*
+Package sqlite implements store.Store on top of a SQLite database, for CLI
+tools and desktop applications needing durable storage without cgo.
+
+The database is driven by modernc.org/sqlite, registered as the "sqlite"
+database/sql driver. The keys are stored as blobs, so that they are ordered
+byte-wise, and the values as their JSON encoding.
+
+The expirations are kept in a deadline column, in nanoseconds since the
+Unix epoch. The expired rows are hidden from every statement, and a sweep
+goroutine deletes them periodically.
+
+Update and CompareAndSet keep the expiration of the key.
+*/
+package sqlite // import "github.com/gokv/store/sqlite"
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// rows, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+// Store is a store.Store backed by a SQLite table.
+type Store struct {
+	db    *sql.DB
+	table string // quoted
+
+	get, set, add, update, del, sweep *sql.Stmt
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// Open opens the SQLite database at path, creating it if needed, and returns
+// a Store keeping the items in a table named "kv". The database is opened in
+// WAL mode, with a busy timeout of five seconds.
+func Open(path string) (*Store, error) {
+	dsn := "file:" + url.PathEscape(path) + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(context.Background(), db, "kv")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a Store keeping the items in the given table of db. The table
+// is created if needed. Closing the Store closes db.
+func New(ctx context.Context, db *sql.DB, table string) (*Store, error) {
+	return NewWithSweepInterval(ctx, db, table, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired rows.
+func NewWithSweepInterval(ctx context.Context, db *sql.DB, table string, interval time.Duration) (*Store, error) {
+	s := &Store{
+		db:    db,
+		table: quoteIdent(table),
+		stop:  make(chan struct{}),
+	}
+	if err := s.bootstrap(ctx, table); err != nil {
+		return nil, err
+	}
+	if err := s.prepare(ctx); err != nil {
+		s.closeStmts()
+		return nil, err
+	}
+
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s, nil
+}
+
+// quoteIdent quotes a SQLite identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// live is the condition selecting the rows which have not expired at the
+// time passed as parameter.
+const live = `(deadline IS NULL OR deadline > ?)`
+
+func (s *Store) bootstrap(ctx context.Context, table string) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		k        BLOB NOT NULL PRIMARY KEY,
+		v        TEXT NOT NULL,
+		deadline INTEGER
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+quoteIdent(table+"_deadline_idx")+
+		` ON `+s.table+` (deadline) WHERE deadline IS NOT NULL`)
+	return err
+}
+
+func (s *Store) prepare(ctx context.Context) error {
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, `SELECT v FROM ` + s.table + ` WHERE k = ? AND ` + live},
+		{&s.set, `INSERT INTO ` + s.table + ` (k, v, deadline) VALUES (?, ?, ?)
+			ON CONFLICT (k) DO UPDATE SET v = excluded.v, deadline = excluded.deadline`},
+		{&s.add, `INSERT INTO ` + s.table + ` (k, v) VALUES (?, ?)`},
+		{&s.update, `UPDATE ` + s.table + ` SET v = ? WHERE k = ? AND ` + live},
+		{&s.del, `DELETE FROM ` + s.table + ` WHERE k = ? AND ` + live},
+		{&s.sweep, `DELETE FROM ` + s.table + ` WHERE deadline <= ?`},
+	} {
+		stmt, err := s.db.PrepareContext(ctx, p.query)
+		if err != nil {
+			return err
+		}
+		*p.stmt = stmt
+	}
+	return nil
+}
+
+func (s *Store) closeStmts() error {
+	var err error
+	for _, stmt := range []*sql.Stmt{s.get, s.set, s.add, s.update, s.del, s.sweep} {
+		if stmt == nil {
+			continue
+		}
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_, _ = s.sweep.Exec(now.UnixNano())
+		}
+	}
+}
+
+// deadlineArg returns the value of the deadline column: NULL for the zero
+// time.
+func deadlineArg(deadline time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: deadline.UnixNano(), Valid: !deadline.IsZero()}
+}
+
+// rowsAffected returns whether res affected at least one row.
+func rowsAffected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	var value []byte
+	err := s.get.QueryRowContext(ctx, []byte(k), time.Now().UnixNano()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(value)
+}
+
+// GetAll unmarshals to c every item in the table, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key, in byte-wise order. A negative limit
+// means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	if offset < 0 {
+		offset = 0
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT v FROM `+s.table+` WHERE `+live+
+		` ORDER BY k LIMIT ? OFFSET ?`, time.Now().UnixNano(), limit, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		if err := c.New().UnmarshalJSON(value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Add assigns the given value to a new key, and returns the key. The keys are
+// random hexadecimal strings.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k := hex.EncodeToString(b)
+	if _, err := s.add.ExecContext(ctx, []byte(k), string(value)); err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = s.set.ExecContext(ctx, []byte(k), string(value), deadlineArg(deadline))
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected(s.update.ExecContext(ctx, string(value), []byte(k), time.Now().UnixNano()))
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	return rowsAffected(s.del.ExecContext(ctx, []byte(k), time.Now().UnixNano()))
+}
+
+// Ping returns a non-nil error if the database can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close stops the sweep goroutine, and closes the prepared statements and the
+// database.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	err := s.closeStmts()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	now := time.Now()
+	var deadline sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT deadline FROM `+s.table+` WHERE k = ? AND `+live,
+		[]byte(k), now.UnixNano()).Scan(&deadline)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil || !deadline.Valid {
+		return 0, err == nil, err
+	}
+	return time.Unix(0, deadline.Int64).Sub(now), true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return s.Delete(ctx, k)
+	}
+	now := time.Now()
+	return rowsAffected(s.db.ExecContext(ctx, `UPDATE `+s.table+` SET deadline = ? WHERE k = ? AND `+live,
+		now.Add(timeout).UnixNano(), []byte(k), now.UnixNano()))
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old. The comparison is made on the minified JSON encodings. The
+// expiration of the key is kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected(s.db.ExecContext(ctx, `UPDATE `+s.table+` SET v = ? WHERE k = ? AND json(v) = json(?) AND `+live,
+		string(value), []byte(k), string(o), time.Now().UnixNano()))
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+s.table+` WHERE k = ? AND `+live+`)`,
+		[]byte(k), time.Now().UnixNano()).Scan(&ok)
+	return ok, err
+}
+
+// hasPrefix is the condition selecting the keys starting with the blob passed
+// as parameter, twice.
+const hasPrefix = `substr(k, 1, length(?)) = ?`
+
+// Keys returns every key starting with prefix, in byte-wise order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	p := []byte(prefix)
+	rows, err := s.db.QueryContext(ctx, `SELECT k FROM `+s.table+` WHERE `+hasPrefix+` AND `+live+` ORDER BY k`,
+		p, p, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ks := []string{}
+	for rows.Next() {
+		var k []byte
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		ks = append(ks, string(k))
+	}
+	return ks, rows.Err()
+}
+
+// Count returns the number of keys in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Count(ctx context.Context) (n int64, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+` WHERE `+live,
+		time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// CountPrefix returns the number of keys starting with prefix.
+// Err is non-nil in case of failure.
+func (s *Store) CountPrefix(ctx context.Context, prefix string) (n int64, err error) {
+	p := []byte(prefix)
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM `+s.table+` WHERE `+hasPrefix+` AND `+live,
+		p, p, time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table)
+	return err
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	p := []byte(prefix)
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE `+hasPrefix, p, p)
+	return err
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Sizer            = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+)
@@ -0,0 +1,28 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/sqlite"
+	"github.com/gokv/store/storetest"
+)
+
+// newStore returns a function opening an empty Store in a new database file
+// of a temporary directory.
+func newStore(tb testing.TB) func() store.Store {
+	return func() store.Store {
+		s, err := sqlite.Open(filepath.Join(tb.TempDir(), "gokv.db"))
+		if err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }
@@ -0,0 +1,33 @@
+package store
+
+import "context"
+
+// Stats holds the metrics of a store. The fields an implementation cannot
+// provide are left to their zero value.
+type Stats struct {
+
+	// Items is the number of keys in the store.
+	Items int64
+
+	// Bytes is the storage used by the store.
+	Bytes int64
+
+	// Hits and Misses count the reads which respectively found and did not
+	// find the requested key, since the store was created.
+	Hits   int64
+	Misses int64
+
+	// OpenConns is the number of connections to the persistence, of which
+	// IdleConns are not currently in use.
+	OpenConns int
+	IdleConns int
+}
+
+// StatsProvider defines a method for surfacing the health of the backend in
+// a uniform way.
+type StatsProvider interface {
+
+	// Stats returns the current metrics of the store.
+	// Err is non-nil in case of failure.
+	Stats(ctx context.Context) (Stats, error)
+}
@@ -0,0 +1,160 @@
+package storegrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/storegrpc/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server serves a store.Store as the gokv.store.v1.Store service.
+type Server struct {
+	storepb.UnimplementedStoreServer
+
+	s store.Store
+}
+
+// NewServer returns a Server serving s. The Server does not close s.
+func NewServer(s store.Store) *Server {
+	return &Server{s: s}
+}
+
+// Register registers a Server serving s to gs.
+func Register(gs grpc.ServiceRegistrar, s store.Store) {
+	storepb.RegisterStoreServer(gs, NewServer(s))
+}
+
+// Get retrieves the value of a key.
+func (s *Server) Get(ctx context.Context, req *storepb.GetRequest) (*storepb.GetResponse, error) {
+	var v json.RawMessage
+	ok, err := s.s.Get(ctx, req.GetKey(), &v)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.GetResponse{Found: ok, Value: v}, nil
+}
+
+// GetAll streams every value of the store.
+func (s *Server) GetAll(req *storepb.GetAllRequest, stream storepb.Store_GetAllServer) error {
+	c := &streamer{stream: stream}
+	if err := s.s.GetAll(stream.Context(), c); err != nil {
+		return toStatus(err)
+	}
+	return c.flush()
+}
+
+// streamer is a store.Collection sending every value to a stream, one
+// behind, so that the send of a value follows its unmarshaling.
+type streamer struct {
+	stream storepb.Store_GetAllServer
+	last   *json.RawMessage
+	err    error
+}
+
+func (c *streamer) New() json.Unmarshaler {
+	if c.err == nil {
+		c.err = c.flush()
+	}
+	c.last = new(json.RawMessage)
+	return c.last
+}
+
+// flush sends the last value, if any.
+func (c *streamer) flush() error {
+	if c.err != nil || c.last == nil {
+		return c.err
+	}
+	v := *c.last
+	c.last = nil
+	return c.stream.Send(&storepb.GetAllResponse{Value: v})
+}
+
+// Add assigns a value to a new key, and returns the key.
+func (s *Server) Add(ctx context.Context, req *storepb.AddRequest) (*storepb.AddResponse, error) {
+	k, err := s.s.Add(ctx, json.RawMessage(req.GetValue()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.AddResponse{Key: k}, nil
+}
+
+// Set assigns a value to a key, with an optional expiration.
+func (s *Server) Set(ctx context.Context, req *storepb.SetRequest) (*storepb.SetResponse, error) {
+	v := json.RawMessage(req.GetValue())
+	var err error
+	switch exp := req.GetExpiration().(type) {
+	case *storepb.SetRequest_Timeout:
+		err = s.s.SetWithTimeout(ctx, req.GetKey(), v, exp.Timeout.AsDuration())
+	case *storepb.SetRequest_Deadline:
+		err = s.s.SetWithDeadline(ctx, req.GetKey(), v, exp.Deadline.AsTime())
+	default:
+		err = s.s.Set(ctx, req.GetKey(), v)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.SetResponse{}, nil
+}
+
+// Update assigns a value to a key, if it exists.
+func (s *Server) Update(ctx context.Context, req *storepb.UpdateRequest) (*storepb.UpdateResponse, error) {
+	ok, err := s.s.Update(ctx, req.GetKey(), json.RawMessage(req.GetValue()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.UpdateResponse{Found: ok}, nil
+}
+
+// Delete removes a key and its value.
+func (s *Server) Delete(ctx context.Context, req *storepb.DeleteRequest) (*storepb.DeleteResponse, error) {
+	ok, err := s.s.Delete(ctx, req.GetKey())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.DeleteResponse{Found: ok}, nil
+}
+
+// Keys returns every key starting with a prefix. It fails with
+// codes.Unimplemented if the store is not a store.KeyLister.
+func (s *Server) Keys(ctx context.Context, req *storepb.KeysRequest) (*storepb.KeysResponse, error) {
+	kl, ok := s.s.(store.KeyLister)
+	if !ok {
+		return nil, toStatus(store.ErrNotSupported)
+	}
+	ks, err := kl.Keys(ctx, req.GetPrefix())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storepb.KeysResponse{Keys: ks}, nil
+}
+
+// Ping checks the health of the store.
+func (s *Server) Ping(ctx context.Context, req *storepb.PingRequest) (*storepb.PingResponse, error) {
+	if err := s.s.Ping(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &storepb.PingResponse{}, nil
+}
+
+// toStatus returns the status of err, with a code according to its class.
+func toStatus(err error) error {
+	code := codes.Unknown
+	switch {
+	case store.IsNotFound(err):
+		code = codes.NotFound
+	case store.IsConflict(err):
+		code = codes.Aborted
+	case store.IsNotSupported(err):
+		code = codes.Unimplemented
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,224 @@
+/*
+Package storegrpc shares a Store across processes over gRPC, so that the
+clients do not embed the drivers of the backend.
+
+The protocol is the gokv.store.v1.Store service of package storepb, which
+mirrors the Store interface; the values travel as their JSON encoding. A
+Server serves any Store:
+
+	gs := grpc.NewServer()
+	storegrpc.Register(gs, s)
+	gs.Serve(lis)
+
+and a Client implements store.Store, and store.KeyLister, over a
+connection:
+
+	cc, err := grpc.NewClient("store:50051", grpc.WithTransportCredentials(creds))
+	s := storegrpc.NewClient(cc)
+
+The failures of the served Store are carried by the status codes, so that
+the errors of the Client wrap store.ErrNotFound, store.ErrConflict and
+store.ErrNotSupported like those of the served Store.
+*/
+package storegrpc // import "github.com/gokv/store/storegrpc"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/storegrpc/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Client is a store.Store served by a Server.
+type Client struct {
+	cc grpc.ClientConnInterface
+	c  storepb.StoreClient
+}
+
+// NewClient returns a Client using cc. Closing the Client closes cc, if it
+// is an io.Closer (e.g. a *grpc.ClientConn).
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc, c: storepb.NewStoreClient(cc)}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	resp, err := c.c.Get(ctx, &storepb.GetRequest{Key: k})
+	if err != nil {
+		return false, fromStatus(err)
+	}
+	if !resp.GetFound() {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(resp.GetValue())
+}
+
+// GetAll unmarshals to coll every item in the store, as they are streamed.
+// Err is non-nil in case of failure.
+func (c *Client) GetAll(ctx context.Context, coll store.Collection) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.c.GetAll(ctx, &storepb.GetAllRequest{})
+	if err != nil {
+		return fromStatus(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		if err := coll.New().UnmarshalJSON(resp.GetValue()); err != nil {
+			return err
+		}
+	}
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (c *Client) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.c.Add(ctx, &storepb.AddRequest{Value: data})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	return resp.GetKey(), nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (c *Client) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return c.set(ctx, &storepb.SetRequest{Key: k}, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan
+// starts when the Server receives the request.
+// Err is non-nil in case of failure.
+func (c *Client) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return c.set(ctx, &storepb.SetRequest{
+		Key:        k,
+		Expiration: &storepb.SetRequest_Timeout{Timeout: durationpb.New(timeout)},
+	}, v)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (c *Client) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	return c.set(ctx, &storepb.SetRequest{
+		Key:        k,
+		Expiration: &storepb.SetRequest_Deadline{Deadline: timestamppb.New(deadline)},
+	}, v)
+}
+
+// set sends req with the value v.
+func (c *Client) set(ctx context.Context, req *storepb.SetRequest, v json.Marshaler) error {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	req.Value = data
+	_, err = c.c.Set(ctx, req)
+	return fromStatus(err)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.c.Update(ctx, &storepb.UpdateRequest{Key: k, Value: data})
+	if err != nil {
+		return false, fromStatus(err)
+	}
+	return resp.GetFound(), nil
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Delete(ctx context.Context, k string) (bool, error) {
+	resp, err := c.c.Delete(ctx, &storepb.DeleteRequest{Key: k})
+	if err != nil {
+		return false, fromStatus(err)
+	}
+	return resp.GetFound(), nil
+}
+
+// Keys returns every key starting with prefix. It fails with
+// store.ErrNotSupported if the served Store is not a store.KeyLister.
+// Err is non-nil in case of failure.
+func (c *Client) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := c.c.Keys(ctx, &storepb.KeysRequest{Prefix: prefix})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return resp.GetKeys(), nil
+}
+
+// Ping returns a non-nil error if the Server is not reachable, or if the
+// served Store is not healthy.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.c.Ping(ctx, &storepb.PingRequest{})
+	return fromStatus(err)
+}
+
+// Close closes the connection, if it is an io.Closer.
+func (c *Client) Close() error {
+	if cl, ok := c.cc.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// fromStatus returns err wrapping the store error of its status code.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var target error
+	switch st.Code() {
+	case codes.NotFound:
+		target = store.ErrNotFound
+	case codes.Aborted:
+		target = store.ErrConflict
+	case codes.Unimplemented:
+		target = store.ErrNotSupported
+	case codes.Canceled:
+		target = context.Canceled
+	case codes.DeadlineExceeded:
+		target = context.DeadlineExceeded
+	default:
+		return fmt.Errorf("storegrpc: %w", err)
+	}
+	return fmt.Errorf("storegrpc: %s: %w", st.Message(), target)
+}
+
+var (
+	_ store.Store     = (*Client)(nil)
+	_ store.KeyLister = (*Client)(nil)
+)
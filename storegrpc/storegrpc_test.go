@@ -0,0 +1,163 @@
+package storegrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storegrpc"
+	"github.com/gokv/store/storegrpc/storepb"
+	"github.com/gokv/store/storetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// client is a Client of a Server in the same process, stopping the Server
+// on Close.
+type client struct {
+	*storegrpc.Client
+	gs *grpc.Server
+}
+
+func (c client) Close() error {
+	err := c.Client.Close()
+	c.gs.Stop()
+	return err
+}
+
+// serve returns a Client of a Server serving s over an in-memory
+// connection.
+func serve(s store.Store) client {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	storegrpc.Register(gs, s)
+	go gs.Serve(lis)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return client{Client: storegrpc.NewClient(cc), gs: gs}
+}
+
+// newStore returns a Client of a Server serving an empty memstore.
+func newStore() store.Store { return serve(memstore.New()) }
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// failing returns a Fake failing every operation with err.
+func failing(err error) *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc: func(context.Context, string, json.Unmarshaler) (bool, error) {
+			return false, err
+		},
+		SetFunc: func(context.Context, string, json.Marshaler) error {
+			return err
+		},
+		UpdateFunc: func(context.Context, string, json.Marshaler) (bool, error) {
+			return false, err
+		},
+		DeleteFunc: func(context.Context, string) (bool, error) {
+			return false, err
+		},
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range [...]struct {
+		err  error
+		code codes.Code
+		want error // wrapped by the errors of the Client
+	}{
+		{fmt.Errorf("memstore: %w", store.ErrNotFound), codes.NotFound, store.ErrNotFound},
+		{fmt.Errorf("memstore: %w", store.ErrConflict), codes.Aborted, store.ErrConflict},
+		{fmt.Errorf("memstore: %w", store.ErrNotSupported), codes.Unimplemented, store.ErrNotSupported},
+		{context.Canceled, codes.Canceled, context.Canceled},
+		{context.DeadlineExceeded, codes.DeadlineExceeded, context.DeadlineExceeded},
+		{errors.New("failed"), codes.Unknown, nil},
+	} {
+		t.Run(tc.code.String(), func(t *testing.T) {
+			fake := failing(tc.err)
+
+			_, err := storegrpc.NewServer(fake).Get(ctx, &storepb.GetRequest{Key: "k"})
+			if status.Code(err) != tc.code {
+				t.Errorf("Server.Get: got the status %v, want the code %v", err, tc.code)
+			}
+
+			c := serve(fake)
+			defer c.Close()
+			var v json.RawMessage
+			_, getErr := c.Get(ctx, "k", &v)
+			_, updateErr := c.Update(ctx, "k", json.RawMessage(`1`))
+			_, deleteErr := c.Delete(ctx, "k")
+			for _, err := range []error{getErr, c.Set(ctx, "k", json.RawMessage(`1`)), updateErr, deleteErr} {
+				if err == nil {
+					t.Fatal("Client: got no error")
+				}
+				if tc.want != nil && !errors.Is(err, tc.want) {
+					t.Errorf("Client: got %v, want it to wrap %v", err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeysNotSupported(t *testing.T) {
+	c := serve(new(storetest.Fake))
+	defer c.Close()
+	if _, err := c.Keys(context.Background(), ""); !store.IsNotSupported(err) {
+		t.Errorf("Keys of a Store without KeyLister: got %v, want %v", err, store.ErrNotSupported)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	hung := &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, _ json.Unmarshaler) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		},
+	}
+	c := serve(hung)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var v json.RawMessage
+	if _, err := c.Get(ctx, "k", &v); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get past the deadline: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	c := serve(m)
+	defer c.Close()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	m.Close()
+	if err := c.Ping(ctx); err == nil {
+		t.Errorf("Ping of a closed Store: got no error")
+	}
+}
@@ -0,0 +1,5 @@
+// Package storepb holds the protocol buffers of the gokv.store.v1.Store
+// service, served and consumed by package storegrpc.
+package storepb // import "github.com/gokv/store/storegrpc/storepb"
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative store.proto
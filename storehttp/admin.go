@@ -0,0 +1,353 @@
+package storehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// DefaultLimit is the default number of keys listed at once.
+const DefaultLimit = 1000
+
+// DefaultMaxBodySize is the default size limit, in bytes, of the written
+// values.
+const DefaultMaxBodySize = 1 << 20
+
+// Op is an operation of the API, given to the Authorizer.
+type Op string
+
+// The operations of the API.
+const (
+	OpList   Op = "list"
+	OpGet    Op = "get"
+	OpSet    Op = "set"
+	OpDelete Op = "delete"
+	OpPing   Op = "ping"
+)
+
+// ErrUnauthorized is returned by an Authorizer to deny a request with 401
+// Unauthorized rather than 403 Forbidden.
+var ErrUnauthorized = errors.New("storehttp: unauthorized")
+
+// Authorizer decides whether r may perform op on the key k, empty for
+// OpList and OpPing. A non-nil error denies the request, with 401
+// Unauthorized if it wraps ErrUnauthorized and 403 Forbidden otherwise.
+type Authorizer func(r *http.Request, op Op, k string) error
+
+// Item is an item of the Store, as answered by GET /keys/KEY.
+type Item struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+
+	// Expires is the time the key clears, if the Store is a store.TTLStore
+	// and the key expires.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Version, CreatedAt and UpdatedAt are the metadata of the item, if the
+	// Store is a store.MetaGetter.
+	Version   string     `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Option configures a handler.
+type Option func(*options)
+
+// WithAuth sets the Authorizer called before every request. Without it,
+// every request is allowed.
+func WithAuth(auth Authorizer) Option {
+	return func(o *options) { o.auth = auth }
+}
+
+// WithReadOnly rejects the writes with 405 Method Not Allowed.
+func WithReadOnly() Option {
+	return func(o *options) { o.readOnly = true }
+}
+
+// WithMaxBodySize sets the size limit, in bytes, of the written values.
+func WithMaxBodySize(n int64) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxBodySize = n
+		}
+	}
+}
+
+type options struct {
+	auth        Authorizer // nil allows everything
+	readOnly    bool
+	maxBodySize int64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// AdminHandler returns a handler serving the admin API of s. It is meant to
+// be mounted with http.StripPrefix.
+func AdminHandler(s store.Store, opts ...Option) http.Handler {
+	return &admin{s: s, o: newOptions(opts)}
+}
+
+type admin struct {
+	s store.Store
+	o *options
+}
+
+func (h *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/ping":
+		h.ping(w, r)
+	case r.URL.Path == "/keys":
+		h.list(w, r)
+	case strings.HasPrefix(r.URL.Path, "/keys/") && len(r.URL.Path) > len("/keys/"):
+		k := strings.TrimPrefix(r.URL.Path, "/keys/")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, k)
+		case http.MethodPut:
+			h.set(w, r, k)
+		case http.MethodDelete:
+			h.delete(w, r, k)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorize reports whether r may perform op on k, and answers the denied
+// requests.
+func (h *admin) authorize(w http.ResponseWriter, r *http.Request, op Op, k string) bool {
+	if (op == OpSet || op == OpDelete) && h.o.readOnly {
+		writeError(w, http.StatusMethodNotAllowed, "read-only")
+		return false
+	}
+	if h.o.auth == nil {
+		return true
+	}
+	err := h.o.auth(r, op, k)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeError(w, http.StatusForbidden, err.Error())
+	}
+	return false
+}
+
+func (h *admin) ping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, "GET, HEAD")
+		return
+	}
+	if !h.authorize(w, r, OpPing, "") {
+		return
+	}
+	if err := h.s.Ping(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *admin) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, "GET, HEAD")
+		return
+	}
+	if !h.authorize(w, r, OpList, "") {
+		return
+	}
+	q := r.URL.Query()
+	limit := DefaultLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	kl, ok := h.s.(store.KeyLister)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, store.ErrNotSupported.Error())
+		return
+	}
+	ks, err := kl.Keys(r.Context(), q.Get("prefix"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	sort.Strings(ks)
+	if after := q.Get("after"); after != "" {
+		i := sort.SearchStrings(ks, after)
+		if i < len(ks) && ks[i] == after {
+			i++
+		}
+		ks = ks[i:]
+	}
+	truncated := len(ks) > limit
+	if truncated {
+		ks = ks[:limit]
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Keys      []string `json:"keys"`
+		Truncated bool     `json:"truncated"`
+	}{append([]string{}, ks...), truncated})
+}
+
+func (h *admin) get(w http.ResponseWriter, r *http.Request, k string) {
+	if !h.authorize(w, r, OpGet, k) {
+		return
+	}
+	it, ok, err := item(r, h.s, k)
+	switch {
+	case err != nil:
+		writeStoreError(w, err)
+	case !ok:
+		writeError(w, http.StatusNotFound, store.ErrNotFound.Error())
+	default:
+		writeJSON(w, http.StatusOK, it)
+	}
+}
+
+// item returns the Item of k.
+// Ok is false if the key was not found.
+func item(r *http.Request, s store.Store, k string) (*Item, bool, error) {
+	ctx := r.Context()
+	it := &Item{Key: k}
+	if ok, err := s.Get(ctx, k, &it.Value); err != nil || !ok {
+		return nil, false, err
+	}
+	if t, ok := s.(store.TTLStore); ok {
+		ttl, ok, err := t.GetTTL(ctx, k)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		if ttl > 0 {
+			expires := time.Now().Add(ttl).UTC()
+			it.Expires = &expires
+		}
+	}
+	if mg, ok := s.(store.MetaGetter); ok {
+		m, ok, err := mg.GetMeta(ctx, k)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		it.Version = m.Version
+		if !m.CreatedAt.IsZero() {
+			it.CreatedAt = &m.CreatedAt
+		}
+		if !m.UpdatedAt.IsZero() {
+			it.UpdatedAt = &m.UpdatedAt
+		}
+	}
+	return it, true, nil
+}
+
+func (h *admin) set(w http.ResponseWriter, r *http.Request, k string) {
+	if !h.authorize(w, r, OpSet, k) {
+		return
+	}
+	var ttl time.Duration
+	if t := r.URL.Query().Get("ttl"); t != "" {
+		var err error
+		if ttl, err = time.ParseDuration(t); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+	}
+	v, ok := readValue(w, r, h.o.maxBodySize)
+	if !ok {
+		return
+	}
+
+	var err error
+	if ttl > 0 {
+		err = h.s.SetWithTimeout(r.Context(), k, v, ttl)
+	} else {
+		err = h.s.Set(r.Context(), k, v)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *admin) delete(w http.ResponseWriter, r *http.Request, k string) {
+	if !h.authorize(w, r, OpDelete, k) {
+		return
+	}
+	ok, err := h.s.Delete(r.Context(), k)
+	switch {
+	case err != nil:
+		writeStoreError(w, err)
+	case !ok:
+		writeError(w, http.StatusNotFound, store.ErrNotFound.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// readValue reads the JSON value in the body of r, and answers the invalid
+// ones.
+func readValue(w http.ResponseWriter, r *http.Request, max int64) (json.RawMessage, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the value is larger than %d bytes", max))
+		return nil, false
+	}
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "the value is not valid JSON")
+		return nil, false
+	}
+	return body, true
+}
+
+// writeStoreError answers a failure of the Store, with a status according
+// to its class.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case store.IsNotFound(err):
+		writeError(w, http.StatusNotFound, err.Error())
+	case store.IsConflict(err):
+		writeError(w, http.StatusConflict, err.Error())
+	case store.IsNotSupported(err):
+		writeError(w, http.StatusNotImplemented, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,297 @@
+package storehttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Client is a store.Store served by Handler.
+type Client struct {
+	base string // without the trailing slash
+	hc   *http.Client
+
+	// Header is added to every request, e.g. for the authorization.
+	Header http.Header
+}
+
+// NewClient returns a Client of the Handler mounted at baseURL, sending the
+// requests with hc, or with http.DefaultClient if hc is nil.
+func NewClient(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		base:   strings.TrimSuffix(baseURL, "/"),
+		hc:     hc,
+		Header: make(http.Header),
+	}
+}
+
+// do sends a request, and returns the response if its status is one of ok.
+// The body of the other responses is read as an error.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		req.Header[k] = vs
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	return nil, responseError(resp)
+}
+
+// responseError returns the error answered by resp, wrapping the store
+// error of its status.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	msg := resp.Status
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err == nil && body.Error != "" {
+		msg = body.Error
+	}
+	var target error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		target = store.ErrNotFound
+	case http.StatusConflict:
+		target = store.ErrConflict
+	case http.StatusNotImplemented:
+		target = store.ErrNotSupported
+	default:
+		return fmt.Errorf("storehttp: %d: %s", resp.StatusCode, msg)
+	}
+	return fmt.Errorf("storehttp: %d: %s: %w", resp.StatusCode, msg, target)
+}
+
+// itemPath returns the path of the item k.
+func itemPath(k string) string {
+	return "/items/" + url.PathEscape(k)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, itemPath(k), nil, nil, http.StatusOK)
+	if store.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(data)
+}
+
+// Exists reports whether the given key is in the store, with a HEAD
+// request.
+// Err is non-nil in case of failure.
+func (c *Client) Exists(ctx context.Context, k string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, itemPath(k), nil, nil, http.StatusOK)
+	if store.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// GetAll unmarshals to coll every item in the store, as they are streamed.
+// Err is non-nil in case of failure.
+func (c *Client) GetAll(ctx context.Context, coll store.Collection) error {
+	resp, err := c.do(ctx, http.MethodGet, "/items", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if err := coll.New().UnmarshalJSON(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if msg := resp.Trailer.Get(ErrorTrailer); msg != "" {
+		return fmt.Errorf("storehttp: GetAll: %s", msg)
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (c *Client) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/items", nil, data, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Key, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (c *Client) Set(ctx context.Context, k string, v json.Marshaler) error {
+	_, err := c.put(ctx, itemPath(k), nil, v)
+	return err
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan
+// starts when the Handler receives the request.
+// Err is non-nil in case of failure.
+func (c *Client) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	_, err := c.put(ctx, itemPath(k)+"?ttl="+url.QueryEscape(timeout.String()), nil, v)
+	return err
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (c *Client) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	_, err := c.put(ctx, itemPath(k)+"?deadline="+url.QueryEscape(deadline.Format(time.RFC3339Nano)), nil, v)
+	return err
+}
+
+// Update assigns the given value to the given key, if it exists, with
+// If-Match: *.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	return c.put(ctx, itemPath(k), http.Header{"If-Match": {"*"}}, v)
+}
+
+// CompareAndSet assigns v to the given key only if its current value is
+// equal to old, with If-Match and the ETag of old. The served Store must be
+// a store.CompareAndSetter.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (c *Client) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	data, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return c.put(ctx, itemPath(k), http.Header{"If-Match": {etag(data)}}, v)
+}
+
+// put sends v to path.
+// Ok is false if a precondition of header failed.
+func (c *Client) put(ctx context.Context, path string, header http.Header, v json.Marshaler) (bool, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ctx, http.MethodPut, path, header, data, http.StatusNoContent, http.StatusPreconditionFailed)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusNoContent, nil
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (c *Client) Delete(ctx context.Context, k string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodDelete, itemPath(k), nil, nil, http.StatusNoContent)
+	if store.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Keys returns every key starting with prefix, in lexical order.
+// Err is non-nil in case of failure.
+func (c *Client) Keys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/keys?prefix="+url.QueryEscape(prefix), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ks []string
+	if err := json.NewDecoder(resp.Body).Decode(&ks); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Ping returns a non-nil error if the Handler is not reachable, or if the
+// served Store is not healthy.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/ping", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Close closes the idle connections of the HTTP client.
+func (c *Client) Close() error {
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+var (
+	_ store.Store            = (*Client)(nil)
+	_ store.KeyLister        = (*Client)(nil)
+	_ store.Exister          = (*Client)(nil)
+	_ store.CompareAndSetter = (*Client)(nil)
+)
@@ -0,0 +1,62 @@
+/*
+Package storehttp exposes a Store over HTTP, for the admin endpoints and for
+the remote access to a Store.
+
+AdminHandler serves a small JSON API to list, read, write and delete the
+items of a Store, for the debug and admin endpoints of a service:
+
+	mux.Handle("/admin/store/", http.StripPrefix("/admin/store", storehttp.AdminHandler(s,
+		storehttp.WithAuth(func(r *http.Request, op storehttp.Op, k string) error {
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				return storehttp.ErrUnauthorized
+			}
+			return nil
+		}),
+	)))
+
+The routes are relative to the mount point:
+
+	GET    /keys?prefix=P&after=K&limit=N  the keys starting with P, after K,
+	                                       in lexical order
+	GET    /keys/KEY                       the Item of KEY
+	PUT    /keys/KEY?ttl=DURATION          assign the JSON body to KEY
+	DELETE /keys/KEY                       remove KEY
+	GET    /ping                           the health of the Store
+
+UIHandler serves a small page to browse a Store from a browser, backed by
+the admin API:
+
+	mux.Handle("/admin/ui/", http.StripPrefix("/admin/ui", storehttp.UIHandler(s)))
+
+Handler serves a Store to the Client of another process, which implements
+store.Store over HTTP, for the environments where gRPC is not an option:
+
+	mux.Handle("/kv/", http.StripPrefix("/kv", storehttp.Handler(s)))
+
+	s := storehttp.NewClient("http://store.internal/kv", nil)
+
+Its routes are:
+
+	GET    /items                           every value, as JSON Lines
+	POST   /items                           add the JSON body, answering its key
+	GET    /items/KEY                       the value of KEY
+	HEAD   /items/KEY                       the presence of KEY
+	PUT    /items/KEY?ttl=D|deadline=T      assign the JSON body to KEY
+	DELETE /items/KEY                       remove KEY
+	GET    /keys?prefix=P                   the keys starting with P
+	GET    /ping                            the health of the Store
+
+The values are served with a strong ETag, derived from their JSON encoding
+without whitespace. GET honours If-None-Match, with the weak comparison.
+PUT honours If-Match, with the strong comparison: with "*" the key is only
+updated if it exists, and with ETags it is only written if its current value
+matches one of them, which the Client uses for CompareAndSet; the weak ETags
+never match. The failed preconditions are answered with 412 Precondition
+Failed.
+
+The failures are answered with a JSON object holding an "error" message,
+and a status according to the class of the error: 404 Not Found for
+store.ErrNotFound, 409 Conflict for store.ErrConflict and 501 Not
+Implemented for store.ErrNotSupported.
+*/
+package storehttp // import "github.com/gokv/store/storehttp"
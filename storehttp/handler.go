@@ -0,0 +1,299 @@
+package storehttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Handler returns a handler serving s over the REST API consumed by Client.
+// It is meant to be mounted with http.StripPrefix. The Authorizer of
+// WithAuth is given OpList for GetAll and Keys, and OpSet with an empty key
+// for Add.
+func Handler(s store.Store, opts ...Option) http.Handler {
+	return &handler{admin{s: s, o: newOptions(opts)}}
+}
+
+// handler shares the authorization, Ping and Delete of the admin API.
+type handler struct {
+	admin
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/ping":
+		h.ping(w, r)
+	case r.URL.Path == "/keys":
+		h.keys(w, r)
+	case r.URL.Path == "/items":
+		switch r.Method {
+		case http.MethodGet:
+			h.getAll(w, r)
+		case http.MethodPost:
+			h.add(w, r)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case strings.HasPrefix(r.URL.Path, "/items/") && len(r.URL.Path) > len("/items/"):
+		k := strings.TrimPrefix(r.URL.Path, "/items/")
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, k)
+		case http.MethodPut:
+			h.put(w, r, k)
+		case http.MethodDelete:
+			h.delete(w, r, k)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *handler) keys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if !h.authorize(w, r, OpList, "") {
+		return
+	}
+	kl, ok := h.s.(store.KeyLister)
+	if !ok {
+		writeStoreError(w, store.ErrNotSupported)
+		return
+	}
+	ks, err := kl.Keys(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	sort.Strings(ks)
+	writeJSON(w, http.StatusOK, append([]string{}, ks...))
+}
+
+// getAll streams the values as JSON Lines. The failures after the first
+// value can not change the status, and are reported by the ErrorTrailer.
+func (h *handler) getAll(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, OpList, "") {
+		return
+	}
+	w.Header().Set("Trailer", ErrorTrailer)
+	c := &lines{w: w}
+	err := h.s.GetAll(r.Context(), c)
+	if err != nil && c.n == 0 && c.last == nil {
+		writeStoreError(w, err)
+		return
+	}
+	c.flush()
+	if c.n == 0 {
+		w.Header().Set("Content-Type", ndjson)
+		w.WriteHeader(http.StatusOK)
+	}
+	if err != nil {
+		w.Header().Set(ErrorTrailer, err.Error())
+	}
+}
+
+// ErrorTrailer is the trailer of GET /items reporting a failure after the
+// first value.
+const ErrorTrailer = "Gokv-Error"
+
+// ndjson is the media type of JSON Lines.
+const ndjson = "application/x-ndjson"
+
+// lines is a store.Collection writing every value as a line, one behind,
+// so that the write of a value follows its unmarshaling.
+type lines struct {
+	w    http.ResponseWriter
+	last *json.RawMessage
+	n    int
+}
+
+func (c *lines) New() json.Unmarshaler {
+	c.flush()
+	c.last = new(json.RawMessage)
+	return c.last
+}
+
+// flush writes the last value, if any.
+func (c *lines) flush() {
+	if c.last == nil {
+		return
+	}
+	if c.n == 0 {
+		c.w.Header().Set("Content-Type", ndjson)
+		c.w.WriteHeader(http.StatusOK)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, *c.last); err != nil {
+		buf.Reset()
+		buf.WriteString("null")
+	}
+	buf.WriteByte('\n')
+	c.w.Write(buf.Bytes())
+	c.last = nil
+	c.n++
+}
+
+func (h *handler) add(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, OpSet, "") {
+		return
+	}
+	v, ok := readValue(w, r, h.o.maxBodySize)
+	if !ok {
+		return
+	}
+	k, err := h.s.Add(r.Context(), v)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", "items/"+url.PathEscape(k))
+	w.Header().Set("ETag", etag(v))
+	writeJSON(w, http.StatusCreated, map[string]string{"key": k})
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, k string) {
+	if !h.authorize(w, r, OpGet, k) {
+		return
+	}
+	var v json.RawMessage
+	ok, err := h.s.Get(r.Context(), k, &v)
+	switch {
+	case err != nil:
+		writeStoreError(w, err)
+		return
+	case !ok:
+		writeError(w, http.StatusNotFound, store.ErrNotFound.Error())
+		return
+	}
+	tag := etag(v)
+	w.Header().Set("ETag", tag)
+	if matchETag(r.Header.Get("If-None-Match"), tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(v)
+	}
+}
+
+// put assigns the body to k. With If-Match: *, the key is only updated if
+// it exists; with If-Match and ETags, it is only assigned if its current
+// value has one of them, which requires a store.CompareAndSetter. The
+// failed preconditions are answered with 412 Precondition Failed.
+func (h *handler) put(w http.ResponseWriter, r *http.Request, k string) {
+	if !h.authorize(w, r, OpSet, k) {
+		return
+	}
+	q := r.URL.Query()
+	var (
+		ttl      time.Duration
+		deadline time.Time
+		err      error
+	)
+	if t := q.Get("ttl"); t != "" {
+		if ttl, err = time.ParseDuration(t); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+	}
+	if d := q.Get("deadline"); d != "" {
+		if deadline, err = time.Parse(time.RFC3339Nano, d); err != nil || ttl > 0 {
+			writeError(w, http.StatusBadRequest, "invalid deadline")
+			return
+		}
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && (ttl > 0 || !deadline.IsZero()) {
+		writeError(w, http.StatusBadRequest, "the expiration can not be set with If-Match")
+		return
+	}
+	v, ok := readValue(w, r, h.o.maxBodySize)
+	if !ok {
+		return
+	}
+
+	ok = true
+	ctx := r.Context()
+	switch {
+	case strings.TrimSpace(ifMatch) == "*":
+		ok, err = h.s.Update(ctx, k, v)
+	case ifMatch != "":
+		ok, err = h.compareAndSet(r, k, ifMatch, v)
+	case ttl > 0:
+		err = h.s.SetWithTimeout(ctx, k, v, ttl)
+	case !deadline.IsZero():
+		err = h.s.SetWithDeadline(ctx, k, v, deadline)
+	default:
+		err = h.s.Set(ctx, k, v)
+	}
+	switch {
+	case err != nil:
+		writeStoreError(w, err)
+	case !ok:
+		writeError(w, http.StatusPreconditionFailed, "precondition failed")
+	default:
+		w.Header().Set("ETag", etag(v))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// compareAndSet assigns v to k if the ETag of its current value is in
+// ifMatch.
+// Ok is false if the key was not found, or if the precondition failed.
+func (h *handler) compareAndSet(r *http.Request, k, ifMatch string, v json.RawMessage) (bool, error) {
+	cas, ok := h.s.(store.CompareAndSetter)
+	if !ok {
+		return false, store.ErrNotSupported
+	}
+	var current json.RawMessage
+	if ok, err := h.s.Get(r.Context(), k, &current); err != nil || !ok {
+		return false, err
+	}
+	if !matchETag(ifMatch, etag(current), false) {
+		return false, nil
+	}
+	return cas.CompareAndSet(r.Context(), k, current, v)
+}
+
+// etag returns the strong ETag of a JSON value, derived from its encoding
+// without the insignificant whitespace, so that the clients can compute
+// it.
+func etag(v []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err == nil {
+		v = buf.Bytes()
+	}
+	sum := sha256.Sum256(v)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchETag reports whether the If-Match or If-None-Match header h lists
+// tag, or is "*". The weak comparison of If-None-Match ignores the W/ prefix
+// of the listed ETags; the strong comparison of If-Match never matches them
+// (RFC 9110, section 8.8.3.2).
+func matchETag(h, tag string, weak bool) bool {
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package storehttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storehttp"
+	"github.com/gokv/store/storetest"
+)
+
+// client is a Client of a test server, which Close shuts down with the
+// served Store.
+type client struct {
+	*storehttp.Client
+	srv *httptest.Server
+	s   store.Store
+}
+
+func (c client) Close() error {
+	err := c.Client.Close()
+	c.srv.Close()
+	if serr := c.s.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// newStore returns a Client of a test server serving an empty memstore with
+// Handler.
+func newStore() store.Store {
+	s := memstore.New()
+	srv := httptest.NewServer(storehttp.Handler(s))
+	return client{Client: storehttp.NewClient(srv.URL, srv.Client()), srv: srv, s: s}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// do sends a request to srv, and returns the status and the ETag header of
+// the response.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	return res.StatusCode, res.Header.Get("ETag")
+}
+
+func TestETag(t *testing.T) {
+	s := memstore.New()
+	defer s.Close()
+	srv := httptest.NewServer(storehttp.Handler(s))
+	defer srv.Close()
+
+	if status, _ := do(t, srv, http.MethodPut, "/items/k", `{"a": 1}`, nil); status >= 300 {
+		t.Fatalf("PUT: got %d", status)
+	}
+	status, tag := do(t, srv, http.MethodGet, "/items/k", "", nil)
+	if status != http.StatusOK || tag == "" || strings.HasPrefix(tag, "W/") {
+		t.Fatalf("GET: got %d with the ETag %s, want 200 with a strong ETag", status, tag)
+	}
+
+	for _, tc := range [...]struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{tag, http.StatusNotModified},
+		{"W/" + tag, http.StatusNotModified},
+		{`"other", ` + tag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	} {
+		status, _ := do(t, srv, http.MethodGet, "/items/k", "", http.Header{"If-None-Match": {tc.ifNoneMatch}})
+		if status != tc.want {
+			t.Errorf("GET with If-None-Match: %s: got %d, want %d", tc.ifNoneMatch, status, tc.want)
+		}
+	}
+
+	for _, tc := range [...]struct {
+		ifMatch string
+		want    int
+	}{
+		{"W/" + tag, http.StatusPreconditionFailed},
+		{`"other"`, http.StatusPreconditionFailed},
+		{`"other", ` + tag, http.StatusNoContent},
+	} {
+		status, _ := do(t, srv, http.MethodPut, "/items/k", `{"a": 1}`, http.Header{"If-Match": {tc.ifMatch}})
+		if status != tc.want {
+			t.Errorf("PUT with If-Match: %s: got %d, want %d", tc.ifMatch, status, tc.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package storehttp
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+
+	"github.com/gokv/store"
+)
+
+//go:embed ui/index.html
+var uiPage []byte
+
+// UIHandler returns a handler serving a single-page UI to browse s, with a
+// key search, the pretty-printed values, their expiration and a delete
+// button, backed by the AdminHandler of s under api/. It is meant to be
+// mounted with http.StripPrefix, on a path ending with a slash. The options
+// are those of the AdminHandler: the page itself holds no data, and is
+// served to every request.
+func UIHandler(s store.Store, opts ...Option) http.Handler {
+	return &ui{api: http.StripPrefix("/api", AdminHandler(s, opts...))}
+}
+
+type ui struct {
+	api http.Handler
+}
+
+func (h *ui) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		h.api.ServeHTTP(w, r)
+	case r.URL.Path == "/" || r.URL.Path == "/index.html":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, "GET, HEAD")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Write(uiPage)
+	default:
+		http.NotFound(w, r)
+	}
+}
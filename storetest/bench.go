@@ -0,0 +1,163 @@
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gokv/store"
+)
+
+// benchKeys is the number of items populating the store before the read
+// benchmarks.
+const benchKeys = 1000
+
+// benchSizes are the approximate sizes of the benchmarked values, in bytes.
+var benchSizes = []struct {
+	name string
+	size int
+}{
+	{"64B", 64},
+	{"1KB", 1 << 10},
+	{"64KB", 64 << 10},
+}
+
+// benchParallelism are the goroutines per GOMAXPROCS of the parallel
+// benchmarks.
+var benchParallelism = []int{1, 8}
+
+// BenchmarkStore runs the shared benchmark suite against the stores returned
+// by newStore, so that implementations can be compared with each other. Every
+// benchmark calls newStore once, and closes the returned store when done;
+// newStore must return an empty store every time.
+//
+// The suite covers Get, Set, Delete and GetAll at several value sizes, both
+// serially and in parallel.
+func BenchmarkStore(b *testing.B, newStore func() store.Store) {
+	for _, size := range benchSizes {
+		v := benchValue(size.size)
+
+		bench(b, "Set/"+size.name, newStore, len(v), nil, func(s store.Store, i int) error {
+			return s.Set(context.Background(), benchKey(i%benchKeys), v)
+		})
+
+		bench(b, "Get/"+size.name, newStore, len(v), func(s store.Store, _ int) error {
+			return populate(s, v, benchKeys)
+		}, func(s store.Store, i int) error {
+			var dst json.RawMessage
+			ok, err := s.Get(context.Background(), benchKey(i%benchKeys), &dst)
+			if err == nil && !ok {
+				err = fmt.Errorf("key %q not found", benchKey(i%benchKeys))
+			}
+			return err
+		})
+
+		b.Run("GetAll/"+size.name, func(b *testing.B) {
+			s := newStore()
+			defer s.Close()
+			if err := populate(s, v, benchKeys); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(v) * benchKeys))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var c rawValues
+				if err := s.GetAll(context.Background(), &c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	b.Run("Delete", func(b *testing.B) {
+		s := newStore()
+		defer s.Close()
+		v := benchValue(benchSizes[0].size)
+		if err := populate(s, v, b.N); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Delete(context.Background(), benchKey(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// bench runs op serially and at every parallelism level. If setup is
+// non-nil, it is called with b.N before the timer starts.
+func bench(b *testing.B, name string, newStore func() store.Store, size int, setup func(store.Store, int) error, op func(store.Store, int) error) {
+	b.Run(name, func(b *testing.B) {
+		s := newStore()
+		defer s.Close()
+		if setup != nil {
+			if err := setup(s, b.N); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.SetBytes(int64(size))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := op(s, i); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, p := range benchParallelism {
+		b.Run(name+"/parallel-"+strconv.Itoa(p), func(b *testing.B) {
+			s := newStore()
+			defer s.Close()
+			if setup != nil {
+				if err := setup(s, b.N); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(size))
+			b.SetParallelism(p)
+			var n int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := op(s, int(atomic.AddInt64(&n, 1))); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// populate assigns v to the first n benchmark keys.
+func populate(s store.Store, v json.RawMessage, n int) error {
+	for i := 0; i < n; i++ {
+		if err := s.Set(context.Background(), benchKey(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func benchKey(i int) string {
+	return "bench/" + strconv.Itoa(i)
+}
+
+// benchValue returns a JSON document of approximately size bytes.
+func benchValue(size int) json.RawMessage {
+	return json.RawMessage(`{"s":"` + strings.Repeat("x", size-8) + `"}`)
+}
+
+// rawValues collects the values of GetAll without decoding them.
+type rawValues []*json.RawMessage
+
+func (c *rawValues) New() json.Unmarshaler {
+	v := new(json.RawMessage)
+	*c = append(*c, v)
+	return v
+}
@@ -0,0 +1,29 @@
+/*
+Package storetest provides utilities for testing the consumers and the
+implementations of store.Store.
+
+TestStore is the conformance suite for the implementations. It documents the
+expected behaviour as executable tests:
+
+	func TestStore(t *testing.T) {
+		storetest.TestStore(t, func() store.Store {
+			return mystore.New()
+		})
+	}
+
+BenchmarkStore is the shared benchmark suite, for comparing the
+implementations with each other and catching regressions.
+
+FuzzStore fuzzes the round trip of keys and values, seeded with HostileKeys
+and HostileValues.
+
+Fake is a scriptable Store for exercising the error paths of the consumers
+without a mocking framework:
+
+	f := &storetest.Fake{
+		PingFunc: func(context.Context) error {
+			return errors.New("connection lost")
+		},
+	}
+*/
+package storetest // import "github.com/gokv/store/storetest"
@@ -0,0 +1,165 @@
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Call records a call to a Fake method.
+type Call struct {
+	Method string
+
+	// Args holds the arguments of the call, except the context.
+	Args []any
+}
+
+// Fake is a store.Store whose behaviour is defined per method by its Func
+// fields. A method whose Func is nil returns zero values: not found and no
+// error. Every call is recorded, whether or not its Func is set.
+//
+// A Fake is safe for concurrent use, as long as the Func fields are not
+// modified while it is in use.
+type Fake struct {
+	GetFunc             func(ctx context.Context, k string, v json.Unmarshaler) (bool, error)
+	GetAllFunc          func(ctx context.Context, c store.Collection) error
+	AddFunc             func(ctx context.Context, v json.Marshaler) (string, error)
+	SetFunc             func(ctx context.Context, k string, v json.Marshaler) error
+	SetWithTimeoutFunc  func(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error
+	SetWithDeadlineFunc func(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error
+	UpdateFunc          func(ctx context.Context, k string, v json.Marshaler) (bool, error)
+	DeleteFunc          func(ctx context.Context, k string) (bool, error)
+	PingFunc            func(ctx context.Context) error
+	CloseFunc           func() error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Calls returns the calls received so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls to the given method received so far, in order.
+func (f *Fake) CallsTo(method string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []Call
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls received so far.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+func (f *Fake) record(method string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+}
+
+// Get records the call and returns the result of GetFunc.
+func (f *Fake) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	f.record("Get", k, v)
+	if f.GetFunc == nil {
+		return false, nil
+	}
+	return f.GetFunc(ctx, k, v)
+}
+
+// GetAll records the call and returns the result of GetAllFunc.
+func (f *Fake) GetAll(ctx context.Context, c store.Collection) error {
+	f.record("GetAll", c)
+	if f.GetAllFunc == nil {
+		return nil
+	}
+	return f.GetAllFunc(ctx, c)
+}
+
+// Add records the call and returns the result of AddFunc.
+func (f *Fake) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	f.record("Add", v)
+	if f.AddFunc == nil {
+		return "", nil
+	}
+	return f.AddFunc(ctx, v)
+}
+
+// Set records the call and returns the result of SetFunc.
+func (f *Fake) Set(ctx context.Context, k string, v json.Marshaler) error {
+	f.record("Set", k, v)
+	if f.SetFunc == nil {
+		return nil
+	}
+	return f.SetFunc(ctx, k, v)
+}
+
+// SetWithTimeout records the call and returns the result of
+// SetWithTimeoutFunc.
+func (f *Fake) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	f.record("SetWithTimeout", k, v, timeout)
+	if f.SetWithTimeoutFunc == nil {
+		return nil
+	}
+	return f.SetWithTimeoutFunc(ctx, k, v, timeout)
+}
+
+// SetWithDeadline records the call and returns the result of
+// SetWithDeadlineFunc.
+func (f *Fake) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	f.record("SetWithDeadline", k, v, deadline)
+	if f.SetWithDeadlineFunc == nil {
+		return nil
+	}
+	return f.SetWithDeadlineFunc(ctx, k, v, deadline)
+}
+
+// Update records the call and returns the result of UpdateFunc.
+func (f *Fake) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	f.record("Update", k, v)
+	if f.UpdateFunc == nil {
+		return false, nil
+	}
+	return f.UpdateFunc(ctx, k, v)
+}
+
+// Delete records the call and returns the result of DeleteFunc.
+func (f *Fake) Delete(ctx context.Context, k string) (bool, error) {
+	f.record("Delete", k)
+	if f.DeleteFunc == nil {
+		return false, nil
+	}
+	return f.DeleteFunc(ctx, k)
+}
+
+// Ping records the call and returns the result of PingFunc.
+func (f *Fake) Ping(ctx context.Context) error {
+	f.record("Ping")
+	if f.PingFunc == nil {
+		return nil
+	}
+	return f.PingFunc(ctx)
+}
+
+// Close records the call and returns the result of CloseFunc.
+func (f *Fake) Close() error {
+	f.record("Close")
+	if f.CloseFunc == nil {
+		return nil
+	}
+	return f.CloseFunc()
+}
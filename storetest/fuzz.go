@@ -0,0 +1,133 @@
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gokv/store"
+)
+
+// HostileKeys are keys which are known to trip implementations: empty, with
+// separators, with escaping characters, not printable, not valid UTF-8, or
+// very long.
+var HostileKeys = []string{
+	"",
+	" ",
+	"/",
+	"a/b",
+	"/a/b/",
+	"..",
+	"../a",
+	`a\b`,
+	"a b",
+	"a\tb",
+	"a\nb",
+	"a\x00b",
+	"*",
+	"?",
+	"%",
+	"%2F",
+	"a:b",
+	"'",
+	`"`,
+	"'; DROP TABLE kv; --",
+	"ключ",
+	"鍵",
+	"🔑",
+	"é",
+	"‮",
+	"\xff\xfe",
+	strings.Repeat("k", 250),
+	strings.Repeat("k", 1025),
+	strings.Repeat("🔑", 300),
+}
+
+// HostileValues are JSON documents which are known to trip implementations.
+var HostileValues = []string{
+	`null`,
+	`""`,
+	`0`,
+	`-0.0`,
+	`1e308`,
+	`12345678901234567890`,
+	`true`,
+	`[]`,
+	`{}`,
+	`{"":""}`,
+	`"\u0000"`,
+	`"🔑"`,
+	`"\"\\\/\b\f\n\r\t"`,
+	`"<script>&amp;</script>"`,
+	`{"a":{"b":{"c":{"d":{"e":{"f":[[[[[[1]]]]]]}}}}}}`,
+	`{"a":1,"a":2}`,
+	`[` + strings.Repeat(`"x",`, 1000) + `"x"]`,
+	`"` + strings.Repeat("v", 100<<10) + `"`,
+}
+
+// FuzzStore fuzzes the round trip of keys and values through the store
+// returned by newStore. The seed corpus combines HostileKeys and
+// HostileValues. newStore is called once for the whole fuzzing session.
+//
+// The implementations may reject keys or values, as long as Set returns an
+// error; whatever Set accepts must then be retrieved by Get with an
+// equivalent JSON value.
+func FuzzStore(f *testing.F, newStore func() store.Store) {
+	for i, k := range HostileKeys {
+		f.Add(k, []byte(HostileValues[i%len(HostileValues)]))
+	}
+	for i, v := range HostileValues {
+		f.Add(HostileKeys[i%len(HostileKeys)], []byte(v))
+	}
+
+	s := newStore()
+	f.Cleanup(func() { s.Close() })
+
+	f.Fuzz(func(t *testing.T, k string, v []byte) {
+		if !json.Valid(v) {
+			// Wrap the arbitrary bytes in a JSON string.
+			v, _ = json.Marshal(string(v))
+		}
+		RoundTrip(t, s, k, json.RawMessage(v))
+	})
+}
+
+// RoundTrip assigns v to k in s, then checks that Get retrieves an
+// equivalent JSON value. The test is skipped if s rejects k or v.
+func RoundTrip(t *testing.T, s store.Store, k string, v json.RawMessage) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.Set(ctx, k, v); err != nil {
+		t.Skipf("Set(%q): %v", k, err)
+	}
+
+	var got json.RawMessage
+	ok, err := s.Get(ctx, k, &got)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if !ok {
+		t.Fatalf("Get(%q): key not found after Set", k)
+	}
+	if !equivalentJSON(got, v) {
+		t.Fatalf("Get(%q): got %.100s, want %.100s", k, got, v)
+	}
+}
+
+// equivalentJSON reports whether a and b decode to the same value.
+func equivalentJSON(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var x, y any
+	if err := json.Unmarshal(a, &x); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
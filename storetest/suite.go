@@ -0,0 +1,1111 @@
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// expiryWait is how long TestStore waits for an expired key to clear.
+const expiryWait = 5 * time.Second
+
+// TestStore runs the conformance suite against the stores returned by
+// newStore. Every subtest calls newStore once, and closes the returned store
+// when done; newStore must return an empty store every time.
+//
+// Besides the store.Store methods, the suite exercises the optional
+// interfaces; the subtests of the interfaces the store does not implement,
+// or whose methods fail with store.ErrNotSupported, are skipped.
+func TestStore(t *testing.T, newStore func() store.Store) {
+	run := func(name string, test func(t *testing.T, s store.Store)) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+			test(t, s)
+		})
+	}
+
+	run("Ping", testPing)
+	run("GetMissing", testGetMissing)
+	run("Set", testSet)
+	run("SetOverwrite", testSetOverwrite)
+	run("Add", testAdd)
+	run("GetAll", testGetAll)
+	run("Update", testUpdate)
+	run("Delete", testDelete)
+	run("SetWithTimeout", testSetWithTimeout)
+	run("SetWithDeadline", testSetWithDeadline)
+	run("SetWithDeadlinePast", testSetWithDeadlinePast)
+	run("ContextCancelled", testContextCancelled)
+
+	run("Batch", testBatch)
+	run("Iter", testIter)
+	run("IterPrefix", testIterPrefix)
+	run("GetPage", testGetPage)
+	run("Query", testQuery)
+	run("Keys", testKeys)
+	run("Exists", testExists)
+	run("Count", testCount)
+	run("Clear", testClear)
+	run("CompareAndSet", testCompareAndSet)
+	run("Patch", testPatch)
+	run("JSONPatch", testJSONPatch)
+	run("GetOrSet", testGetOrSet)
+	run("GetAndDelete", testGetAndDelete)
+	run("Incr", testIncr)
+	run("TTL", testTTL)
+	run("GetMeta", testGetMeta)
+	run("Txn", testTxn)
+	run("Watch", testWatch)
+	run("List", testList)
+}
+
+// value is the value type used by the suite.
+type value struct {
+	S string `json:"s"`
+}
+
+func (v value) MarshalJSON() ([]byte, error) {
+	type plain value
+	return json.Marshal(plain(v))
+}
+
+func (v *value) UnmarshalJSON(data []byte) error {
+	type plain value
+	return json.Unmarshal(data, (*plain)(v))
+}
+
+// values collects the values of GetAll.
+type values []*value
+
+func (c *values) New() json.Unmarshaler {
+	v := new(value)
+	*c = append(*c, v)
+	return v
+}
+
+// strings returns the sorted contents of the collected values.
+func (c values) strings() []string {
+	ss := make([]string, len(c))
+	for i, v := range c {
+		ss[i] = v.S
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mustSet assigns {S: s} to k, and fails the test immediately on error.
+func mustSet(t *testing.T, st store.Store, k, s string) {
+	t.Helper()
+	if err := st.Set(context.Background(), k, value{S: s}); err != nil {
+		t.Fatalf("Set(%q): %v", k, err)
+	}
+}
+
+// expect checks that the value of k is {S: want}.
+func expect(t *testing.T, st store.Store, k, want string) {
+	t.Helper()
+	var v value
+	ok, err := st.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if !ok {
+		t.Fatalf("Get(%q): key not found", k)
+	}
+	if v.S != want {
+		t.Errorf("Get(%q): got %q, want %q", k, v.S, want)
+	}
+}
+
+// expectMissing checks that k is not found.
+func expectMissing(t *testing.T, st store.Store, k string) {
+	t.Helper()
+	ok, err := st.Get(context.Background(), k, new(value))
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if ok {
+		t.Errorf("Get(%q): found, want not found", k)
+	}
+}
+
+// eventuallyMissing waits up to expiryWait for k to clear.
+func eventuallyMissing(t *testing.T, st store.Store, k string) {
+	t.Helper()
+	for deadline := time.Now().Add(expiryWait); ; {
+		ok, err := st.Get(context.Background(), k, new(value))
+		if err != nil {
+			t.Fatalf("Get(%q): %v", k, err)
+		}
+		if !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get(%q): still found %v after expiration", k, expiryWait)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func testPing(t *testing.T, s store.Store) {
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func testGetMissing(t *testing.T, s store.Store) {
+	expectMissing(t, s, "missing")
+}
+
+func testSet(t *testing.T, s store.Store) {
+	mustSet(t, s, "a", "one")
+	expect(t, s, "a", "one")
+	expectMissing(t, s, "b")
+}
+
+func testSetOverwrite(t *testing.T, s store.Store) {
+	mustSet(t, s, "a", "one")
+	mustSet(t, s, "a", "two")
+	expect(t, s, "a", "two")
+}
+
+func testAdd(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	k1, err := s.Add(ctx, value{S: "one"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	k2, err := s.Add(ctx, value{S: "two"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if k1 == k2 {
+		t.Fatalf("Add: got the same key %q twice", k1)
+	}
+	expect(t, s, k1, "one")
+	expect(t, s, k2, "two")
+}
+
+func testGetAll(t *testing.T, s store.Store) {
+	var empty values
+	err := s.GetAll(context.Background(), &empty)
+	if store.IsNotSupported(err) {
+		t.Skip("GetAll is not supported")
+	}
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("GetAll on an empty store: got %d items", len(empty))
+	}
+
+	mustSet(t, s, "a", "one")
+	mustSet(t, s, "b", "two")
+	mustSet(t, s, "c", "three")
+
+	var c values
+	if err := s.GetAll(context.Background(), &c); err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if got, want := c.strings(), []string{"one", "three", "two"}; !equal(got, want) {
+		t.Errorf("GetAll: got %q, want %q", got, want)
+	}
+}
+
+func testUpdate(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	ok, err := s.Update(ctx, "a", value{S: "one"})
+	if err != nil {
+		t.Fatalf("Update on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("Update on a missing key: got ok")
+	}
+	expectMissing(t, s, "a")
+
+	mustSet(t, s, "a", "one")
+	ok, err = s.Update(ctx, "a", value{S: "two"})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !ok {
+		t.Errorf("Update: key not found")
+	}
+	expect(t, s, "a", "two")
+}
+
+func testDelete(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	ok, err := s.Delete(ctx, "a")
+	if err != nil {
+		t.Fatalf("Delete on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("Delete on a missing key: got ok")
+	}
+
+	mustSet(t, s, "a", "one")
+	mustSet(t, s, "b", "two")
+	ok, err = s.Delete(ctx, "a")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !ok {
+		t.Errorf("Delete: key not found")
+	}
+	expectMissing(t, s, "a")
+	expect(t, s, "b", "two")
+}
+
+func testSetWithTimeout(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if err := s.SetWithTimeout(ctx, "a", value{S: "one"}, time.Hour); err != nil {
+		t.Fatalf("SetWithTimeout: %v", err)
+	}
+	if err := s.SetWithTimeout(ctx, "b", value{S: "two"}, time.Second); err != nil {
+		t.Fatalf("SetWithTimeout: %v", err)
+	}
+	expect(t, s, "a", "one")
+	eventuallyMissing(t, s, "b")
+	expect(t, s, "a", "one")
+}
+
+func testSetWithDeadline(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if err := s.SetWithDeadline(ctx, "a", value{S: "one"}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SetWithDeadline: %v", err)
+	}
+	if err := s.SetWithDeadline(ctx, "b", value{S: "two"}, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("SetWithDeadline: %v", err)
+	}
+	expect(t, s, "a", "one")
+	eventuallyMissing(t, s, "b")
+	expect(t, s, "a", "one")
+}
+
+func testSetWithDeadlinePast(t *testing.T, s store.Store) {
+	err := s.SetWithDeadline(context.Background(), "a", value{S: "one"}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("SetWithDeadline: %v", err)
+	}
+	eventuallyMissing(t, s, "a")
+}
+
+func testContextCancelled(t *testing.T, s store.Store) {
+	mustSet(t, s, "a", "one")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Get(ctx, "a", new(value)); err == nil {
+		t.Errorf("Get with a cancelled context: got no error")
+	}
+	if err := s.Set(ctx, "a", value{S: "two"}); err == nil {
+		t.Errorf("Set with a cancelled context: got no error")
+	}
+	expect(t, s, "a", "one")
+}
+
+func testBatch(t *testing.T, st store.Store) {
+	s, ok := st.(store.Batch)
+	if !ok {
+		t.Skip("not a store.Batch")
+	}
+	ctx := context.Background()
+	err := s.SetMulti(ctx, []string{"a", "b"}, []json.Marshaler{value{S: "one"}, value{S: "two"}})
+	if err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+
+	var a, b, c value
+	found, err := s.GetMulti(ctx, []string{"a", "b", "c"}, []json.Unmarshaler{&a, &b, &c})
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	if len(found) != 3 || !found[0] || !found[1] || found[2] {
+		t.Errorf("GetMulti: got ok %v, want [true true false]", found)
+	}
+	if a.S != "one" || b.S != "two" {
+		t.Errorf("GetMulti: got %q and %q, want %q and %q", a.S, b.S, "one", "two")
+	}
+
+	found, err = s.DeleteMulti(ctx, []string{"a", "c"})
+	if err != nil {
+		t.Fatalf("DeleteMulti: %v", err)
+	}
+	if len(found) != 2 || !found[0] || found[1] {
+		t.Errorf("DeleteMulti: got ok %v, want [true false]", found)
+	}
+	found, err = s.GetMulti(ctx, []string{"a", "b"}, []json.Unmarshaler{new(value), new(value)})
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	if len(found) != 2 || found[0] || !found[1] {
+		t.Errorf("GetMulti after DeleteMulti: got ok %v, want [false true]", found)
+	}
+}
+
+func testIter(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Iterable); !ok {
+		t.Skip("not a store.Iterable")
+	}
+	ctx := context.Background()
+	mustSet(t, s, "a", "one")
+	mustSet(t, s, "b", "two")
+
+	it, err := s.(store.Iterable).Iter(ctx)
+	if store.IsNotSupported(err) {
+		t.Skip("Iter is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Iter: %v", err)
+	}
+	defer it.Close()
+
+	got := make(map[string]string)
+	for {
+		ok, err := it.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		var v value
+		if err := it.Value(&v); err != nil {
+			t.Fatalf("Value: %v", err)
+		}
+		got[it.Key()] = v.S
+	}
+	if len(got) != 2 || got["a"] != "one" || got["b"] != "two" {
+		t.Errorf("Iter: got %v, want map[a:one b:two]", got)
+	}
+}
+
+func testIterPrefix(t *testing.T, s store.Store) {
+	if _, ok := s.(store.PrefixIterable); !ok {
+		t.Skip("not a store.PrefixIterable")
+	}
+	ctx := context.Background()
+	mustSet(t, s, "user/1", "one")
+	mustSet(t, s, "user/2", "two")
+	mustSet(t, s, "group/1", "three")
+
+	it, err := s.(store.PrefixIterable).IterPrefix(ctx, "user/")
+	if store.IsNotSupported(err) {
+		t.Skip("IterPrefix is not supported")
+	}
+	if err != nil {
+		t.Fatalf("IterPrefix: %v", err)
+	}
+	defer it.Close()
+
+	got := make(map[string]string)
+	for {
+		ok, err := it.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		var v value
+		if err := it.Value(&v); err != nil {
+			t.Fatalf("Value: %v", err)
+		}
+		got[it.Key()] = v.S
+	}
+	if len(got) != 2 || got["user/1"] != "one" || got["user/2"] != "two" {
+		t.Errorf("IterPrefix: got %v, want map[user/1:one user/2:two]", got)
+	}
+}
+
+func testGetPage(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Pager); !ok {
+		t.Skip("not a store.Pager")
+	}
+	for _, k := range []string{"c", "a", "e", "b", "d"} {
+		mustSet(t, s, k, k)
+	}
+
+	var got []string
+	for offset := 0; ; offset += 2 {
+		var c values
+		if err := s.(store.Pager).GetPage(context.Background(), &c, offset, 2); err != nil {
+			t.Fatalf("GetPage(%d, 2): %v", offset, err)
+		}
+		for _, v := range c {
+			got = append(got, v.S)
+		}
+		if len(c) < 2 {
+			break
+		}
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !equal(got, want) {
+		t.Errorf("GetPage: got %q, want %q", got, want)
+	}
+}
+
+func testQuery(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Querier); !ok {
+		t.Skip("not a store.Querier")
+	}
+	ctx := context.Background()
+	for k, doc := range map[string]string{
+		"a": `{"s":"one","n":1,"tag":{"color":"red"}}`,
+		"b": `{"s":"two","n":2,"tag":{"color":"blue"}}`,
+		"c": `{"s":"three","n":3,"tag":{"color":"red"}}`,
+	} {
+		if err := s.Set(ctx, k, json.RawMessage(doc)); err != nil {
+			t.Fatalf("Set(%q): %v", k, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		f    store.Filter
+		want []string
+	}{
+		{"Eq", store.Filter{store.Eq("s", "two")}, []string{"two"}},
+		{"Nested", store.Filter{store.Eq("tag.color", "red")}, []string{"one", "three"}},
+		{"Gt", store.Filter{store.Gt("n", 1)}, []string{"three", "two"}},
+		{"And", store.Filter{store.Gte("n", 2), store.Eq("tag.color", "red")}, []string{"three"}},
+		{"Empty", nil, []string{"one", "three", "two"}},
+		{"None", store.Filter{store.Eq("s", "four")}, []string{}},
+	} {
+		var c values
+		err := s.(store.Querier).Query(ctx, tc.f, &c)
+		if store.IsNotSupported(err) {
+			t.Skip("Query is not supported")
+		}
+		if err != nil {
+			t.Fatalf("Query %s: %v", tc.name, err)
+		}
+		if got := c.strings(); !equal(got, tc.want) {
+			t.Errorf("Query %s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func testKeys(t *testing.T, s store.Store) {
+	if _, ok := s.(store.KeyLister); !ok {
+		t.Skip("not a store.KeyLister")
+	}
+	for _, k := range []string{"user/1", "user/2", "group/1"} {
+		mustSet(t, s, k, k)
+	}
+
+	ks, err := s.(store.KeyLister).Keys(context.Background(), "user/")
+	if store.IsNotSupported(err) {
+		t.Skip("Keys is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	sort.Strings(ks)
+	if want := []string{"user/1", "user/2"}; !equal(ks, want) {
+		t.Errorf("Keys: got %q, want %q", ks, want)
+	}
+
+	ks, err = s.(store.KeyLister).Keys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(ks) != 3 {
+		t.Errorf("Keys with an empty prefix: got %q, want 3 keys", ks)
+	}
+}
+
+func testExists(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Exister); !ok {
+		t.Skip("not a store.Exister")
+	}
+	mustSet(t, s, "a", "one")
+	for k, want := range map[string]bool{"a": true, "b": false} {
+		ok, err := s.(store.Exister).Exists(context.Background(), k)
+		if store.IsNotSupported(err) {
+			t.Skip("Exists is not supported")
+		}
+		if err != nil {
+			t.Fatalf("Exists(%q): %v", k, err)
+		}
+		if ok != want {
+			t.Errorf("Exists(%q): got %v, want %v", k, ok, want)
+		}
+	}
+}
+
+func testCount(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Sizer); !ok {
+		t.Skip("not a store.Sizer")
+	}
+	ctx := context.Background()
+	sz := s.(store.Sizer)
+
+	n, err := sz.Count(ctx)
+	if store.IsNotSupported(err) {
+		t.Skip("Count is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Count on an empty store: got %d, want 0", n)
+	}
+
+	for _, k := range []string{"user/1", "user/2", "group/1"} {
+		mustSet(t, s, k, k)
+	}
+	if n, err = sz.Count(ctx); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Count: got %d, want 3", n)
+	}
+
+	n, err = sz.CountPrefix(ctx, "user/")
+	if store.IsNotSupported(err) {
+		t.Skip("CountPrefix is not supported")
+	}
+	if err != nil {
+		t.Fatalf("CountPrefix: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("CountPrefix: got %d, want 2", n)
+	}
+}
+
+func testClear(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Clearer); !ok {
+		t.Skip("not a store.Clearer")
+	}
+	ctx := context.Background()
+	mustSet(t, s, "user/1", "one")
+	mustSet(t, s, "group/1", "two")
+
+	err := s.(store.Clearer).ClearPrefix(ctx, "user/")
+	if store.IsNotSupported(err) {
+		t.Skip("ClearPrefix is not supported")
+	}
+	if err != nil {
+		t.Fatalf("ClearPrefix: %v", err)
+	}
+	expectMissing(t, s, "user/1")
+	expect(t, s, "group/1", "two")
+
+	if err := s.(store.Clearer).Clear(ctx); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	expectMissing(t, s, "group/1")
+}
+
+func testCompareAndSet(t *testing.T, s store.Store) {
+	if _, ok := s.(store.CompareAndSetter); !ok {
+		t.Skip("not a store.CompareAndSetter")
+	}
+	ctx := context.Background()
+	cas := s.(store.CompareAndSetter)
+
+	ok, err := cas.CompareAndSet(ctx, "a", value{S: "one"}, value{S: "two"})
+	if err != nil {
+		t.Fatalf("CompareAndSet on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("CompareAndSet on a missing key: got ok")
+	}
+
+	mustSet(t, s, "a", "one")
+	ok, err = cas.CompareAndSet(ctx, "a", value{S: "other"}, value{S: "two"})
+	if err != nil {
+		t.Fatalf("CompareAndSet with a different value: %v", err)
+	}
+	if ok {
+		t.Errorf("CompareAndSet with a different value: got ok")
+	}
+	expect(t, s, "a", "one")
+
+	ok, err = cas.CompareAndSet(ctx, "a", value{S: "one"}, value{S: "two"})
+	if err != nil {
+		t.Fatalf("CompareAndSet: %v", err)
+	}
+	if !ok {
+		t.Errorf("CompareAndSet: got not ok")
+	}
+	expect(t, s, "a", "two")
+}
+
+func testPatch(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Patcher); !ok {
+		t.Skip("not a store.Patcher")
+	}
+	ctx := context.Background()
+	p := s.(store.Patcher)
+
+	ok, err := p.Patch(ctx, "a", json.RawMessage(`{"s":"two"}`))
+	if err != nil {
+		t.Fatalf("Patch on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("Patch on a missing key: got ok")
+	}
+	expectMissing(t, s, "a")
+
+	if err := s.Set(ctx, "a", json.RawMessage(`{"s":"one","n":{"x":1,"y":2}}`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	ok, err = p.Patch(ctx, "a", json.RawMessage(`{"s":"two","n":{"x":null,"z":3}}`))
+	if err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if !ok {
+		t.Errorf("Patch: got not ok")
+	}
+	var got struct {
+		S string         `json:"s"`
+		N map[string]int `json:"n"`
+	}
+	var data json.RawMessage
+	if _, err := s.Get(ctx, "a", &data); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.S != "two" || len(got.N) != 2 || got.N["y"] != 2 || got.N["z"] != 3 {
+		t.Errorf("Patch: got %s, want {\"s\":\"two\",\"n\":{\"y\":2,\"z\":3}}", data)
+	}
+}
+
+func testJSONPatch(t *testing.T, s store.Store) {
+	if _, ok := s.(store.JSONPatcher); !ok {
+		t.Skip("not a store.JSONPatcher")
+	}
+	ctx := context.Background()
+	p := s.(store.JSONPatcher)
+	ops := []store.Operation{
+		{Op: "test", Path: "/s", Value: json.RawMessage(`"one"`)},
+		{Op: "replace", Path: "/s", Value: json.RawMessage(`"two"`)},
+		{Op: "add", Path: "/a/1", Value: json.RawMessage(`2`)},
+		{Op: "move", From: "/n/x", Path: "/n/z"},
+		{Op: "copy", From: "/n/y", Path: "/a/-"},
+		{Op: "remove", Path: "/n/y"},
+	}
+
+	_, ok, err := p.JSONPatch(ctx, "a", ops)
+	if err != nil {
+		t.Fatalf("JSONPatch on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("JSONPatch on a missing key: got ok")
+	}
+	expectMissing(t, s, "a")
+
+	if err := s.Set(ctx, "a", json.RawMessage(`{"s":"one","a":[1,3],"n":{"x":1,"y":4}}`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	version, ok, err := p.JSONPatch(ctx, "a", ops)
+	if err != nil {
+		t.Fatalf("JSONPatch: %v", err)
+	}
+	if !ok {
+		t.Errorf("JSONPatch: got not ok")
+	}
+	var got struct {
+		S string         `json:"s"`
+		A []int          `json:"a"`
+		N map[string]int `json:"n"`
+	}
+	var data json.RawMessage
+	if _, err := s.Get(ctx, "a", &data); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.S != "two" || len(got.A) != 4 || got.A[1] != 2 || got.A[3] != 4 || len(got.N) != 1 || got.N["z"] != 1 {
+		t.Errorf("JSONPatch: got %s, want {\"s\":\"two\",\"a\":[1,2,3,4],\"n\":{\"z\":1}}", data)
+	}
+	if mg, ok := s.(store.MetaGetter); ok {
+		m, _, err := mg.GetMeta(ctx, "a")
+		if err != nil {
+			t.Fatalf("GetMeta: %v", err)
+		}
+		if m.Version != version {
+			t.Errorf("JSONPatch: got version %q, want %q", version, m.Version)
+		}
+	}
+
+	_, _, err = p.JSONPatch(ctx, "a", []store.Operation{
+		{Op: "remove", Path: "/s"},
+		{Op: "test", Path: "/a/0", Value: json.RawMessage(`0`)},
+	})
+	if !store.IsConflict(err) {
+		t.Errorf("JSONPatch with a failing test: got %v, want a conflict", err)
+	}
+	expect(t, s, "a", "two")
+}
+
+func testGetOrSet(t *testing.T, s store.Store) {
+	if _, ok := s.(store.GetOrSetter); !ok {
+		t.Skip("not a store.GetOrSetter")
+	}
+	ctx := context.Background()
+	gs := s.(store.GetOrSetter)
+
+	var current value
+	loaded, err := gs.GetOrSet(ctx, "a", value{S: "one"}, &current)
+	if err != nil {
+		t.Fatalf("GetOrSet on a missing key: %v", err)
+	}
+	if loaded {
+		t.Errorf("GetOrSet on a missing key: got loaded")
+	}
+	expect(t, s, "a", "one")
+
+	loaded, err = gs.GetOrSet(ctx, "a", value{S: "two"}, &current)
+	if err != nil {
+		t.Fatalf("GetOrSet: %v", err)
+	}
+	if !loaded || current.S != "one" {
+		t.Errorf("GetOrSet: got loaded=%v and %q, want loaded and %q", loaded, current.S, "one")
+	}
+	expect(t, s, "a", "one")
+}
+
+func testGetAndDelete(t *testing.T, s store.Store) {
+	if _, ok := s.(store.GetAndDeleter); !ok {
+		t.Skip("not a store.GetAndDeleter")
+	}
+	ctx := context.Background()
+	gd := s.(store.GetAndDeleter)
+	mustSet(t, s, "a", "one")
+
+	var v value
+	ok, err := gd.GetAndDelete(ctx, "a", &v)
+	if err != nil {
+		t.Fatalf("GetAndDelete: %v", err)
+	}
+	if !ok || v.S != "one" {
+		t.Errorf("GetAndDelete: got ok=%v and %q, want ok and %q", ok, v.S, "one")
+	}
+	expectMissing(t, s, "a")
+
+	ok, err = gd.GetAndDelete(ctx, "a", &v)
+	if err != nil {
+		t.Fatalf("GetAndDelete on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("GetAndDelete on a missing key: got ok")
+	}
+}
+
+func testIncr(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Counter); !ok {
+		t.Skip("not a store.Counter")
+	}
+	ctx := context.Background()
+	c := s.(store.Counter)
+	for _, step := range []struct{ delta, want int64 }{{3, 3}, {-1, 2}, {0, 2}} {
+		n, err := c.Incr(ctx, "n", step.delta)
+		if err != nil {
+			t.Fatalf("Incr(%d): %v", step.delta, err)
+		}
+		if n != step.want {
+			t.Errorf("Incr(%d): got %d, want %d", step.delta, n, step.want)
+		}
+	}
+
+	mustSet(t, s, "a", "one")
+	if _, err := c.Incr(ctx, "a", 1); err == nil {
+		t.Errorf("Incr on a non-integer value: got no error")
+	}
+}
+
+func testTTL(t *testing.T, s store.Store) {
+	if _, ok := s.(store.TTLStore); !ok {
+		t.Skip("not a store.TTLStore")
+	}
+	ctx := context.Background()
+	ts := s.(store.TTLStore)
+
+	mustSet(t, s, "a", "one")
+	ttl, ok, err := ts.GetTTL(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetTTL: %v", err)
+	}
+	if !ok || ttl != 0 {
+		t.Errorf("GetTTL on a key without expiration: got %v and ok=%v, want 0 and ok", ttl, ok)
+	}
+
+	if _, ok, err := ts.GetTTL(ctx, "missing"); err != nil || ok {
+		t.Errorf("GetTTL on a missing key: got ok=%v and error %v", ok, err)
+	}
+
+	ok, err = ts.Expire(ctx, "a", time.Hour)
+	if err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if !ok {
+		t.Errorf("Expire: key not found")
+	}
+	ttl, _, err = ts.GetTTL(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetTTL: %v", err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Errorf("GetTTL after Expire(1h): got %v", ttl)
+	}
+
+	if _, err := ts.Expire(ctx, "a", time.Second); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	eventuallyMissing(t, s, "a")
+}
+
+func testGetMeta(t *testing.T, s store.Store) {
+	if _, ok := s.(store.MetaGetter); !ok {
+		t.Skip("not a store.MetaGetter")
+	}
+	ctx := context.Background()
+	mg := s.(store.MetaGetter)
+
+	_, ok, err := mg.GetMeta(ctx, "a")
+	if store.IsNotSupported(err) {
+		t.Skip("GetMeta is not supported")
+	}
+	if err != nil {
+		t.Fatalf("GetMeta on a missing key: %v", err)
+	}
+	if ok {
+		t.Errorf("GetMeta on a missing key: got ok")
+	}
+
+	mustSet(t, s, "a", "one")
+	m1, ok, err := mg.GetMeta(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetMeta: %v", err)
+	}
+	if !ok {
+		t.Fatalf("GetMeta: key not found")
+	}
+	if !m1.UpdatedAt.IsZero() && m1.UpdatedAt.Before(m1.CreatedAt) {
+		t.Errorf("GetMeta: updated at %v, before the creation at %v", m1.UpdatedAt, m1.CreatedAt)
+	}
+
+	mustSet(t, s, "a", "two")
+	m2, _, err := mg.GetMeta(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetMeta: %v", err)
+	}
+	if m1.Version != "" && m2.Version == m1.Version {
+		t.Errorf("GetMeta after Set: version %q did not change", m2.Version)
+	}
+	if !m1.CreatedAt.IsZero() && !m2.CreatedAt.Equal(m1.CreatedAt) {
+		t.Errorf("GetMeta after Set: got created at %v, want %v", m2.CreatedAt, m1.CreatedAt)
+	}
+	if !m1.UpdatedAt.IsZero() && m2.UpdatedAt.Before(m1.UpdatedAt) {
+		t.Errorf("GetMeta after Set: got updated at %v, before %v", m2.UpdatedAt, m1.UpdatedAt)
+	}
+}
+
+func testTxn(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Txn); !ok {
+		t.Skip("not a store.Txn")
+	}
+	ctx := context.Background()
+	mustSet(t, s, "b", "two")
+
+	tx, err := s.(store.Txn).Begin(ctx)
+	if store.IsNotSupported(err) {
+		t.Skip("Begin is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := tx.Set(ctx, "a", value{S: "one"}); err != nil {
+		t.Fatalf("Tx.Set: %v", err)
+	}
+	var v value
+	ok, err := tx.Get(ctx, "b", &v)
+	if err != nil {
+		t.Fatalf("Tx.Get: %v", err)
+	}
+	if !ok || v.S != "two" {
+		t.Errorf("Tx.Get: got ok=%v and %q, want ok and %q", ok, v.S, "two")
+	}
+	if ok, err := tx.Delete(ctx, "b"); err != nil || !ok {
+		t.Fatalf("Tx.Delete: got ok=%v and error %v", ok, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	expect(t, s, "a", "one")
+	expectMissing(t, s, "b")
+
+	if tx, err = s.(store.Txn).Begin(ctx); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := tx.Set(ctx, "a", value{S: "other"}); err != nil {
+		t.Fatalf("Tx.Set: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	expect(t, s, "a", "one")
+}
+
+// nextEvent waits up to expiryWait for an event on ch.
+func nextEvent(t *testing.T, ch <-chan store.Event) store.Event {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatalf("Watch: channel closed")
+		}
+		return e
+	case <-time.After(expiryWait):
+		t.Fatalf("Watch: no event after %v", expiryWait)
+	}
+	return store.Event{}
+}
+
+// expectEvent waits for an event on ch, and checks its type, key and value.
+func expectEvent(t *testing.T, ch <-chan store.Event, typ store.EventType, k, want string) {
+	t.Helper()
+	e := nextEvent(t, ch)
+	if e.Type != typ || e.Key != k {
+		t.Fatalf("Watch: got event %d on %q, want %d on %q", e.Type, e.Key, typ, k)
+	}
+	if e.Value != nil && want != "" {
+		var v value
+		if err := v.UnmarshalJSON(e.Value); err != nil || v.S != want {
+			t.Errorf("Watch: got value %s, want %q", e.Value, want)
+		}
+	}
+}
+
+func testWatch(t *testing.T, s store.Store) {
+	if _, ok := s.(store.Watcher); !ok {
+		t.Skip("not a store.Watcher")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := s.(store.Watcher)
+
+	ch, err := w.Watch(ctx, "a")
+	if store.IsNotSupported(err) {
+		t.Skip("Watch is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	prefixCh, err := w.WatchPrefix(ctx, "user/")
+	if err != nil {
+		t.Fatalf("WatchPrefix: %v", err)
+	}
+
+	mustSet(t, s, "b", "ignored")
+	mustSet(t, s, "a", "one")
+	expectEvent(t, ch, store.EventSet, "a", "one")
+	if _, err := s.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	expectEvent(t, ch, store.EventDelete, "a", "")
+
+	mustSet(t, s, "group/1", "ignored")
+	mustSet(t, s, "user/1", "two")
+	expectEvent(t, prefixCh, store.EventSet, "user/1", "two")
+
+	cancel()
+	for deadline := time.After(expiryWait); ; {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Watch: channel still open %v after the cancellation", expiryWait)
+		}
+	}
+}
+
+func testList(t *testing.T, s store.Store) {
+	if _, ok := s.(store.ListStore); !ok {
+		t.Skip("not a store.ListStore")
+	}
+	ctx := context.Background()
+	l := s.(store.ListStore)
+
+	n, err := l.Append(ctx, "l", value{S: "a"}, value{S: "b"}, value{S: "c"})
+	if store.IsNotSupported(err) {
+		t.Skip("Append is not supported")
+	}
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Append: got length %d, want 3", n)
+	}
+	if n, err = l.Append(ctx, "l", value{S: "d"}); err != nil || n != 4 {
+		t.Errorf("Append: got length %d and error %v, want 4", n, err)
+	}
+
+	listRange := func(start, stop int) []string {
+		t.Helper()
+		var c values
+		if err := l.Range(ctx, "l", start, stop, &c); err != nil {
+			t.Fatalf("Range(%d, %d): %v", start, stop, err)
+		}
+		ss := make([]string, len(c))
+		for i, v := range c {
+			ss[i] = v.S
+		}
+		return ss
+	}
+	for _, tc := range []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"a", "b", "c", "d"}},
+		{1, 2, []string{"b", "c"}},
+		{-2, -1, []string{"c", "d"}},
+		{2, 10, []string{"c", "d"}},
+		{3, 1, []string{}},
+	} {
+		if got := listRange(tc.start, tc.stop); !equal(got, tc.want) {
+			t.Errorf("Range(%d, %d): got %q, want %q", tc.start, tc.stop, got, tc.want)
+		}
+	}
+
+	if err := l.Trim(ctx, "l", 1, -2); err != nil {
+		t.Fatalf("Trim: %v", err)
+	}
+	if got, want := listRange(0, -1), []string{"b", "c"}; !equal(got, want) {
+		t.Errorf("Range after Trim(1, -2): got %q, want %q", got, want)
+	}
+
+	var c values
+	if err := l.Range(ctx, "missing", 0, -1, &c); err != nil || len(c) != 0 {
+		t.Errorf("Range on a missing key: got %d values and error %v", len(c), err)
+	}
+}
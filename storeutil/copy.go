@@ -0,0 +1,79 @@
+package storeutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Copy copies every item of src to dst, with their expiration if src
+// implements store.TTLStore, and returns the progress. The items already in
+// dst are overwritten, unless WithConflict says otherwise. Copy stops at the
+// first failure, and can be resumed from the Checkpoint of the returned
+// Progress.
+// Err is non-nil in case of failure, or if src is not a store.KeyLister.
+func Copy(ctx context.Context, src, dst store.Store, opts ...Option) (Progress, error) {
+	o := newOptions(opts)
+	ks, err := o.keys(ctx, src)
+	if err != nil {
+		return Progress{Checkpoint: o.resume}, err
+	}
+	return o.run(ctx, ks, func(ctx context.Context, k string) (bool, error) {
+		return copyKey(ctx, src, dst, k, o.conflict)
+	})
+}
+
+// copyKey copies k from src to dst, and reports whether it was copied.
+func copyKey(ctx context.Context, src, dst store.Store, k string, c Conflict) (bool, error) {
+	var data json.RawMessage
+	if ok, err := src.Get(ctx, k, &data); err != nil || !ok {
+		return false, err
+	}
+	var deadline time.Time
+	if t, ok := src.(store.TTLStore); ok {
+		ttl, ok, err := t.GetTTL(ctx, k)
+		if err != nil || !ok {
+			return false, err
+		}
+		if ttl > 0 {
+			deadline = time.Now().Add(ttl)
+		}
+	}
+
+	if ok, err := checkConflict(ctx, dst, k, c); err != nil || !ok {
+		return false, err
+	}
+
+	if deadline.IsZero() {
+		return true, dst.Set(ctx, k, data)
+	}
+	return true, dst.SetWithDeadline(ctx, k, data, deadline)
+}
+
+// checkConflict applies the policy c to k in s, and reports whether k may
+// be written.
+func checkConflict(ctx context.Context, s store.Store, k string, c Conflict) (bool, error) {
+	if c == ConflictOverwrite {
+		return true, nil
+	}
+	var exists bool
+	var err error
+	if e, ok := s.(store.Exister); ok {
+		exists, err = e.Exists(ctx, k)
+	} else {
+		exists, err = s.Get(ctx, k, new(json.RawMessage))
+	}
+	switch {
+	case err != nil:
+		return false, err
+	case !exists:
+		return true, nil
+	case c == ConflictFail:
+		return false, fmt.Errorf("%w: the key exists", store.ErrConflict)
+	default:
+		return false, nil
+	}
+}
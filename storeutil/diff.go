@@ -0,0 +1,182 @@
+package storeutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/gokv/store"
+)
+
+// DefaultMaxValueSize is the default size, in bytes, above which the values
+// of a Mismatch are reported by their hash only.
+const DefaultMaxValueSize = 1024
+
+// Report is the outcome of Diff.
+type Report struct {
+	// OnlyInA and OnlyInB are the keys found in one Store only, in lexical
+	// order.
+	OnlyInA, OnlyInB []string
+
+	// Mismatches are the keys whose values differ, in lexical order.
+	Mismatches []Mismatch
+
+	// Compared is the number of keys found in both Stores whose values were
+	// compared, and Equal the number of them with equal values.
+	Compared, Equal int
+}
+
+// Mismatch is a key whose values differ between the Stores.
+type Mismatch struct {
+	Key string
+
+	// A and B are the values, nil if they are larger than WithMaxValueSize.
+	A, B json.RawMessage
+
+	// HashA and HashB are the hex-encoded SHA-256 hashes of the values,
+	// without the insignificant whitespace.
+	HashA, HashB string
+}
+
+func (m Mismatch) String() string {
+	if m.A == nil || m.B == nil {
+		return fmt.Sprintf("storeutil: %q: hash %s, in B %s", m.Key, m.HashA, m.HashB)
+	}
+	return fmt.Sprintf("storeutil: %q: value %s, in B %s", m.Key, m.A, m.B)
+}
+
+// WithSample restricts the comparison of the values by Diff to a fraction,
+// between 0 and 1, of the keys found in both Stores. The sample is chosen by
+// a hash of the keys, so that it is the same across runs. The keys found in
+// one Store only are all reported.
+func WithSample(fraction float64) Option {
+	return func(o *options) {
+		if fraction >= 0 && fraction < 1 {
+			o.sample = fraction
+		}
+	}
+}
+
+// WithMaxValueSize sets the size, in bytes, above which the values of a
+// Mismatch are reported by their hash only.
+func WithMaxValueSize(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.maxValueSize = n
+		}
+	}
+}
+
+// Diff compares the items of a and b, and reports the keys found in one
+// Store only and the keys whose values differ. The values are compared
+// without their insignificant whitespace. Diff honours WithPrefix,
+// WithConcurrency, WithRate, WithProgress and WithResume; the Copied of the
+// progress is the number of keys compared, and Skipped the number of the
+// others.
+// Err is non-nil in case of failure, or if a or b is not a store.KeyLister.
+func Diff(ctx context.Context, a, b store.Store, opts ...Option) (*Report, error) {
+	o := newOptions(opts)
+	ka, err := o.keys(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	kb, err := o.keys(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu sync.Mutex
+		r  = new(Report)
+		ks []string
+	)
+	for i, j := 0, 0; i < len(ka) || j < len(kb); {
+		switch {
+		case j == len(kb) || (i < len(ka) && ka[i] < kb[j]):
+			r.OnlyInA = append(r.OnlyInA, ka[i])
+			i++
+		case i == len(ka) || kb[j] < ka[i]:
+			r.OnlyInB = append(r.OnlyInB, kb[j])
+			j++
+		default:
+			ks = append(ks, ka[i])
+			i, j = i+1, j+1
+		}
+	}
+
+	_, err = o.run(ctx, ks, func(ctx context.Context, k string) (bool, error) {
+		if !o.sampled(k) {
+			return false, nil
+		}
+		var va, vb json.RawMessage
+		okA, err := a.Get(ctx, k, &va)
+		if err != nil {
+			return false, err
+		}
+		okB, err := b.Get(ctx, k, &vb)
+		if err != nil {
+			return false, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case !okA && !okB:
+			return false, nil
+		case !okA:
+			r.OnlyInB = append(r.OnlyInB, k)
+			return false, nil
+		case !okB:
+			r.OnlyInA = append(r.OnlyInA, k)
+			return false, nil
+		}
+		r.Compared++
+		ca, cb := compact(va), compact(vb)
+		if bytes.Equal(ca, cb) {
+			r.Equal++
+			return true, nil
+		}
+		m := Mismatch{Key: k, HashA: hash(ca), HashB: hash(cb)}
+		if len(va) <= o.maxValueSize && len(vb) <= o.maxValueSize {
+			m.A, m.B = va, vb
+		}
+		r.Mismatches = append(r.Mismatches, m)
+		return true, nil
+	})
+
+	sort.Strings(r.OnlyInA)
+	sort.Strings(r.OnlyInB)
+	sort.Slice(r.Mismatches, func(i, j int) bool { return r.Mismatches[i].Key < r.Mismatches[j].Key })
+	return r, err
+}
+
+// sampled reports whether the values of k are compared.
+func (o *options) sampled(k string) bool {
+	if o.sample < 0 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(k))
+	return float64(h.Sum32()) < o.sample*math.MaxUint32
+}
+
+// compact returns data without the insignificant whitespace, for comparison.
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+/*
+Package storeutil provides bulk operations over whole Stores, for the backend
+migrations and the seeding of environments.
+
+Copy copies every item of a Store to another one:
+
+	p, err := storeutil.Copy(ctx, oldStore, newStore,
+		storeutil.WithConcurrency(16),
+		storeutil.WithRate(500),
+		storeutil.WithProgress(func(p storeutil.Progress) {
+			log.Printf("%d/%d copied, resume at %q", p.Copied, p.Total, p.Checkpoint)
+		}),
+	)
+
+Export dumps the items of a Store as JSON Lines, one Record per line, for
+the backups and the debugging:
+
+	{"key":"user/1","value":{"name":"gopher"},"expires":"2026-01-02T15:04:05Z"}
+
+Import restores such a dump into any Store, in batches if it implements
+store.MultiSetter, with a policy for the keys it already holds:
+
+	p, err := storeutil.Import(ctx, s, f,
+		storeutil.WithConflict(storeutil.ConflictSkip),
+		storeutil.WithBatchSize(500),
+	)
+
+Diff compares two Stores, to validate a migration or a replication:
+
+	r, err := storeutil.Diff(ctx, oldStore, newStore, storeutil.WithSample(0.1))
+	for _, m := range r.Mismatches {
+		log.Print(m)
+	}
+
+Copy, Export and Diff list the keys of their source Stores, which must implement
+store.KeyLister, and walk them in lexical order, so that an interrupted run
+can be resumed from its last Checkpoint with WithResume.
+*/
+package storeutil // import "github.com/gokv/store/storeutil"
@@ -0,0 +1,84 @@
+package storeutil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Record is an item of a JSON Lines dump, as written by Export and read by
+// Import.
+type Record struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+
+	// Expires is the time the key clears, if the Store is a store.TTLStore
+	// and the key expires.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Version, CreatedAt and UpdatedAt are the metadata of the item, if the
+	// Store is a store.MetaGetter. They are informative: Import does not
+	// restore them.
+	Version   string     `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Export writes every item of s to w as JSON Lines, one Record per line, in
+// the lexical order of the keys, and returns the progress. It honours
+// WithPrefix, WithRate, WithProgress and WithResume; the items are read one
+// at a time, whatever WithConcurrency.
+// Err is non-nil in case of failure, or if s is not a store.KeyLister.
+func Export(ctx context.Context, s store.Store, w io.Writer, opts ...Option) (Progress, error) {
+	o := newOptions(opts)
+	o.concurrency = 1
+	ks, err := o.keys(ctx, s)
+	if err != nil {
+		return Progress{Checkpoint: o.resume}, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return o.run(ctx, ks, func(ctx context.Context, k string) (bool, error) {
+		r, ok, err := record(ctx, s, k)
+		if err != nil || !ok {
+			return false, err
+		}
+		return true, enc.Encode(r)
+	})
+}
+
+// record returns the Record of k.
+// Ok is false if the key was not found.
+func record(ctx context.Context, s store.Store, k string) (*Record, bool, error) {
+	r := &Record{Key: k}
+	if ok, err := s.Get(ctx, k, &r.Value); err != nil || !ok {
+		return nil, false, err
+	}
+	if t, ok := s.(store.TTLStore); ok {
+		ttl, ok, err := t.GetTTL(ctx, k)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		if ttl > 0 {
+			expires := time.Now().Add(ttl).UTC()
+			r.Expires = &expires
+		}
+	}
+	if mg, ok := s.(store.MetaGetter); ok {
+		m, ok, err := mg.GetMeta(ctx, k)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		r.Version = m.Version
+		if !m.CreatedAt.IsZero() {
+			r.CreatedAt = &m.CreatedAt
+		}
+		if !m.UpdatedAt.IsZero() {
+			r.UpdatedAt = &m.UpdatedAt
+		}
+	}
+	return r, true, nil
+}
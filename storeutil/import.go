@@ -0,0 +1,150 @@
+package storeutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Import writes to s the Records read from r as JSON Lines, as written by
+// Export, and returns the progress. The records which expired are skipped;
+// the others are given their expiration, if any. The keys already in s are
+// overwritten, unless WithConflict says otherwise.
+//
+// The records without expiration are written WithBatchSize at a time if s
+// implements store.MultiSetter. Import honours WithPrefix, WithRate,
+// WithProgress and WithResume, which skips the records up to the
+// checkpoint in lexical order; the records are read one at a time,
+// whatever WithConcurrency. The Total of the progress is the number of
+// records read so far.
+// Err is non-nil in case of failure, or if a line is not a valid Record.
+func Import(ctx context.Context, s store.Store, r io.Reader, opts ...Option) (Progress, error) {
+	im := &importer{
+		o: newOptions(opts),
+		s: s,
+	}
+	im.p.Checkpoint = im.o.resume
+	if ms, ok := s.(store.MultiSetter); ok && im.o.batchSize > 1 {
+		im.ms = ms
+	}
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return im.p, err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var rec Record
+			if err := json.Unmarshal(b, &rec); err != nil {
+				return im.p, fmt.Errorf("storeutil: line %d: %w", line, err)
+			}
+			if rec.Key == "" {
+				return im.p, fmt.Errorf("storeutil: line %d: missing key", line)
+			}
+			if err := im.add(ctx, &rec); err != nil {
+				return im.p, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return im.p, im.flush(ctx)
+}
+
+// importer holds the state of an Import.
+type importer struct {
+	o  *options
+	s  store.Store
+	ms store.MultiSetter // nil if the writes are not batched
+	p  Progress
+
+	// ks and vs are the pending batch.
+	ks []string
+	vs []json.Marshaler
+}
+
+// add imports rec, or adds it to the pending batch.
+func (im *importer) add(ctx context.Context, rec *Record) error {
+	if !strings.HasPrefix(rec.Key, im.o.prefix) || (im.o.resume != "" && rec.Key <= im.o.resume) {
+		return nil
+	}
+	if err := im.o.limiter.wait(ctx); err != nil {
+		return err
+	}
+	im.p.Total++
+
+	if rec.Expires == nil && im.ms != nil && im.o.conflict == ConflictOverwrite {
+		im.ks = append(im.ks, rec.Key)
+		im.vs = append(im.vs, rec.Value)
+		if len(im.ks) < im.o.batchSize {
+			return nil
+		}
+		return im.flush(ctx)
+	}
+
+	// The pending batch is written first, so that the checkpoint only
+	// moves forward.
+	if err := im.flush(ctx); err != nil {
+		return err
+	}
+	written, err := im.write(ctx, rec)
+	if err != nil {
+		return fmt.Errorf("storeutil: key %q: %w", rec.Key, err)
+	}
+	im.done(rec.Key, written)
+	return nil
+}
+
+// write writes rec to the Store, and reports whether it was written.
+func (im *importer) write(ctx context.Context, rec *Record) (bool, error) {
+	if rec.Expires != nil && !rec.Expires.After(time.Now()) {
+		return false, nil
+	}
+	if ok, err := checkConflict(ctx, im.s, rec.Key, im.o.conflict); err != nil || !ok {
+		return false, err
+	}
+	if rec.Expires == nil {
+		return true, im.s.Set(ctx, rec.Key, rec.Value)
+	}
+	return true, im.s.SetWithDeadline(ctx, rec.Key, rec.Value, *rec.Expires)
+}
+
+// flush writes the pending batch.
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.ks) == 0 {
+		return nil
+	}
+	if err := im.ms.SetMulti(ctx, im.ks, im.vs); err != nil {
+		return fmt.Errorf("storeutil: keys %q to %q: %w", im.ks[0], im.ks[len(im.ks)-1], err)
+	}
+	for _, k := range im.ks {
+		im.done(k, true)
+	}
+	im.ks, im.vs = im.ks[:0], im.vs[:0]
+	return nil
+}
+
+// done records the processing of k.
+func (im *importer) done(k string, written bool) {
+	im.p.Done++
+	if written {
+		im.p.Copied++
+	} else {
+		im.p.Skipped++
+	}
+	if k > im.p.Checkpoint {
+		im.p.Checkpoint = k
+	}
+	if im.o.progress != nil {
+		im.o.progress(im.p)
+	}
+}
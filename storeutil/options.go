@@ -0,0 +1,257 @@
+package storeutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// DefaultConcurrency is the default number of items processed at once.
+const DefaultConcurrency = 8
+
+// DefaultBatchSize is the default number of items Import writes at once,
+// when the destination implements store.MultiSetter.
+const DefaultBatchSize = 100
+
+// Conflict is the policy for the keys already in the destination.
+type Conflict int
+
+const (
+	// ConflictOverwrite overwrites the existing keys. It is the default.
+	ConflictOverwrite Conflict = iota
+
+	// ConflictSkip leaves the existing keys untouched.
+	ConflictSkip
+
+	// ConflictFail stops the operation with an error wrapping
+	// store.ErrConflict.
+	ConflictFail
+)
+
+// Progress is the progress of an operation.
+type Progress struct {
+	// Total is the number of keys to process.
+	Total int
+
+	// Done is the number of keys processed, of which Copied were written and
+	// Skipped were not: they cleared since they were listed, or were
+	// already in the destination with ConflictSkip.
+	Done, Copied, Skipped int
+
+	// Checkpoint is the greatest key such that every key up to it has been
+	// processed. It is given to WithResume to resume an interrupted run.
+	Checkpoint string
+}
+
+// Option configures an operation.
+type Option func(*options)
+
+// WithPrefix restricts the operation to the keys starting with prefix.
+func WithPrefix(prefix string) Option {
+	return func(o *options) { o.prefix = prefix }
+}
+
+// WithConcurrency sets the number of items processed at once.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// WithRate limits the number of items processed per second.
+func WithRate(perSecond float64) Option {
+	return func(o *options) {
+		if perSecond > 0 {
+			o.limiter = &limiter{interval: time.Duration(float64(time.Second) / perSecond)}
+		}
+	}
+}
+
+// WithProgress sets a function called with the progress after every item.
+// The calls are serialized.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) { o.progress = fn }
+}
+
+// WithResume resumes an interrupted run, skipping the keys up to checkpoint.
+func WithResume(checkpoint string) Option {
+	return func(o *options) { o.resume = checkpoint }
+}
+
+// WithSkipExisting makes Copy and Import leave the keys already in the
+// destination untouched, rather than overwrite them. It is a shorthand for
+// WithConflict(ConflictSkip).
+func WithSkipExisting() Option {
+	return WithConflict(ConflictSkip)
+}
+
+// WithConflict sets the policy of Copy and Import for the keys already in
+// the destination.
+func WithConflict(c Conflict) Option {
+	return func(o *options) { o.conflict = c }
+}
+
+// WithBatchSize sets the number of items Import writes at once, when the
+// destination implements store.MultiSetter. A size of 1 disables batching.
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+type options struct {
+	prefix      string
+	concurrency int
+	limiter     *limiter // nil without WithRate
+	progress    func(Progress)
+	resume      string
+	conflict    Conflict
+	batchSize   int
+
+	// sample is the fraction of the keys Diff compares, or -1 for every
+	// key.
+	sample       float64
+	maxValueSize int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		concurrency:  DefaultConcurrency,
+		batchSize:    DefaultBatchSize,
+		sample:       -1,
+		maxValueSize: DefaultMaxValueSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// limiter spaces the items by a fixed interval.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait waits for the turn of the next item, or for ctx to be done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	turn := l.next
+	if turn.Before(now) {
+		turn = now
+	}
+	l.next = turn.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(turn)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// keys returns the keys of s under the prefix and after the checkpoint of
+// o, in lexical order.
+func (o *options) keys(ctx context.Context, s store.Store) ([]string, error) {
+	kl, ok := s.(store.KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("storeutil: Keys: %w", store.ErrNotSupported)
+	}
+	ks, err := kl.Keys(ctx, o.prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ks)
+	if o.resume != "" {
+		i := sort.Search(len(ks), func(i int) bool { return ks[i] > o.resume })
+		ks = ks[i:]
+	}
+	return ks, nil
+}
+
+// run calls fn concurrently with every key of ks, in order, and returns the
+// progress. Fn reports whether it copied the item. Run stops at the first
+// failure.
+func (o *options) run(ctx context.Context, ks []string, fn func(ctx context.Context, k string) (bool, error)) (Progress, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := Progress{Total: len(ks), Checkpoint: o.resume}
+	var (
+		mu    sync.Mutex
+		done  = make([]bool, len(ks))
+		next  int // the index of the first key not done
+		first error
+		wg    sync.WaitGroup
+	)
+	indexes := make(chan int)
+	for w := 0; w < o.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				copied, err := fn(ctx, ks[i])
+
+				mu.Lock()
+				if err != nil {
+					if first == nil {
+						first = fmt.Errorf("storeutil: key %q: %w", ks[i], err)
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				done[i] = true
+				p.Done++
+				if copied {
+					p.Copied++
+				} else {
+					p.Skipped++
+				}
+				for next < len(ks) && done[next] {
+					p.Checkpoint = ks[next]
+					next++
+				}
+				if o.progress != nil {
+					o.progress(p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+	for i := range ks {
+		if err = o.limiter.wait(ctx); err != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if first != nil {
+		return p, first
+	}
+	return p, err
+}
@@ -0,0 +1,290 @@
+package storeutil_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+	"github.com/gokv/store/storeutil"
+)
+
+const n = 20
+
+// key returns the i-th key, in lexical order.
+func key(i int) string { return fmt.Sprintf("k%02d", i) }
+
+// fill returns a memstore holding the values 0 to n-1 at key(0) to
+// key(n-1).
+func fill(t *testing.T) *memstore.Store {
+	t.Helper()
+	m := memstore.New()
+	for i := 0; i < n; i++ {
+		if err := m.Set(context.Background(), key(i), json.RawMessage(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+var errFailed = errors.New("failed")
+
+// failing returns a Fake writing to m, but failing the writes of k.
+func failing(m *memstore.Store, k string) *storetest.Fake {
+	return &storetest.Fake{
+		SetFunc: func(ctx context.Context, key string, v json.Marshaler) error {
+			if key == k {
+				return errFailed
+			}
+			return m.Set(ctx, key, v)
+		},
+	}
+}
+
+func TestCopyResume(t *testing.T) {
+	ctx := context.Background()
+	src, dst := fill(t), memstore.New()
+
+	// The progress is reported with the keys of dst up to its checkpoint.
+	var gaps []string
+	progress := storeutil.WithProgress(func(p storeutil.Progress) {
+		for i := 0; i < n && key(i) <= p.Checkpoint; i++ {
+			if ok, _ := dst.Exists(ctx, key(i)); !ok {
+				gaps = append(gaps, key(i))
+			}
+		}
+	})
+
+	p, err := storeutil.Copy(ctx, src, failing(dst, key(10)), storeutil.WithConcurrency(4), progress)
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Copy: got %v, want %v", err, errFailed)
+	}
+	if p.Checkpoint >= key(10) {
+		t.Fatalf("the checkpoint %q is past the failed key %q", p.Checkpoint, key(10))
+	}
+	if len(gaps) > 0 {
+		t.Errorf("the progress was reported with the keys %q missing up to its checkpoint", gaps)
+	}
+
+	p, err = storeutil.Copy(ctx, src, dst, storeutil.WithResume(p.Checkpoint), progress)
+	if err != nil {
+		t.Fatalf("Copy resumed: %v", err)
+	}
+	if p.Checkpoint != key(n-1) || p.Done != p.Total || p.Total < n-10 {
+		t.Errorf("Copy resumed: got the progress %+v", p)
+	}
+	for i := 0; i < n; i++ {
+		expectValue(t, dst, key(i), fmt.Sprint(i))
+	}
+}
+
+func TestCopyConflict(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range [...]struct {
+		name     string
+		conflict storeutil.Conflict
+		copied   int
+		want     string // the value of the existing key
+		err      error
+	}{
+		{"overwrite", storeutil.ConflictOverwrite, n, "0", nil},
+		{"skip", storeutil.ConflictSkip, n - 1, `"kept"`, nil},
+		{"fail", storeutil.ConflictFail, 0, `"kept"`, store.ErrConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := memstore.New()
+			if err := dst.Set(ctx, key(0), json.RawMessage(`"kept"`)); err != nil {
+				t.Fatal(err)
+			}
+			p, err := storeutil.Copy(ctx, fill(t), dst, storeutil.WithConflict(tc.conflict), storeutil.WithConcurrency(1))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Copy: got %v, want %v", err, tc.err)
+			}
+			if p.Copied != tc.copied {
+				t.Errorf("Copy: got %d items copied, want %d", p.Copied, tc.copied)
+			}
+			expectValue(t, dst, key(0), tc.want)
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t)
+	if err := src.SetWithTimeout(ctx, "expires", json.RawMessage(`{"a": 1}`), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p, err := storeutil.Export(ctx, src, &buf)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if p.Copied != n+1 || strings.Count(buf.String(), "\n") != n+1 {
+		t.Fatalf("Export: got %d items, and the dump:\n%s", p.Copied, &buf)
+	}
+	// An expired record is skipped.
+	buf.WriteString(`{"key":"expired","value":1,"expires":"2000-01-01T00:00:00Z"}` + "\n")
+
+	dst := memstore.New()
+	p, err = storeutil.Import(ctx, dst, &buf, storeutil.WithBatchSize(3))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if p.Copied != n+1 || p.Skipped != 1 {
+		t.Errorf("Import: got the progress %+v", p)
+	}
+	for i := 0; i < n; i++ {
+		expectValue(t, dst, key(i), fmt.Sprint(i))
+	}
+	expectValue(t, dst, "expires", `{"a":1}`)
+	expectValue(t, dst, "expired", "")
+	if ttl, ok, err := dst.GetTTL(ctx, "expires"); err != nil || !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("the expiration was not imported: got %v, %v, %v", ttl, ok, err)
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	ctx := context.Background()
+	dump := `{"key":"a","value":1}` + "\n" + `{"key":"b","value":2}` + "\n"
+	for _, tc := range [...]struct {
+		name     string
+		conflict storeutil.Conflict
+		want     string // the value of the existing key
+		err      error
+	}{
+		{"overwrite", storeutil.ConflictOverwrite, "1", nil},
+		{"skip", storeutil.ConflictSkip, `"kept"`, nil},
+		{"fail", storeutil.ConflictFail, `"kept"`, store.ErrConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := memstore.New()
+			if err := dst.Set(ctx, "a", json.RawMessage(`"kept"`)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := storeutil.Import(ctx, dst, strings.NewReader(dump), storeutil.WithConflict(tc.conflict))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Import: got %v, want %v", err, tc.err)
+			}
+			expectValue(t, dst, "a", tc.want)
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	for _, dump := range []string{
+		`{"key":"a","value":1}` + "\nnot json\n",
+		`{"value":1}`,
+	} {
+		if _, err := storeutil.Import(context.Background(), memstore.New(), strings.NewReader(dump)); err == nil {
+			t.Errorf("Import(%q): got no error", dump)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	a, b := fill(t), fill(t)
+	a.Set(ctx, "onlyA", json.RawMessage(`1`))
+	b.Set(ctx, "onlyB", json.RawMessage(`1`))
+	a.Set(ctx, key(3), json.RawMessage(`{"v": 1}`))
+	b.Set(ctx, key(3), json.RawMessage(`{"v":2}`))
+	// Equal without the whitespace.
+	b.Set(ctx, key(4), json.RawMessage(` 4 `))
+
+	r, err := storeutil.Diff(ctx, a, b)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if len(r.OnlyInA) != 1 || r.OnlyInA[0] != "onlyA" || len(r.OnlyInB) != 1 || r.OnlyInB[0] != "onlyB" {
+		t.Errorf("Diff: got the keys %q only in a and %q only in b", r.OnlyInA, r.OnlyInB)
+	}
+	if r.Compared != n || r.Equal != n-1 || len(r.Mismatches) != 1 {
+		t.Fatalf("Diff: got %d compared, %d equal, the mismatches %v", r.Compared, r.Equal, r.Mismatches)
+	}
+	if m := r.Mismatches[0]; m.Key != key(3) || string(m.B) != `{"v":2}` || m.HashA == m.HashB {
+		t.Errorf("Diff: got the mismatch %+v", m)
+	}
+
+	r, err = storeutil.Diff(ctx, a, b, storeutil.WithMaxValueSize(4))
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if m := r.Mismatches[0]; m.A != nil || m.B != nil || m.HashA == "" {
+		t.Errorf("Diff with a maximum value size: got the mismatch %+v, want the hashes only", m)
+	}
+}
+
+func TestDiffSample(t *testing.T) {
+	ctx := context.Background()
+	a, b := memstore.New(), memstore.New()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		a.Set(ctx, fmt.Sprint(i), json.RawMessage(`1`))
+		b.Set(ctx, fmt.Sprint(i), json.RawMessage(`2`))
+	}
+	a.Set(ctx, "onlyA", json.RawMessage(`1`))
+
+	r, err := storeutil.Diff(ctx, a, b, storeutil.WithSample(0.1))
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if r.Compared == 0 || r.Compared > n/4 || len(r.Mismatches) != r.Compared {
+		t.Errorf("Diff of a tenth: got %d keys compared and %d mismatches out of %d", r.Compared, len(r.Mismatches), n)
+	}
+	if len(r.OnlyInA) != 1 {
+		t.Errorf("Diff of a tenth: got the keys %q only in a, want every one", r.OnlyInA)
+	}
+
+	again, err := storeutil.Diff(ctx, a, b, storeutil.WithSample(0.1))
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if again.Compared != r.Compared || again.Mismatches[0].Key != r.Mismatches[0].Key {
+		t.Errorf("Diff of a tenth: the sample changed across runs")
+	}
+
+	if r, err := storeutil.Diff(ctx, a, b, storeutil.WithSample(0)); err != nil || r.Compared != 0 {
+		t.Errorf("Diff of none: got %d keys compared, %v", r.Compared, err)
+	}
+}
+
+func TestNotKeyLister(t *testing.T) {
+	ctx := context.Background()
+	fake := new(storetest.Fake)
+	if _, err := storeutil.Copy(ctx, fake, memstore.New()); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("Copy: got %v, want %v", err, store.ErrNotSupported)
+	}
+	if _, err := storeutil.Export(ctx, fake, new(bytes.Buffer)); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("Export: got %v, want %v", err, store.ErrNotSupported)
+	}
+	if _, err := storeutil.Diff(ctx, memstore.New(), fake); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("Diff: got %v, want %v", err, store.ErrNotSupported)
+	}
+}
@@ -0,0 +1,107 @@
+package tiered
+
+import (
+	"container/list"
+	"encoding/json"
+	"time"
+)
+
+// entry is an item of the memory tier.
+type entry struct {
+	key      string
+	value    json.RawMessage
+	deadline time.Time // zero if the item does not expire
+	stale    time.Time // zero if the entry is not to be dropped before deadline
+	dirty    bool      // not written to the persistent tier yet
+	version  uint64    // of the last write of the entry
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.deadline.IsZero() && !now.Before(e.deadline)
+}
+
+// droppable reports whether the entry is to be dropped from the memory tier
+// and read again from the persistent one.
+func (e *entry) droppable(now time.Time) bool {
+	if e.dirty {
+		return false
+	}
+	return e.expired(now) || !e.stale.IsZero() && !now.Before(e.stale)
+}
+
+// memory is the bounded memory tier. It is not safe for concurrent use.
+type memory struct {
+	capacity int
+	touch    bool       // whether the reads move the entries to the front
+	order    *list.List // of *entry, the most recent first
+	entries  map[string]*list.Element
+}
+
+func newMemory(capacity int, touch bool) *memory {
+	return &memory{
+		capacity: capacity,
+		touch:    touch,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry of k, dropping it if it is droppable.
+func (m *memory) get(k string, now time.Time) (*entry, bool) {
+	el, ok := m.entries[k]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if e.droppable(now) {
+		m.order.Remove(el)
+		delete(m.entries, k)
+		return nil, false
+	}
+	if m.touch {
+		m.order.MoveToFront(el)
+	}
+	return e, true
+}
+
+// put adds e to the front of the memory tier, replacing the entry of its key,
+// and returns the dirty entries evicted to make room for it.
+func (m *memory) put(e *entry) (evicted []*entry) {
+	if el, ok := m.entries[e.key]; ok {
+		el.Value = e
+		m.order.MoveToFront(el)
+		return nil
+	}
+	m.entries[e.key] = m.order.PushFront(e)
+	for m.order.Len() > m.capacity {
+		el := m.order.Back()
+		old := m.order.Remove(el).(*entry)
+		delete(m.entries, old.key)
+		if old.dirty {
+			evicted = append(evicted, old)
+		}
+	}
+	return evicted
+}
+
+// remove removes the entry of k.
+func (m *memory) remove(k string) (*entry, bool) {
+	el, ok := m.entries[k]
+	if !ok {
+		return nil, false
+	}
+	m.order.Remove(el)
+	delete(m.entries, k)
+	return el.Value.(*entry), true
+}
+
+// dirty returns the dirty entries.
+func (m *memory) dirty() []*entry {
+	var es []*entry
+	for el := m.order.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*entry); e.dirty {
+			es = append(es, e)
+		}
+	}
+	return es
+}
@@ -0,0 +1,552 @@
+/*
+Package tiered provides a Store keeping a bounded hot set of items in memory,
+in front of a persistent Store.
+
+The memory tier holds at most the capacity given with WithCapacity; when it
+is full, the least recently used items are evicted, or the oldest ones with
+FIFO eviction. The items read from the persistent tier are promoted to the
+memory tier, and so are the items written, unless specified otherwise with
+WithPromotion. The promoted items are read again from the persistent tier
+after the ttl given with WithTTL, which bounds the staleness of the items
+written to it by other means, and never after their expiration: it is read
+from the persistent tier when it implements store.TTLStore.
+
+With the WriteThrough policy, the default, the writes go to the persistent
+tier before the memory one. With the WriteBack policy, the writes go to the
+memory tier only, and are flushed to the persistent tier in the background,
+and on Flush, GetAll, Close or Shutdown; the dirty items evicted are kept
+aside until the next flush, and the failed writes are retried at the next
+flush. Delete, and Update when the
+memory tier holds no write of the key, still go to the persistent tier.
+*/
+package tiered // import "github.com/gokv/store/tiered"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// The default settings of the Store.
+const (
+	DefaultCapacity      = 10000
+	DefaultTTL           = time.Minute
+	DefaultFlushInterval = time.Second
+)
+
+// WritePolicy is the behaviour of the writes of a Store.
+type WritePolicy int
+
+// The write policies.
+const (
+	// WriteThrough writes to the persistent tier, then to the memory one.
+	WriteThrough WritePolicy = iota
+
+	// WriteBack writes to the memory tier, and flushes the writes to the
+	// persistent one later.
+	WriteBack
+)
+
+// Promotion selects the items promoted to the memory tier.
+type Promotion int
+
+// The promotion policies.
+const (
+	// PromoteAlways promotes the items read and the items written.
+	PromoteAlways Promotion = iota
+
+	// PromoteOnRead promotes the items read from the persistent tier only.
+	PromoteOnRead
+
+	// PromoteOnWrite promotes the items written only. With the WriteBack
+	// policy, the items written are always promoted.
+	PromoteOnWrite
+)
+
+// Eviction selects the items evicted from the full memory tier.
+type Eviction int
+
+// The eviction policies.
+const (
+	// EvictLRU evicts the least recently used items.
+	EvictLRU Eviction = iota
+
+	// EvictFIFO evicts the least recently written items.
+	EvictFIFO
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithCapacity sets the maximum number of items in the memory tier.
+func WithCapacity(n int) Option {
+	return func(s *Store) { s.capacity = n }
+}
+
+// WithTTL sets the duration after which the promoted items are read again
+// from the persistent tier. A non-positive ttl means that they are only
+// dropped when they expire.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) { s.ttl = ttl }
+}
+
+// WithWritePolicy sets the write policy.
+func WithWritePolicy(p WritePolicy) Option {
+	return func(s *Store) { s.writes = p }
+}
+
+// WithPromotion sets the promotion policy.
+func WithPromotion(p Promotion) Option {
+	return func(s *Store) { s.promotion = p }
+}
+
+// WithEviction sets the eviction policy.
+func WithEviction(e Eviction) Option {
+	return func(s *Store) { s.eviction = e }
+}
+
+// WithFlushInterval sets the interval between two flushes of the WriteBack
+// policy.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *Store) { s.interval = interval }
+}
+
+// Store is a store.Store with a memory tier in front of a persistent one.
+type Store struct {
+	store.Wrapper
+	capacity  int
+	ttl       time.Duration
+	writes    WritePolicy
+	promotion Promotion
+	eviction  Eviction
+	interval  time.Duration
+
+	// flushing is held during the flushes, and by the writes to the
+	// persistent tier of the WriteBack policy.
+	flushing sync.Mutex
+
+	mu       sync.Mutex
+	mem      *memory
+	spilled  map[string]*entry // the dirty entries evicted
+	inflight map[string]entry  // the dirty entries of the flush in progress
+	version  uint64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store with a memory tier in front of persistent.
+func New(persistent store.Store, opts ...Option) *Store {
+	s := &Store{
+		Wrapper:  store.Wrapper{Store: persistent},
+		capacity: DefaultCapacity,
+		ttl:      DefaultTTL,
+		interval: DefaultFlushInterval,
+		spilled:  make(map[string]*entry),
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mem = newMemory(s.capacity, s.eviction == EvictLRU)
+	if s.writes == WriteBack {
+		s.done.Add(1)
+		go s.flushEvery(s.interval)
+	}
+	return s
+}
+
+func (s *Store) flushEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			// A failed flush is retried at the next tick.
+			_ = s.Flush(context.Background())
+		}
+	}
+}
+
+// Flush writes the dirty items of the memory tier to the persistent tier, and
+// returns the first error. The failed writes are retried at the next flush.
+// Err is non-nil in case of failure.
+func (s *Store) Flush(ctx context.Context) error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.mu.Lock()
+	batch := make(map[string]entry, len(s.spilled))
+	for k, e := range s.spilled {
+		batch[k] = *e
+	}
+	s.spilled = make(map[string]*entry)
+	for _, e := range s.mem.dirty() {
+		batch[e.key] = *e
+		e.dirty = false
+	}
+	s.inflight = batch
+	s.mu.Unlock()
+
+	failed, err := s.flush(ctx, batch)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range failed {
+		e := batch[k]
+		if cur, ok := s.mem.entries[k]; ok {
+			if cur := cur.Value.(*entry); cur.version == e.version {
+				cur.dirty = true
+			}
+		} else if _, ok := s.spilled[k]; !ok {
+			s.spilled[k] = &e
+		}
+	}
+	s.inflight = nil
+	return err
+}
+
+// flush writes batch to the persistent tier, and returns the keys whose write
+// failed and the first error.
+func (s *Store) flush(ctx context.Context, batch map[string]entry) ([]string, error) {
+	var (
+		failed []string
+		first  error
+	)
+	fail := func(err error, ks ...string) {
+		failed = append(failed, ks...)
+		if first == nil {
+			first = err
+		}
+	}
+
+	var ks []string
+	var vs []json.Marshaler
+	now := time.Now()
+	for k, e := range batch {
+		switch {
+		case e.expired(now):
+			// The item may have been written before it was set to expire.
+			if _, err := s.Store.Delete(ctx, k); err != nil {
+				fail(err, k)
+			}
+		case !e.deadline.IsZero():
+			if err := s.Store.SetWithDeadline(ctx, k, e.value, e.deadline); err != nil {
+				fail(err, k)
+			}
+		default:
+			ks = append(ks, k)
+			vs = append(vs, e.value)
+		}
+	}
+	if len(ks) == 0 {
+		return failed, first
+	}
+
+	if m, ok := s.Store.(store.MultiSetter); ok {
+		if err := m.SetMulti(ctx, ks, vs); err != nil {
+			fail(err, ks...)
+		}
+		return failed, first
+	}
+	for i, k := range ks {
+		if err := s.Store.Set(ctx, k, vs[i]); err != nil {
+			fail(err, k)
+		}
+	}
+	return failed, first
+}
+
+// lookup returns the entry of k in the memory tier, the spilled entries or
+// the flush in progress. It must be called holding mu.
+func (s *Store) lookup(k string, now time.Time) (entry, bool) {
+	if e, ok := s.mem.get(k, now); ok {
+		return *e, true
+	}
+	if e, ok := s.spilled[k]; ok {
+		return *e, true
+	}
+	e, ok := s.inflight[k]
+	return e, ok
+}
+
+// put adds e to the memory tier, spilling the dirty entries evicted. It
+// must be called holding mu.
+func (s *Store) put(e *entry) {
+	s.version++
+	e.version = s.version
+	delete(s.spilled, e.key)
+	for _, old := range s.mem.put(e) {
+		s.spilled[old.key] = old
+	}
+}
+
+// stale returns the time after which a promoted entry is read again from the
+// persistent tier.
+func (s *Store) stale() time.Time {
+	if s.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(s.ttl)
+}
+
+func (s *Store) promotesReads() bool {
+	return s.promotion != PromoteOnWrite
+}
+
+func (s *Store) promotesWrites() bool {
+	return s.promotion != PromoteOnRead
+}
+
+// Get retrieves a new value by key and unmarshals it to v, from the memory
+// tier if it holds the key, from the persistent tier otherwise.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	e, ok := s.lookup(k, now)
+	s.mu.Unlock()
+	if ok {
+		// A dirty entry hides the value of the persistent tier.
+		if e.expired(now) {
+			return false, nil
+		}
+		return true, v.UnmarshalJSON(e.value)
+	}
+
+	var data json.RawMessage
+	if ok, err := s.Store.Get(ctx, k, &data); err != nil || !ok {
+		return false, err
+	}
+	if s.promotesReads() {
+		promoted := &entry{key: k, value: data, stale: s.stale()}
+		if t, ok := s.Store.(store.TTLStore); ok {
+			if ttl, ok, err := t.GetTTL(ctx, k); err == nil && ok && ttl > 0 {
+				promoted.deadline = time.Now().Add(ttl)
+			}
+		}
+		s.mu.Lock()
+		// The key may have been written meanwhile.
+		if _, ok := s.lookup(k, time.Now()); !ok {
+			s.put(promoted)
+		}
+		s.mu.Unlock()
+	}
+	return true, v.UnmarshalJSON(data)
+}
+
+// GetAll unmarshals to c every item in the persistent tier, after flushing
+// the dirty items with the WriteBack policy.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	if s.writes == WriteBack {
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	if s.writes == WriteBack {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		return k, s.Set(ctx, k, v)
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	k, err := s.Store.Add(ctx, json.RawMessage(data))
+	if err != nil || !s.promotesWrites() {
+		return k, err
+	}
+	s.mu.Lock()
+	s.put(&entry{key: k, value: data, stale: s.stale()})
+	s.mu.Unlock()
+	return k, nil
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if s.writes == WriteBack {
+		s.mu.Lock()
+		s.put(&entry{key: k, value: data, deadline: deadline, dirty: true})
+		s.mu.Unlock()
+		return nil
+	}
+
+	if deadline.IsZero() {
+		err = s.Store.Set(ctx, k, json.RawMessage(data))
+	} else {
+		err = s.Store.SetWithDeadline(ctx, k, json.RawMessage(data), deadline)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || !s.promotesWrites() {
+		// The persistent tier may have been written anyway.
+		s.mem.remove(k)
+		return err
+	}
+	s.put(&entry{key: k, value: data, deadline: deadline, stale: s.stale()})
+	return nil
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	if s.writes == WriteBack {
+		// No flush may mark the entry of k clean meanwhile.
+		s.flushing.Lock()
+		defer s.flushing.Unlock()
+		s.mu.Lock()
+		if e, ok := s.dirtyEntry(k); ok {
+			defer s.mu.Unlock()
+			if e.expired(time.Now()) {
+				return false, nil
+			}
+			s.put(&entry{key: k, value: data, deadline: e.deadline, dirty: true})
+			return true, nil
+		}
+		s.mu.Unlock()
+	}
+
+	ok, err := s.Store.Update(ctx, k, json.RawMessage(data))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || !ok {
+		s.mem.remove(k)
+		return ok, err
+	}
+	// The update keeps the expiration, known to the entry.
+	if e, found := s.mem.get(k, time.Now()); found && !e.dirty {
+		s.put(&entry{key: k, value: data, deadline: e.deadline, stale: e.stale})
+	}
+	return true, nil
+}
+
+// dirtyEntry returns the dirty entry of k, in the memory tier or spilled. It
+// must be called holding mu.
+func (s *Store) dirtyEntry(k string) (*entry, bool) {
+	if el, ok := s.mem.entries[k]; ok {
+		if e := el.Value.(*entry); e.dirty {
+			return e, true
+		}
+	}
+	e, ok := s.spilled[k]
+	return e, ok
+}
+
+// Delete removes a key and its value from both tiers.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if s.writes == WriteBack {
+		s.flushing.Lock()
+		defer s.flushing.Unlock()
+	}
+	s.mu.Lock()
+	dirty, hidden := s.dirtyEntry(k)
+	s.mem.remove(k)
+	delete(s.spilled, k)
+	s.mu.Unlock()
+
+	ok, err := s.Store.Delete(ctx, k)
+
+	s.mu.Lock()
+	// The key may have been promoted by a concurrent read.
+	s.mem.remove(k)
+	s.mu.Unlock()
+	if hidden {
+		// The dirty entry hid the value of the persistent tier.
+		ok = !dirty.expired(time.Now())
+	}
+	return ok, err
+}
+
+// Close flushes the dirty items, then closes the persistent tier.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown flushes the dirty items, then closes the persistent tier. If ctx is
+// done before the flush completes, the remaining writes are lost.
+// Err is non-nil in case of failure, or if ctx is done before the shutdown
+// completes.
+func (s *Store) Shutdown(ctx context.Context) error {
+	var err error
+	if s.writes == WriteBack {
+		close(s.stop)
+		s.done.Wait()
+		err = s.Flush(ctx)
+	}
+	if cerr := closeStore(ctx, s.Store); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeStore shuts s down with ctx if it is a store.Shutdowner, or closes it.
+func closeStore(ctx context.Context, s store.Store) error {
+	if sd, ok := s.(store.Shutdowner); ok {
+		return sd.Shutdown(ctx)
+	}
+	return s.Close()
+}
+
+var (
+	_ store.Store      = (*Store)(nil)
+	_ store.Shutdowner = (*Store)(nil)
+)
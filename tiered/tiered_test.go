@@ -0,0 +1,278 @@
+package tiered_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+	"github.com/gokv/store/tiered"
+)
+
+// newStore returns a Store tiering an empty memstore behind the memory tier.
+func newStore() store.Store {
+	return tiered.New(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+// backedBy returns a Fake reading from and writing to m, not implementing
+// store.MultiSetter, so that the flushes write one key at a time.
+func backedBy(m *memstore.Store) *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc:             m.Get,
+		SetFunc:             m.Set,
+		SetWithDeadlineFunc: m.SetWithDeadline,
+		UpdateFunc:          m.Update,
+		DeleteFunc:          m.Delete,
+	}
+}
+
+// writeBack returns a Store with the WriteBack policy in front of
+// persistent, flushed on Flush only.
+func writeBack(persistent store.Store, opts ...tiered.Option) *tiered.Store {
+	opts = append([]tiered.Option{tiered.WithWritePolicy(tiered.WriteBack), tiered.WithFlushInterval(time.Hour)}, opts...)
+	return tiered.New(persistent, opts...)
+}
+
+func TestWriteBack(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := writeBack(m)
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	expectValue(t, m, "k", "")
+	expectValue(t, s, "k", "1")
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, m, "k", "1")
+}
+
+func TestSpilled(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := writeBack(m, tiered.WithCapacity(1))
+	defer s.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := s.Set(ctx, k, json.RawMessage(`"`+k+`"`)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	// a and b were evicted before they were flushed.
+	expectValue(t, s, "a", `"a"`)
+	expectValue(t, s, "b", `"b"`)
+	if ok, err := s.Update(ctx, "a", json.RawMessage(`"updated"`)); err != nil || !ok {
+		t.Fatalf("Update of a spilled key: %v, %v", ok, err)
+	}
+	if ok, err := s.Delete(ctx, "b"); err != nil || !ok {
+		t.Fatalf("Delete of a spilled key: %v, %v", ok, err)
+	}
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, m, "a", `"updated"`)
+	expectValue(t, m, "b", "")
+	expectValue(t, m, "c", `"c"`)
+}
+
+func TestFailedFlush(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	fake := backedBy(m)
+	errDown := errors.New("down")
+	fake.SetFunc = func(context.Context, string, json.Marshaler) error { return errDown }
+	s := writeBack(fake)
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Flush(ctx); !errors.Is(err, errDown) {
+		t.Fatalf("Flush: got %v, want %v", err, errDown)
+	}
+	expectValue(t, s, "k", "1")
+
+	fake.SetFunc = m.Set
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, m, "k", "1")
+}
+
+func TestWriteDuringFlush(t *testing.T) {
+	for _, tc := range [...]struct {
+		name string
+		err  error // of the write of the first value
+	}{
+		{"written", nil},
+		{"failed", errors.New("down")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := memstore.New()
+			fake := backedBy(m)
+			started, release := make(chan struct{}), make(chan struct{})
+			fake.SetFunc = func(ctx context.Context, k string, v json.Marshaler) error {
+				started <- struct{}{}
+				<-release
+				if tc.err != nil {
+					return tc.err
+				}
+				return m.Set(ctx, k, v)
+			}
+			s := writeBack(fake)
+			defer s.Close()
+
+			if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			flushed := make(chan error)
+			go func() { flushed <- s.Flush(ctx) }()
+			<-started
+
+			// The value being flushed is still read.
+			expectValue(t, s, "k", "1")
+			if err := s.Set(ctx, "k", json.RawMessage(`2`)); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			close(release)
+			if err := <-flushed; err != tc.err {
+				t.Fatalf("Flush: got %v, want %v", err, tc.err)
+			}
+			expectValue(t, s, "k", "2")
+
+			// The second value is still dirty, whatever the outcome of the
+			// write of the first one.
+			fake.SetFunc = m.Set
+			if err := s.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			expectValue(t, m, "k", "2")
+		})
+	}
+}
+
+func TestDeleteDirty(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := writeBack(m)
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if ok, err := s.Delete(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Delete of a dirty key: got %v, %v, want found", ok, err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, s, "k", "")
+	expectValue(t, m, "k", "")
+}
+
+func TestStale(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := tiered.New(m, tiered.WithTTL(50*time.Millisecond))
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := m.Set(ctx, "k", json.RawMessage(`2`)); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, s, "k", "1")
+	time.Sleep(100 * time.Millisecond)
+	expectValue(t, s, "k", "2")
+}
+
+func TestPromotion(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range [...]struct {
+		name          string
+		promotion     tiered.Promotion
+		read, written bool // whether the items read and written are promoted
+	}{
+		{"always", tiered.PromoteAlways, true, true},
+		{"read", tiered.PromoteOnRead, true, false},
+		{"write", tiered.PromoteOnWrite, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := memstore.New()
+			fake := backedBy(m)
+			s := tiered.New(fake, tiered.WithPromotion(tc.promotion))
+			defer s.Close()
+			if err := m.Set(ctx, "read", json.RawMessage(`1`)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Set(ctx, "written", json.RawMessage(`1`)); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			expectValue(t, s, "read", "1")
+
+			fake.Reset()
+			expectValue(t, s, "read", "1")
+			expectValue(t, s, "written", "1")
+			persisted := make(map[string]bool)
+			for _, c := range fake.CallsTo("Get") {
+				persisted[c.Args[0].(string)] = true
+			}
+			if persisted["read"] == tc.read {
+				t.Errorf("the item read was promoted: %v, want %v", !persisted["read"], tc.read)
+			}
+			if persisted["written"] == tc.written {
+				t.Errorf("the item written was promoted: %v, want %v", !persisted["written"], tc.written)
+			}
+		})
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := writeBack(backedBy(m))
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	expectValue(t, m, "k", "1")
+}
@@ -0,0 +1,145 @@
+/*
+Package timeout provides a Store wrapper bounding the duration of the
+operations whose context has no deadline.
+
+Every operation whose context has no deadline is given one, after the
+default timeout of its method, so that a caller forgetting to set a deadline
+can not hang forever on a dead backend. The contexts which already have a
+deadline are passed as is, even if it is later than the default timeout.
+*/
+package timeout // import "github.com/gokv/store/timeout"
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithMethodTimeout sets the default timeout of the given method (e.g. "Get"
+// or "GetAll"), in place of the one of Wrap. A non-positive timeout means no
+// timeout.
+func WithMethodTimeout(method string, timeout time.Duration) Option {
+	return func(s *Store) { s.methods[method] = timeout }
+}
+
+// Store is a store.Store bounding the duration of the operations of the
+// wrapped Store.
+type Store struct {
+	store.Wrapper
+	timeout time.Duration
+	methods map[string]time.Duration
+}
+
+// Wrap returns a Store giving the operations of s the default timeout, if
+// their context has no deadline.
+func Wrap(s store.Store, timeout time.Duration, opts ...Option) *Store {
+	t := &Store{
+		Wrapper: store.Wrapper{Store: s},
+		timeout: timeout,
+		methods: make(map[string]time.Duration),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// context returns ctx, with the default timeout of method if it has no
+// deadline.
+func (s *Store) context(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout, ok := s.methods[method]
+	if !ok {
+		timeout = s.timeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	ctx, cancel := s.context(ctx, "Get")
+	defer cancel()
+	return s.Store.Get(ctx, k, v)
+}
+
+// GetAll unmarshals to c every item in the store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	ctx, cancel := s.context(ctx, "GetAll")
+	defer cancel()
+	return s.Store.GetAll(ctx, c)
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	ctx, cancel := s.context(ctx, "Add")
+	defer cancel()
+	return s.Store.Add(ctx, v)
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	ctx, cancel := s.context(ctx, "Set")
+	defer cancel()
+	return s.Store.Set(ctx, k, v)
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	ctx, cancel := s.context(ctx, "SetWithTimeout")
+	defer cancel()
+	return s.Store.SetWithTimeout(ctx, k, v, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	ctx, cancel := s.context(ctx, "SetWithDeadline")
+	defer cancel()
+	return s.Store.SetWithDeadline(ctx, k, v, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	ctx, cancel := s.context(ctx, "Update")
+	defer cancel()
+	return s.Store.Update(ctx, k, v)
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	ctx, cancel := s.context(ctx, "Delete")
+	defer cancel()
+	return s.Store.Delete(ctx, k)
+}
+
+// Ping returns a non-nil error if the wrapped Store is not healthy.
+func (s *Store) Ping(ctx context.Context) error {
+	ctx, cancel := s.context(ctx, "Ping")
+	defer cancel()
+	return s.Store.Ping(ctx)
+}
+
+var _ store.Store = (*Store)(nil)
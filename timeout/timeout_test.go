@@ -0,0 +1,109 @@
+package timeout_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+	"github.com/gokv/store/timeout"
+)
+
+// newStore returns a Store bounding the operations of an empty memstore
+// with a default timeout.
+func newStore() store.Store {
+	return timeout.Wrap(memstore.New(), time.Second)
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// deadlines returns a Fake recording the deadline of the context of its Get
+// and Set calls to got, the zero time if there is none.
+func deadlines(got *time.Time) *storetest.Fake {
+	record := func(ctx context.Context) {
+		*got, _ = ctx.Deadline()
+	}
+	return &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, _ json.Unmarshaler) (bool, error) {
+			record(ctx)
+			return false, nil
+		},
+		SetFunc: func(ctx context.Context, _ string, _ json.Marshaler) error {
+			record(ctx)
+			return nil
+		},
+	}
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	var got time.Time
+	s := timeout.Wrap(deadlines(&got), time.Minute, timeout.WithMethodTimeout("Set", time.Hour))
+
+	start := time.Now()
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if d := got.Sub(start); d < time.Minute-time.Second || d > time.Minute+time.Second {
+		t.Errorf("Get: got a deadline in %v, want the default timeout of a minute", d)
+	}
+
+	if err := s.Set(context.Background(), "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if d := got.Sub(start); d < time.Hour-time.Second || d > time.Hour+time.Second {
+		t.Errorf("Set: got a deadline in %v, want the timeout of the method of an hour", d)
+	}
+}
+
+func TestDeadlineKept(t *testing.T) {
+	var got time.Time
+	s := timeout.Wrap(deadlines(&got), time.Minute)
+
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var v json.RawMessage
+	if _, err := s.Get(ctx, "k", &v); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !got.Equal(deadline) {
+		t.Errorf("Get: got the deadline %v, want the one of the caller %v", got, deadline)
+	}
+}
+
+func TestNoTimeout(t *testing.T) {
+	var got time.Time
+	s := timeout.Wrap(deadlines(&got), time.Minute, timeout.WithMethodTimeout("Get", 0))
+
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !got.IsZero() {
+		t.Errorf("Get without timeout: got the deadline %v", got)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	f := &storetest.Fake{
+		GetFunc: func(ctx context.Context, _ string, _ json.Unmarshaler) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		},
+	}
+	s := timeout.Wrap(f, 10*time.Millisecond)
+
+	var v json.RawMessage
+	if _, err := s.Get(context.Background(), "k", &v); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get of a hung store: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,23 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// TTLStore defines methods for inspecting and adjusting the expiration of the
+// keys assigned with SetWithTimeout or SetWithDeadline.
+type TTLStore interface {
+
+	// GetTTL returns the time left before the given key clears. A zero
+	// duration means that the key does not expire.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	GetTTL(ctx context.Context, k string) (ttl time.Duration, ok bool, err error)
+
+	// Expire sets the given key to clear after timeout, replacing any
+	// previous expiration. The lifespan starts when this function is called.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	Expire(ctx context.Context, k string, timeout time.Duration) (ok bool, err error)
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Txn defines a method for starting a transaction, for stores able to apply
+// multiple operations atomically.
+type Txn interface {
+
+	// Begin starts a new transaction. The transaction is bound to ctx: if ctx
+	// is cancelled before Commit, the transaction is rolled back.
+	// Err is non-nil in case of failure.
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a transaction in progress. The operations are not visible outside of
+// the transaction until Commit returns successfully. A Tx must be terminated
+// with exactly one call to either Commit or Rollback.
+type Tx interface {
+
+	// Get retrieves a new value by key and unmarshals it to v.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	Get(ctx context.Context, k string, v json.Unmarshaler) (ok bool, err error)
+
+	// Set idempotently assigns the given value to the given key.
+	// Err is non-nil in case of failure.
+	Set(ctx context.Context, k string, v json.Marshaler) error
+
+	// Delete removes a key and its value from the store.
+	// Ok is false if the key was not found.
+	// Err is non-nil in case of failure.
+	Delete(ctx context.Context, k string) (ok bool, err error)
+
+	// Commit atomically applies every operation of the transaction.
+	// Err is non-nil in case of failure, and wraps ErrConflict when the
+	// transaction conflicted with a concurrent one. In that case none of the
+	// operations is applied.
+	Commit() error
+
+	// Rollback discards every operation of the transaction.
+	// Err is non-nil in case of failure.
+	Rollback() error
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Typed adapts a Store to values of type T, taking care of the JSON
+// marshaling. T is encoded with encoding/json, so it can be any type that
+// json.Marshal and json.Unmarshal accept.
+type Typed[T any] struct {
+	s Store
+}
+
+// NewTyped returns a Typed using s as its underlying Store.
+func NewTyped[T any](s Store) Typed[T] {
+	return Typed[T]{s: s}
+}
+
+// Get retrieves the value of the given key.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (t Typed[T]) Get(ctx context.Context, k string) (v T, ok bool, err error) {
+	ok, err = t.s.Get(ctx, k, jsonValue[T]{&v})
+	return v, ok, err
+}
+
+// GetAll returns every value in the store.
+// Err is non-nil in case of failure.
+func (t Typed[T]) GetAll(ctx context.Context) ([]T, error) {
+	var c typedCollection[T]
+	if err := t.s.GetAll(ctx, &c); err != nil {
+		return nil, err
+	}
+	vs := make([]T, len(c))
+	for i := range c {
+		vs[i] = *c[i]
+	}
+	return vs, nil
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (t Typed[T]) Add(ctx context.Context, v T) (k string, err error) {
+	return t.s.Add(ctx, jsonValue[T]{&v})
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (t Typed[T]) Set(ctx context.Context, k string, v T) error {
+	return t.s.Set(ctx, k, jsonValue[T]{&v})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout.
+// Err is non-nil in case of failure.
+func (t Typed[T]) SetWithTimeout(ctx context.Context, k string, v T, timeout time.Duration) error {
+	return t.s.SetWithTimeout(ctx, k, jsonValue[T]{&v}, timeout)
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (t Typed[T]) SetWithDeadline(ctx context.Context, k string, v T, deadline time.Time) error {
+	return t.s.SetWithDeadline(ctx, k, jsonValue[T]{&v}, deadline)
+}
+
+// Update assigns the given value to the given key, if it exists.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (t Typed[T]) Update(ctx context.Context, k string, v T) (ok bool, err error) {
+	return t.s.Update(ctx, k, jsonValue[T]{&v})
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (t Typed[T]) Delete(ctx context.Context, k string) (ok bool, err error) {
+	return t.s.Delete(ctx, k)
+}
+
+// jsonValue implements json.Marshaler and json.Unmarshaler on behalf of the
+// value it points to.
+type jsonValue[T any] struct {
+	v *T
+}
+
+func (j jsonValue[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.v)
+}
+
+func (j jsonValue[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, j.v)
+}
+
+// typedCollection collects values of type T for Typed.GetAll.
+type typedCollection[T any] []*T
+
+func (c *typedCollection[T]) New() json.Unmarshaler {
+	v := new(T)
+	*c = append(*c, v)
+	return jsonValue[T]{v}
+}
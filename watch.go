@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// EventType is the kind of change notified by a Watcher.
+type EventType int
+
+const (
+	// EventSet notifies that a value was assigned to a key.
+	EventSet EventType = iota + 1
+
+	// EventDelete notifies that a key was removed from the store, either
+	// explicitly or because it expired.
+	EventDelete
+)
+
+// Event describes a change to a key.
+type Event struct {
+	Type EventType
+	Key  string
+
+	// Value holds the JSON encoding of the new value for EventSet. It is nil
+	// for EventDelete, and it may be nil for EventSet if the implementation
+	// cannot provide the value along with the notification.
+	Value json.RawMessage
+}
+
+// Watcher defines methods for receiving change notifications.
+type Watcher interface {
+
+	// Watch notifies the changes to the given key on the returned channel.
+	// The channel is closed when ctx is done or when the Watcher can no
+	// longer deliver events; in the latter case the consumer is expected to
+	// re-read the key and watch again.
+	// Err is non-nil in case of failure.
+	Watch(ctx context.Context, k string) (<-chan Event, error)
+
+	// WatchPrefix notifies the changes to every key starting with prefix on
+	// the returned channel. The channel follows the same rules as in Watch.
+	// Err is non-nil in case of failure.
+	WatchPrefix(ctx context.Context, prefix string) (<-chan Event, error)
+}
@@ -0,0 +1,370 @@
+/*
+Package writebehind provides a Store wrapper acknowledging the writes
+immediately and flushing them to the wrapped Store asynchronously.
+
+Set, SetWithTimeout and SetWithDeadline buffer the value in memory; the
+buffered writes of a key are coalesced, so that only the last one is
+flushed. The buffer is flushed periodically in batches, with SetMulti when
+the wrapped Store implements store.MultiSetter, and on Close or Shutdown.
+When the buffer holds the maximum number of keys, the writes wait for the
+next flush, which is started right away.
+
+Get reads the buffered values first. Update and Delete apply to the buffered
+value if any, and otherwise wait for the flush in progress, so that the
+writes of a key are applied in order. GetAll flushes the buffer first.
+
+The failed writes are not retried: they are reported to the error handler,
+and lost. Wrap the Store with a retrying wrapper to retry them.
+*/
+package writebehind // import "github.com/gokv/store/writebehind"
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gokv/store"
+)
+
+// The default settings of the Store.
+const (
+	DefaultFlushInterval = time.Second
+	DefaultMaxPending    = 1000
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithFlushInterval sets the interval between two flushes of the buffer.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *Store) { s.interval = interval }
+}
+
+// WithMaxPending sets the maximum number of keys in the buffer.
+func WithMaxPending(n int) Option {
+	return func(s *Store) { s.max = n }
+}
+
+// WithErrorHandler sets the function called with the keys and the error of
+// every failed write of a flush. The handler must not call the Store.
+func WithErrorHandler(fn func(ks []string, err error)) Option {
+	return func(s *Store) { s.onError = fn }
+}
+
+// write is a buffered write.
+type write struct {
+	value    json.RawMessage
+	deadline time.Time // zero if the key does not expire
+}
+
+func (w write) expired(now time.Time) bool {
+	return !w.deadline.IsZero() && !now.Before(w.deadline)
+}
+
+// Store is a store.Store buffering the writes of the wrapped Store.
+type Store struct {
+	store.Wrapper
+	interval time.Duration
+	max      int
+	onError  func(ks []string, err error)
+
+	// flushing is held during the flushes.
+	flushing sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[string]write
+	inflight map[string]write // the writes of the flush in progress
+	drained  chan struct{}    // closed when the pending writes are taken
+
+	kick chan struct{}
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store buffering the writes to s, and flushing them in the
+// background.
+func New(s store.Store, opts ...Option) *Store {
+	w := &Store{
+		Wrapper:  store.Wrapper{Store: s},
+		interval: DefaultFlushInterval,
+		max:      DefaultMaxPending,
+		onError:  func([]string, error) {},
+		pending:  make(map[string]write),
+		drained:  make(chan struct{}),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.done.Add(1)
+	go w.flushEvery(w.interval)
+	return w
+}
+
+func (s *Store) flushEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		case <-s.kick:
+		}
+		// The failures are reported to the error handler.
+		_ = s.Flush(context.Background())
+	}
+}
+
+// Flush writes the buffered values to the wrapped Store, and returns the
+// first error.
+// Err is non-nil in case of failure.
+func (s *Store) Flush(ctx context.Context) error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = make(map[string]write)
+	s.inflight = batch
+	close(s.drained)
+	s.drained = make(chan struct{})
+	s.mu.Unlock()
+
+	err := s.flush(ctx, batch)
+
+	s.mu.Lock()
+	s.inflight = nil
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Store) flush(ctx context.Context, batch map[string]write) error {
+	var first error
+	fail := func(ks []string, err error) {
+		s.onError(ks, err)
+		if first == nil {
+			first = err
+		}
+	}
+
+	var ks []string
+	var vs []json.Marshaler
+	for k, w := range batch {
+		if !w.deadline.IsZero() {
+			if err := s.Store.SetWithDeadline(ctx, k, w.value, w.deadline); err != nil {
+				fail([]string{k}, err)
+			}
+			continue
+		}
+		ks = append(ks, k)
+		vs = append(vs, w.value)
+	}
+	if len(ks) == 0 {
+		return first
+	}
+
+	if m, ok := s.Store.(store.MultiSetter); ok {
+		if err := m.SetMulti(ctx, ks, vs); err != nil {
+			fail(ks, err)
+		}
+		return first
+	}
+	for i, k := range ks {
+		if err := s.Store.Set(ctx, k, vs[i]); err != nil {
+			fail([]string{k}, err)
+		}
+	}
+	return first
+}
+
+// buffer adds a write of k to the buffer, waiting for a flush if it is full.
+func (s *Store) buffer(ctx context.Context, k string, w write) error {
+	for {
+		s.mu.Lock()
+		if _, ok := s.pending[k]; ok || len(s.pending) < s.max {
+			s.pending[k] = w
+			s.mu.Unlock()
+			return nil
+		}
+		drained := s.drained
+		s.mu.Unlock()
+
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-drained:
+		}
+	}
+}
+
+// take removes the buffered write of k, if any, and waits for the flush in
+// progress if it writes k.
+func (s *Store) take(k string) (write, bool) {
+	s.mu.Lock()
+	w, ok := s.pending[k]
+	delete(s.pending, k)
+	_, inflight := s.inflight[k]
+	s.mu.Unlock()
+	if inflight {
+		s.waitFlush()
+	}
+	return w, ok
+}
+
+// waitFlush waits for the flush in progress, if any.
+func (s *Store) waitFlush() {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+}
+
+// Get retrieves a new value by key and unmarshals it to v, from the buffer
+// if it holds the key, from the wrapped Store otherwise.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	w, ok := s.pending[k]
+	if !ok {
+		w, ok = s.inflight[k]
+	}
+	s.mu.Unlock()
+	if !ok {
+		return s.Store.Get(ctx, k, v)
+	}
+	// A buffered write hides the value of the wrapped Store.
+	if w.expired(time.Now()) {
+		return false, nil
+	}
+	return true, v.UnmarshalJSON(w.value)
+}
+
+// GetAll flushes the buffer, then unmarshals to c every item in the wrapped
+// Store.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+	return s.Store.GetAll(ctx, c)
+}
+
+// Set idempotently assigns the given value to the given key, in the buffer.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting, in the buffer. The assigned key will clear after timeout. The
+// lifespan starts when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting, in the buffer. The assigned key will clear after deadline.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.buffer(ctx, k, write{value: data, deadline: deadline})
+}
+
+// Update assigns the given value to the given key, if it exists. A buffered
+// write is updated in the buffer.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	w, ok := s.pending[k]
+	if ok {
+		if w.expired(time.Now()) {
+			s.mu.Unlock()
+			return false, nil
+		}
+		w.value = data
+		s.pending[k] = w
+		s.mu.Unlock()
+		return true, nil
+	}
+	_, inflight := s.inflight[k]
+	s.mu.Unlock()
+	if inflight {
+		s.waitFlush()
+	}
+	return s.Store.Update(ctx, k, json.RawMessage(data))
+}
+
+// Delete removes a key and its value from the buffer and from the wrapped
+// Store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	w, buffered := s.take(k)
+	ok, err := s.Store.Delete(ctx, k)
+	if buffered {
+		// The buffered write hid the value of the wrapped Store.
+		ok = !w.expired(time.Now())
+	}
+	return ok, err
+}
+
+// Close flushes the buffer, then closes the wrapped Store.
+// Err is non-nil in case of failure.
+func (s *Store) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown flushes the buffer, then closes the wrapped Store. If ctx is done
+// before the flush completes, the remaining writes are lost.
+// Err is non-nil in case of failure, or if ctx is done before the shutdown
+// completes.
+func (s *Store) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	s.done.Wait()
+	err := s.Flush(ctx)
+	if cerr := closeStore(ctx, s.Store); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeStore shuts s down with ctx if it is a store.Shutdowner, or closes it.
+func closeStore(ctx context.Context, s store.Store) error {
+	if sd, ok := s.(store.Shutdowner); ok {
+		return sd.Shutdown(ctx)
+	}
+	return s.Close()
+}
+
+var (
+	_ store.Store      = (*Store)(nil)
+	_ store.Shutdowner = (*Store)(nil)
+)
@@ -0,0 +1,218 @@
+package writebehind_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gokv/store"
+	"github.com/gokv/store/memstore"
+	"github.com/gokv/store/storetest"
+	"github.com/gokv/store/writebehind"
+)
+
+// newStore returns a Store writing behind to an empty memstore.
+func newStore() store.Store {
+	return writebehind.New(memstore.New())
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore) }
+
+// expectValue checks the value of k in s, or that it is not found if want is
+// empty.
+func expectValue(t *testing.T, s store.Store, k, want string) {
+	t.Helper()
+	var v json.RawMessage
+	ok, err := s.Get(context.Background(), k, &v)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", k, err)
+	}
+	if want == "" {
+		if ok {
+			t.Errorf("Get(%q): got %s, want not found", k, v)
+		}
+		return
+	}
+	if !ok || string(v) != want {
+		t.Errorf("Get(%q): got %s, %v, want %s", k, v, ok, want)
+	}
+}
+
+// backedBy returns a Fake reading from and writing to m, not implementing
+// store.MultiSetter, so that the flushes write one key at a time.
+func backedBy(m *memstore.Store) *storetest.Fake {
+	return &storetest.Fake{
+		GetFunc:             m.Get,
+		SetFunc:             m.Set,
+		SetWithDeadlineFunc: m.SetWithDeadline,
+		UpdateFunc:          m.Update,
+		DeleteFunc:          m.Delete,
+	}
+}
+
+// newManual returns a Store writing behind to s, flushed on Flush only.
+func newManual(s store.Store, opts ...writebehind.Option) *writebehind.Store {
+	return writebehind.New(s, append([]writebehind.Option{writebehind.WithFlushInterval(time.Hour)}, opts...)...)
+}
+
+func TestCoalesced(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	fake := backedBy(m)
+	s := newManual(fake)
+	defer s.Close()
+
+	for _, v := range []string{"1", "2", "3"} {
+		if err := s.Set(ctx, "k", json.RawMessage(v)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	expectValue(t, m, "k", "")
+	expectValue(t, s, "k", "3")
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := len(fake.CallsTo("Set")); got != 1 {
+		t.Errorf("got %d writes of the key, want 1", got)
+	}
+	expectValue(t, m, "k", "3")
+}
+
+func TestMaxPending(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := newManual(m, writebehind.WithMaxPending(2))
+	defer s.Close()
+
+	for _, k := range []string{"a", "b"} {
+		if err := s.Set(ctx, k, json.RawMessage(`1`)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	// A buffered key is overwritten without waiting.
+	if err := s.Set(ctx, "a", json.RawMessage(`2`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := s.Set(tctx, "c", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set with a full buffer: %v", err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, m, "a", "2")
+	expectValue(t, m, "b", "1")
+	expectValue(t, m, "c", "1")
+}
+
+func TestFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("down")
+	fake := &storetest.Fake{
+		SetFunc: func(context.Context, string, json.Marshaler) error { return errDown },
+	}
+	var failed []string
+	s := newManual(fake, writebehind.WithErrorHandler(func(ks []string, err error) {
+		if err == errDown {
+			failed = append(failed, ks...)
+		}
+	}))
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Flush(ctx); err != errDown {
+		t.Fatalf("Flush: got %v, want %v", err, errDown)
+	}
+	if len(failed) != 1 || failed[0] != "k" {
+		t.Errorf("the error handler got the keys %q, want k", failed)
+	}
+	// The failed write is lost.
+	expectValue(t, s, "k", "")
+}
+
+func TestDeleteBuffered(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := newManual(m)
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if ok, err := s.Delete(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Delete of a buffered key: got %v, %v, want found", ok, err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	expectValue(t, m, "k", "")
+}
+
+func TestUpdateDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	fake := backedBy(m)
+	started, release := make(chan struct{}), make(chan struct{})
+	fake.SetFunc = func(ctx context.Context, k string, v json.Marshaler) error {
+		close(started)
+		<-release
+		return m.Set(ctx, k, v)
+	}
+	s := newManual(fake)
+	defer s.Close()
+
+	if err := s.Set(ctx, "k", json.RawMessage(`1`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	flushed := make(chan error)
+	go func() { flushed <- s.Flush(ctx) }()
+	<-started
+
+	// The key being flushed is still read.
+	expectValue(t, s, "k", "1")
+	updated := make(chan bool)
+	go func() {
+		ok, _ := s.Update(ctx, "k", json.RawMessage(`2`))
+		updated <- ok
+	}()
+	select {
+	case <-updated:
+		t.Fatal("Update did not wait for the flush of the key")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-flushed; err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if ok := <-updated; !ok {
+		t.Fatal("Update after the flush: got not found")
+	}
+	expectValue(t, m, "k", "2")
+}
+
+func TestCloseFlushes(t *testing.T) {
+	ctx := context.Background()
+	m := memstore.New()
+	s := newManual(backedBy(m))
+	if err := s.SetWithTimeout(ctx, "k", json.RawMessage(`1`), time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	expectValue(t, m, "k", "1")
+	if ttl, ok, err := m.GetTTL(ctx, "k"); err != nil || !ok || ttl <= 0 {
+		t.Errorf("the expiration was not flushed: %v, %v, %v", ttl, ok, err)
+	}
+}
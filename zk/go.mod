@@ -0,0 +1,8 @@
+module github.com/gokv/store/zk
+
+go 1.26.7
+
+require (
+	github.com/go-zookeeper/zk v1.0.4
+	github.com/gokv/store v0.1.0
+)
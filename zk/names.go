@@ -0,0 +1,70 @@
+package zk
+
+import (
+	"errors"
+	"strings"
+)
+
+// nodeName returns the name of the znode holding the value of k: 'k'
+// followed by the key, whose bytes other than the ASCII letters, the digits,
+// '-' and '_' are percent-encoded with uppercase hexadecimal digits. The
+// names are thus valid znode names, even for the empty key.
+func nodeName(k string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(k) + 1)
+	b.WriteByte('k')
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xF])
+	}
+	return b.String()
+}
+
+var errName = errors.New("zk: not a value znode")
+
+// keyOf returns the key held in the znode with the given name.
+func keyOf(name string) (string, error) {
+	if !strings.HasPrefix(name, "k") {
+		return "", errName
+	}
+	var b strings.Builder
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", errName
+		}
+		hi, lo := unhex(name[i+1]), unhex(name[i+2])
+		if hi < 0 || lo < 0 {
+			return "", errName
+		}
+		b.WriteByte(byte(hi<<4 | lo))
+		i += 2
+	}
+	// Only the names produced by nodeName are value znodes.
+	k := b.String()
+	if nodeName(k) != name {
+		return "", errName
+	}
+	return k, nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -0,0 +1,585 @@
+/*
+Package zk implements store.Store on top of ZooKeeper, with the go-zookeeper
+client.
+
+Each key is held by a child znode of a root znode, whose data is the deadline
+of the key as a big-endian int64 of nanoseconds since the Unix epoch, zero if
+the key does not expire, followed by the JSON encoding of the value. The
+znode names are the percent-encoded keys; see nodeName.
+
+The keys set with a timeout or a deadline are held by ephemeral znodes, so
+that they never outlive the session which set them. The expired keys are
+hidden on read, and a sweep goroutine deletes them periodically. Update and
+CompareAndSet are writes conditioned on the version of the znode read, and
+keep the expiration of the key.
+
+The client calls do not take a context: the context is only checked before
+each call.
+*/
+package zk // import "github.com/gokv/store/zk"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/gokv/store"
+)
+
+// DefaultSweepInterval is the interval between two sweeps of the expired
+// keys, unless specified otherwise with NewWithSweepInterval.
+const DefaultSweepInterval = time.Minute
+
+var errMalformed = errors.New("zk: malformed znode data")
+
+// Store is a store.Store backed by the children of a znode.
+type Store struct {
+	c    *zk.Conn
+	root string
+	acl  []zk.ACL
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// New returns a Store keeping the items in the children of the root znode,
+// an absolute path other than "/", which is created with its parents if
+// missing. The znodes are created with the world ACL.
+func New(c *zk.Conn, root string) (*Store, error) {
+	return NewWithSweepInterval(c, root, DefaultSweepInterval)
+}
+
+// NewWithSweepInterval is like New, with a custom interval between two sweeps
+// of the expired keys.
+func NewWithSweepInterval(c *zk.Conn, root string, interval time.Duration) (*Store, error) {
+	s := &Store{
+		c:    c,
+		root: strings.TrimSuffix(root, "/"),
+		acl:  zk.WorldACL(zk.PermAll),
+		stop: make(chan struct{}),
+	}
+	if err := s.createRoot(); err != nil {
+		return nil, err
+	}
+	s.done.Add(1)
+	go s.sweepEvery(interval)
+	return s, nil
+}
+
+// createRoot creates the missing znodes of the root path.
+func (s *Store) createRoot() error {
+	var p string
+	for _, name := range strings.Split(strings.TrimPrefix(s.root, "/"), "/") {
+		p += "/" + name
+		_, err := s.c.Create(p, nil, 0, s.acl)
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-t.C:
+			// A failed sweep is retried at the next tick.
+			_ = s.sweep(now)
+		}
+	}
+}
+
+// sweep deletes every key expired at now. The deletions are conditioned on
+// the version read, so that the keys set meanwhile are kept.
+func (s *Store) sweep(now time.Time) error {
+	names, _, err := s.c.Children(s.root)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		p := s.root + "/" + name
+		r, st, err := s.read(p)
+		if err != nil {
+			return err
+		}
+		if r == nil || r.live(now) {
+			continue
+		}
+		if err := s.c.Delete(p, st.Version); err != nil && !retryable(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the path of the znode of k.
+func (s *Store) path(k string) string {
+	return s.root + "/" + nodeName(k)
+}
+
+// record is the content of a znode.
+type record struct {
+	deadline int64 // zero if the key does not expire
+	value    []byte
+}
+
+func (r *record) live(now time.Time) bool {
+	return r.deadline == 0 || now.UnixNano() < r.deadline
+}
+
+func encode(deadline int64, value []byte) []byte {
+	b := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(b, uint64(deadline))
+	copy(b[8:], value)
+	return b
+}
+
+func deadlineOf(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// read returns the record and the stat of the znode at p, or nil if it does
+// not exist. The expired records are returned too.
+func (s *Store) read(p string) (*record, *zk.Stat, error) {
+	b, st, err := s.c.Get(p)
+	if err == zk.ErrNoNode {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) < 8 {
+		return nil, nil, errMalformed
+	}
+	return &record{deadline: int64(binary.BigEndian.Uint64(b)), value: b[8:]}, st, nil
+}
+
+// get returns the record and the stat of the znode of k, or nil if it was
+// not found or expired.
+func (s *Store) get(ctx context.Context, k string) (*record, *zk.Stat, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	r, st, err := s.read(s.path(k))
+	if err != nil || r == nil || !r.live(time.Now()) {
+		return nil, nil, err
+	}
+	return r, st, nil
+}
+
+// retryable reports whether err is a conflict with a concurrent write.
+func retryable(err error) bool {
+	return err == zk.ErrNoNode || err == zk.ErrNodeExists || err == zk.ErrBadVersion
+}
+
+// write replaces the znode at p, whose stat is st or nil if it does not
+// exist, with an ephemeral or persistent one holding data. As a znode can
+// not change its mode nor its owner, the other ones are recreated in a
+// transaction conditioned on their version.
+func (s *Store) write(p string, data []byte, ephemeral bool, st *zk.Stat) error {
+	var flags int32
+	var owner int64
+	if ephemeral {
+		flags, owner = zk.FlagEphemeral, s.c.SessionID()
+	}
+	if st == nil {
+		_, err := s.c.Create(p, data, flags, s.acl)
+		return err
+	}
+	if st.EphemeralOwner == owner {
+		_, err := s.c.Set(p, data, st.Version)
+		return err
+	}
+	res, err := s.c.Multi(
+		&zk.DeleteRequest{Path: p, Version: st.Version},
+		&zk.CreateRequest{Path: p, Data: data, Acl: s.acl, Flags: flags},
+	)
+	for _, r := range res {
+		if r.Error != nil {
+			return r.Error
+		}
+	}
+	return err
+}
+
+// put writes data to the znode at p, ephemeral or not, retrying on
+// concurrent writes.
+func (s *Store) put(ctx context.Context, p string, data []byte, ephemeral bool) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, st, err := s.c.Exists(p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			st = nil
+		}
+		if err := s.write(p, data, ephemeral, st); !retryable(err) {
+			return err
+		}
+	}
+}
+
+// Get retrieves a new value by key and unmarshals it to v.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Get(ctx context.Context, k string, v json.Unmarshaler) (bool, error) {
+	r, _, err := s.get(ctx, k)
+	if err != nil || r == nil {
+		return false, err
+	}
+	return true, v.UnmarshalJSON(r.value)
+}
+
+// GetAll unmarshals to c every item in the store, ordered by key.
+// Err is non-nil in case of failure.
+func (s *Store) GetAll(ctx context.Context, c store.Collection) error {
+	return s.GetPage(ctx, c, 0, -1)
+}
+
+// GetPage unmarshals to c at most limit items, skipping the first offset
+// ones. The items are ordered by key. A negative limit means no limit.
+// Err is non-nil in case of failure.
+func (s *Store) GetPage(ctx context.Context, c store.Collection, offset, limit int) error {
+	return s.scan(ctx, "", func(_ string, r *record) (bool, error) {
+		if limit == 0 {
+			return false, nil
+		}
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+		limit--
+		return true, c.New().UnmarshalJSON(r.value)
+	})
+}
+
+// keys returns the keys of the children of the root starting with prefix,
+// in ascending order, including the expired ones.
+func (s *Store) keys(prefix string) ([]string, error) {
+	names, _, err := s.c.Children(s.root)
+	if err != nil {
+		return nil, err
+	}
+	ks := make([]string, 0, len(names))
+	for _, name := range names {
+		k, err := keyOf(name)
+		if err != nil || !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks, nil
+}
+
+// scan calls fn with every key starting with prefix and its record, in
+// ascending key order, until fn returns false.
+func (s *Store) scan(ctx context.Context, prefix string, fn func(k string, r *record) (bool, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ks, err := s.keys(prefix)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, k := range ks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, _, err := s.read(s.path(k))
+		if err != nil {
+			return err
+		}
+		if r == nil || !r.live(now) {
+			continue
+		}
+		if more, err := fn(k, r); err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add assigns the given value to a new key, and returns the key.
+// Err is non-nil in case of failure.
+func (s *Store) Add(ctx context.Context, v json.Marshaler) (string, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		k := hex.EncodeToString(b)
+		_, err := s.c.Create(s.path(k), encode(0, value), 0, s.acl)
+		if err == zk.ErrNodeExists {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return k, nil
+	}
+}
+
+// Set idempotently assigns the given value to the given key.
+// Err is non-nil in case of failure.
+func (s *Store) Set(ctx context.Context, k string, v json.Marshaler) error {
+	return s.SetWithDeadline(ctx, k, v, time.Time{})
+}
+
+// SetWithTimeout assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after timeout. The lifespan starts
+// when this function is called.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithTimeout(ctx context.Context, k string, v json.Marshaler, timeout time.Duration) error {
+	return s.SetWithDeadline(ctx, k, v, time.Now().Add(timeout))
+}
+
+// SetWithDeadline assigns the given value to the given key, possibly
+// overwriting. The assigned key will clear after deadline, or at the end of
+// the session if it comes first.
+// Err is non-nil in case of failure.
+func (s *Store) SetWithDeadline(ctx context.Context, k string, v json.Marshaler, deadline time.Time) error {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p := s.path(k)
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		if err := s.c.Delete(p, -1); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+		return nil
+	}
+	return s.put(ctx, p, encode(deadlineOf(deadline), value), !deadline.IsZero())
+}
+
+// set assigns value to the given key if cond holds for its current value,
+// keeping its expiration. The write is conditioned on the version of the
+// znode read, and retried on concurrent writes.
+func (s *Store) set(ctx context.Context, k string, value []byte, cond func(current []byte) bool) (bool, error) {
+	for {
+		r, st, err := s.get(ctx, k)
+		if err != nil || r == nil || !cond(r.value) {
+			return false, err
+		}
+		_, err = s.c.Set(s.path(k), encode(r.deadline, value), st.Version)
+		if retryable(err) {
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+// Update assigns the given value to the given key, if it exists. The
+// expiration of the key is kept.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Update(ctx context.Context, k string, v json.Marshaler) (bool, error) {
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.set(ctx, k, value, func([]byte) bool { return true })
+}
+
+// Delete removes a key and its value from the store.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Delete(ctx context.Context, k string) (bool, error) {
+	p := s.path(k)
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		r, st, err := s.read(p)
+		if err != nil || r == nil {
+			return false, err
+		}
+		// The expired znodes are deleted too, but not reported.
+		err = s.c.Delete(p, st.Version)
+		if err == zk.ErrBadVersion {
+			continue
+		}
+		if err == zk.ErrNoNode {
+			return false, nil
+		}
+		return err == nil && r.live(time.Now()), err
+	}
+}
+
+// Ping returns a non-nil error if ZooKeeper can not be reached.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, _, err := s.c.Exists(s.root)
+	return err
+}
+
+// Close stops the sweep goroutine. The connection is owned by the caller.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.done.Wait()
+	return nil
+}
+
+// Exists reports whether the given key is in the store.
+// Err is non-nil in case of failure.
+func (s *Store) Exists(ctx context.Context, k string) (bool, error) {
+	r, _, err := s.get(ctx, k)
+	return r != nil, err
+}
+
+// Keys returns every key starting with prefix, in ascending order.
+// Err is non-nil in case of failure.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ks := []string{}
+	err := s.scan(ctx, prefix, func(k string, _ *record) (bool, error) {
+		ks = append(ks, k)
+		return true, nil
+	})
+	return ks, err
+}
+
+// Clear removes every key and value from the store.
+// Err is non-nil in case of failure.
+func (s *Store) Clear(ctx context.Context) error {
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix removes every key starting with prefix, and its value.
+// Err is non-nil in case of failure.
+func (s *Store) ClearPrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ks, err := s.keys(prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.c.Delete(s.path(k), -1); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMeta retrieves the metadata of the given key. The version is the zxid
+// of the last change of the znode, which keeps increasing when the znode is
+// recreated.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetMeta(ctx context.Context, k string) (store.Meta, bool, error) {
+	r, st, err := s.get(ctx, k)
+	if err != nil || r == nil {
+		return store.Meta{}, false, err
+	}
+	return store.Meta{
+		Version:   strconv.FormatInt(st.Mzxid, 10),
+		CreatedAt: time.UnixMilli(st.Ctime),
+		UpdatedAt: time.UnixMilli(st.Mtime),
+	}, true, nil
+}
+
+// GetTTL returns the time left before the given key clears. A zero
+// duration means that the key does not expire.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) GetTTL(ctx context.Context, k string) (time.Duration, bool, error) {
+	r, _, err := s.get(ctx, k)
+	if err != nil || r == nil {
+		return 0, false, err
+	}
+	if r.deadline == 0 {
+		return 0, true, nil
+	}
+	return time.Until(time.Unix(0, r.deadline)), true, nil
+}
+
+// Expire sets the given key to clear after timeout, replacing any previous
+// expiration. The lifespan starts when this function is called. The znode
+// becomes an ephemeral one of the session.
+// Ok is false if the key was not found.
+// Err is non-nil in case of failure.
+func (s *Store) Expire(ctx context.Context, k string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	p := s.path(k)
+	for {
+		r, st, err := s.get(ctx, k)
+		if err != nil || r == nil {
+			return false, err
+		}
+		if timeout <= 0 {
+			err = s.c.Delete(p, st.Version)
+		} else {
+			err = s.write(p, encode(deadline.UnixNano(), r.value), true, st)
+		}
+		if retryable(err) {
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+// CompareAndSet assigns v to the given key only if the JSON encoding of its
+// current value is equal to the one of old. The expiration of the key is
+// kept.
+// Ok is false if the key was not found or if its value was not old.
+// Err is non-nil in case of failure.
+func (s *Store) CompareAndSet(ctx context.Context, k string, old, v json.Marshaler) (bool, error) {
+	o, err := old.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	value, err := v.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	return s.set(ctx, k, value, func(current []byte) bool { return string(current) == string(o) })
+}
+
+var (
+	_ store.Store            = (*Store)(nil)
+	_ store.Pager            = (*Store)(nil)
+	_ store.Exister          = (*Store)(nil)
+	_ store.KeyLister        = (*Store)(nil)
+	_ store.Clearer          = (*Store)(nil)
+	_ store.MetaGetter       = (*Store)(nil)
+	_ store.TTLStore         = (*Store)(nil)
+	_ store.CompareAndSetter = (*Store)(nil)
+)
@@ -0,0 +1,46 @@
+package zk_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gozk "github.com/go-zookeeper/zk"
+	"github.com/gokv/store"
+	"github.com/gokv/store/storetest"
+	"github.com/gokv/store/zk"
+)
+
+// newStore returns a function connecting an empty Store to the ensemble of
+// the comma-separated servers of the GOKV_ZK_SERVERS environment variable,
+// under the /gokv-test znode, which is cleared every time. The test is
+// skipped if it is not set.
+func newStore(tb testing.TB) func() store.Store {
+	servers := os.Getenv("GOKV_ZK_SERVERS")
+	if servers == "" {
+		tb.Skip("GOKV_ZK_SERVERS is not set")
+	}
+	c, _, err := gozk.Connect(strings.Split(servers, ","), 5*time.Second, gozk.WithLogInfo(false))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(c.Close)
+	return func() store.Store {
+		s, err := zk.NewWithSweepInterval(c, "/gokv-test", 100*time.Millisecond)
+		if err != nil {
+			panic(err)
+		}
+		if err := s.Clear(context.Background()); err != nil {
+			panic(err)
+		}
+		return s
+	}
+}
+
+func TestStore(t *testing.T) { storetest.TestStore(t, newStore(t)) }
+
+func FuzzStore(f *testing.F) { storetest.FuzzStore(f, newStore(f)) }
+
+func BenchmarkStore(b *testing.B) { storetest.BenchmarkStore(b, newStore(b)) }